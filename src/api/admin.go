@@ -0,0 +1,397 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"authzen/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// CreatePolicyRequest represents a policy to create, optionally as a grant
+// that expires after a time to live
+type CreatePolicyRequest struct {
+	policy.Policy
+	TTL string `json:"ttl,omitempty"` // Time to live, e.g. "8h", which sets not_after; exclusive with not_after
+}
+
+// BulkPoliciesRequest represents a bulk upsert of policies
+type BulkPoliciesRequest struct {
+	Policies []policy.Policy `json:"policies"`
+}
+
+// BulkPoliciesResponse represents the policies stored by a bulk upsert
+type BulkPoliciesResponse struct {
+	Policies []policy.Policy `json:"policies"`
+}
+
+// registerAdminHandlers registers the policy administration endpoints.
+// They are restricted to trusted callers when callers are configured.
+func (s *Server) registerAdminHandlers() {
+	s.scoped.HandleFunc("/v1/policies", s.requireTrusted(s.handleListPolicies)).Methods("GET")
+	s.scoped.HandleFunc("/v1/policies", s.requireTrusted(s.handleCreatePolicy)).Methods("POST")
+	s.scoped.HandleFunc("/v1/policies/bulk", s.requireTrusted(s.handleBulkUpsertPolicies)).Methods("POST")
+	s.scoped.HandleFunc("/v1/policies/cedar", s.requireTrusted(s.handleUpsertCedarPolicies)).Methods("POST")
+	s.scoped.HandleFunc("/v1/policies/{id}", s.requireTrusted(s.handleGetPolicy)).Methods("GET")
+	s.scoped.HandleFunc("/v1/policies/{id}", s.requireTrusted(s.handleUpdatePolicy)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/policies/{id}", s.requireTrusted(s.handlePatchPolicy)).Methods("PATCH")
+	s.scoped.HandleFunc("/v1/policies/{id}", s.requireTrusted(s.handleDeletePolicy)).Methods("DELETE")
+
+	// Policy file endpoints
+	s.scoped.HandleFunc("/v1/policy-file/status", s.requireTrusted(s.handlePolicyFileStatus)).Methods("GET")
+	s.scoped.HandleFunc("/v1/policy-file/reload", s.requireTrusted(s.handlePolicyFileReload)).Methods("POST")
+
+	// Policy set endpoints
+	s.scoped.HandleFunc("/v1/policy-sets", s.requireTrusted(s.handleListPolicySets)).Methods("GET")
+	s.scoped.HandleFunc("/v1/policy-sets/{name}", s.requireTrusted(s.handleGetPolicySet)).Methods("GET")
+	s.scoped.HandleFunc("/v1/policy-sets/{name}", s.requireTrusted(s.handlePutPolicySet)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/policy-sets/{name}", s.requireTrusted(s.handleDeletePolicySet)).Methods("DELETE")
+
+	s.registerRBACHandlers()
+	s.registerRelationHandlers()
+	s.registerHierarchyHandlers()
+	s.registerElevationHandlers()
+	s.registerTenantHandlers()
+	s.registerCacheHandlers()
+}
+
+// WithPolicyLoader exposes the reload status of a policy file loader
+func WithPolicyLoader(loader *policy.Loader) ServerOption {
+	return func(s *Server) {
+		s.loader = loader
+	}
+}
+
+// handleListPolicies returns a list of policies, optionally filtered by the
+// subject_type, subject_id, resource_type, resource_id and action query parameters
+func (s *Server) handleListPolicies(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	policies := s.tenantStore(r.Context()).ListPolicies(policy.PolicyFilter{
+		SubjectType:  q.Get("subject_type"),
+		SubjectID:    q.Get("subject_id"),
+		ResourceType: q.Get("resource_type"),
+		ResourceID:   q.Get("resource_id"),
+		Action:       q.Get("action"),
+	})
+
+	writeJSON(w, http.StatusOK, policies)
+}
+
+// handleCreatePolicy creates a policy
+func (s *Server) handleCreatePolicy(w http.ResponseWriter, r *http.Request) {
+	var req CreatePolicyRequest
+	if err := decodeStrict(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	p := req.Policy
+	if req.TTL != "" {
+		ttl, err := time.ParseDuration(req.TTL)
+		if err != nil || ttl <= 0 {
+			writeError(w, r, http.StatusBadRequest, "ttl must be a positive duration such as \"8h\"")
+			return
+		}
+		if p.NotAfter != nil {
+			writeError(w, r, http.StatusBadRequest, "ttl and not_after are mutually exclusive")
+			return
+		}
+		notAfter := time.Now().UTC().Add(ttl)
+		p.NotAfter = &notAfter
+	}
+
+	created, err := s.tenantStore(r.Context()).AddPolicy(p)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/policies/%s", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleBulkUpsertPolicies creates or replaces several policies at once
+func (s *Server) handleBulkUpsertPolicies(w http.ResponseWriter, r *http.Request) {
+	var req BulkPoliciesRequest
+	if err := decodeStrict(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Policies) == 0 {
+		writeError(w, r, http.StatusBadRequest, "at least one policy is required")
+		return
+	}
+
+	stored, err := s.tenantStore(r.Context()).UpsertPolicies(req.Policies)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BulkPoliciesResponse{Policies: stored})
+}
+
+// cedarBodyLimit is the maximum size of a Cedar policy set accepted in a request
+const cedarBodyLimit = 1 << 20
+
+// handleUpsertCedarPolicies creates or replaces the policies of a Cedar
+// policy set sent as the request body
+func (s *Server) handleUpsertCedarPolicies(w http.ResponseWriter, r *http.Request) {
+	src, err := io.ReadAll(http.MaxBytesReader(w, r.Body, cedarBodyLimit))
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	policies, err := policy.ParseCedar(string(src))
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	if len(policies) == 0 {
+		writeError(w, r, http.StatusBadRequest, "at least one policy is required")
+		return
+	}
+
+	stored, err := s.tenantStore(r.Context()).UpsertPolicies(policies)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, BulkPoliciesResponse{Policies: stored})
+}
+
+// handleGetPolicy returns a single policy
+func (s *Server) handleGetPolicy(w http.ResponseWriter, r *http.Request) {
+	p, err := s.tenantStore(r.Context()).GetPolicy(mux.Vars(r)["id"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, p)
+}
+
+// handleUpdatePolicy replaces a policy
+func (s *Server) handleUpdatePolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var p policy.Policy
+	if err := decodeStrict(r, &p); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if p.ID != "" && p.ID != id {
+		writeError(w, r, http.StatusBadRequest, "policy id in body does not match the URL")
+		return
+	}
+	p.ID = id
+
+	s.writeUpdatedPolicy(w, r, p)
+}
+
+// handlePatchPolicy applies a JSON merge patch (RFC 7396) to a policy
+func (s *Server) handlePatchPolicy(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	current, err := s.tenantStore(r.Context()).GetPolicy(id)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	var patch map[string]interface{}
+	if err := json.NewDecoder(r.Body).Decode(&patch); err != nil {
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
+		return
+	}
+	if patchID, ok := patch["id"]; ok && patchID != id {
+		writeError(w, r, http.StatusBadRequest, "policy id cannot be changed")
+		return
+	}
+
+	// Apply the patch to the JSON form of the current policy
+	var doc map[string]interface{}
+	raw, err := json.Marshal(current)
+	if err == nil {
+		err = json.Unmarshal(raw, &doc)
+	}
+	if err == nil {
+		raw, err = json.Marshal(mergePatch(doc, patch))
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, fmt.Sprintf("cannot patch policy: %v", err))
+		return
+	}
+
+	var p policy.Policy
+	dec := json.NewDecoder(bytes.NewReader(raw))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&p); err != nil {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("invalid patch: %v", err))
+		return
+	}
+
+	s.writeUpdatedPolicy(w, r, p)
+}
+
+// writeUpdatedPolicy replaces a policy and sends it as the store keeps it
+func (s *Server) writeUpdatedPolicy(w http.ResponseWriter, r *http.Request, p policy.Policy) {
+	store := s.tenantStore(r.Context())
+	if err := store.UpdatePolicy(p); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	updated, err := store.GetPolicy(p.ID)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, updated)
+}
+
+// handleDeletePolicy removes a policy
+func (s *Server) handleDeletePolicy(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemovePolicy(mux.Vars(r)["id"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handlePolicyFileStatus returns the reload status of the policy file
+func (s *Server) handlePolicyFileStatus(w http.ResponseWriter, r *http.Request) {
+	if s.loader == nil || tenantID(r.Context()) != policy.DefaultTenant {
+		writeError(w, r, http.StatusNotFound, "no policy file is configured")
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.loader.Status())
+}
+
+// handlePolicyFileReload reloads the policy file immediately
+func (s *Server) handlePolicyFileReload(w http.ResponseWriter, r *http.Request) {
+	if s.loader == nil || tenantID(r.Context()) != policy.DefaultTenant {
+		writeError(w, r, http.StatusNotFound, "no policy file is configured")
+		return
+	}
+
+	if err := s.loader.Load(); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.loader.Status())
+}
+
+// handleListPolicySets returns all policy sets
+func (s *Server) handleListPolicySets(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListPolicySets())
+}
+
+// handleGetPolicySet returns a single policy set
+func (s *Server) handleGetPolicySet(w http.ResponseWriter, r *http.Request) {
+	ps, err := s.tenantStore(r.Context()).GetPolicySet(mux.Vars(r)["name"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ps)
+}
+
+// handlePutPolicySet creates or replaces a policy set
+func (s *Server) handlePutPolicySet(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var ps policy.PolicySet
+	if err := decodeStrict(r, &ps); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ps.Name != "" && ps.Name != name {
+		writeError(w, r, http.StatusBadRequest, "policy set name in body does not match the URL")
+		return
+	}
+	ps.Name = name
+
+	if err := s.tenantStore(r.Context()).PutPolicySet(ps); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ps)
+}
+
+// handleDeletePolicySet removes a policy set
+func (s *Server) handleDeletePolicySet(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemovePolicySet(mux.Vars(r)["name"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// writePolicyError maps a policy store error to an error response
+func writePolicyError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, policy.ErrInvalidPolicy), errors.Is(err, policy.ErrInvalidTenant):
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	case errors.Is(err, policy.ErrPolicyNotFound), errors.Is(err, policy.ErrRoleNotFound),
+		errors.Is(err, policy.ErrGroupNotFound), errors.Is(err, policy.ErrBindingNotFound),
+		errors.Is(err, policy.ErrNamespaceNotFound), errors.Is(err, policy.ErrPolicySetNotFound),
+		errors.Is(err, policy.ErrResourceNodeNotFound), errors.Is(err, policy.ErrTenantNotFound):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	case errors.Is(err, policy.ErrPolicyExists), errors.Is(err, policy.ErrRoleInUse):
+		writeError(w, r, http.StatusConflict, err.Error())
+	case errors.Is(err, policy.ErrQuotaExceeded):
+		writeError(w, r, http.StatusForbidden, err.Error())
+	default:
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+	}
+}
+
+// mergePatch applies a JSON merge patch to a document: null removes a member,
+// objects are merged recursively and any other value replaces the member
+func mergePatch(doc, patch map[string]interface{}) map[string]interface{} {
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	for key, value := range patch {
+		switch v := value.(type) {
+		case nil:
+			delete(doc, key)
+		case map[string]interface{}:
+			current, _ := doc[key].(map[string]interface{})
+			doc[key] = mergePatch(current, v)
+		default:
+			doc[key] = v
+		}
+	}
+	return doc
+}
+
+// decodeStrict decodes a JSON request body, rejecting unknown fields
+func decodeStrict(r *http.Request, v interface{}) error {
+	dec := json.NewDecoder(r.Body)
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(v); err != nil {
+		return fmt.Errorf("invalid request body: %v", err)
+	}
+	return nil
+}
+
+// writeJSON sends a JSON response with the given status code
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
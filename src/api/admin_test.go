@@ -0,0 +1,240 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"authzen/policy"
+)
+
+// send sends a request with a raw JSON body to the server as a trusted caller
+func send(s *Server, method, path, body string) *httptest.ResponseRecorder {
+	r := httptest.NewRequest(method, path, strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	s.Router().ServeHTTP(w, r)
+	return w
+}
+
+// newAdminServer returns a server with a trusted caller "admin"
+func newAdminServer(store policy.Store) *Server {
+	return NewServer(store, "", WithCallers(map[string]Caller{"admin": {ID: "admin", Trusted: true}}))
+}
+
+// decodePolicy decodes a policy response with the given status
+func decodePolicy(t *testing.T, w *httptest.ResponseRecorder, status int) policy.Policy {
+	t.Helper()
+	if w.Code != status {
+		t.Fatalf("status %d, want %d: %s", w.Code, status, w.Body)
+	}
+	var p policy.Policy
+	if err := json.NewDecoder(w.Body).Decode(&p); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestPolicyCRUD(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	s := newAdminServer(store)
+
+	w := send(s, http.MethodPost, "/v1/policies", `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"read","allow":true}`)
+	created := decodePolicy(t, w, http.StatusCreated)
+	if created.ID == "" || w.Header().Get("Location") != "/v1/policies/"+created.ID {
+		t.Fatalf("created %+v at %q, want an assigned ID and its location", created, w.Header().Get("Location"))
+	}
+	path := "/v1/policies/" + created.ID
+
+	if got := decodePolicy(t, send(s, http.MethodGet, path, ""), http.StatusOK); !reflect.DeepEqual(got, created) {
+		t.Errorf("GET = %+v, want %+v", got, created)
+	}
+
+	updated := decodePolicy(t, send(s, http.MethodPut, path, `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"2"},"action":"read","allow":false}`), http.StatusOK)
+	stored, err := store.GetPolicy(created.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(updated, stored) || updated.ID != created.ID || updated.Resource.ID != "2" || updated.Allow {
+		t.Errorf("PUT = %+v, want the stored %+v", updated, stored)
+	}
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{name: "create with unknown field", method: http.MethodPost, path: "/v1/policies", body: `{"subject":{"type":"user","id":"a"},"resource":{"type":"document","id":"1"},"action":"read","effect":"allow"}`, status: http.StatusBadRequest},
+		{name: "create incomplete", method: http.MethodPost, path: "/v1/policies", body: `{"subject":{"type":"user","id":"a"},"action":"read"}`, status: http.StatusBadRequest},
+		{name: "create existing", method: http.MethodPost, path: "/v1/policies", body: `{"id":"` + created.ID + `","subject":{"type":"user","id":"a"},"resource":{"type":"document","id":"1"},"action":"read"}`, status: http.StatusConflict},
+		{name: "update with another id", method: http.MethodPut, path: path, body: `{"id":"other","subject":{"type":"user","id":"a"},"resource":{"type":"document","id":"1"},"action":"read"}`, status: http.StatusBadRequest},
+		{name: "update missing", method: http.MethodPut, path: "/v1/policies/missing", body: `{"subject":{"type":"user","id":"a"},"resource":{"type":"document","id":"1"},"action":"read"}`, status: http.StatusNotFound},
+		{name: "get missing", method: http.MethodGet, path: "/v1/policies/missing", status: http.StatusNotFound},
+		{name: "delete", method: http.MethodDelete, path: path, status: http.StatusNoContent},
+		{name: "get deleted", method: http.MethodGet, path: path, status: http.StatusNotFound},
+		{name: "delete deleted", method: http.MethodDelete, path: path, status: http.StatusNotFound},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(s, tt.method, tt.path, tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestBulkUpsertPolicies(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	if _, err := store.AddPolicy(policy.Policy{ID: "p1", Subject: policy.Entity{Type: "user", ID: "alice"}, Resource: policy.Entity{Type: "document", ID: "1"}, Action: "read", Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	s := newAdminServer(store)
+
+	w := send(s, http.MethodPost, "/v1/policies/bulk", `{"policies":[
+		{"id":"p1","subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"read","allow":false},
+		{"subject":{"type":"user","id":"bob"},"resource":{"type":"document","id":"1"},"action":"read","allow":true}
+	]}`)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var resp BulkPoliciesResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.Policies) != 2 || resp.Policies[0].ID != "p1" || resp.Policies[1].ID == "" {
+		t.Fatalf("policies = %+v, want p1 and a policy with an assigned ID", resp.Policies)
+	}
+	for _, want := range resp.Policies {
+		if got, err := store.GetPolicy(want.ID); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("stored %+v, %v, want %+v", got, err, want)
+		}
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "empty", body: `{"policies":[]}`, status: http.StatusBadRequest},
+		{name: "one invalid", body: `{"policies":[{"id":"p3","subject":{"type":"user","id":"carol"},"resource":{"type":"document","id":"1"},"action":"read"},{"subject":{"type":"user","id":"dave"},"action":"read"}]}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(s, http.MethodPost, "/v1/policies/bulk", tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+	if _, err := store.GetPolicy("p3"); err == nil {
+		t.Error("a rejected bulk upsert stored some of its policies")
+	}
+}
+
+func TestPatchPolicy(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	base := policy.Policy{
+		ID:       "p1",
+		Subject:  policy.Entity{Type: "user", ID: "alice"},
+		Resource: policy.Entity{Type: "document", ID: "1"},
+		Action:   "read",
+		Allow:    true,
+		Priority: 5,
+		Reason:   &policy.Reason{ID: "owner", User: map[string]string{"en": "You own it", "de": "Es gehört Ihnen"}},
+	}
+	tests := []struct {
+		name   string
+		patch  string
+		status int
+		want   func(p *policy.Policy)
+	}{
+		{
+			name:   "replace a member",
+			patch:  `{"allow":false}`,
+			status: http.StatusOK,
+			want:   func(p *policy.Policy) { p.Allow = false },
+		},
+		{
+			name:   "merge an object",
+			patch:  `{"resource":{"id":"2"}}`,
+			status: http.StatusOK,
+			want:   func(p *policy.Policy) { p.Resource.ID = "2" },
+		},
+		{
+			name:   "null deletes a member",
+			patch:  `{"priority":null,"reason":null}`,
+			status: http.StatusOK,
+			want:   func(p *policy.Policy) { p.Priority, p.Reason = 0, nil },
+		},
+		{
+			name:   "null deletes a nested member",
+			patch:  `{"reason":{"reason_user":{"de":null}}}`,
+			status: http.StatusOK,
+			want: func(p *policy.Policy) {
+				p.Reason = &policy.Reason{ID: "owner", User: map[string]string{"en": "You own it"}}
+			},
+		},
+		{
+			name:   "same id",
+			patch:  `{"id":"p1","action":"write"}`,
+			status: http.StatusOK,
+			want:   func(p *policy.Policy) { p.Action = "write" },
+		},
+		{name: "id is immutable", patch: `{"id":"p2"}`, status: http.StatusBadRequest},
+		{name: "id cannot be removed", patch: `{"id":null}`, status: http.StatusBadRequest},
+		{name: "invalid result", patch: `{"subject":null}`, status: http.StatusBadRequest},
+		{name: "unknown member", patch: `{"effect":"deny"}`, status: http.StatusBadRequest},
+		{name: "not an object", patch: `[]`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			store := policy.NewMemoryStore()
+			if _, err := store.AddPolicy(base); err != nil {
+				t.Fatal(err)
+			}
+			s := newAdminServer(store)
+
+			w := send(s, http.MethodPatch, "/v1/policies/p1", tt.patch)
+			stored, err := store.GetPolicy("p1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tt.status != http.StatusOK {
+				if w.Code != tt.status {
+					t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+				}
+				if !reflect.DeepEqual(stored, base) {
+					t.Errorf("stored %+v after a rejected patch, want %+v", stored, base)
+				}
+				return
+			}
+
+			want := base
+			want.Reason = &policy.Reason{ID: base.Reason.ID, User: map[string]string{"en": "You own it", "de": "Es gehört Ihnen"}}
+			tt.want(&want)
+			got := decodePolicy(t, w, tt.status)
+			if !reflect.DeepEqual(got, want) || !reflect.DeepEqual(stored, want) {
+				t.Errorf("patched %+v, stored %+v, want %+v", got, stored, want)
+			}
+		})
+	}
+
+	s := newAdminServer(policy.NewMemoryStore())
+	if w := send(s, http.MethodPatch, "/v1/policies/missing", `{"allow":false}`); w.Code != http.StatusNotFound {
+		t.Errorf("patch of a missing policy: status %d, want %d", w.Code, http.StatusNotFound)
+	}
+}
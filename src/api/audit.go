@@ -0,0 +1,52 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"time"
+
+	"authzen/policy"
+)
+
+// AuditEvent is an entry of the audit log, which records administrative
+// actions that need to be accounted for
+type AuditEvent struct {
+	Time          time.Time      `json:"time"`
+	Event         string         `json:"event"` // What happened, e.g. "elevation"
+	RequestID     string         `json:"request_id,omitempty"`
+	Caller        string         `json:"caller,omitempty"`        // ID of the caller; empty for anonymous callers
+	Tenant        string         `json:"tenant,omitempty"`        // Tenant the action applies to
+	Policy        *policy.Policy `json:"policy,omitempty"`        // Policy the action created
+	Justification string         `json:"justification,omitempty"` // Why the caller took the action
+}
+
+// WithAuditLog writes the audit log to w, one JSON event per line. Without
+// it, audit events are written to the standard logger.
+func WithAuditLog(w io.Writer) ServerOption {
+	return func(s *Server) {
+		s.auditLog = log.New(w, "", 0)
+	}
+}
+
+// audit records an event of the request in the audit log
+func (s *Server) audit(r *http.Request, e AuditEvent) {
+	e.Time = time.Now().UTC()
+	e.RequestID = RequestID(r.Context())
+	e.Caller = s.caller(r).ID
+	if e.Tenant == "" {
+		e.Tenant = tenantID(r.Context())
+	}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("[%s] cannot write audit event %s: %v", e.RequestID, e.Event, err)
+		return
+	}
+	if s.auditLog == nil {
+		log.Printf("audit: %s", data)
+		return
+	}
+	s.auditLog.Print(string(data))
+}
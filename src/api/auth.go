@@ -0,0 +1,51 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Caller identifies the client (PEP) making a request
+type Caller struct {
+	ID      string // Caller identifier, empty for anonymous callers
+	Trusted bool   // Whether the caller may see administrative details such as reason_admin
+	Tenant  string // Tenant the caller is bound to; empty for callers that may select any tenant
+}
+
+// WithCallers registers the bearer tokens that authenticate callers.
+// Requests without a known token are treated as anonymous, untrusted callers.
+func WithCallers(callers map[string]Caller) ServerOption {
+	return func(s *Server) {
+		for token, caller := range callers {
+			s.callers[token] = caller
+		}
+	}
+}
+
+// WithAnonymousAdmin lets anyone use the administration endpoints while no
+// callers are configured. Without it, they are closed until trusted callers
+// are configured. Meant for local development only.
+func WithAnonymousAdmin(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.anonymousAdmin = enabled
+	}
+}
+
+// caller returns the caller authenticated by the request's bearer token
+func (s *Server) caller(r *http.Request) Caller {
+	token := bearerToken(r)
+	if token == "" {
+		return Caller{}
+	}
+	return s.callers[token]
+}
+
+// bearerToken extracts the bearer token from the Authorization header
+func bearerToken(r *http.Request) string {
+	auth := r.Header.Get("Authorization")
+	const prefix = "bearer "
+	if len(auth) <= len(prefix) || !strings.EqualFold(auth[:len(prefix)], prefix) {
+		return ""
+	}
+	return strings.TrimSpace(auth[len(prefix):])
+}
@@ -0,0 +1,100 @@
+package api
+
+import (
+	"context"
+	"runtime"
+	"sync"
+	"sync/atomic"
+
+	"authzen/policy"
+)
+
+// Evaluation semantics of a batch, as defined in the spec
+const (
+	semanticExecuteAll          = "execute_all"
+	semanticDenyOnFirstDeny     = "deny_on_first_deny"
+	semanticPermitOnFirstPermit = "permit_on_first_permit"
+)
+
+// WithBatchWorkers sets the number of evaluations of a batch that run in
+// parallel. It defaults to the number of CPUs.
+func WithBatchWorkers(n int) ServerOption {
+	return func(s *Server) {
+		if n > 0 {
+			s.batchWorkers = n
+		}
+	}
+}
+
+// stopsBatch reports whether a decision ends a batch under the semantic
+func stopsBatch(semantic string, allowed bool) bool {
+	switch semantic {
+	case semanticDenyOnFirstDeny:
+		return !allowed
+	case semanticPermitOnFirstPermit:
+		return allowed
+	}
+	return false
+}
+
+// evaluateBatch evaluates the requests of a batch on a pool of workers and
+// returns their results in request order. Under a short-circuiting semantic
+// it returns the same prefix as evaluating the requests one at a time: up to
+// and including the first request whose decision stops the batch. Workers
+// take requests in order and stop once they reach a request after a stopping
+// one or ctx is cancelled, so no request of the prefix is ever skipped. Only
+// the decisions of the returned prefix are logged, once all workers are done.
+// It returns nil, and logs nothing, if ctx is cancelled first.
+func (s *Server) evaluateBatch(ctx context.Context, requests []AuthorizeRequest, caller Caller, semantic string) []EvaluationResult {
+	n := len(requests)
+	results := make([]EvaluationResult, n)
+	decisions := make([]policy.Decision, n)
+	var (
+		next int64          // Index of the next request to evaluate
+		stop = int64(n - 1) // Index of the last result to return
+		wg   sync.WaitGroup
+	)
+	worker := func() {
+		defer wg.Done()
+		for {
+			i := atomic.AddInt64(&next, 1) - 1
+			if i >= int64(n) || i > atomic.LoadInt64(&stop) || ctx.Err() != nil {
+				return
+			}
+			resp, decision := s.decide(ctx, requests[i], caller)
+			results[i], decisions[i] = EvaluationResult(resp), decision
+			if !stopsBatch(semantic, decision.Allow) {
+				continue
+			}
+			for {
+				current := atomic.LoadInt64(&stop)
+				if i >= current || atomic.CompareAndSwapInt64(&stop, current, i) {
+					break
+				}
+			}
+		}
+	}
+
+	workers := s.batchWorkers
+	if workers > n {
+		workers = n
+	}
+	wg.Add(workers)
+	for w := 0; w < workers; w++ {
+		go worker()
+	}
+	wg.Wait()
+
+	if ctx.Err() != nil {
+		return nil
+	}
+	for i := int64(0); i <= stop; i++ {
+		logDecision(ctx, requests[i], decisions[i], caller)
+	}
+	return results[:stop+1]
+}
+
+// defaultBatchWorkers is the number of parallel evaluations of a batch by default
+func defaultBatchWorkers() int {
+	return runtime.GOMAXPROCS(0)
+}
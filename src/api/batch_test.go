@@ -0,0 +1,222 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"authzen/policy"
+)
+
+// batchUsers is the number of users with a policy in the batch test store
+const batchUsers = 200
+
+// newBatchStore returns a store in which every third user is denied reading
+// document 1, the others are allowed if the request's tags pass a condition
+// and users beyond batchUsers have no policy
+func newBatchStore(tb testing.TB) policy.Store {
+	policies := make([]policy.Policy, 0, batchUsers)
+	for i := 0; i < batchUsers; i++ {
+		p := policy.Policy{
+			ID:       fmt.Sprintf("p%d", i),
+			Subject:  policy.Entity{Type: "user", ID: fmt.Sprintf("u%d", i)},
+			Resource: policy.Entity{Type: "document", ID: "1"},
+			Action:   "read",
+			Allow:    i%3 != 0,
+		}
+		if p.Allow {
+			p.Condition = `context.tags.all(t, t.matches("^[a-z]+[0-9]*$")) && size(context.tags) > 2`
+		}
+		policies = append(policies, p)
+	}
+	store := policy.NewMemoryStore()
+	if _, err := store.UpsertPolicies(policies); err != nil {
+		tb.Fatal(err)
+	}
+	return store
+}
+
+// batchRequests returns n requests for random users, some without a policy
+func batchRequests(rng *rand.Rand, n int) []AuthorizeRequest {
+	requests := make([]AuthorizeRequest, n)
+	for i := range requests {
+		tags := []interface{}{"alpha", "beta1", "gamma22"}
+		if rng.Intn(10) == 0 {
+			tags = tags[:2]
+		}
+		requests[i] = AuthorizeRequest{
+			Subject:  Subject{Type: "user", ID: fmt.Sprintf("u%d", rng.Intn(batchUsers*11/10))},
+			Resource: Resource{Type: "document", ID: "1"},
+			Action:   Action{Name: "read"},
+			Context:  Context{"tags": tags},
+		}
+	}
+	return requests
+}
+
+// evaluateSequentially is the reference behavior of a batch: evaluating its
+// requests one at a time and stopping after the first stopping decision
+func evaluateSequentially(s *Server, requests []AuthorizeRequest, semantic string) []EvaluationResult {
+	results := make([]EvaluationResult, 0, len(requests))
+	for _, req := range requests {
+		result := EvaluationResult(s.evaluate(context.Background(), req, Caller{}))
+		results = append(results, result)
+		if stopsBatch(semantic, result.Decision) {
+			break
+		}
+	}
+	return results
+}
+
+// TestEvaluateBatchMatchesSequential checks that parallel evaluation returns
+// the results and the short-circuited prefix of sequential evaluation. Run
+// it with -race to check the worker pool for data races.
+func TestEvaluateBatchMatchesSequential(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	s := NewServer(newBatchStore(t), "", WithBatchWorkers(8))
+	rng := rand.New(rand.NewSource(1))
+	semantics := []string{semanticExecuteAll, semanticDenyOnFirstDeny, semanticPermitOnFirstPermit}
+
+	for round := 0; round < 50; round++ {
+		requests := batchRequests(rng, 1+rng.Intn(100))
+		for _, semantic := range semantics {
+			want := evaluateSequentially(s, requests, semantic)
+			got := s.evaluateBatch(context.Background(), requests, Caller{}, semantic)
+			if !reflect.DeepEqual(got, want) {
+				t.Fatalf("round %d, %s: got %d results %v, want %d results %v", round, semantic, len(got), got, len(want), want)
+			}
+		}
+	}
+}
+
+func TestEvaluateBatchCancelled(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	s := NewServer(newBatchStore(t), "", WithBatchWorkers(4))
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	requests := batchRequests(rand.New(rand.NewSource(2)), 100)
+	if got := s.evaluateBatch(ctx, requests, Caller{}, semanticExecuteAll); got != nil {
+		t.Fatalf("evaluateBatch with a cancelled context = %d results, want nil", len(got))
+	}
+}
+
+// stallingStore holds back the evaluation of one subject's requests until
+// other requests have been evaluated, so that the workers of a batch run
+// past a stopping request
+type stallingStore struct {
+	policy.Store
+	stall     string // ID of the subject whose requests are held back
+	evaluated int64  // Number of evaluated requests of other subjects
+}
+
+func (s *stallingStore) Evaluate(req policy.Request) policy.Decision {
+	if req.Subject.ID != s.stall {
+		atomic.AddInt64(&s.evaluated, 1)
+		return s.Store.Evaluate(req)
+	}
+	for deadline := time.Now().Add(time.Second); atomic.LoadInt64(&s.evaluated) < 20 && time.Now().Before(deadline); {
+		time.Sleep(time.Millisecond)
+	}
+	return s.Store.Evaluate(req)
+}
+
+// TestEvaluateBatchLogsReturnedDecisions checks that a short-circuited batch
+// logs only the decisions it returns, not those its workers evaluated past
+// the stopping request
+func TestEvaluateBatchLogsReturnedDecisions(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	store := &stallingStore{Store: newBatchStore(t), stall: "u3"}
+	s := NewServer(store, "", WithBatchWorkers(8))
+	requests := make([]AuthorizeRequest, 40)
+	for i := range requests {
+		user := "u1" // Allowed
+		if i == 5 {
+			user = "u3" // Denied
+		}
+		requests[i] = AuthorizeRequest{
+			Subject:  Subject{Type: "user", ID: user},
+			Resource: Resource{Type: "document", ID: "1"},
+			Action:   Action{Name: "read"},
+			Context:  Context{"tags": []interface{}{"alpha", "beta1", "gamma22"}},
+		}
+	}
+
+	tests := []struct {
+		semantic string
+		logged   int
+	}{
+		{semantic: semanticExecuteAll, logged: 40},
+		{semantic: semanticDenyOnFirstDeny, logged: 6},
+		{semantic: semanticPermitOnFirstPermit, logged: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.semantic, func(t *testing.T) {
+			logged.Reset()
+			atomic.StoreInt64(&store.evaluated, 0)
+			results := s.evaluateBatch(context.Background(), requests, Caller{}, tt.semantic)
+			if len(results) != tt.logged {
+				t.Fatalf("%d results, want %d", len(results), tt.logged)
+			}
+			if n := strings.Count(logged.String(), "] decision "); n != tt.logged {
+				t.Errorf("%d decisions logged, want %d", n, tt.logged)
+			}
+		})
+	}
+
+	logged.Reset()
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if s.evaluateBatch(ctx, requests, Caller{}, semanticExecuteAll); logged.Len() != 0 {
+		t.Errorf("cancelled batch logged %q, want nothing", logged.String())
+	}
+}
+
+// benchmarkEvaluations posts a batch of 500 evaluations to a server with
+// the given number of workers
+func benchmarkEvaluations(b *testing.B, workers int) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	s := NewServer(newBatchStore(b), "", WithBatchWorkers(workers))
+	var req EvaluationsRequest
+	for _, r := range batchRequests(rand.New(rand.NewSource(3)), 500) {
+		r := r
+		req.Evaluations = append(req.Evaluations, EvaluationItem{Subject: &r.Subject, Resource: &r.Resource, Action: &r.Action, Context: r.Context})
+	}
+	body, err := json.Marshal(req)
+	if err != nil {
+		b.Fatal(err)
+	}
+	handler := s.Router()
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/access/v1/evaluations", bytes.NewReader(body)))
+		if w.Code != http.StatusOK {
+			b.Fatalf("status %d: %s", w.Code, w.Body)
+		}
+	}
+}
+
+func BenchmarkEvaluationsSequential(b *testing.B) { benchmarkEvaluations(b, 1) }
+func BenchmarkEvaluationsParallel(b *testing.B)   { benchmarkEvaluations(b, defaultBatchWorkers()) }
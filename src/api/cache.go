@@ -0,0 +1,218 @@
+package api
+
+import (
+	"container/list"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"authzen/policy"
+)
+
+// decisionCache is a bounded LRU cache of decisions. An entry is keyed by
+// the tenant's store and everything a decision depends on: the subject,
+// resource and action with their properties and the context. It holds the
+// store generation it was decided at and is never served once the store has
+// changed, its TTL has passed or the validity period of a policy it depends
+// on starts or ends.
+type decisionCache struct {
+	mu      sync.Mutex
+	size    int           // Maximum number of entries
+	ttl     time.Duration // Time an entry is served for
+	entries map[decisionKey]*list.Element
+	lru     *list.List // Entries, most recently used first
+
+	hits      uint64
+	misses    uint64
+	evictions uint64
+}
+
+// decisionKey identifies a request to a tenant's store
+type decisionKey struct {
+	store   policy.Store
+	request string // Canonical JSON of the request
+}
+
+// cachedDecision is an entry of the decision cache
+type cachedDecision struct {
+	key        decisionKey
+	generation uint64 // Store generation the decision was taken at
+	expires    time.Time
+	decision   policy.Decision
+}
+
+// DecisionCacheStats reports the size and effectiveness of the decision cache
+type DecisionCacheStats struct {
+	Enabled   bool   `json:"enabled"`
+	Size      int    `json:"size"`     // Current number of entries
+	Capacity  int    `json:"capacity"` // Maximum number of entries
+	TTL       string `json:"ttl,omitempty"`
+	Hits      uint64 `json:"hits"`
+	Misses    uint64 `json:"misses"`
+	Evictions uint64 `json:"evictions"` // Entries dropped to make room for others
+}
+
+// WithDecisionCache caches up to size decisions for at most ttl. A size of
+// zero or less disables the cache.
+func WithDecisionCache(size int, ttl time.Duration) ServerOption {
+	return func(s *Server) {
+		if size <= 0 || ttl <= 0 {
+			s.cache = nil
+			return
+		}
+		s.cache = &decisionCache{
+			size:    size,
+			ttl:     ttl,
+			entries: make(map[decisionKey]*list.Element),
+			lru:     list.New(),
+		}
+	}
+}
+
+// decide evaluates a request against a store, serving the decision from the
+// cache when it is still current
+func (c *decisionCache) decide(store policy.Store, req policy.Request) policy.Decision {
+	if c == nil {
+		return store.Evaluate(req)
+	}
+	key, ok := newDecisionKey(store, req)
+	if !ok {
+		atomic.AddUint64(&c.misses, 1)
+		return store.Evaluate(req)
+	}
+
+	// Read the generation before evaluating: a decision taken after a
+	// concurrent change is then recorded as older and never served
+	generation := store.Generation()
+	now := time.Now()
+	if d, ok := c.get(key, generation, now); ok {
+		atomic.AddUint64(&c.hits, 1)
+		return d
+	}
+	atomic.AddUint64(&c.misses, 1)
+
+	d := store.Evaluate(req)
+	expires := now.Add(c.ttl)
+	if !d.Expires.IsZero() && d.Expires.Before(expires) {
+		expires = d.Expires
+	}
+	c.put(&cachedDecision{key: key, generation: generation, expires: expires, decision: d})
+	return d
+}
+
+// newDecisionKey builds the cache key of a request. Map keys are sorted when
+// encoding, so equal requests have equal keys.
+func newDecisionKey(store policy.Store, req policy.Request) (decisionKey, bool) {
+	data, err := json.Marshal(req)
+	if err != nil {
+		return decisionKey{}, false
+	}
+	return decisionKey{store: store, request: string(data)}, true
+}
+
+// get returns the cached decision of a key if it was taken at the given
+// generation and has not expired, and drops it otherwise
+func (c *decisionCache) get(key decisionKey, generation uint64, now time.Time) (policy.Decision, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.entries[key]
+	if !ok {
+		return policy.Decision{}, false
+	}
+	entry := elem.Value.(*cachedDecision)
+	if entry.generation != generation || !now.Before(entry.expires) {
+		c.lru.Remove(elem)
+		delete(c.entries, key)
+		return policy.Decision{}, false
+	}
+	c.lru.MoveToFront(elem)
+	return entry.decision, true
+}
+
+// put adds or replaces an entry, evicting the least recently used entries
+// beyond the cache's size
+func (c *decisionCache) put(entry *cachedDecision) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.entries[entry.key]; ok {
+		elem.Value = entry
+		c.lru.MoveToFront(elem)
+		return
+	}
+	c.entries[entry.key] = c.lru.PushFront(entry)
+	for c.lru.Len() > c.size {
+		oldest := c.lru.Back()
+		c.lru.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cachedDecision).key)
+		c.evictions++
+	}
+}
+
+// purge drops all entries
+func (c *decisionCache) purge() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.entries = make(map[decisionKey]*list.Element)
+	c.lru.Init()
+}
+
+// purgeStore drops the entries of a store, e.g. of a removed tenant, so
+// that the cache does not keep the store reachable
+func (c *decisionCache) purgeStore(store policy.Store) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	for key, elem := range c.entries {
+		if key.store == store {
+			c.lru.Remove(elem)
+			delete(c.entries, key)
+		}
+	}
+}
+
+// stats returns the cache's current statistics
+func (c *decisionCache) stats() DecisionCacheStats {
+	if c == nil {
+		return DecisionCacheStats{}
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return DecisionCacheStats{
+		Enabled:   true,
+		Size:      c.lru.Len(),
+		Capacity:  c.size,
+		TTL:       c.ttl.String(),
+		Hits:      atomic.LoadUint64(&c.hits),
+		Misses:    atomic.LoadUint64(&c.misses),
+		Evictions: c.evictions,
+	}
+}
+
+// registerCacheHandlers registers the decision cache endpoints
+func (s *Server) registerCacheHandlers() {
+	s.router.HandleFunc("/v1/decision-cache", s.requireTrusted(s.requireAllTenants(s.handleDecisionCacheStats))).Methods("GET")
+	s.router.HandleFunc("/v1/decision-cache", s.requireTrusted(s.requireAllTenants(s.handlePurgeDecisionCache))).Methods("DELETE")
+}
+
+// handleDecisionCacheStats returns the statistics of the decision cache
+func (s *Server) handleDecisionCacheStats(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.cache.stats())
+}
+
+// handlePurgeDecisionCache drops all cached decisions. The cache never
+// serves stale decisions, so this is only needed to free memory.
+func (s *Server) handlePurgeDecisionCache(w http.ResponseWriter, r *http.Request) {
+	if s.cache != nil {
+		s.cache.purge()
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
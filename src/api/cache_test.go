@@ -0,0 +1,128 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"authzen/policy"
+)
+
+// cacheRequest returns a request of the user reading document 1
+func cacheRequest(user string) policy.Request {
+	return policy.Request{
+		Subject:  policy.Entity{Type: "user", ID: user},
+		Resource: policy.Entity{Type: "document", ID: "1"},
+		Action:   "read",
+	}
+}
+
+// newTestCache returns a cache of the given size with a TTL of a minute
+func newTestCache(size int) *decisionCache {
+	s := &Server{}
+	WithDecisionCache(size, time.Minute)(s)
+	return s.cache
+}
+
+func TestDecisionCacheGeneration(t *testing.T) {
+	store := policy.NewMemoryStore()
+	c := newTestCache(10)
+	req := cacheRequest("alice")
+
+	if d := c.decide(store, req); d.Allow {
+		t.Fatal("allowed without a policy")
+	}
+	if _, err := store.AddPolicy(policy.Policy{Subject: req.Subject, Resource: req.Resource, Action: "read", Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	if d := c.decide(store, req); !d.Allow {
+		t.Error("decision taken before the store changed was served")
+	}
+	if stats := c.stats(); stats.Hits != 0 || stats.Misses != 2 || stats.Size != 1 {
+		t.Errorf("stats = %+v, want 0 hits, 2 misses and 1 entry", stats)
+	}
+	c.decide(store, req)
+	if stats := c.stats(); stats.Hits != 1 {
+		t.Errorf("stats = %+v, want a hit for an unchanged store", stats)
+	}
+}
+
+func TestDecisionCacheExpires(t *testing.T) {
+	store := policy.NewMemoryStore()
+	c := newTestCache(10)
+	req := cacheRequest("alice")
+	notAfter := time.Now().Add(10 * time.Second)
+	if _, err := store.AddPolicy(policy.Policy{Subject: req.Subject, Resource: req.Resource, Action: "read", Allow: true, NotAfter: &notAfter}); err != nil {
+		t.Fatal(err)
+	}
+
+	d := c.decide(store, req)
+	if !d.Allow || !d.Expires.Equal(notAfter) {
+		t.Fatalf("decision = %v until %v, want an allow until %v", d.Allow, d.Expires, notAfter)
+	}
+	key, _ := newDecisionKey(store, req)
+	if _, ok := c.get(key, store.Generation(), notAfter.Add(-time.Second)); !ok {
+		t.Error("entry not served before the policy's validity ends")
+	}
+	if _, ok := c.get(key, store.Generation(), notAfter); ok {
+		t.Error("entry served once the policy's validity ended, before the TTL")
+	}
+}
+
+func TestDecisionCacheEviction(t *testing.T) {
+	store := policy.NewMemoryStore()
+	c := newTestCache(2)
+
+	c.decide(store, cacheRequest("a"))
+	c.decide(store, cacheRequest("b"))
+	c.decide(store, cacheRequest("a")) // a is now the most recently used
+	c.decide(store, cacheRequest("c")) // evicts b
+
+	generation := store.Generation()
+	for user, cached := range map[string]bool{"a": true, "b": false, "c": true} {
+		key, _ := newDecisionKey(store, cacheRequest(user))
+		if _, ok := c.get(key, generation, time.Now()); ok != cached {
+			t.Errorf("%s cached = %v, want %v", user, ok, cached)
+		}
+	}
+	if stats := c.stats(); stats.Size != 2 || stats.Evictions != 1 {
+		t.Errorf("stats = %+v, want 2 entries and 1 eviction", stats)
+	}
+}
+
+func TestDecisionCachePurgesRemovedTenant(t *testing.T) {
+	store := policy.NewMemoryStore()
+	reg, err := policy.NewTenantRegistry(store, policy.TenantOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Put(policy.Tenant{ID: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store, "", WithTenants(reg), WithDecisionCache(10, time.Minute),
+		WithCallers(map[string]Caller{"admin": {ID: "admin", Trusted: true}}))
+	acme, err := reg.Store("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.cache.decide(acme, cacheRequest("alice"))
+	s.cache.decide(store, cacheRequest("alice"))
+
+	r := httptest.NewRequest(http.MethodDelete, "/v1/tenants/acme", nil)
+	r.Header.Set("Authorization", "Bearer admin")
+	w := httptest.NewRecorder()
+	s.Router().ServeHTTP(w, r)
+	if w.Code != http.StatusNoContent {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+
+	for key := range s.cache.entries {
+		if key.store == acme {
+			t.Fatal("cache keeps an entry of the removed tenant")
+		}
+	}
+	if size := s.cache.stats().Size; size != 1 {
+		t.Errorf("size = %d, want the default tenant's entry", size)
+	}
+}
@@ -0,0 +1,82 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"authzen/policy"
+)
+
+// defaultMaxElevation is the longest elevation granted unless configured otherwise
+const defaultMaxElevation = 8 * time.Hour
+
+// ElevationRequest asks for temporary access, e.g. for on-call duty
+type ElevationRequest struct {
+	Subject       policy.Entity `json:"subject"`
+	Resource      policy.Entity `json:"resource"`
+	Action        string        `json:"action"`
+	Duration      string        `json:"duration"`      // How long access is granted, e.g. "1h"
+	Justification string        `json:"justification"` // Why access is needed; recorded in the audit log
+}
+
+// WithMaxElevation limits how long an elevation may grant access
+func WithMaxElevation(d time.Duration) ServerOption {
+	return func(s *Server) {
+		s.maxElevation = d
+	}
+}
+
+// registerElevationHandlers registers the just-in-time access endpoint
+func (s *Server) registerElevationHandlers() {
+	s.scoped.HandleFunc("/v1/elevations", s.requireTrusted(s.handleRequestElevation)).Methods("POST")
+}
+
+// handleRequestElevation grants temporary access with a policy that expires
+// after the requested duration and records the justification in the audit log
+func (s *Server) handleRequestElevation(w http.ResponseWriter, r *http.Request) {
+	var req ElevationRequest
+	if err := decodeStrict(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	grant := policy.Policy{Subject: req.Subject, Resource: req.Resource, Action: req.Action}
+	if grant.IsPattern() {
+		writeError(w, r, http.StatusBadRequest, "subject, resource and action of an elevation must not contain wildcards")
+		return
+	}
+	if strings.TrimSpace(req.Justification) == "" {
+		writeError(w, r, http.StatusBadRequest, "justification is required")
+		return
+	}
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		writeError(w, r, http.StatusBadRequest, "duration must be a positive duration such as \"1h\"")
+		return
+	}
+	if duration > s.maxElevation {
+		writeError(w, r, http.StatusBadRequest, fmt.Sprintf("duration must not exceed %s", s.maxElevation))
+		return
+	}
+
+	now := time.Now().UTC()
+	notAfter := now.Add(duration)
+	grant.Allow = true
+	grant.NotBefore = &now
+	grant.NotAfter = &notAfter
+	grant.Reason = &policy.Reason{
+		ID:    "elevation",
+		Admin: map[string]string{"en": "Temporary access until " + notAfter.Format(time.RFC3339) + ": " + req.Justification},
+		User:  map[string]string{"en": "Access granted"},
+	}
+	created, err := s.tenantStore(r.Context()).AddPolicy(grant)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	s.audit(r, AuditEvent{Event: "elevation", Policy: &created, Justification: req.Justification})
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/policies/%s", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
@@ -0,0 +1,93 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"authzen/policy"
+)
+
+func TestRequestElevation(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	var audit bytes.Buffer
+	s := NewServer(store, "",
+		WithCallers(map[string]Caller{"admin": {ID: "admin", Trusted: true}, "app": {ID: "app"}}),
+		WithMaxElevation(time.Hour),
+		WithAuditLog(&audit))
+
+	before := time.Now()
+	w := send(s, http.MethodPost, "/v1/elevations", `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"write","duration":"30m","justification":"INC-42"}`)
+	created := decodePolicy(t, w, http.StatusCreated)
+	if !created.Allow || created.NotBefore == nil || created.NotAfter == nil {
+		t.Fatalf("created %+v, want a time-bounded allow", created)
+	}
+	if d := created.NotAfter.Sub(*created.NotBefore); d != 30*time.Minute {
+		t.Errorf("validity = %s, want 30m", d)
+	}
+	if created.NotBefore.Before(before.Truncate(time.Second)) {
+		t.Errorf("not_before = %s, want the time of the request", created.NotBefore)
+	}
+
+	d := store.Evaluate(policy.Request{Subject: policy.Entity{Type: "user", ID: "alice"}, Resource: policy.Entity{Type: "document", ID: "1"}, Action: "write"})
+	if !d.Allow || !d.Expires.Equal(*created.NotAfter) {
+		t.Errorf("decision allow=%v expires=%s, want allowed until %s", d.Allow, d.Expires, created.NotAfter)
+	}
+
+	var event AuditEvent
+	if err := json.Unmarshal(audit.Bytes(), &event); err != nil {
+		t.Fatalf("audit log %q: %v", audit.String(), err)
+	}
+	if event.Event != "elevation" || event.Caller != "admin" || event.Justification != "INC-42" || event.Policy == nil || event.Policy.ID != created.ID {
+		t.Errorf("audit event = %+v, want the elevation of %s by admin", event, created.ID)
+	}
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "wildcard subject", body: `{"subject":{"type":"user","id":"*"},"resource":{"type":"document","id":"1"},"action":"write","duration":"1h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "subject pattern", body: `{"subject":{"type":"user","id":"a*"},"resource":{"type":"document","id":"1"},"action":"write","duration":"1h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "wildcard resource", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"*","id":"*"},"action":"write","duration":"1h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "wildcard action", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"*","duration":"1h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "no justification", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"write","duration":"1h","justification":" "}`, status: http.StatusBadRequest},
+		{name: "invalid duration", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"write","duration":"soon","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "negative duration", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"write","duration":"-1h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "duration above the maximum", body: `{"subject":{"type":"user","id":"alice"},"resource":{"type":"document","id":"1"},"action":"write","duration":"2h","justification":"x"}`, status: http.StatusBadRequest},
+		{name: "incomplete subject", body: `{"subject":{"type":"user"},"resource":{"type":"document","id":"1"},"action":"write","duration":"1h","justification":"x"}`, status: http.StatusBadRequest},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if w := send(s, http.MethodPost, "/v1/elevations", tt.body); w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+
+	untrusted := post(s, "/v1/elevations", "app", "", ElevationRequest{
+		Subject:       policy.Entity{Type: "user", ID: "app"},
+		Resource:      policy.Entity{Type: "document", ID: "1"},
+		Action:        "write",
+		Duration:      "1h",
+		Justification: "x",
+	})
+	if untrusted.Code != http.StatusForbidden {
+		t.Errorf("untrusted caller: status %d, want %d", untrusted.Code, http.StatusForbidden)
+	}
+
+	if n := len(store.ListPolicies(policy.PolicyFilter{})); n != 1 {
+		t.Errorf("%d policies, want only the accepted elevation", n)
+	}
+	if lines := strings.Count(audit.String(), "\n"); lines != 1 {
+		t.Errorf("%d audit events, want 1", lines)
+	}
+}
@@ -0,0 +1,45 @@
+package api
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+)
+
+// ErrorResponse represents the JSON body of an error response
+type ErrorResponse struct {
+	Error ErrorDetail `json:"error"`
+}
+
+// ErrorDetail describes an error that applies to the whole request
+type ErrorDetail struct {
+	Status    int    `json:"status"`
+	Message   string `json:"message"`
+	RequestID string `json:"request_id,omitempty"`
+}
+
+// writeError sends a JSON error response with the given status code
+func writeError(w http.ResponseWriter, r *http.Request, status int, message string) {
+	requestID := RequestID(r.Context())
+	log.Printf("[%s] %s %s: %d %s", requestID, r.Method, r.URL.Path, status, message)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(ErrorResponse{
+		Error: ErrorDetail{
+			Status:    status,
+			Message:   message,
+			RequestID: requestID,
+		},
+	})
+}
+
+// handleNotFound handles requests for unknown endpoints
+func handleNotFound(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusNotFound, "endpoint not found")
+}
+
+// handleMethodNotAllowed handles requests with an unsupported method
+func handleMethodNotAllowed(w http.ResponseWriter, r *http.Request) {
+	writeError(w, r, http.StatusMethodNotAllowed, "method not allowed")
+}
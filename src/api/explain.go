@@ -0,0 +1,134 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"authzen/policy"
+)
+
+// redacted replaces sensitive values in explain traces
+const redacted = "[REDACTED]"
+
+// defaultSensitiveAttributes are redacted from explain traces unless
+// configured otherwise
+var defaultSensitiveAttributes = []string{"password", "secret", "token", "ssn", "credit_card", "api_key", "private_key"}
+
+// WithSensitiveAttributes adds to the attributes whose values are redacted
+// from explain traces. An attribute is sensitive if a segment of its path,
+// or a key within its value, contains one of the names, ignoring case.
+func WithSensitiveAttributes(names ...string) ServerOption {
+	return func(s *Server) {
+		for _, name := range names {
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				s.sensitive = append(s.sensitive, name)
+			}
+		}
+	}
+}
+
+// allowExplain checks that the caller may request an explain trace, i.e.
+// that it is trusted, and writes an error response if not. Without
+// configured callers no one may.
+func (s *Server) allowExplain(w http.ResponseWriter, r *http.Request, explain bool) bool {
+	if !explain {
+		return true
+	}
+	if len(s.callers) == 0 {
+		writeError(w, r, http.StatusForbidden, "explaining decisions requires trusted callers to be configured")
+		return false
+	}
+	if bearerToken(r) == "" {
+		writeError(w, r, http.StatusUnauthorized, "bearer token required to explain decisions")
+		return false
+	}
+	if !s.caller(r).Trusted {
+		writeError(w, r, http.StatusForbidden, "caller is not allowed to explain decisions")
+		return false
+	}
+	return true
+}
+
+// redactTrace returns the trace with the values of sensitive attributes, and
+// the literals that condition expressions compare with them, replaced. The
+// request's values are copied, not modified.
+func (s *Server) redactTrace(t policy.Trace) policy.Trace {
+	policies := make([]policy.PolicyTrace, len(t.Policies))
+	for i, p := range t.Policies {
+		if len(p.Conditions) > 0 {
+			conditions := make([]policy.ConditionTrace, len(p.Conditions))
+			for j, c := range p.Conditions {
+				if s.isSensitive(c.Attribute) || s.isSensitive(c.ValueFrom) {
+					if c.Actual != nil {
+						c.Actual = redacted
+					}
+					if c.Value != nil {
+						c.Value = redacted
+					}
+				} else {
+					c.Actual, c.Value = s.redactValue(c.Actual), s.redactValue(c.Value)
+				}
+				conditions[j] = c
+			}
+			p.Conditions = conditions
+		}
+		if p.Expression != nil {
+			e := *p.Expression
+			e.Source = policy.RedactExpression(e.Source, s.isSensitive, redacted)
+			if p.Expression.Values != nil {
+				e.Values = make(map[string]interface{}, len(p.Expression.Values))
+				for path, v := range p.Expression.Values {
+					if s.isSensitive(path) {
+						e.Values[path] = redacted
+					} else {
+						e.Values[path] = s.redactValue(v)
+					}
+				}
+			}
+			p.Expression = &e
+		}
+		policies[i] = p
+	}
+	t.Policies = policies
+	return t
+}
+
+// redactValue returns a copy of a value with the members of objects whose
+// keys are sensitive replaced, at any depth
+func (s *Server) redactValue(v interface{}) interface{} {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		out := make(map[string]interface{}, len(v))
+		for key, member := range v {
+			if s.isSensitive(key) {
+				out[key] = redacted
+			} else {
+				out[key] = s.redactValue(member)
+			}
+		}
+		return out
+	case []interface{}:
+		out := make([]interface{}, len(v))
+		for i, elem := range v {
+			out[i] = s.redactValue(elem)
+		}
+		return out
+	}
+	return v
+}
+
+// isSensitive reports whether a segment of an attribute path contains the
+// name of a sensitive attribute
+func (s *Server) isSensitive(path string) bool {
+	if path == "" {
+		return false
+	}
+	for _, segment := range strings.Split(strings.ToLower(path), ".") {
+		for _, name := range s.sensitive {
+			if strings.Contains(segment, name) {
+				return true
+			}
+		}
+	}
+	return false
+}
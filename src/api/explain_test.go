@@ -0,0 +1,139 @@
+package api
+
+import (
+	"io"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+
+	"authzen/policy"
+)
+
+func TestExplainRequiresTrustedCaller(t *testing.T) {
+	callers := map[string]Caller{
+		"admin": {ID: "admin", Trusted: true},
+		"app":   {ID: "app"},
+	}
+	tests := []struct {
+		name    string
+		opts    []ServerOption
+		token   string
+		explain bool
+		status  int
+	}{
+		{name: "without explain", status: http.StatusOK},
+		{name: "no callers configured", explain: true, status: http.StatusForbidden},
+		{name: "no callers but anonymous administration", opts: []ServerOption{WithAnonymousAdmin(true)}, explain: true, status: http.StatusForbidden},
+		{name: "no token", opts: []ServerOption{WithCallers(callers)}, explain: true, status: http.StatusUnauthorized},
+		{name: "untrusted caller", opts: []ServerOption{WithCallers(callers)}, token: "app", explain: true, status: http.StatusForbidden},
+		{name: "trusted caller", opts: []ServerOption{WithCallers(callers)}, token: "admin", explain: true, status: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(policy.NewMemoryStore(), "", tt.opts...)
+			w := post(s, "/access/v1/evaluation", tt.token, "", AuthorizeRequest{
+				Subject:  Subject{Type: "user", ID: "alice"},
+				Resource: Resource{Type: "document", ID: "1"},
+				Action:   Action{Name: "read"},
+				Options:  EvaluationOptions{Explain: tt.explain},
+			})
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestExplainRedactsSensitiveAttributes(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	alice, doc := policy.Entity{Type: "user", ID: "alice"}, policy.Entity{Type: "document", ID: "1"}
+	for _, p := range []policy.Policy{
+		{
+			ID: "conditions", Subject: alice, Resource: doc, Action: "read", Allow: true,
+			Conditions: []policy.Condition{
+				{Attribute: "context.password", Operator: policy.OpEquals, Value: "hunter2"},
+				{Attribute: "subject.properties.department", Operator: policy.OpEquals, ValueFrom: "context.session_token"},
+				{Attribute: "resource.properties.salary", Operator: policy.OpLess, Value: 100000},
+				{Attribute: "context.auth", Operator: policy.OpExists},
+				{Attribute: "subject.properties.department", Operator: policy.OpEquals, Value: "eng"},
+			},
+		},
+		{
+			ID: "expression", Subject: alice, Resource: doc, Action: "read", Allow: true,
+			Condition: `context.api_key == "s3cr3t" && resource.properties.salary > 0 && subject.properties.department == "eng"`,
+		},
+	} {
+		if _, err := store.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer(store, "", WithCallers(map[string]Caller{"admin": {ID: "admin", Trusted: true}}), WithSensitiveAttributes("Salary"))
+
+	req := AuthorizeRequest{
+		Subject:  Subject{Type: "user", ID: "alice", Properties: map[string]interface{}{"department": "eng"}},
+		Resource: Resource{Type: "document", ID: "1", Properties: map[string]interface{}{"salary": 123456}},
+		Action:   Action{Name: "read"},
+		Context: Context{
+			"password":      "hunter2",
+			"session_token": "tok-123",
+			"api_key":       "s3cr3t",
+			"auth":          map[string]interface{}{"user": "alice", "token": "tok-456"},
+		},
+		Options: EvaluationOptions{Explain: true},
+	}
+	w := post(s, "/access/v1/evaluation", "admin", "", req)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	for _, secret := range []string{"hunter2", "tok-123", "tok-456", "s3cr3t", "123456", "100000"} {
+		if strings.Contains(w.Body.String(), secret) {
+			t.Errorf("response discloses %q: %s", secret, w.Body)
+		}
+	}
+
+	_, trace := store.Explain(policyRequest(req.Subject, req.Resource, req.Action, req.Context))
+	got := s.redactTrace(trace)
+	if len(got.Policies) != 2 {
+		t.Fatalf("%d traced policies, want 2", len(got.Policies))
+	}
+
+	conditions := got.Policies[0].Conditions
+	tests := []struct {
+		name          string
+		actual, value interface{}
+	}{
+		{name: "password", actual: redacted, value: redacted},
+		{name: "value_from a token", actual: redacted, value: redacted},
+		{name: "custom sensitive attribute", actual: redacted, value: redacted},
+		{name: "sensitive key within a value", actual: map[string]interface{}{"user": "alice", "token": redacted}},
+		{name: "not sensitive", actual: "eng", value: "eng"},
+	}
+	for i, tt := range tests {
+		c := conditions[i]
+		if !reflect.DeepEqual(c.Actual, tt.actual) || !reflect.DeepEqual(c.Value, tt.value) {
+			t.Errorf("%s: Actual, Value = %v, %v, want %v, %v", tt.name, c.Actual, c.Value, tt.actual, tt.value)
+		}
+	}
+
+	e := got.Policies[1].Expression
+	wantValues := map[string]interface{}{
+		"context.api_key":               redacted,
+		"resource.properties.salary":    redacted,
+		"subject.properties.department": "eng",
+	}
+	if !reflect.DeepEqual(e.Values, wantValues) {
+		t.Errorf("Values = %v, want %v", e.Values, wantValues)
+	}
+	if want := `context.api_key == "[REDACTED]" && resource.properties.salary > 0 && subject.properties.department == "eng"`; e.Source != want {
+		t.Errorf("Source = %s, want %s", e.Source, want)
+	}
+
+	if v := trace.Policies[0].Conditions[0].Actual; v != "hunter2" {
+		t.Errorf("redaction modified the trace: Actual = %v", v)
+	}
+}
@@ -0,0 +1,73 @@
+package api
+
+import (
+	"net/http"
+
+	"authzen/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// registerHierarchyHandlers registers the endpoints that manage the resource
+// hierarchy. They are restricted to trusted callers.
+func (s *Server) registerHierarchyHandlers() {
+	s.scoped.HandleFunc("/v1/resources/{type}/{id}", s.requireTrusted(s.handleGetResourceNode)).Methods("GET")
+	s.scoped.HandleFunc("/v1/resources/{type}/{id}", s.requireTrusted(s.handlePutResourceNode)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/resources/{type}/{id}", s.requireTrusted(s.handleDeleteResourceNode)).Methods("DELETE")
+	s.scoped.HandleFunc("/v1/resources/{type}/{id}/children", s.requireTrusted(s.handleListResourceChildren)).Methods("GET")
+}
+
+// resourceFromURL returns the resource named by the URL
+func resourceFromURL(r *http.Request) policy.Entity {
+	vars := mux.Vars(r)
+	return policy.Entity{Type: vars["type"], ID: vars["id"]}
+}
+
+// handleGetResourceNode returns the place of a resource in the hierarchy
+func (s *Server) handleGetResourceNode(w http.ResponseWriter, r *http.Request) {
+	n, err := s.tenantStore(r.Context()).GetResourceNode(resourceFromURL(r))
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, n)
+}
+
+// handlePutResourceNode places a resource in the hierarchy or moves it
+func (s *Server) handlePutResourceNode(w http.ResponseWriter, r *http.Request) {
+	resource := resourceFromURL(r)
+
+	var n policy.ResourceNode
+	if err := decodeStrict(r, &n); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if n.Resource != (policy.Entity{}) && n.Resource != resource {
+		writeError(w, r, http.StatusBadRequest, "resource in body does not match the URL")
+		return
+	}
+	n.Resource = resource
+
+	if err := s.tenantStore(r.Context()).PutResourceNode(n); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, n)
+}
+
+// handleDeleteResourceNode removes a resource from the hierarchy
+func (s *Server) handleDeleteResourceNode(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemoveResourceNode(resourceFromURL(r)); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListResourceChildren returns the resources registered directly below a resource
+func (s *Server) handleListResourceChildren(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListResourceChildren(resourceFromURL(r)))
+}
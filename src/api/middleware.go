@@ -0,0 +1,119 @@
+package api
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"log"
+	"net/http"
+)
+
+// requestIDHeader is the header used to identify requests, as defined in the spec's Transport section
+const requestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the length of the longest caller's request ID that is reused
+const maxRequestIDLength = 128
+
+// contextKey is the type of keys for values stored in a request context
+type contextKey int
+
+const (
+	requestIDKey contextKey = iota
+	tenantKey
+)
+
+// RequestID returns the request identifier stored in the context, if any
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey).(string)
+	return id
+}
+
+// withRequestID reuses the caller's X-Request-ID or generates a new one,
+// stores it in the request context and echoes it in the response. A caller's
+// ID is only reused if it is a valid request ID, since it ends up in logs.
+func withRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestIDHeader)
+		if !validRequestID(id) {
+			id = newRequestID()
+		}
+
+		w.Header().Set(requestIDHeader, id)
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), requestIDKey, id)))
+	})
+}
+
+// withRecovery turns a panicking handler into a 500 error response
+func withRecovery(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if err := recover(); err != nil {
+				log.Printf("[%s] panic: %v", RequestID(r.Context()), err)
+				writeError(w, r, http.StatusInternalServerError, "internal server error")
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withAuthentication rejects requests that present an unknown bearer token.
+// Requests without a token are passed through as anonymous callers.
+func (s *Server) withAuthentication(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if token := bearerToken(r); token != "" && len(s.callers) > 0 {
+			if _, ok := s.callers[token]; !ok {
+				writeError(w, r, http.StatusUnauthorized, "invalid bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// requireTrusted restricts a handler to trusted callers. Without configured
+// callers it fails closed, unless anonymous administration is enabled.
+func (s *Server) requireTrusted(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case len(s.callers) == 0:
+			if !s.anonymousAdmin {
+				writeError(w, r, http.StatusForbidden, "administration requires trusted callers to be configured")
+				return
+			}
+		case bearerToken(r) == "":
+			writeError(w, r, http.StatusUnauthorized, "bearer token required")
+			return
+		case !s.caller(r).Trusted:
+			writeError(w, r, http.StatusForbidden, "caller is not allowed to access this endpoint")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// validRequestID reports whether id is a non-empty request ID of at most
+// maxRequestIDLength letters, digits, dots, underscores and hyphens
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		switch c := id[i]; {
+		case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9', c == '.', c == '_', c == '-':
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// newRequestID generates a random UUID (version 4) to identify a request
+func newRequestID() string {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16])
+}
@@ -0,0 +1,94 @@
+package api
+
+import (
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"strings"
+	"testing"
+
+	"authzen/policy"
+)
+
+// uuidPattern matches the request IDs generated by the server
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-4[0-9a-f]{3}-[89ab][0-9a-f]{3}-[0-9a-f]{12}$`)
+
+func TestRequestID(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	s := NewServer(policy.NewMemoryStore(), "")
+	tests := []struct {
+		name   string
+		header string
+		echo   bool
+	}{
+		{name: "missing", header: ""},
+		{name: "uuid", header: "bfe9eb29-ab87-4ca3-be83-a1d5d8305716", echo: true},
+		{name: "letters, digits, dots and underscores", header: "pep_1.req-42", echo: true},
+		{name: "longest accepted", header: strings.Repeat("a", maxRequestIDLength), echo: true},
+		{name: "too long", header: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "space", header: "a b"},
+		{name: "log injection", header: "a\r\nb"},
+		{name: "quote", header: `a"b`},
+		{name: "non-ASCII", header: "é"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/nope", nil)
+			if tt.header != "" {
+				r.Header.Set(requestIDHeader, tt.header)
+			}
+			w := httptest.NewRecorder()
+			s.Router().ServeHTTP(w, r)
+
+			id := w.Header().Get(requestIDHeader)
+			if tt.echo {
+				if id != tt.header {
+					t.Errorf("%s = %q, want the caller's %q", requestIDHeader, id, tt.header)
+				}
+			} else if !uuidPattern.MatchString(id) {
+				t.Errorf("%s = %q, want a generated UUID", requestIDHeader, id)
+			}
+
+			if w.Code != http.StatusNotFound {
+				t.Fatalf("status %d, want %d", w.Code, http.StatusNotFound)
+			}
+			if got := w.Header().Get("Content-Type"); got != "application/json" {
+				t.Errorf("Content-Type = %q, want application/json", got)
+			}
+			var resp ErrorResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if want := (ErrorDetail{Status: http.StatusNotFound, Message: "endpoint not found", RequestID: id}); resp.Error != want {
+				t.Errorf("error = %+v, want %+v", resp.Error, want)
+			}
+		})
+	}
+}
+
+func TestRecoveryErrorEnvelope(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	h := withRequestID(withRecovery(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("boom")
+	})))
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set(requestIDHeader, "req-1")
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	var resp ErrorResponse
+	if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+		t.Fatal(err)
+	}
+	want := ErrorDetail{Status: http.StatusInternalServerError, Message: "internal server error", RequestID: "req-1"}
+	if w.Code != http.StatusInternalServerError || resp.Error != want {
+		t.Errorf("status %d, error = %+v, want %d, %+v", w.Code, resp.Error, want.Status, want)
+	}
+}
@@ -25,38 +25,95 @@ type Context map[string]interface{}
 
 // AuthorizeRequest represents an authorization request
 type AuthorizeRequest struct {
-	Subject  Subject  `json:"subject"`
-	Resource Resource `json:"resource"`
-	Action   Action   `json:"action"`
-	Context  Context  `json:"context,omitempty"`
+	Subject  Subject           `json:"subject"`
+	Resource Resource          `json:"resource"`
+	Action   Action            `json:"action"`
+	Context  Context           `json:"context,omitempty"`
+	Options  EvaluationOptions `json:"options,omitempty"`
+}
+
+// EvaluationOptions are the options of an evaluation
+type EvaluationOptions struct {
+	Explain bool `json:"explain,omitempty"` // Whether to return a trace of how the decision was reached; trusted callers only
 }
 
 // AuthorizeResponse represents an authorization response
 type AuthorizeResponse struct {
-	Decision string                 `json:"decision"`
+	Decision bool                   `json:"decision"`
 	Context  map[string]interface{} `json:"context,omitempty"`
 }
 
-// EvaluationItem represents an evaluation item
+// EvaluationItem represents an evaluation item.
+// Fields that are omitted take their value from the enclosing request.
 type EvaluationItem struct {
-	Resource Resource `json:"resource"`
-	Action   Action   `json:"action,omitempty"`
+	Subject  *Subject  `json:"subject,omitempty"`
+	Resource *Resource `json:"resource,omitempty"`
+	Action   *Action   `json:"action,omitempty"`
+	Context  Context   `json:"context,omitempty"`
 }
 
-// EvaluationsRequest represents multiple authorization requests
+// EvaluationsRequest represents multiple authorization requests.
+// The top-level subject, resource, action and context are default values for each evaluation.
 type EvaluationsRequest struct {
-	Subject     Subject          `json:"subject"`
-	Action      Action           `json:"action,omitempty"`
+	Subject     *Subject         `json:"subject,omitempty"`
+	Resource    *Resource        `json:"resource,omitempty"`
+	Action      *Action          `json:"action,omitempty"`
 	Context     Context          `json:"context,omitempty"`
-	Evaluations []EvaluationItem `json:"evaluations"`
+	Evaluations []EvaluationItem `json:"evaluations,omitempty"`
 	Options     struct {
 		EvaluationsSemantic string `json:"evaluations_semantic,omitempty"`
+		Explain             bool   `json:"explain,omitempty"` // Whether to return a trace of how each decision was reached; trusted callers only
 	} `json:"options,omitempty"`
 }
 
+// resolve merges each evaluation with the request's default values and returns
+// the resulting authorization requests. Values in an evaluation take precedence
+// over the defaults. Without an evaluations array, the request itself is the
+// single authorization request.
+func (req EvaluationsRequest) resolve() []AuthorizeRequest {
+	if len(req.Evaluations) == 0 {
+		return []AuthorizeRequest{req.merge(EvaluationItem{})}
+	}
+
+	requests := make([]AuthorizeRequest, len(req.Evaluations))
+	for i, eval := range req.Evaluations {
+		requests[i] = req.merge(eval)
+	}
+	return requests
+}
+
+// merge applies the request's default values to a single evaluation
+func (req EvaluationsRequest) merge(eval EvaluationItem) AuthorizeRequest {
+	var merged AuthorizeRequest
+
+	if eval.Subject != nil {
+		merged.Subject = *eval.Subject
+	} else if req.Subject != nil {
+		merged.Subject = *req.Subject
+	}
+	if eval.Resource != nil {
+		merged.Resource = *eval.Resource
+	} else if req.Resource != nil {
+		merged.Resource = *req.Resource
+	}
+	if eval.Action != nil {
+		merged.Action = *eval.Action
+	} else if req.Action != nil {
+		merged.Action = *req.Action
+	}
+	if eval.Context != nil {
+		merged.Context = eval.Context
+	} else {
+		merged.Context = req.Context
+	}
+	merged.Options.Explain = req.Options.Explain
+
+	return merged
+}
+
 // EvaluationResult represents an evaluation result
 type EvaluationResult struct {
-	Decision string                 `json:"decision"`
+	Decision bool                   `json:"decision"`
 	Context  map[string]interface{} `json:"context,omitempty"`
 }
 
@@ -65,60 +122,75 @@ type EvaluationsResponse struct {
 	Evaluations []EvaluationResult `json:"evaluations"`
 }
 
+// LegacyAuthorizeResponse represents an authorization response in the
+// pre-1.0 format, where the decision is the string "ALLOW" or "DENY"
+type LegacyAuthorizeResponse struct {
+	Decision string                 `json:"decision"`
+	Context  map[string]interface{} `json:"context,omitempty"`
+}
+
+// LegacyEvaluationsResponse represents multiple authorization responses in the pre-1.0 format
+type LegacyEvaluationsResponse struct {
+	Evaluations []LegacyAuthorizeResponse `json:"evaluations"`
+}
+
+// PageRequest selects the page of search results to return
+type PageRequest struct {
+	NextToken string `json:"next_token,omitempty"`
+}
+
+// PageResponse carries the token of the next page of search results.
+// An empty token means there are no more results; it is always present so
+// that the last page is explicit.
+type PageResponse struct {
+	NextToken string `json:"next_token"`
+}
+
 // SubjectSearchRequest represents a Subject search request
 type SubjectSearchRequest struct {
-	Subject  Subject  `json:"subject"`
-	Resource Resource `json:"resource"`
-	Action   Action   `json:"action"`
-	Context  Context  `json:"context,omitempty"`
-	Page     struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Subject  Subject     `json:"subject"`
+	Resource Resource    `json:"resource"`
+	Action   Action      `json:"action"`
+	Context  Context     `json:"context,omitempty"`
+	Page     PageRequest `json:"page,omitempty"`
+	Options  struct {
+		ExpandGroups *bool `json:"expand_groups,omitempty"` // Whether group grants yield the groups' members; defaults to true
+	} `json:"options,omitempty"`
 }
 
 // SubjectSearchResponse represents a Subject search response
 type SubjectSearchResponse struct {
-	Results []Subject `json:"results"`
-	Page    struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Results []Subject    `json:"results"`
+	Page    PageResponse `json:"page"`
 }
 
 // ResourceSearchRequest represents a Resource search request
 type ResourceSearchRequest struct {
-	Subject  Subject  `json:"subject"`
-	Resource Resource `json:"resource"`
-	Action   Action   `json:"action"`
-	Context  Context  `json:"context,omitempty"`
-	Page     struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Subject  Subject     `json:"subject"`
+	Resource Resource    `json:"resource"`
+	Action   Action      `json:"action"`
+	Context  Context     `json:"context,omitempty"`
+	Page     PageRequest `json:"page,omitempty"`
 }
 
 // ResourceSearchResponse represents a Resource search response
 type ResourceSearchResponse struct {
-	Results []Resource `json:"results"`
-	Page    struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Results []Resource   `json:"results"`
+	Page    PageResponse `json:"page"`
 }
 
 // ActionSearchRequest represents an Action search request
 type ActionSearchRequest struct {
-	Subject  Subject  `json:"subject"`
-	Resource Resource `json:"resource"`
-	Context  Context  `json:"context,omitempty"`
-	Page     struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Subject  Subject     `json:"subject"`
+	Resource Resource    `json:"resource"`
+	Context  Context     `json:"context,omitempty"`
+	Page     PageRequest `json:"page,omitempty"`
 }
 
 // ActionSearchResponse represents an Action search response
 type ActionSearchResponse struct {
-	Results []Action `json:"results"`
-	Page    struct {
-		NextToken string `json:"next_token,omitempty"`
-	} `json:"page,omitempty"`
+	Results []Action     `json:"results"`
+	Page    PageResponse `json:"page"`
 }
 
 // MetadataResponse represents a metadata response
@@ -0,0 +1,116 @@
+package api
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strings"
+
+	"authzen/policy"
+)
+
+// defaultPageSize is the maximum number of search results returned per page
+const defaultPageSize = 100
+
+// errInvalidPageToken is returned for page tokens that are malformed, were not
+// issued by this server, or belong to a different search
+var errInvalidPageToken = errors.New("invalid page.next_token")
+
+// pageToken is the signed content of a page.next_token
+type pageToken struct {
+	Search string `json:"s"` // Fingerprint of the search the token was issued for
+	Cursor string `json:"c"` // Store cursor of the next page
+}
+
+// WithPageSize sets the maximum number of search results returned per page
+func WithPageSize(size int) ServerOption {
+	return func(s *Server) {
+		if size > 0 {
+			s.pageSize = size
+		}
+	}
+}
+
+// WithPageTokenSecret sets the key used to sign page tokens. Without it, a
+// random key is generated and tokens do not survive a server restart.
+func WithPageTokenSecret(secret []byte) ServerOption {
+	return func(s *Server) {
+		if len(secret) > 0 {
+			s.pageSecret = secret
+		}
+	}
+}
+
+// newPageSecret generates a random key for signing page tokens
+func newPageSecret() []byte {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		panic("cannot generate page token secret: " + err.Error())
+	}
+	return secret
+}
+
+// page converts a request's page.next_token into a store page for the given search
+func (s *Server) page(search, token string) (policy.Page, error) {
+	page := policy.Page{Limit: s.pageSize}
+	if token == "" {
+		return page, nil
+	}
+
+	cursor, err := s.decodePageToken(search, token)
+	if err != nil {
+		return policy.Page{}, err
+	}
+	page.Cursor = cursor
+	return page, nil
+}
+
+// encodePageToken returns an opaque, signed token for the next page of a search
+func (s *Server) encodePageToken(search, cursor string) string {
+	if cursor == "" {
+		return ""
+	}
+
+	payload, _ := json.Marshal(pageToken{Search: search, Cursor: cursor})
+	return base64.RawURLEncoding.EncodeToString(payload) + "." +
+		base64.RawURLEncoding.EncodeToString(s.signPageToken(payload))
+}
+
+// decodePageToken verifies a page token and returns its cursor
+func (s *Server) decodePageToken(search, token string) (string, error) {
+	parts := strings.SplitN(token, ".", 2)
+	if len(parts) != 2 {
+		return "", errInvalidPageToken
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return "", errInvalidPageToken
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil || !hmac.Equal(signature, s.signPageToken(payload)) {
+		return "", errInvalidPageToken
+	}
+
+	var t pageToken
+	if err := json.Unmarshal(payload, &t); err != nil || t.Search != search || t.Cursor == "" {
+		return "", errInvalidPageToken
+	}
+	return t.Cursor, nil
+}
+
+// signPageToken computes the HMAC-SHA256 signature of a page token payload
+func (s *Server) signPageToken(payload []byte) []byte {
+	mac := hmac.New(sha256.New, s.pageSecret)
+	mac.Write(payload)
+	return mac.Sum(nil)
+}
+
+// searchFingerprint identifies a search so that its page tokens cannot be replayed against another one
+func searchFingerprint(kind string, parts ...string) string {
+	sum := sha256.Sum256([]byte(kind + "\x00" + strings.Join(parts, "\x00")))
+	return base64.RawURLEncoding.EncodeToString(sum[:12])
+}
@@ -0,0 +1,92 @@
+package api
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"testing"
+
+	"authzen/policy"
+)
+
+func TestPageTokens(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	for _, user := range []string{"alice", "bob", "carol"} {
+		for _, doc := range []string{"1", "2"} {
+			if _, err := store.AddPolicy(policy.Policy{Subject: policy.Entity{Type: "user", ID: user}, Resource: policy.Entity{Type: "document", ID: doc}, Action: "read", Allow: true}); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	secret := []byte("page token secret")
+	s := NewServer(store, "", WithPageSize(2), WithPageTokenSecret(secret))
+	search := func(s *Server, doc, token string) (SubjectSearchResponse, int) {
+		t.Helper()
+		w := post(s, "/access/v1/search/subject", "", "", SubjectSearchRequest{
+			Subject:  Subject{Type: "user"},
+			Resource: Resource{Type: "document", ID: doc},
+			Action:   Action{Name: "read"},
+			Page:     PageRequest{NextToken: token},
+		})
+		var resp SubjectSearchResponse
+		if w.Code == http.StatusOK {
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+		}
+		return resp, w.Code
+	}
+
+	first, status := search(s, "1", "")
+	if status != http.StatusOK || len(first.Results) != 2 || first.Page.NextToken == "" {
+		t.Fatalf("first page = %d %+v, want 2 results and a next token", status, first)
+	}
+	token := first.Page.NextToken
+
+	// A server with the same secret, such as after a restart, accepts the token
+	restarted := NewServer(store, "", WithPageSize(2), WithPageTokenSecret(secret))
+	if second, status := search(restarted, "1", token); status != http.StatusOK || len(second.Results) != 1 || second.Page.NextToken != "" {
+		t.Fatalf("second page = %d %+v, want the last result", status, second)
+	}
+
+	payload, signature, _ := strings.Cut(token, ".")
+	data, err := base64.RawURLEncoding.DecodeString(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var content pageToken
+	if err := json.Unmarshal(data, &content); err != nil {
+		t.Fatal(err)
+	}
+	content.Cursor += "x"
+	data, _ = json.Marshal(content)
+	tampered := base64.RawURLEncoding.EncodeToString(data) + "." + signature
+
+	tests := []struct {
+		name   string
+		server *Server
+		doc    string
+		token  string
+	}{
+		{name: "tampered cursor", server: s, doc: "1", token: tampered},
+		{name: "tampered signature", server: s, doc: "1", token: payload + "." + base64.RawURLEncoding.EncodeToString([]byte("signature"))},
+		{name: "foreign search", server: s, doc: "2", token: token},
+		{name: "wrong secret", server: NewServer(store, "", WithPageSize(2), WithPageTokenSecret([]byte("other secret"))), doc: "1", token: token},
+		{name: "random secret", server: NewServer(store, "", WithPageSize(2)), doc: "1", token: token},
+		{name: "no signature", server: s, doc: "1", token: payload},
+		{name: "not base64", server: s, doc: "1", token: "!.!"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, status := search(tt.server, tt.doc, tt.token); status != http.StatusBadRequest {
+				t.Errorf("status %d, want %d", status, http.StatusBadRequest)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+
+	"authzen/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// registerRBACHandlers registers the endpoints that manage roles, groups and
+// role bindings. Like the policy endpoints, they are restricted to trusted callers.
+func (s *Server) registerRBACHandlers() {
+	s.scoped.HandleFunc("/v1/roles", s.requireTrusted(s.handleListRoles)).Methods("GET")
+	s.scoped.HandleFunc("/v1/roles/{id}", s.requireTrusted(s.handleGetRole)).Methods("GET")
+	s.scoped.HandleFunc("/v1/roles/{id}", s.requireTrusted(s.handlePutRole)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/roles/{id}", s.requireTrusted(s.handleDeleteRole)).Methods("DELETE")
+
+	s.scoped.HandleFunc("/v1/groups", s.requireTrusted(s.handleListGroups)).Methods("GET")
+	s.scoped.HandleFunc("/v1/groups/{id}", s.requireTrusted(s.handleGetGroup)).Methods("GET")
+	s.scoped.HandleFunc("/v1/groups/{id}", s.requireTrusted(s.handlePutGroup)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/groups/{id}", s.requireTrusted(s.handleDeleteGroup)).Methods("DELETE")
+
+	s.scoped.HandleFunc("/v1/role-bindings", s.requireTrusted(s.handleListRoleBindings)).Methods("GET")
+	s.scoped.HandleFunc("/v1/role-bindings", s.requireTrusted(s.handleCreateRoleBinding)).Methods("POST")
+	s.scoped.HandleFunc("/v1/role-bindings/{id}", s.requireTrusted(s.handleGetRoleBinding)).Methods("GET")
+	s.scoped.HandleFunc("/v1/role-bindings/{id}", s.requireTrusted(s.handleDeleteRoleBinding)).Methods("DELETE")
+}
+
+// handleListRoles returns all roles
+func (s *Server) handleListRoles(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListRoles())
+}
+
+// handleGetRole returns a single role
+func (s *Server) handleGetRole(w http.ResponseWriter, r *http.Request) {
+	role, err := s.tenantStore(r.Context()).GetRole(mux.Vars(r)["id"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, role)
+}
+
+// handlePutRole creates or replaces a role
+func (s *Server) handlePutRole(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var role policy.Role
+	if err := decodeStrict(r, &role); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if role.ID != "" && role.ID != id {
+		writeError(w, r, http.StatusBadRequest, "role id in body does not match the URL")
+		return
+	}
+	role.ID = id
+
+	if err := s.tenantStore(r.Context()).PutRole(role); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, role)
+}
+
+// handleDeleteRole removes a role
+func (s *Server) handleDeleteRole(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemoveRole(mux.Vars(r)["id"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListGroups returns all groups
+func (s *Server) handleListGroups(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListGroups())
+}
+
+// handleGetGroup returns a single group
+func (s *Server) handleGetGroup(w http.ResponseWriter, r *http.Request) {
+	group, err := s.tenantStore(r.Context()).GetGroup(mux.Vars(r)["id"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, group)
+}
+
+// handlePutGroup creates or replaces a group
+func (s *Server) handlePutGroup(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+
+	var group policy.Group
+	if err := decodeStrict(r, &group); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if group.ID != "" && group.ID != id {
+		writeError(w, r, http.StatusBadRequest, "group id in body does not match the URL")
+		return
+	}
+	group.ID = id
+
+	if err := s.tenantStore(r.Context()).PutGroup(group); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, group)
+}
+
+// handleDeleteGroup removes a group
+func (s *Server) handleDeleteGroup(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemoveGroup(mux.Vars(r)["id"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleListRoleBindings returns all role bindings
+func (s *Server) handleListRoleBindings(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListRoleBindings())
+}
+
+// handleCreateRoleBinding creates a role binding
+func (s *Server) handleCreateRoleBinding(w http.ResponseWriter, r *http.Request) {
+	var b policy.RoleBinding
+	if err := decodeStrict(r, &b); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	created, err := s.tenantStore(r.Context()).AddRoleBinding(b)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Location", fmt.Sprintf("/v1/role-bindings/%s", created.ID))
+	writeJSON(w, http.StatusCreated, created)
+}
+
+// handleGetRoleBinding returns a single role binding
+func (s *Server) handleGetRoleBinding(w http.ResponseWriter, r *http.Request) {
+	b, err := s.tenantStore(r.Context()).GetRoleBinding(mux.Vars(r)["id"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, b)
+}
+
+// handleDeleteRoleBinding removes a role binding
+func (s *Server) handleDeleteRoleBinding(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemoveRoleBinding(mux.Vars(r)["id"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,122 @@
+package api
+
+import (
+	"net/http"
+	"strings"
+
+	"authzen/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// RelationTuplesRequest represents an atomic change of relation tuples.
+// Tuples are written in text form, e.g. "document:123#viewer@group:eng#member".
+type RelationTuplesRequest struct {
+	Writes  []policy.RelationTuple `json:"writes,omitempty"`
+	Deletes []policy.RelationTuple `json:"deletes,omitempty"`
+}
+
+// RelationTuplesResponse represents a list of relation tuples
+type RelationTuplesResponse struct {
+	Tuples []policy.RelationTuple `json:"tuples"`
+}
+
+// registerRelationHandlers registers the endpoints that manage relation
+// namespaces and tuples. They are restricted to trusted callers.
+func (s *Server) registerRelationHandlers() {
+	s.scoped.HandleFunc("/v1/namespaces", s.requireTrusted(s.handleListNamespaces)).Methods("GET")
+	s.scoped.HandleFunc("/v1/namespaces/{name}", s.requireTrusted(s.handleGetNamespace)).Methods("GET")
+	s.scoped.HandleFunc("/v1/namespaces/{name}", s.requireTrusted(s.handlePutNamespace)).Methods("PUT")
+	s.scoped.HandleFunc("/v1/namespaces/{name}", s.requireTrusted(s.handleDeleteNamespace)).Methods("DELETE")
+
+	s.scoped.HandleFunc("/v1/relation-tuples", s.requireTrusted(s.handleReadRelationTuples)).Methods("GET")
+	s.scoped.HandleFunc("/v1/relation-tuples", s.requireTrusted(s.handleWriteRelationTuples)).Methods("POST")
+}
+
+// handleListNamespaces returns all relation namespaces
+func (s *Server) handleListNamespaces(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, s.tenantStore(r.Context()).ListNamespaces())
+}
+
+// handleGetNamespace returns a single relation namespace
+func (s *Server) handleGetNamespace(w http.ResponseWriter, r *http.Request) {
+	ns, err := s.tenantStore(r.Context()).GetNamespace(mux.Vars(r)["name"])
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ns)
+}
+
+// handlePutNamespace creates or replaces a relation namespace
+func (s *Server) handlePutNamespace(w http.ResponseWriter, r *http.Request) {
+	name := mux.Vars(r)["name"]
+
+	var ns policy.Namespace
+	if err := decodeStrict(r, &ns); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if ns.Name != "" && ns.Name != name {
+		writeError(w, r, http.StatusBadRequest, "namespace name in body does not match the URL")
+		return
+	}
+	ns.Name = name
+
+	if err := s.tenantStore(r.Context()).PutNamespace(ns); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, ns)
+}
+
+// handleDeleteNamespace removes a relation namespace
+func (s *Server) handleDeleteNamespace(w http.ResponseWriter, r *http.Request) {
+	if err := s.tenantStore(r.Context()).RemoveNamespace(mux.Vars(r)["name"]); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// handleReadRelationTuples returns relation tuples, optionally filtered by
+// the object ("type" or "type:id"), relation and subject ("type" or "type:id") query parameters
+func (s *Server) handleReadRelationTuples(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	tuples := s.tenantStore(r.Context()).ReadRelationTuples(policy.TupleFilter{
+		Object:   entityFilter(q.Get("object")),
+		Relation: q.Get("relation"),
+		Subject:  entityFilter(q.Get("subject")),
+	})
+
+	writeJSON(w, http.StatusOK, RelationTuplesResponse{Tuples: tuples})
+}
+
+// handleWriteRelationTuples atomically deletes and writes relation tuples
+func (s *Server) handleWriteRelationTuples(w http.ResponseWriter, r *http.Request) {
+	var req RelationTuplesRequest
+	if err := decodeStrict(r, &req); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(req.Writes) == 0 && len(req.Deletes) == 0 {
+		writeError(w, r, http.StatusBadRequest, "at least one write or delete is required")
+		return
+	}
+
+	if err := s.tenantStore(r.Context()).WriteRelationTuples(req.Writes, req.Deletes); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// entityFilter parses an entity filter in "type" or "type:id" form
+func entityFilter(s string) policy.Entity {
+	typ, id, _ := strings.Cut(s, ":")
+	return policy.Entity{Type: typ, ID: id}
+}
@@ -1,44 +1,108 @@
 package api
 
 import (
+	"context"
 	"encoding/json"
 	"fmt"
+	"log"
 	"net/http"
 	"strings"
+	"time"
 
 	"authzen/policy"
 
 	"github.com/gorilla/mux"
 )
 
+// Decision formats that a client can request with the X-AuthZEN-Decision-Format header
+const (
+	DecisionFormatBoolean = "boolean" // AuthZEN 1.0: "decision": true|false
+	DecisionFormatLegacy  = "legacy"  // Pre-1.0: "decision": "ALLOW"|"DENY"
+)
+
+// decisionFormatHeader is the header used to negotiate the decision wire format
+const decisionFormatHeader = "X-AuthZEN-Decision-Format"
+
 // Server represents an Authorization API server
 type Server struct {
-	store    *policy.Store
-	baseURL  string
-	router   *mux.Router
-	handlers map[string]http.HandlerFunc
+	store           policy.Store // Store of the default tenant
+	tenants         *policy.TenantRegistry
+	baseURL         string
+	router          *mux.Router
+	scoped          *mux.Router // Routes served for the request's tenant: evaluation, search and administration
+	handler         http.Handler
+	handlers        map[string]http.HandlerFunc
+	callers         map[string]Caller
+	anonymousAdmin  bool // Whether the administration endpoints are open while no callers are configured
+	legacyDecisions bool
+	pageSize        int
+	pageSecret      []byte
+	loader          *policy.Loader
+	auditLog        *log.Logger // Audit log; nil for the standard logger
+	maxElevation    time.Duration
+	cache           *decisionCache // Decision cache; nil if disabled
+	batchWorkers    int            // Evaluations of a batch that run in parallel
+	sensitive       []string       // Names of attributes redacted from explain traces, in lower case
+}
+
+// ServerOption configures optional Server behavior
+type ServerOption func(*Server)
+
+// WithLegacyDecisions makes the pre-1.0 "ALLOW"/"DENY" string decisions the
+// default wire format. Clients can still select a format per request.
+func WithLegacyDecisions(enabled bool) ServerOption {
+	return func(s *Server) {
+		s.legacyDecisions = enabled
+	}
 }
 
 // NewServer creates a new API server
-func NewServer(store *policy.Store, baseURL string) *Server {
+func NewServer(store policy.Store, baseURL string, opts ...ServerOption) *Server {
 	s := &Server{
-		store:    store,
-		baseURL:  baseURL,
-		handlers: make(map[string]http.HandlerFunc),
+		store:        store,
+		baseURL:      baseURL,
+		handlers:     make(map[string]http.HandlerFunc),
+		callers:      make(map[string]Caller),
+		pageSize:     defaultPageSize,
+		batchWorkers: defaultBatchWorkers(),
+		maxElevation: defaultMaxElevation,
+		sensitive:    append([]string(nil), defaultSensitiveAttributes...),
+	}
+
+	for _, opt := range opts {
+		opt(s)
+	}
+	if s.pageSecret == nil {
+		s.pageSecret = newPageSecret()
+	}
+	if s.tenants == nil {
+		// A registry without a file keeps its tenants in memory and cannot fail
+		tenants, err := policy.NewTenantRegistry(store, policy.TenantOptions{})
+		if err != nil {
+			panic("cannot create tenant registry: " + err.Error())
+		}
+		s.tenants = tenants
 	}
 
 	// Initialize router
 	s.router = mux.NewRouter()
+	s.scoped = s.router.NewRoute().Subrouter()
+	s.scoped.Use(s.withTenant)
 
 	// Register handlers
 	s.registerHandlers()
+	s.router.NotFoundHandler = http.HandlerFunc(handleNotFound)
+	s.router.MethodNotAllowedHandler = http.HandlerFunc(handleMethodNotAllowed)
+
+	// Wrap the router so that every response, including errors, carries a request ID
+	s.handler = withRequestID(withRecovery(s.withAuthentication(s.router)))
 
 	return s
 }
 
 // Router returns the API router
 func (s *Server) Router() http.Handler {
-	return s.router
+	return s.handler
 }
 
 // registerHandlers registers API handlers
@@ -47,16 +111,16 @@ func (s *Server) registerHandlers() {
 	s.router.HandleFunc("/.well-known/authzen-configuration", s.handleMetadata).Methods("GET")
 
 	// Authorization endpoints
-	s.router.HandleFunc("/access/v1/evaluation", s.handleAuthorize).Methods("POST")
-	s.router.HandleFunc("/access/v1/evaluations", s.handleEvaluations).Methods("POST")
+	s.scoped.HandleFunc("/access/v1/evaluation", s.handleAuthorize).Methods("POST")
+	s.scoped.HandleFunc("/access/v1/evaluations", s.handleEvaluations).Methods("POST")
 
 	// Search endpoints
-	s.router.HandleFunc("/access/v1/search/subject", s.handleSearchSubject).Methods("POST")
-	s.router.HandleFunc("/access/v1/search/resource", s.handleSearchResource).Methods("POST")
-	s.router.HandleFunc("/access/v1/search/action", s.handleSearchAction).Methods("POST")
+	s.scoped.HandleFunc("/access/v1/search/subject", s.handleSearchSubject).Methods("POST")
+	s.scoped.HandleFunc("/access/v1/search/resource", s.handleSearchResource).Methods("POST")
+	s.scoped.HandleFunc("/access/v1/search/action", s.handleSearchAction).Methods("POST")
 
-	// Policy listing endpoint
-	s.router.HandleFunc("/v1/policies", s.handleListPolicies).Methods("GET")
+	// Policy administration endpoints
+	s.registerAdminHandlers()
 
 	// Health check endpoint
 	s.router.HandleFunc("/health", s.handleHealth).Methods("GET")
@@ -81,135 +145,133 @@ func (s *Server) handleMetadata(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleAuthorize(w http.ResponseWriter, r *http.Request) {
 	var req AuthorizeRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Validate request
 	if err := validateAuthorizeRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := s.requestTenant(r, req.Context)
+	if err != nil {
+		writeTenantError(w, r, err)
+		return
+	}
+	if !s.allowExplain(w, r, req.Options.Explain) {
 		return
 	}
 
 	// Evaluate policy
-	allowed := s.store.CheckPolicy(req.Subject.ID, req.Resource.ID, req.Action.Name)
-
-	// Create response
-	resp := AuthorizeResponse{}
-	if allowed {
-		resp.Decision = "ALLOW"
-	} else {
-		resp.Decision = "DENY"
-		resp.Context = map[string]interface{}{
-			"reason": "Access denied by policy",
-		}
-	}
+	resp := s.evaluate(r.Context(), req, s.caller(r))
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	s.writeAuthorizeResponse(w, r, resp)
 }
 
 // handleEvaluations handles multiple authorization requests
 func (s *Server) handleEvaluations(w http.ResponseWriter, r *http.Request) {
 	var req EvaluationsRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Validate request
 	if err := validateEvaluationsRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := s.requestTenant(r, req.Context)
+	if err != nil {
+		writeTenantError(w, r, err)
+		return
+	}
+	if !s.allowExplain(w, r, req.Options.Explain) {
 		return
 	}
 
-	// Prepare response
-	resp := EvaluationsResponse{
-		Evaluations: make([]EvaluationResult, len(req.Evaluations)),
+	caller := s.caller(r)
+	requests := req.resolve()
+
+	// All evaluations of a batch belong to the tenant of the request
+	for i, eval := range requests {
+		if id, err := contextTenant(eval.Context); err != nil || (id != "" && id != tenantID(r.Context())) {
+			writeError(w, r, http.StatusBadRequest, fmt.Sprintf("evaluations[%d]: context.tenant must match the tenant of the request", i))
+			return
+		}
+	}
+
+	// Without an evaluations array the request behaves like a single evaluation
+	if len(req.Evaluations) == 0 {
+		s.writeAuthorizeResponse(w, r, s.evaluate(r.Context(), requests[0], caller))
+		return
 	}
 
 	// Get evaluation semantics
 	semantic := req.Options.EvaluationsSemantic
 	if semantic == "" {
-		semantic = "execute_all" // Default
+		semantic = semanticExecuteAll // Default
 	}
 
-	// Process each evaluation request
-	for i, eval := range req.Evaluations {
-		// Use action from request level if specified
-		action := eval.Action.Name
-		if action == "" && req.Action.Name != "" {
-			action = req.Action.Name
-		}
-
-		// Evaluate policy
-		allowed := s.store.CheckPolicy(req.Subject.ID, eval.Resource.ID, action)
-
-		// Set result
-		if allowed {
-			resp.Evaluations[i].Decision = "ALLOW"
-		} else {
-			resp.Evaluations[i].Decision = "DENY"
-			resp.Evaluations[i].Context = map[string]interface{}{
-				"reason": "Access denied by policy",
-			}
-		}
-
-		// Process based on semantics
-		if semantic == "deny_on_first_deny" && !allowed {
-			// Stop on first deny
-			resp.Evaluations = resp.Evaluations[:i+1]
-			break
-		} else if semantic == "permit_on_first_permit" && allowed {
-			// Stop on first permit
-			resp.Evaluations = resp.Evaluations[:i+1]
-			break
-		}
+	// Evaluate the requests in parallel, keeping their order
+	results := s.evaluateBatch(r.Context(), requests, caller, semantic)
+	if results == nil {
+		// The client went away; nobody reads the response
+		return
 	}
+	resp := EvaluationsResponse{Evaluations: results}
 
 	// Send response
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(resp)
+	s.writeEvaluationsResponse(w, r, resp)
 }
 
 // handleSearchSubject handles Subject search requests
 func (s *Server) handleSearchSubject(w http.ResponseWriter, r *http.Request) {
 	var req SubjectSearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Validate request
 	if err := validateSubjectSearchRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := s.requestTenant(r, req.Context)
+	if err != nil {
+		writeTenantError(w, r, err)
 		return
 	}
 
 	// Search for subjects
-	subjects := s.store.FindSubjectsForResource(req.Resource.ID, req.Action.Name)
+	direct := req.Options.ExpandGroups != nil && !*req.Options.ExpandGroups
+	parts := []string{tenantID(r.Context()), req.Subject.Type, req.Resource.Type, req.Resource.ID, req.Action.Name}
+	if direct {
+		parts = append(parts, "direct")
+	}
+	search := searchFingerprint("subject", parts...)
+	page, err := s.page(search, req.Page.NextToken)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	subjects, next := s.tenantStore(r.Context()).FindSubjectsForResource(policyRequest(req.Subject, req.Resource, req.Action, req.Context), page,
+		policy.SubjectSearch{DirectOnly: direct})
 
 	// Create response
 	resp := SubjectSearchResponse{
 		Results: make([]Subject, 0),
-		Page: struct {
-			NextToken string `json:"next_token,omitempty"`
-		}{
-			NextToken: "",
-		},
+		Page:    PageResponse{NextToken: s.encodePageToken(search, next)},
 	}
 
 	// Add results
-	for _, subjectID := range subjects {
-		parts := strings.SplitN(subjectID, ":", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
+	for _, subject := range subjects {
 		resp.Results = append(resp.Results, Subject{
-			Type: parts[0],
-			ID:   subjectID,
+			Type: subject.Type,
+			ID:   subject.ID,
 		})
 	}
 
@@ -222,39 +284,41 @@ func (s *Server) handleSearchSubject(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleSearchResource(w http.ResponseWriter, r *http.Request) {
 	var req ResourceSearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Validate request
 	if err := validateResourceSearchRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := s.requestTenant(r, req.Context)
+	if err != nil {
+		writeTenantError(w, r, err)
 		return
 	}
 
 	// Search for resources
-	resources := s.store.FindResourcesForSubject(req.Subject.ID, req.Action.Name)
+	search := searchFingerprint("resource", tenantID(r.Context()), req.Subject.Type, req.Subject.ID, req.Resource.Type, req.Action.Name)
+	page, err := s.page(search, req.Page.NextToken)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	resources, next := s.tenantStore(r.Context()).FindResourcesForSubject(policyRequest(req.Subject, req.Resource, req.Action, req.Context), page)
 
 	// Create response
 	resp := ResourceSearchResponse{
 		Results: make([]Resource, 0),
-		Page: struct {
-			NextToken string `json:"next_token,omitempty"`
-		}{
-			NextToken: "",
-		},
+		Page:    PageResponse{NextToken: s.encodePageToken(search, next)},
 	}
 
 	// Add results
-	for _, resourceID := range resources {
-		parts := strings.SplitN(resourceID, ":", 2)
-		if len(parts) != 2 {
-			continue
-		}
-
+	for _, resource := range resources {
 		resp.Results = append(resp.Results, Resource{
-			Type: parts[0],
-			ID:   resourceID,
+			Type: resource.Type,
+			ID:   resource.ID,
 		})
 	}
 
@@ -267,27 +331,34 @@ func (s *Server) handleSearchResource(w http.ResponseWriter, r *http.Request) {
 func (s *Server) handleSearchAction(w http.ResponseWriter, r *http.Request) {
 	var req ActionSearchRequest
 	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request body", http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, "invalid request body")
 		return
 	}
 
 	// Validate request
 	if err := validateActionSearchRequest(req); err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	r, err := s.requestTenant(r, req.Context)
+	if err != nil {
+		writeTenantError(w, r, err)
 		return
 	}
 
 	// Search for actions
-	actions := s.store.FindActionsForSubjectAndResource(req.Subject.ID, req.Resource.ID)
+	search := searchFingerprint("action", tenantID(r.Context()), req.Subject.Type, req.Subject.ID, req.Resource.Type, req.Resource.ID)
+	page, err := s.page(search, req.Page.NextToken)
+	if err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	actions, next := s.tenantStore(r.Context()).FindActionsForSubjectAndResource(policyRequest(req.Subject, req.Resource, Action{}, req.Context), page)
 
 	// Create response
 	resp := ActionSearchResponse{
 		Results: make([]Action, 0),
-		Page: struct {
-			NextToken string `json:"next_token,omitempty"`
-		}{
-			NextToken: "",
-		},
+		Page:    PageResponse{NextToken: s.encodePageToken(search, next)},
 	}
 
 	// Add results
@@ -302,18 +373,167 @@ func (s *Server) handleSearchAction(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(resp)
 }
 
-// handleListPolicies returns a list of policies
-func (s *Server) handleListPolicies(w http.ResponseWriter, r *http.Request) {
-	policies := s.store.ListPolicies()
+// handleHealth handles health check requests
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+}
+
+// evaluate evaluates a single authorization request and records the
+// decision in the log
+func (s *Server) evaluate(ctx context.Context, req AuthorizeRequest, caller Caller) AuthorizeResponse {
+	resp, decision := s.decide(ctx, req, caller)
+	logDecision(ctx, req, decision, caller)
+	return resp
+}
+
+// decide evaluates a single authorization request against the policy store
+// of the request's tenant, through the decision cache if enabled, without
+// logging the decision. An explained request bypasses the cache and its
+// response context carries the redacted trace.
+func (s *Server) decide(ctx context.Context, req AuthorizeRequest, caller Caller) (AuthorizeResponse, policy.Decision) {
+	store, preq := s.tenantStore(ctx), policyRequest(req.Subject, req.Resource, req.Action, req.Context)
+	if !req.Options.Explain {
+		decision := s.cache.decide(store, preq)
+		return AuthorizeResponse{
+			Decision: decision.Allow,
+			Context:  reasonContext(decision, caller),
+		}, decision
+	}
+
+	decision, trace := store.Explain(preq)
+	reason := reasonContext(decision, caller)
+	reason["trace"] = s.redactTrace(trace)
+	return AuthorizeResponse{
+		Decision: decision.Allow,
+		Context:  reason,
+	}, decision
+}
 
+// logDecision writes a decision record for an evaluated request
+func logDecision(ctx context.Context, req AuthorizeRequest, decision policy.Decision, caller Caller) {
+	log.Printf("[%s] decision tenant=%s subject=%s:%s resource=%s:%s action=%s decision=%t reason=%s caller=%s",
+		RequestID(ctx), tenantID(ctx), req.Subject.Type, req.Subject.ID, req.Resource.Type, req.Resource.ID,
+		req.Action.Name, decision.Allow, decision.Reason().ID, caller.ID)
+}
+
+// policyRequest converts the parts of an API request, including their
+// properties and the context, to a request for the policy store
+func policyRequest(subject Subject, resource Resource, action Action, ctx Context) policy.Request {
+	return policy.Request{
+		Subject:            policy.Entity{Type: subject.Type, ID: subject.ID},
+		Resource:           policy.Entity{Type: resource.Type, ID: resource.ID},
+		Action:             action.Name,
+		SubjectProperties:  subject.Properties,
+		ResourceProperties: resource.Properties,
+		ActionProperties:   action.Properties,
+		Context:            ctx,
+	}
+}
+
+// reasonContext builds a decision context holding the spec's Reason Object
+// and the decision's obligations and advice for the PEP. The administrative
+// reason, and which policy decided under which combining algorithm, are only
+// disclosed to trusted callers.
+func reasonContext(decision policy.Decision, caller Caller) map[string]interface{} {
+	reason := decision.Reason()
+	ctx := map[string]interface{}{
+		"id": reason.ID,
+	}
+	if len(reason.User) > 0 {
+		ctx["reason_user"] = reason.User
+	}
+	if caller.Trusted && len(reason.Admin) > 0 {
+		ctx["reason_admin"] = reason.Admin
+	}
+	if caller.Trusted && decision.Algorithm != "" {
+		ctx["decided_by"] = decidedBy(decision)
+	}
+	if len(decision.Obligations) > 0 {
+		ctx["obligations"] = decision.Obligations
+	}
+	if len(decision.Advice) > 0 {
+		ctx["advice"] = decision.Advice
+	}
+	return ctx
+}
+
+// decidedBy describes the policy that decided and how it was selected
+func decidedBy(decision policy.Decision) map[string]interface{} {
+	by := map[string]interface{}{
+		"algorithm": decision.Algorithm,
+	}
+	if decision.Policy != nil {
+		by["policy_id"] = decision.Policy.ID
+	}
+	if decision.Set != "" {
+		by["policy_set"] = decision.Set
+	}
+	if decision.Conflict {
+		by["conflict"] = true
+	}
+	return by
+}
+
+// decisionFormat returns the decision wire format for a request. The
+// X-AuthZEN-Decision-Format header takes precedence over the server default.
+func (s *Server) decisionFormat(r *http.Request) string {
+	switch strings.ToLower(r.Header.Get(decisionFormatHeader)) {
+	case DecisionFormatBoolean:
+		return DecisionFormatBoolean
+	case DecisionFormatLegacy:
+		return DecisionFormatLegacy
+	}
+	if s.legacyDecisions {
+		return DecisionFormatLegacy
+	}
+	return DecisionFormatBoolean
+}
+
+// writeAuthorizeResponse sends an authorization response in the negotiated format
+func (s *Server) writeAuthorizeResponse(w http.ResponseWriter, r *http.Request, resp AuthorizeResponse) {
+	format := s.decisionFormat(r)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(policies)
+	w.Header().Set(decisionFormatHeader, format)
+
+	if format == DecisionFormatLegacy {
+		json.NewEncoder(w).Encode(LegacyAuthorizeResponse{
+			Decision: legacyDecision(resp.Decision),
+			Context:  resp.Context,
+		})
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
 }
 
-// handleHealth handles health check requests
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+// writeEvaluationsResponse sends multiple authorization responses in the negotiated format
+func (s *Server) writeEvaluationsResponse(w http.ResponseWriter, r *http.Request, resp EvaluationsResponse) {
+	format := s.decisionFormat(r)
 	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]string{"status": "ok"})
+	w.Header().Set(decisionFormatHeader, format)
+
+	if format == DecisionFormatLegacy {
+		legacy := LegacyEvaluationsResponse{
+			Evaluations: make([]LegacyAuthorizeResponse, len(resp.Evaluations)),
+		}
+		for i, eval := range resp.Evaluations {
+			legacy.Evaluations[i] = LegacyAuthorizeResponse{
+				Decision: legacyDecision(eval.Decision),
+				Context:  eval.Context,
+			}
+		}
+		json.NewEncoder(w).Encode(legacy)
+		return
+	}
+	json.NewEncoder(w).Encode(resp)
+}
+
+// legacyDecision converts a boolean decision to its pre-1.0 string form
+func legacyDecision(allowed bool) string {
+	if allowed {
+		return "ALLOW"
+	}
+	return "DENY"
 }
 
 // validateAuthorizeRequest validates an authorization request
@@ -330,27 +550,21 @@ func validateAuthorizeRequest(req AuthorizeRequest) error {
 	return nil
 }
 
-// validateEvaluationsRequest validates multiple authorization requests
+// validateEvaluationsRequest validates multiple authorization requests.
+// Each evaluation must be complete once the request's default values are applied.
 func validateEvaluationsRequest(req EvaluationsRequest) error {
-	if req.Subject.Type == "" || req.Subject.ID == "" {
-		return fmt.Errorf("subject type and id are required")
-	}
-	if len(req.Evaluations) == 0 {
-		return fmt.Errorf("at least one evaluation is required")
-	}
-	for i, eval := range req.Evaluations {
-		if eval.Resource.Type == "" || eval.Resource.ID == "" {
-			return fmt.Errorf("resource type and id are required for evaluation %d", i)
-		}
-		// Action is required for each evaluation if not specified at the request level
-		if req.Action.Name == "" && eval.Action.Name == "" {
-			return fmt.Errorf("action name is required for evaluation %d", i)
+	for i, eval := range req.resolve() {
+		if err := validateAuthorizeRequest(eval); err != nil {
+			if len(req.Evaluations) == 0 {
+				return err
+			}
+			return fmt.Errorf("%v for evaluation %d", err, i)
 		}
 	}
 	// Validate evaluation semantics
 	if req.Options.EvaluationsSemantic != "" {
 		semantic := req.Options.EvaluationsSemantic
-		if semantic != "execute_all" && semantic != "deny_on_first_deny" && semantic != "permit_on_first_permit" {
+		if semantic != semanticExecuteAll && semantic != semanticDenyOnFirstDeny && semantic != semanticPermitOnFirstPermit {
 			return fmt.Errorf("invalid evaluations_semantic: %s", semantic)
 		}
 	}
@@ -0,0 +1,351 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"authzen/policy"
+)
+
+// newServerStore returns a store that allows alice and denies bob reading document 1
+func newServerStore(t *testing.T) policy.Store {
+	t.Helper()
+	store := policy.NewMemoryStore()
+	doc := policy.Entity{Type: "document", ID: "1"}
+	for user, allow := range map[string]bool{"alice": true, "bob": false} {
+		if _, err := store.AddPolicy(policy.Policy{ID: user, Subject: policy.Entity{Type: "user", ID: user}, Resource: doc, Action: "read", Allow: allow}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return store
+}
+
+// postWithHeaders sends a JSON request to the server with the given headers
+func postWithHeaders(s *Server, path string, headers map[string]string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	for name, value := range headers {
+		r.Header.Set(name, value)
+	}
+	w := httptest.NewRecorder()
+	s.Router().ServeHTTP(w, r)
+	return w
+}
+
+func TestDecisionFormat(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	tests := []struct {
+		name   string
+		legacy bool   // Server default
+		header string // X-AuthZEN-Decision-Format
+		format string
+		allow  interface{}
+		deny   interface{}
+	}{
+		{name: "default", format: DecisionFormatBoolean, allow: true, deny: false},
+		{name: "legacy header", header: "legacy", format: DecisionFormatLegacy, allow: "ALLOW", deny: "DENY"},
+		{name: "header is case insensitive", header: "Legacy", format: DecisionFormatLegacy, allow: "ALLOW", deny: "DENY"},
+		{name: "legacy server default", legacy: true, format: DecisionFormatLegacy, allow: "ALLOW", deny: "DENY"},
+		{name: "boolean header over legacy server default", legacy: true, header: "boolean", format: DecisionFormatBoolean, allow: true, deny: false},
+		{name: "unknown header uses the server default", legacy: true, header: "xml", format: DecisionFormatLegacy, allow: "ALLOW", deny: "DENY"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewServer(newServerStore(t), "", WithLegacyDecisions(tt.legacy))
+			headers := map[string]string{}
+			if tt.header != "" {
+				headers[decisionFormatHeader] = tt.header
+			}
+			check := func(w *httptest.ResponseRecorder) []interface{} {
+				t.Helper()
+				if w.Code != http.StatusOK {
+					t.Fatalf("status %d: %s", w.Code, w.Body)
+				}
+				if got := w.Header().Get(decisionFormatHeader); got != tt.format {
+					t.Errorf("%s = %q, want %q", decisionFormatHeader, got, tt.format)
+				}
+				var body struct {
+					Decision    interface{} `json:"decision"`
+					Evaluations []struct {
+						Decision interface{} `json:"decision"`
+					} `json:"evaluations"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+				if body.Evaluations == nil {
+					return []interface{}{body.Decision}
+				}
+				decisions := make([]interface{}, len(body.Evaluations))
+				for i, eval := range body.Evaluations {
+					decisions[i] = eval.Decision
+				}
+				return decisions
+			}
+
+			for user, want := range map[string]interface{}{"alice": tt.allow, "bob": tt.deny} {
+				got := check(postWithHeaders(s, "/access/v1/evaluation", headers, AuthorizeRequest{
+					Subject:  Subject{Type: "user", ID: user},
+					Resource: Resource{Type: "document", ID: "1"},
+					Action:   Action{Name: "read"},
+				}))
+				if got[0] != want {
+					t.Errorf("%s: decision = %#v, want %#v", user, got[0], want)
+				}
+			}
+
+			got := check(postWithHeaders(s, "/access/v1/evaluations", headers, EvaluationsRequest{
+				Resource: &Resource{Type: "document", ID: "1"},
+				Action:   &Action{Name: "read"},
+				Evaluations: []EvaluationItem{
+					{Subject: &Subject{Type: "user", ID: "alice"}},
+					{Subject: &Subject{Type: "user", ID: "bob"}},
+				},
+			}))
+			if len(got) != 2 || got[0] != tt.allow || got[1] != tt.deny {
+				t.Errorf("evaluations = %#v, want [%#v %#v]", got, tt.allow, tt.deny)
+			}
+		})
+	}
+}
+
+func TestReasonContext(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	if _, err := store.AddPolicy(policy.Policy{
+		ID:       "contractors",
+		Subject:  policy.Entity{Type: "user", ID: "bob"},
+		Resource: policy.Entity{Type: "document", ID: "1"},
+		Action:   "read",
+		Reason: &policy.Reason{
+			ID:    "contractor",
+			Admin: map[string]string{"en": "bob is a contractor"},
+			User:  map[string]string{"en": "Contractors cannot read this document"},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	s := NewServer(store, "", WithCallers(map[string]Caller{
+		"admin": {ID: "admin", Trusted: true},
+		"app":   {ID: "app"},
+	}))
+
+	tests := []struct {
+		name    string
+		token   string
+		subject string
+		id      string
+		admin   bool // Whether reason_admin is disclosed
+		by      bool // Whether decided_by is disclosed
+	}{
+		{name: "trusted caller", token: "admin", subject: "bob", id: "contractor", admin: true, by: true},
+		{name: "untrusted caller", token: "app", subject: "bob", id: "contractor"},
+		{name: "anonymous caller", subject: "bob", id: "contractor"},
+		{name: "no policy", token: "admin", subject: "carol", id: "no_matching_policy", admin: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(s, "/access/v1/evaluation", tt.token, "", AuthorizeRequest{
+				Subject:  Subject{Type: "user", ID: tt.subject},
+				Resource: Resource{Type: "document", ID: "1"},
+				Action:   Action{Name: "read"},
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp AuthorizeResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Decision {
+				t.Error("decision = true, want false")
+			}
+			if resp.Context["id"] != tt.id {
+				t.Errorf("id = %v, want %q", resp.Context["id"], tt.id)
+			}
+			if _, ok := resp.Context["reason_user"]; !ok {
+				t.Error("reason_user missing")
+			}
+			if _, ok := resp.Context["reason_admin"]; ok != tt.admin {
+				t.Errorf("reason_admin present = %v, want %v", ok, tt.admin)
+			}
+			if _, ok := resp.Context["decided_by"]; ok != tt.by {
+				t.Errorf("decided_by present = %v, want %v", ok, tt.by)
+			}
+		})
+	}
+}
+
+func TestEvaluationsDefaults(t *testing.T) {
+	alice, bob := &Subject{Type: "user", ID: "alice"}, &Subject{Type: "user", ID: "bob"}
+	doc1, doc2 := &Resource{Type: "document", ID: "1"}, &Resource{Type: "document", ID: "2"}
+	read, write := &Action{Name: "read"}, &Action{Name: "write"}
+	req := EvaluationsRequest{
+		Subject:  alice,
+		Resource: doc1,
+		Action:   read,
+		Context:  Context{"ip": "10.0.0.1"},
+		Evaluations: []EvaluationItem{
+			{},
+			{Subject: bob},
+			{Resource: doc2, Action: write},
+			{Context: Context{"ip": "10.0.0.2"}},
+			{Subject: bob, Resource: doc2, Action: write, Context: Context{}},
+		},
+	}
+	req.Options.Explain = true
+
+	want := []AuthorizeRequest{
+		{Subject: *alice, Resource: *doc1, Action: *read, Context: Context{"ip": "10.0.0.1"}},
+		{Subject: *bob, Resource: *doc1, Action: *read, Context: Context{"ip": "10.0.0.1"}},
+		{Subject: *alice, Resource: *doc2, Action: *write, Context: Context{"ip": "10.0.0.1"}},
+		{Subject: *alice, Resource: *doc1, Action: *read, Context: Context{"ip": "10.0.0.2"}},
+		{Subject: *bob, Resource: *doc2, Action: *write, Context: Context{}},
+	}
+	got := req.resolve()
+	if len(got) != len(want) {
+		t.Fatalf("resolve = %d requests, want %d", len(got), len(want))
+	}
+	for i := range want {
+		want[i].Options.Explain = true
+		if !reflect.DeepEqual(got[i], want[i]) {
+			t.Errorf("evaluation %d = %+v, want %+v", i, got[i], want[i])
+		}
+	}
+
+	req.Evaluations = nil
+	if got := req.resolve(); len(got) != 1 || got[0].Subject.ID != "alice" || got[0].Resource.ID != "1" {
+		t.Errorf("resolve without evaluations = %+v, want the request itself", got)
+	}
+}
+
+func TestValidateEvaluationsRequest(t *testing.T) {
+	alice := &Subject{Type: "user", ID: "alice"}
+	doc := &Resource{Type: "document", ID: "1"}
+	read := &Action{Name: "read"}
+	tests := []struct {
+		name string
+		req  EvaluationsRequest
+		err  string
+	}{
+		{
+			name: "defaults complete every evaluation",
+			req:  EvaluationsRequest{Subject: alice, Resource: doc, Action: read, Evaluations: []EvaluationItem{{}, {Subject: &Subject{Type: "user", ID: "bob"}}}},
+		},
+		{
+			name: "evaluations complete without defaults",
+			req:  EvaluationsRequest{Evaluations: []EvaluationItem{{Subject: alice, Resource: doc, Action: read}}},
+		},
+		{
+			name: "evaluation missing a subject",
+			req:  EvaluationsRequest{Resource: doc, Action: read, Evaluations: []EvaluationItem{{Subject: alice}, {}}},
+			err:  "subject type and id are required for evaluation 1",
+		},
+		{
+			name: "incomplete override",
+			req:  EvaluationsRequest{Subject: alice, Resource: doc, Action: read, Evaluations: []EvaluationItem{{Resource: &Resource{Type: "document"}}}},
+			err:  "resource type and id are required for evaluation 0",
+		},
+		{
+			name: "single request without evaluations",
+			req:  EvaluationsRequest{Subject: alice, Resource: doc},
+			err:  "action name is required",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := validateEvaluationsRequest(tt.req)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatalf("error = %v, want none", err)
+				}
+				return
+			}
+			if err == nil || err.Error() != tt.err {
+				t.Fatalf("error = %v, want %q", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestTypedIdentities(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	store := policy.NewMemoryStore()
+	for _, p := range []policy.Policy{
+		{Subject: policy.Entity{Type: "user", ID: "alice"}, Resource: policy.Entity{Type: "document", ID: "1"}, Action: "read", Allow: true},
+		{Subject: policy.Entity{Type: "service", ID: "alice"}, Resource: policy.Entity{Type: "folder", ID: "1"}, Action: "read", Allow: true},
+		{Subject: policy.Entity{Type: "user", ID: "bob:admin"}, Resource: policy.Entity{Type: "document", ID: "1"}, Action: "read", Allow: true},
+	} {
+		if _, err := store.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+	s := NewServer(store, "")
+
+	tests := []struct {
+		subject  Subject
+		resource Resource
+		allow    bool
+	}{
+		{subject: Subject{Type: "user", ID: "alice"}, resource: Resource{Type: "document", ID: "1"}, allow: true},
+		{subject: Subject{Type: "service", ID: "alice"}, resource: Resource{Type: "document", ID: "1"}},
+		{subject: Subject{Type: "user", ID: "alice"}, resource: Resource{Type: "folder", ID: "1"}},
+		{subject: Subject{Type: "service", ID: "alice"}, resource: Resource{Type: "folder", ID: "1"}, allow: true},
+		{subject: Subject{Type: "user", ID: "bob:admin"}, resource: Resource{Type: "document", ID: "1"}, allow: true},
+		{subject: Subject{Type: "user:bob", ID: "admin"}, resource: Resource{Type: "document", ID: "1"}},
+	}
+	for _, tt := range tests {
+		name := tt.subject.Type + ":" + tt.subject.ID + " " + tt.resource.Type + ":" + tt.resource.ID
+		t.Run(name, func(t *testing.T) {
+			w := post(s, "/access/v1/evaluation", "", "", AuthorizeRequest{Subject: tt.subject, Resource: tt.resource, Action: Action{Name: "read"}})
+			if w.Code != http.StatusOK {
+				t.Fatalf("status %d: %s", w.Code, w.Body)
+			}
+			var resp AuthorizeResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Decision != tt.allow {
+				t.Errorf("decision = %v, want %v", resp.Decision, tt.allow)
+			}
+		})
+	}
+
+	w := post(s, "/access/v1/search/subject", "", "", SubjectSearchRequest{
+		Subject:  Subject{Type: "user"},
+		Resource: Resource{Type: "document", ID: "1"},
+		Action:   Action{Name: "read"},
+	})
+	var subjects SubjectSearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&subjects); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Subject{{Type: "user", ID: "alice"}, {Type: "user", ID: "bob:admin"}}; !reflect.DeepEqual(subjects.Results, want) {
+		t.Errorf("subjects = %+v, want %+v", subjects.Results, want)
+	}
+
+	w = post(s, "/access/v1/search/resource", "", "", ResourceSearchRequest{
+		Subject:  Subject{Type: "service", ID: "alice"},
+		Resource: Resource{Type: "folder"},
+		Action:   Action{Name: "read"},
+	})
+	var resources ResourceSearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&resources); err != nil {
+		t.Fatal(err)
+	}
+	if want := []Resource{{Type: "folder", ID: "1"}}; !reflect.DeepEqual(resources.Results, want) {
+		t.Errorf("resources = %+v, want %+v", resources.Results, want)
+	}
+}
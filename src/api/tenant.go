@@ -0,0 +1,263 @@
+package api
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"authzen/policy"
+
+	"github.com/gorilla/mux"
+)
+
+// tenantHeader is the header a request selects its tenant with
+const tenantHeader = "X-Tenant-ID"
+
+// Errors of tenant selection
+var (
+	errTenantConflict  = errors.New("conflicting tenants")
+	errTenantForbidden = errors.New("tenant not accessible")
+)
+
+// tenantScope is the tenant a request was resolved to
+type tenantScope struct {
+	id    string
+	store policy.Store
+}
+
+// TenantResponse represents a tenant with its current usage
+type TenantResponse struct {
+	policy.Tenant
+	Usage TenantUsage `json:"usage"`
+}
+
+// TenantUsage counts what a tenant stores, to compare with its quota
+type TenantUsage struct {
+	Policies     int `json:"policies"`
+	RoleBindings int `json:"role_bindings"`
+}
+
+// WithTenants serves the tenants of a registry. Without it, the server has
+// only the default tenant, backed by the store passed to NewServer, and
+// tenants created through /v1/tenants are kept in memory.
+func WithTenants(reg *policy.TenantRegistry) ServerOption {
+	return func(s *Server) {
+		s.tenants = reg
+	}
+}
+
+// withTenant resolves the tenant selected by the authenticated caller or the
+// X-Tenant-ID header and stores it in the request context. A caller bound to
+// a tenant cannot select another one; a trusted caller that is not bound to
+// one may select any tenant. It wraps only the routes whose data belongs to a
+// tenant, so that /health and the discovery metadata ignore the header.
+func (s *Server) withTenant(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		scope, err := s.selectTenant(r, "")
+		if err != nil {
+			writeTenantError(w, r, err)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tenantKey, scope)))
+	})
+}
+
+// requestTenant selects the tenant named by the "tenant" member of an AuthZEN
+// request's context, if any, and returns the request scoped to it
+func (s *Server) requestTenant(r *http.Request, ctx Context) (*http.Request, error) {
+	requested, err := contextTenant(ctx)
+	if err != nil || requested == "" {
+		return r, err
+	}
+	scope, err := s.selectTenant(r, requested)
+	if err != nil {
+		return r, err
+	}
+	return r.WithContext(context.WithValue(r.Context(), tenantKey, scope)), nil
+}
+
+// selectTenant resolves the tenant of a request: the caller's tenant if it is
+// bound to one, otherwise the requested tenant or the one named by the
+// X-Tenant-ID header, which must agree, otherwise the default tenant
+func (s *Server) selectTenant(r *http.Request, requested string) (tenantScope, error) {
+	id := r.Header.Get(tenantHeader)
+	if requested != "" {
+		if id != "" && id != requested {
+			return tenantScope{}, fmt.Errorf("%w: context.tenant %q does not match %s %q", errTenantConflict, requested, tenantHeader, id)
+		}
+		id = requested
+	}
+	if bound := s.caller(r).Tenant; bound != "" {
+		if id != "" && id != bound {
+			return tenantScope{}, fmt.Errorf("%w: caller may not access tenant %q", errTenantForbidden, id)
+		}
+		id = bound
+	}
+	if id == "" {
+		id = policy.DefaultTenant
+	}
+
+	store, err := s.tenants.Store(id)
+	if err != nil {
+		return tenantScope{}, err
+	}
+	return tenantScope{id: id, store: store}, nil
+}
+
+// contextTenant returns the tenant named by an AuthZEN request's context
+func contextTenant(ctx Context) (string, error) {
+	v, ok := ctx["tenant"]
+	if !ok {
+		return "", nil
+	}
+	id, ok := v.(string)
+	if !ok || id == "" {
+		return "", fmt.Errorf("%w: context.tenant must be a non-empty string", errTenantConflict)
+	}
+	return id, nil
+}
+
+// tenantStore returns the store of the request's tenant
+func (s *Server) tenantStore(ctx context.Context) policy.Store {
+	if scope, ok := ctx.Value(tenantKey).(tenantScope); ok {
+		return scope.store
+	}
+	return s.store
+}
+
+// tenantID returns the ID of the request's tenant
+func tenantID(ctx context.Context) string {
+	if scope, ok := ctx.Value(tenantKey).(tenantScope); ok {
+		return scope.id
+	}
+	return policy.DefaultTenant
+}
+
+// writeTenantError sends the error response for a failed tenant selection
+func writeTenantError(w http.ResponseWriter, r *http.Request, err error) {
+	switch {
+	case errors.Is(err, errTenantForbidden):
+		writeError(w, r, http.StatusForbidden, err.Error())
+	case errors.Is(err, policy.ErrTenantNotFound):
+		writeError(w, r, http.StatusNotFound, err.Error())
+	default:
+		writeError(w, r, http.StatusBadRequest, err.Error())
+	}
+}
+
+// registerTenantHandlers registers the tenant administration endpoints.
+// Only trusted callers that are not bound to a tenant may manage tenants;
+// a tenant's callers may read their own tenant.
+func (s *Server) registerTenantHandlers() {
+	s.router.HandleFunc("/v1/tenants", s.requireTrusted(s.requireAllTenants(s.handleListTenants))).Methods("GET")
+	s.router.HandleFunc("/v1/tenants/{id}", s.requireTrusted(s.handleGetTenant)).Methods("GET")
+	s.router.HandleFunc("/v1/tenants/{id}", s.requireTrusted(s.requireAllTenants(s.handlePutTenant))).Methods("PUT")
+	s.router.HandleFunc("/v1/tenants/{id}", s.requireTrusted(s.requireAllTenants(s.handleDeleteTenant))).Methods("DELETE")
+}
+
+// requireAllTenants restricts a handler to callers that are not bound to a tenant
+func (s *Server) requireAllTenants(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if s.caller(r).Tenant != "" {
+			writeError(w, r, http.StatusForbidden, "caller is not allowed to manage tenants")
+			return
+		}
+		next(w, r)
+	}
+}
+
+// handleListTenants returns all tenants with their usage
+func (s *Server) handleListTenants(w http.ResponseWriter, r *http.Request) {
+	tenants := s.tenants.List()
+	resp := make([]TenantResponse, 0, len(tenants))
+	for _, t := range tenants {
+		if tr, err := s.tenantResponse(t); err == nil {
+			resp = append(resp, tr)
+		}
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handleGetTenant returns a tenant with its usage
+func (s *Server) handleGetTenant(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	if bound := s.caller(r).Tenant; bound != "" && bound != id {
+		writeError(w, r, http.StatusForbidden, fmt.Sprintf("caller may not access tenant %q", id))
+		return
+	}
+	t, err := s.tenants.Get(id)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	resp, err := s.tenantResponse(t)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, resp)
+}
+
+// handlePutTenant creates a tenant or replaces its metadata, combining
+// algorithm and quota
+func (s *Server) handlePutTenant(w http.ResponseWriter, r *http.Request) {
+	var t policy.Tenant
+	if err := decodeStrict(r, &t); err != nil {
+		writeError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	id := mux.Vars(r)["id"]
+	if t.ID != "" && t.ID != id {
+		writeError(w, r, http.StatusBadRequest, "tenant id does not match the URL")
+		return
+	}
+	t.ID = id
+
+	created, err := s.tenants.Put(t)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	status, event := http.StatusOK, "tenant_updated"
+	if created {
+		status, event = http.StatusCreated, "tenant_created"
+		w.Header().Set("Location", fmt.Sprintf("/v1/tenants/%s", t.ID))
+	}
+	s.audit(r, AuditEvent{Event: event, Tenant: t.ID})
+
+	resp, err := s.tenantResponse(t)
+	if err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	writeJSON(w, status, resp)
+}
+
+// handleDeleteTenant removes a tenant and all of its data
+func (s *Server) handleDeleteTenant(w http.ResponseWriter, r *http.Request) {
+	id := mux.Vars(r)["id"]
+	store, _ := s.tenants.Store(id)
+	if err := s.tenants.Remove(id); err != nil {
+		writePolicyError(w, r, err)
+		return
+	}
+	s.cache.purgeStore(store)
+	s.audit(r, AuditEvent{Event: "tenant_removed", Tenant: id})
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// tenantResponse adds the current usage to a tenant
+func (s *Server) tenantResponse(t policy.Tenant) (TenantResponse, error) {
+	store, err := s.tenants.Store(t.ID)
+	if err != nil {
+		return TenantResponse{}, err
+	}
+	return TenantResponse{
+		Tenant: t,
+		Usage: TenantUsage{
+			Policies:     store.CountPolicies(),
+			RoleBindings: store.CountRoleBindings(),
+		},
+	}, nil
+}
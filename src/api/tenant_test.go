@@ -0,0 +1,221 @@
+package api
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"authzen/policy"
+)
+
+// newTenantServer returns a server whose default tenant allows alice and
+// whose acme tenant allows bob to read document 1, with an unbound trusted
+// caller "admin" and a caller "acme" bound to the acme tenant
+func newTenantServer(t *testing.T) *Server {
+	t.Helper()
+	store := policy.NewMemoryStore()
+	reg, err := policy.NewTenantRegistry(store, policy.TenantOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Put(policy.Tenant{ID: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	acme, err := reg.Store("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	doc := policy.Entity{Type: "document", ID: "1"}
+	if _, err := store.AddPolicy(policy.Policy{Subject: policy.Entity{Type: "user", ID: "alice"}, Resource: doc, Action: "read", Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	for _, user := range []string{"bob", "carol", "dave"} {
+		if _, err := acme.AddPolicy(policy.Policy{Subject: policy.Entity{Type: "user", ID: user}, Resource: doc, Action: "read", Allow: true}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return NewServer(store, "", WithTenants(reg), WithPageSize(2), WithCallers(map[string]Caller{
+		"admin": {ID: "admin", Trusted: true},
+		"acme":  {ID: "acme", Trusted: true, Tenant: "acme"},
+	}))
+}
+
+// post sends a JSON request to the server with an optional bearer token and tenant header
+func post(s *Server, path, token, tenant string, body interface{}) *httptest.ResponseRecorder {
+	data, _ := json.Marshal(body)
+	r := httptest.NewRequest(http.MethodPost, path, bytes.NewReader(data))
+	if token != "" {
+		r.Header.Set("Authorization", "Bearer "+token)
+	}
+	if tenant != "" {
+		r.Header.Set(tenantHeader, tenant)
+	}
+	w := httptest.NewRecorder()
+	s.Router().ServeHTTP(w, r)
+	return w
+}
+
+func TestTenantIsolation(t *testing.T) {
+	s := newTenantServer(t)
+	tests := []struct {
+		name    string
+		token   string
+		header  string
+		context Context
+		subject string
+		status  int
+		allow   bool
+	}{
+		{name: "default tenant", subject: "alice", status: http.StatusOK, allow: true},
+		{name: "other tenant's subject", subject: "bob", status: http.StatusOK},
+		{name: "header", header: "acme", subject: "bob", status: http.StatusOK, allow: true},
+		{name: "header hides the default tenant", header: "acme", subject: "alice", status: http.StatusOK},
+		{name: "context", context: Context{"tenant": "acme"}, subject: "bob", status: http.StatusOK, allow: true},
+		{name: "context and header agree", header: "acme", context: Context{"tenant": "acme"}, subject: "bob", status: http.StatusOK, allow: true},
+		{name: "context and header disagree", header: "acme", context: Context{"tenant": "default"}, subject: "bob", status: http.StatusBadRequest},
+		{name: "context tenant not a string", context: Context{"tenant": 1}, subject: "bob", status: http.StatusBadRequest},
+		{name: "unknown tenant", header: "nope", subject: "bob", status: http.StatusNotFound},
+		{name: "bound caller", token: "acme", subject: "bob", status: http.StatusOK, allow: true},
+		{name: "bound caller selecting its tenant", token: "acme", header: "acme", subject: "bob", status: http.StatusOK, allow: true},
+		{name: "bound caller selecting another tenant by header", token: "acme", header: "default", subject: "alice", status: http.StatusForbidden},
+		{name: "bound caller selecting another tenant by context", token: "acme", context: Context{"tenant": "default"}, subject: "alice", status: http.StatusForbidden},
+		{name: "unbound caller", token: "admin", header: "acme", subject: "bob", status: http.StatusOK, allow: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			w := post(s, "/access/v1/evaluation", tt.token, tt.header, AuthorizeRequest{
+				Subject:  Subject{Type: "user", ID: tt.subject},
+				Resource: Resource{Type: "document", ID: "1"},
+				Action:   Action{Name: "read"},
+				Context:  tt.context,
+			})
+			if w.Code != tt.status {
+				t.Fatalf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			var resp AuthorizeResponse
+			if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			if resp.Decision != tt.allow {
+				t.Errorf("decision = %v, want %v", resp.Decision, tt.allow)
+			}
+		})
+	}
+}
+
+func TestTenantPageTokens(t *testing.T) {
+	s := newTenantServer(t)
+	search := func(tenant, token string) *httptest.ResponseRecorder {
+		return post(s, "/access/v1/search/subject", "admin", tenant, SubjectSearchRequest{
+			Subject:  Subject{Type: "user"},
+			Resource: Resource{Type: "document", ID: "1"},
+			Action:   Action{Name: "read"},
+			Page:     PageRequest{NextToken: token},
+		})
+	}
+
+	w := search("acme", "")
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var first SubjectSearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&first); err != nil {
+		t.Fatal(err)
+	}
+	if len(first.Results) != 2 || first.Page.NextToken == "" {
+		t.Fatalf("first page = %+v, want 2 results and a next token", first)
+	}
+
+	if w := search("default", first.Page.NextToken); w.Code != http.StatusBadRequest {
+		t.Errorf("token of the acme tenant used on the default tenant: status %d, want %d", w.Code, http.StatusBadRequest)
+	}
+	w = search("acme", first.Page.NextToken)
+	if w.Code != http.StatusOK {
+		t.Fatalf("status %d: %s", w.Code, w.Body)
+	}
+	var second SubjectSearchResponse
+	if err := json.NewDecoder(w.Body).Decode(&second); err != nil {
+		t.Fatal(err)
+	}
+	if len(second.Results) != 1 || second.Results[0].ID != "dave" || second.Page.NextToken != "" {
+		t.Errorf("second page = %+v, want dave and no next token", second)
+	}
+}
+
+// TestUnboundCallerSelectsAnyTenant pins that a trusted caller that is not
+// bound to a tenant reads and manages every tenant it selects by header
+func TestUnboundCallerSelectsAnyTenant(t *testing.T) {
+	s := newTenantServer(t)
+	list := func(tenant string) []policy.Policy {
+		t.Helper()
+		r := httptest.NewRequest(http.MethodGet, "/v1/policies", nil)
+		r.Header.Set("Authorization", "Bearer admin")
+		r.Header.Set(tenantHeader, tenant)
+		w := httptest.NewRecorder()
+		s.Router().ServeHTTP(w, r)
+		if w.Code != http.StatusOK {
+			t.Fatalf("tenant %q: status %d: %s", tenant, w.Code, w.Body)
+		}
+		var policies []policy.Policy
+		if err := json.NewDecoder(w.Body).Decode(&policies); err != nil {
+			t.Fatal(err)
+		}
+		return policies
+	}
+
+	if policies := list("default"); len(policies) != 1 || policies[0].Subject.ID != "alice" {
+		t.Errorf("default tenant policies = %v, want alice's", policies)
+	}
+	if policies := list("acme"); len(policies) != 3 {
+		t.Errorf("acme tenant policies = %v, want bob's, carol's and dave's", policies)
+	}
+
+	body := policy.Policy{Subject: policy.Entity{Type: "user", ID: "erin"}, Resource: policy.Entity{Type: "document", ID: "1"}, Action: "read", Allow: true}
+	if w := post(s, "/v1/policies", "admin", "acme", body); w.Code != http.StatusCreated {
+		t.Fatalf("create in acme: status %d: %s", w.Code, w.Body)
+	}
+	if n := len(list("acme")); n != 4 {
+		t.Errorf("acme tenant holds %d policies, want 4", n)
+	}
+	if n := len(list("default")); n != 1 {
+		t.Errorf("default tenant holds %d policies, want 1", n)
+	}
+
+	if w := post(s, "/v1/policies", "acme", "default", body); w.Code != http.StatusForbidden {
+		t.Errorf("bound caller creating in another tenant: status %d, want %d", w.Code, http.StatusForbidden)
+	}
+}
+
+func TestTenantIndependentRoutes(t *testing.T) {
+	s := newTenantServer(t)
+	tests := []struct {
+		method string
+		path   string
+		status int
+	}{
+		{method: http.MethodGet, path: "/health", status: http.StatusOK},
+		{method: http.MethodGet, path: "/.well-known/authzen-configuration", status: http.StatusOK},
+		{method: http.MethodGet, path: "/v1/tenants/acme", status: http.StatusOK},
+		{method: http.MethodGet, path: "/v1/policies", status: http.StatusNotFound},
+		{method: http.MethodPost, path: "/access/v1/evaluation", status: http.StatusNotFound},
+		{method: http.MethodGet, path: "/v1/nope", status: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/health", status: http.StatusMethodNotAllowed},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+" "+tt.path, func(t *testing.T) {
+			r := httptest.NewRequest(tt.method, tt.path, nil)
+			r.Header.Set("Authorization", "Bearer admin")
+			r.Header.Set(tenantHeader, "unknown")
+			w := httptest.NewRecorder()
+			s.Router().ServeHTTP(w, r)
+			if w.Code != tt.status {
+				t.Errorf("status %d, want %d: %s", w.Code, tt.status, w.Body)
+			}
+		})
+	}
+}
@@ -6,6 +6,9 @@ set -e
 # Default API endpoint
 API_URL=${1:-"http://localhost:8080"}
 
+# Bearer token of a trusted caller, for the administration endpoints
+ADMIN_TOKEN=${ADMIN_TOKEN:-""}
+
 # Check if jq command exists
 if ! command -v jq &> /dev/null; then
     echo "jq command not found. Please install it."
@@ -28,11 +31,11 @@ curl -s -X POST "$API_URL/access/v1/evaluation" \
   -d '{
     "subject": {
       "type": "user",
-      "id": "user:alice"
+      "id": "alice"
     },
     "resource": {
       "type": "document",
-      "id": "document:123"
+      "id": "123"
     },
     "action": {
       "name": "read"
@@ -47,11 +50,11 @@ curl -s -X POST "$API_URL/access/v1/evaluation" \
   -d '{
     "subject": {
       "type": "user",
-      "id": "user:charlie"
+      "id": "charlie"
     },
     "resource": {
       "type": "document",
-      "id": "document:123"
+      "id": "123"
     },
     "action": {
       "name": "read"
@@ -66,13 +69,13 @@ curl -s -X POST "$API_URL/access/v1/evaluations" \
   -d '{
     "subject": {
       "type": "user",
-      "id": "user:alice"
+      "id": "alice"
     },
     "evaluations": [
       {
         "resource": {
           "type": "document",
-          "id": "document:123"
+          "id": "123"
         },
         "action": {
           "name": "read"
@@ -81,7 +84,7 @@ curl -s -X POST "$API_URL/access/v1/evaluations" \
       {
         "resource": {
           "type": "document",
-          "id": "document:123"
+          "id": "123"
         },
         "action": {
           "name": "write"
@@ -101,7 +104,7 @@ curl -s -X POST "$API_URL/access/v1/search/subject" \
     },
     "resource": {
       "type": "document",
-      "id": "document:123"
+      "id": "123"
     },
     "action": {
       "name": "read"
@@ -116,7 +119,7 @@ curl -s -X POST "$API_URL/access/v1/search/resource" \
   -d '{
     "subject": {
       "type": "user",
-      "id": "user:alice"
+      "id": "alice"
     },
     "resource": {
       "type": "document"
@@ -134,18 +137,18 @@ curl -s -X POST "$API_URL/access/v1/search/action" \
   -d '{
     "subject": {
       "type": "user",
-      "id": "user:alice"
+      "id": "alice"
     },
     "resource": {
       "type": "document",
-      "id": "document:123"
+      "id": "123"
     }
   }' | jq
 echo
 
 # List policies
 echo "=== List Policies ==="
-curl -s -X GET "$API_URL/v1/policies" | jq
+curl -s -X GET "$API_URL/v1/policies" -H "Authorization: Bearer $ADMIN_TOKEN" | jq
 echo
 
 echo "All API requests completed."
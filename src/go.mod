@@ -3,3 +3,5 @@ module authzen
 go 1.18
 
 require github.com/gorilla/mux v1.8.1
+
+require gopkg.in/yaml.v3 v3.0.1
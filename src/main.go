@@ -8,7 +8,12 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strings"
 	"syscall"
+	"time"
+	_ "time/tzdata" // Time zones for policy conditions; the container image has no zoneinfo
 
 	"authzen/api"
 	"authzen/policy"
@@ -22,21 +27,85 @@ func main() {
 		tlsFlag = flag.Bool("tls", false, "Enable TLS")
 		cert    = flag.String("cert", "server.crt", "TLS certificate file")
 		key     = flag.String("key", "server.key", "TLS key file")
+		legacy  = flag.Bool("legacy-decisions", false, "Return \"ALLOW\"/\"DENY\" string decisions by default instead of AuthZEN 1.0 booleans")
+		tokens  = flag.String("trusted-tokens", "", "Comma-separated bearer tokens of trusted callers that may see administrative reasons and use the administration endpoints; token@tenant binds a caller to a tenant")
+		anonAdm = flag.Bool("allow-anonymous-admin", false, "Open the administration endpoints to anyone while no trusted tokens are configured; for local development only")
+		pages   = flag.Int("page-size", 100, "Maximum number of search results per page")
+		backend = flag.String("store", "memory", "Policy store backend: memory or file")
+		dataDir = flag.String("data-dir", "data", "Directory of the file policy store")
+		snapInt = flag.Duration("snapshot-interval", time.Minute, "How often the file policy store compacts its log into a snapshot")
+		polFile = flag.String("policy-file", "", "YAML or JSON file of policies to load and watch for changes")
+		polPoll = flag.Duration("policy-reload-interval", 2*time.Second, "How often to check the policy file for changes")
+		audit   = flag.String("audit-log", "", "File to append the audit log to; the standard log if empty")
+		expiry  = flag.Duration("expiry-interval", time.Minute, "How often to remove policies whose validity has ended")
+		elevate = flag.Duration("max-elevation", 8*time.Hour, "Longest temporary access that can be requested through /v1/elevations")
+		cacheN  = flag.Int("decision-cache-size", 10000, "Maximum number of cached decisions; 0 disables the decision cache")
+		cacheT  = flag.Duration("decision-cache-ttl", time.Minute, "Longest time a decision is served from the cache")
+		workers = flag.Int("batch-workers", runtime.GOMAXPROCS(0), "Number of evaluations of a batch request that run in parallel")
+		secrets = flag.String("sensitive-attributes", "", "Comma-separated names of attributes to redact from explain traces, in addition to passwords, secrets, tokens and keys")
+		combine = flag.String("combining-algorithm", string(policy.DefaultCombiningAlgorithm), "How conflicting policies are combined: deny-overrides, permit-overrides, first-applicable, priority-ordered or only-one-applicable")
 	)
 	flag.Parse()
 
 	// Initialize policy store
-	store := policy.NewStore()
+	store, err := openStore(*backend, *dataDir, *snapInt)
+	if err != nil {
+		log.Fatalf("Failed to open policy store: %v", err)
+	}
+	defer store.Close()
+	if err := store.SetCombiningAlgorithm(policy.CombiningAlgorithm(*combine)); err != nil {
+		log.Fatalf("Invalid combining algorithm: %v", err)
+	}
+
+	// Load policies from the policy file, or add sample policies to an empty store
+	var loader *policy.Loader
+	if *polFile != "" {
+		loader = policy.NewLoader(*polFile, store)
+		if err := loader.Load(); err != nil {
+			log.Fatalf("Failed to load policy file: %v", err)
+		}
+		loader.Watch(*polPoll)
+		defer loader.Close()
+	} else if len(store.ListPolicies(policy.PolicyFilter{})) == 0 {
+		addSamplePolicies(store)
+	}
+
+	// Open the stores of the other tenants
+	tenants, err := openTenants(store, *backend, *dataDir, *snapInt)
+	if err != nil {
+		log.Fatalf("Failed to open tenants: %v", err)
+	}
+	defer tenants.Close()
 
-	// Add sample policies
-	store.AddPolicy("user:alice", "document:123", "read", true)
-	store.AddPolicy("user:alice", "document:123", "write", true)
-	store.AddPolicy("user:bob", "document:123", "read", true)
-	store.AddPolicy("user:bob", "document:123", "write", false)
-	store.AddPolicy("user:charlie", "document:123", "read", false)
+	// Remove expired policies of all tenants in the background
+	reaper := policy.NewTenantReaper(tenants)
+	reaper.Reap()
+	reaper.Watch(*expiry)
+	defer reaper.Close()
 
 	// Initialize API server
-	server := api.NewServer(store, *baseURL)
+	opts := []api.ServerOption{
+		api.WithLegacyDecisions(*legacy),
+		api.WithCallers(trustedCallers(*tokens)),
+		api.WithAnonymousAdmin(*anonAdm),
+		api.WithPageSize(*pages),
+		api.WithPageTokenSecret([]byte(os.Getenv("AUTHZEN_PAGE_TOKEN_SECRET"))),
+		api.WithPolicyLoader(loader),
+		api.WithMaxElevation(*elevate),
+		api.WithTenants(tenants),
+		api.WithDecisionCache(*cacheN, *cacheT),
+		api.WithBatchWorkers(*workers),
+		api.WithSensitiveAttributes(strings.Split(*secrets, ",")...),
+	}
+	if *audit != "" {
+		f, err := os.OpenFile(*audit, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0o600)
+		if err != nil {
+			log.Fatalf("Failed to open audit log: %v", err)
+		}
+		defer f.Close()
+		opts = append(opts, api.WithAuditLog(f))
+	}
+	server := api.NewServer(store, *baseURL, opts...)
 
 	// Set up signal handling
 	sigCh := make(chan os.Signal, 1)
@@ -51,6 +120,91 @@ func main() {
 	log.Println("Shutting down server...")
 }
 
+// openStore opens the policy store backend selected on the command line
+func openStore(backend, dataDir string, snapshotInterval time.Duration) (policy.Store, error) {
+	switch backend {
+	case "memory":
+		return policy.NewMemoryStore(), nil
+	case "file":
+		log.Printf("Using file policy store in %s", dataDir)
+		return policy.OpenFileStore(dataDir, policy.FileStoreOptions{
+			SnapshotInterval: snapshotInterval,
+			SnapshotEvery:    1000,
+		})
+	default:
+		return nil, fmt.Errorf("unknown store backend %q", backend)
+	}
+}
+
+// openTenants creates the tenant registry. With the file backend, tenants
+// are kept in the data directory and each has its own store below it.
+func openTenants(store policy.Store, backend, dataDir string, snapshotInterval time.Duration) (*policy.TenantRegistry, error) {
+	if backend != "file" {
+		return policy.NewTenantRegistry(store, policy.TenantOptions{})
+	}
+	tenantDir := func(id string) string { return filepath.Join(dataDir, "tenants", id) }
+	return policy.NewTenantRegistry(store, policy.TenantOptions{
+		Path: filepath.Join(dataDir, "tenants.json"),
+		Open: func(id string) (policy.Store, error) {
+			return policy.OpenFileStore(tenantDir(id), policy.FileStoreOptions{
+				SnapshotInterval: snapshotInterval,
+				SnapshotEvery:    1000,
+			})
+		},
+		Drop: func(id string) error { return os.RemoveAll(tenantDir(id)) },
+	})
+}
+
+// addSamplePolicies adds the sample policies used by client-example.sh
+func addSamplePolicies(store policy.Store) {
+	alice := policy.Entity{Type: "user", ID: "alice"}
+	bob := policy.Entity{Type: "user", ID: "bob"}
+	charlie := policy.Entity{Type: "user", ID: "charlie"}
+	doc123 := policy.Entity{Type: "document", ID: "123"}
+
+	samples := []policy.Policy{
+		{ID: "alice-read-doc123", Subject: alice, Resource: doc123, Action: "read", Allow: true},
+		{ID: "alice-write-doc123", Subject: alice, Resource: doc123, Action: "write", Allow: true},
+		{ID: "bob-read-doc123", Subject: bob, Resource: doc123, Action: "read", Allow: true},
+		{
+			ID:       "bob-write-doc123",
+			Subject:  bob,
+			Resource: doc123,
+			Action:   "write",
+			Allow:    false,
+			Reason: &policy.Reason{
+				ID:    "read_only_reviewer",
+				Admin: map[string]string{"en": "bob is a read-only reviewer of document 123"},
+				User:  map[string]string{"en": "You have read-only access to this document"},
+			},
+		},
+		{ID: "charlie-read-doc123", Subject: charlie, Resource: doc123, Action: "read", Allow: false},
+	}
+	for _, p := range samples {
+		if _, err := store.AddPolicy(p); err != nil {
+			log.Fatalf("Failed to add sample policy: %v", err)
+		}
+	}
+}
+
+// trustedCallers builds the trusted caller registry from a comma-separated
+// token list. A token written as token@tenant binds its caller to the tenant.
+func trustedCallers(tokens string) map[string]api.Caller {
+	callers := make(map[string]api.Caller)
+	for i, token := range strings.Split(tokens, ",") {
+		token = strings.TrimSpace(token)
+		if token == "" {
+			continue
+		}
+		caller := api.Caller{ID: fmt.Sprintf("trusted-%d", i+1), Trusted: true}
+		if at := strings.LastIndex(token, "@"); at > 0 {
+			token, caller.Tenant = token[:at], token[at+1:]
+		}
+		callers[token] = caller
+	}
+	return callers
+}
+
 // startServer starts the server
 func startServer(server *api.Server, port int, tlsEnabled bool, certFile, keyFile string) {
 	addr := fmt.Sprintf(":%d", port)
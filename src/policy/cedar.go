@@ -0,0 +1,241 @@
+package policy
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// CedarPolicySet is the policy set of policies written in Cedar. Its
+// policies are always combined with deny-overrides, so a forbid policy
+// overrides any permit policy, as in Cedar.
+const CedarPolicySet = "cedar"
+
+// CedarActionType is the entity type of the action in Cedar policies,
+// e.g. Action::"read" for the request's action "read"
+const CedarActionType = "Action"
+
+// cedarPolicy is a parsed Cedar policy
+type cedarPolicy struct {
+	text        string // Source of the policy, from its annotations to the semicolon
+	pos         int    // Byte offset of the policy in the parsed source
+	annotations map[string]string
+	permit      bool
+	principal   cedarScope
+	action      cedarActionScope
+	resource    cedarScope
+	conditions  []cedarCondition
+	err         error // Parse error of a stored policy that validation did not check
+}
+
+// cedarScope constrains the principal or resource of a policy
+type cedarScope struct {
+	op     string  // "==", "in", "is" or empty for any entity
+	typ    string  // Entity type of "is"
+	entity *Entity // Entity of "==" and "in", and of "is ... in"
+}
+
+// matches reports whether the scope admits the entity
+func (s cedarScope) matches(env *cedarEnv, e Entity) bool {
+	switch s.op {
+	case "==":
+		return e == *s.entity
+	case "in":
+		return env.in(e, *s.entity)
+	case "is":
+		return e.Type == s.typ && (s.entity == nil || env.in(e, *s.entity))
+	}
+	return true
+}
+
+// pattern returns the policy subject or resource that the scope admits,
+// or that admits all entities the scope admits. Entities "in" another are
+// found through group membership and the resource hierarchy.
+func (s cedarScope) pattern() Entity {
+	switch {
+	case s.entity != nil:
+		return *s.entity
+	case s.op == "is":
+		return Entity{Type: s.typ, ID: Wildcard}
+	}
+	return Entity{Type: Wildcard, ID: Wildcard}
+}
+
+// cedarActionScope constrains the action of a policy
+type cedarActionScope struct {
+	op       string // "==", "in" or empty for any action
+	entities []Entity
+}
+
+// matches reports whether the scope admits the request's action. Action
+// groups are not supported, so an action is only in itself.
+func (s cedarActionScope) matches(action string) bool {
+	if s.op == "" {
+		return true
+	}
+	for _, e := range s.entities {
+		if isCedarAction(e.Type) && e.ID == action {
+			return true
+		}
+	}
+	return false
+}
+
+// pattern returns the policy action that admits the actions the scope admits
+func (s cedarActionScope) pattern() string {
+	if len(s.entities) == 1 {
+		return s.entities[0].ID
+	}
+	return Wildcard
+}
+
+// isCedarAction reports whether an entity type is that of actions,
+// Action or a namespaced one such as PhotoApp::Action
+func isCedarAction(typ string) bool {
+	return typ == CedarActionType || strings.HasSuffix(typ, "::"+CedarActionType)
+}
+
+// cedarCondition is a when or unless clause of a policy
+type cedarCondition struct {
+	when bool
+	expr *cedarNode
+}
+
+// id returns the policy's @id annotation, or an ID derived from its text
+func (c *cedarPolicy) id() string {
+	if id, ok := c.annotations["id"]; ok {
+		return id
+	}
+	sum := sha256.Sum256([]byte(c.text))
+	return "cedar-" + hex.EncodeToString(sum[:6])
+}
+
+// policy returns the store policy of a Cedar policy. Its subject, resource
+// and action admit at least the requests the Cedar policy's scope does,
+// so the store finds it through its indexes.
+func (c *cedarPolicy) policy() Policy {
+	return Policy{
+		ID:       c.id(),
+		Subject:  c.principal.pattern(),
+		Resource: c.resource.pattern(),
+		Action:   c.action.pattern(),
+		Allow:    c.permit,
+		Set:      CedarPolicySet,
+		Cedar:    c.text,
+	}
+}
+
+// applies reports whether the request satisfies the policy's scope and
+// conditions. As in Cedar, a policy whose conditions cannot be evaluated
+// does not apply.
+func (c *cedarPolicy) applies(st *state, req Request) bool {
+	if c.err != nil {
+		return false
+	}
+	env := &cedarEnv{st: st, req: req}
+	if !c.principal.matches(env, req.Subject) || !c.action.matches(req.Action) || !c.resource.matches(env, req.Resource) {
+		return false
+	}
+	for _, cond := range c.conditions {
+		v, err := env.eval(cond.expr)
+		if err != nil {
+			return false
+		}
+		b, ok := v.(bool)
+		if !ok || b != cond.when {
+			return false
+		}
+	}
+	return true
+}
+
+// ParseCedar parses a Cedar policy set into policies of the CedarPolicySet.
+// A policy's ID is its @id annotation, or else derived from its text.
+//
+// The request maps to Cedar's variables: the subject is the principal, the
+// resource the resource and the action the entity Action::"<action>"; their
+// properties are the entities' attributes and the context is the context.
+// Entity types are used as they are, e.g. User::"alice" is the subject
+// {type: "User", id: "alice"}, and "in" follows group membership and the
+// resource hierarchy. Templates and action groups are not supported.
+func ParseCedar(src string) ([]Policy, error) {
+	parsed, err := parseCedar(src)
+	if err != nil {
+		return nil, fmt.Errorf("%w: cedar: %v", ErrInvalidPolicy, err)
+	}
+
+	policies := make([]Policy, 0, len(parsed))
+	seen := make(map[string]bool)
+	for _, c := range parsed {
+		p := c.policy()
+		if seen[p.ID] {
+			return nil, fmt.Errorf("%w: cedar: %v", ErrInvalidPolicy, positionOf(src, c.pos).errorf("duplicate policy id %q", p.ID))
+		}
+		seen[p.ID] = true
+		policies = append(policies, p)
+	}
+	return policies, nil
+}
+
+// cedarCache shares parsed Cedar policies between validating a policy and
+// storing it, like expressionCache
+var cedarCache struct {
+	sync.Map       // Source → *cedarPolicy
+	size     int64 // Number of cached policies, updated atomically
+}
+
+// parseCedarPolicy parses the text of a single Cedar policy, or returns the
+// same policy parsed earlier
+func parseCedarPolicy(src string) (*cedarPolicy, error) {
+	if v, ok := cedarCache.Load(src); ok {
+		return v.(*cedarPolicy), nil
+	}
+
+	parsed, err := parseCedar(src)
+	if err != nil {
+		return nil, err
+	}
+	if len(parsed) != 1 {
+		return nil, fmt.Errorf("expected one policy, found %d", len(parsed))
+	}
+	if atomic.AddInt64(&cedarCache.size, 1) <= expressionCacheLimit {
+		cedarCache.Store(src, parsed[0])
+	} else {
+		atomic.AddInt64(&cedarCache.size, -1)
+	}
+	return parsed[0], nil
+}
+
+// compileCedar returns the parsed Cedar policy of a policy, or nil if it is
+// not written in Cedar. A policy that does not parse, which policy
+// validation rules out, never applies.
+func compileCedar(src string) *cedarPolicy {
+	if src == "" {
+		return nil
+	}
+	c, err := parseCedarPolicy(src)
+	if err != nil {
+		return &cedarPolicy{text: src, err: err}
+	}
+	return c
+}
+
+// validateCedar checks that a policy written in Cedar is a single policy
+// whose subject, resource, action and effect are those derived from it
+func (p Policy) validateCedar() error {
+	c, err := parseCedarPolicy(p.Cedar)
+	if err != nil {
+		return fmt.Errorf("%w: cedar: %v", ErrInvalidPolicy, err)
+	}
+	if len(p.Conditions) > 0 || p.Condition != "" {
+		return fmt.Errorf("%w: a cedar policy cannot have conditions; use when or unless", ErrInvalidPolicy)
+	}
+	want := c.policy()
+	if p.Subject != want.Subject || p.Resource != want.Resource || p.Action != want.Action || p.Allow != want.Allow || p.Set != want.Set {
+		return fmt.Errorf("%w: subject, resource, action, allow and set of a cedar policy must be those of its scope and effect", ErrInvalidPolicy)
+	}
+	return nil
+}
@@ -0,0 +1,661 @@
+package policy
+
+import (
+	"fmt"
+	"math"
+	"net/netip"
+	"strconv"
+	"strings"
+)
+
+// Cedar values are bool, int64 (Long), string, Entity, cedarSet,
+// cedarRecord, cedarIP and cedarDecimal
+type (
+	cedarSet     []interface{}
+	cedarRecord  map[string]interface{}
+	cedarIP      netip.Prefix // A single address has all bits of the prefix
+	cedarDecimal int64        // Scaled by 10^4
+)
+
+// cedarEnv holds the state of one evaluation of a Cedar policy
+type cedarEnv struct {
+	st   *state // Store for group membership and the resource hierarchy; nil for none
+	req  Request
+	cost int
+}
+
+// charge adds to the cost of the evaluation and fails once it exceeds the limit
+func (env *cedarEnv) charge(cost int) error {
+	if env.cost += cost; env.cost > ExpressionCostLimit {
+		return fmt.Errorf("evaluation exceeds the cost limit of %d", ExpressionCostLimit)
+	}
+	return nil
+}
+
+// in reports whether an entity is the other entity or one of its
+// descendants: a member of the group, directly or through nested groups,
+// or a resource below it in the hierarchy. Without a store, an entity is
+// only in itself.
+func (env *cedarEnv) in(e, ancestor Entity) bool {
+	if cedarEntityEqual(e, ancestor) {
+		return true
+	}
+	if env.st == nil {
+		return false
+	}
+	for _, g := range env.st.rbac.principals(e)[1:] {
+		if g == ancestor {
+			return true
+		}
+	}
+	var props map[string]interface{}
+	if e == env.req.Resource {
+		props = env.req.ResourceProperties
+	}
+	for _, a := range env.st.lineage(Request{Resource: e, ResourceProperties: props})[1:] {
+		if a == ancestor {
+			return true
+		}
+	}
+	return false
+}
+
+// attributes returns the attributes of an entity, the properties of the
+// request's subject, resource or action. Other entities have none.
+func (env *cedarEnv) attributes(e Entity) (map[string]interface{}, bool) {
+	req := env.req
+	switch {
+	case e == req.Subject:
+		return req.SubjectProperties, true
+	case e == req.Resource:
+		return req.ResourceProperties, true
+	case isCedarAction(e.Type) && e.ID == req.Action:
+		return req.ActionProperties, true
+	}
+	return nil, false
+}
+
+// eval evaluates an expression
+func (env *cedarEnv) eval(n *cedarNode) (interface{}, error) {
+	if err := env.charge(1); err != nil {
+		return nil, err
+	}
+
+	switch n.kind {
+	case nodeLiteral:
+		return n.value, nil
+	case nodeIdent:
+		switch n.name {
+		case "principal":
+			return env.req.Subject, nil
+		case "resource":
+			return env.req.Resource, nil
+		case "action":
+			return Entity{Type: CedarActionType, ID: env.req.Action}, nil
+		}
+		return cedarValue(nonNil(env.req.Context))
+	case nodeSelect:
+		return env.attribute(n)
+	case nodeUnary:
+		return env.unary(n)
+	case nodeBinary:
+		return env.binary(n)
+	case nodeConditional:
+		cond, err := env.evalBool(n.args[0])
+		if err != nil {
+			return nil, err
+		}
+		if cond {
+			return env.eval(n.args[1])
+		}
+		return env.eval(n.args[2])
+	case nodeList:
+		set := make(cedarSet, 0, len(n.args))
+		for _, arg := range n.args {
+			v, err := env.eval(arg)
+			if err != nil {
+				return nil, err
+			}
+			set = append(set, v)
+		}
+		return set, nil
+	case nodeMap:
+		record := make(cedarRecord, len(n.args)/2)
+		for i := 0; i < len(n.args); i += 2 {
+			v, err := env.eval(n.args[i+1])
+			if err != nil {
+				return nil, err
+			}
+			record[n.args[i].value.(string)] = v
+		}
+		return record, nil
+	case nodeCall:
+		return env.call(n)
+	case cedarNodeHas:
+		v, err := env.eval(n.args[0])
+		if err != nil {
+			return nil, err
+		}
+		switch x := v.(type) {
+		case cedarRecord:
+			_, ok := x[n.name]
+			return ok, nil
+		case Entity:
+			attrs, _ := env.attributes(x)
+			_, ok := attrs[n.name]
+			return ok, nil
+		}
+		return nil, fmt.Errorf("has: expected entity or record, found %s", cedarTypeName(v))
+	case cedarNodeLike:
+		s, err := env.evalString(n.args[0], "like")
+		if err != nil {
+			return nil, err
+		}
+		if err := env.charge(len(s)); err != nil {
+			return nil, err
+		}
+		return likeMatch(n.value.([]string), s), nil
+	case cedarNodeIs:
+		e, err := env.evalEntity(n.args[0], "is")
+		if err != nil {
+			return nil, err
+		}
+		if e.Type != n.name {
+			return false, nil
+		}
+		if len(n.args) == 1 {
+			return true, nil
+		}
+		return env.evalIn(e, n.args[1])
+	}
+	return nil, fmt.Errorf("unsupported expression")
+}
+
+func (env *cedarEnv) evalBool(n *cedarNode) (bool, error) {
+	v, err := env.eval(n)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, fmt.Errorf("expected bool, found %s", cedarTypeName(v))
+	}
+	return b, nil
+}
+
+func (env *cedarEnv) evalLong(n *cedarNode, op string) (int64, error) {
+	v, err := env.eval(n)
+	if err != nil {
+		return 0, err
+	}
+	i, ok := v.(int64)
+	if !ok {
+		return 0, fmt.Errorf("%s: expected long, found %s", op, cedarTypeName(v))
+	}
+	return i, nil
+}
+
+func (env *cedarEnv) evalString(n *cedarNode, op string) (string, error) {
+	v, err := env.eval(n)
+	if err != nil {
+		return "", err
+	}
+	s, ok := v.(string)
+	if !ok {
+		return "", fmt.Errorf("%s: expected string, found %s", op, cedarTypeName(v))
+	}
+	return s, nil
+}
+
+func (env *cedarEnv) evalEntity(n *cedarNode, op string) (Entity, error) {
+	v, err := env.eval(n)
+	if err != nil {
+		return Entity{}, err
+	}
+	e, ok := v.(Entity)
+	if !ok {
+		return Entity{}, fmt.Errorf("%s: expected entity, found %s", op, cedarTypeName(v))
+	}
+	return e, nil
+}
+
+// attribute evaluates the attribute of a record or entity
+func (env *cedarEnv) attribute(n *cedarNode) (interface{}, error) {
+	v, err := env.eval(n.args[0])
+	if err != nil {
+		return nil, err
+	}
+	switch x := v.(type) {
+	case cedarRecord:
+		if a, ok := x[n.name]; ok {
+			return a, nil
+		}
+		return nil, fmt.Errorf("record does not have attribute %q", n.name)
+	case Entity:
+		attrs, ok := env.attributes(x)
+		if !ok {
+			return nil, fmt.Errorf("entity %s::%q has no attributes", x.Type, x.ID)
+		}
+		a, ok := attrs[n.name]
+		if !ok {
+			return nil, fmt.Errorf("entity %s::%q does not have attribute %q", x.Type, x.ID, n.name)
+		}
+		return cedarValue(a)
+	}
+	return nil, fmt.Errorf("expected entity or record, found %s", cedarTypeName(v))
+}
+
+func (env *cedarEnv) unary(n *cedarNode) (interface{}, error) {
+	if n.name == "!" {
+		b, err := env.evalBool(n.args[0])
+		return !b, err
+	}
+	i, err := env.evalLong(n.args[0], "-")
+	if err != nil {
+		return nil, err
+	}
+	if i == math.MinInt64 {
+		return nil, fmt.Errorf("integer overflow")
+	}
+	return -i, nil
+}
+
+func (env *cedarEnv) binary(n *cedarNode) (interface{}, error) {
+	switch n.name {
+	case "&&", "||":
+		left, err := env.evalBool(n.args[0])
+		if err != nil || left == (n.name == "||") {
+			return left, err
+		}
+		return env.evalBool(n.args[1])
+	case "==", "!=":
+		left, err := env.eval(n.args[0])
+		if err != nil {
+			return nil, err
+		}
+		right, err := env.eval(n.args[1])
+		if err != nil {
+			return nil, err
+		}
+		return cedarEqual(left, right) == (n.name == "=="), nil
+	case "in":
+		e, err := env.evalEntity(n.args[0], "in")
+		if err != nil {
+			return nil, err
+		}
+		return env.evalIn(e, n.args[1])
+	}
+
+	left, err := env.evalLong(n.args[0], n.name)
+	if err != nil {
+		return nil, err
+	}
+	right, err := env.evalLong(n.args[1], n.name)
+	if err != nil {
+		return nil, err
+	}
+	switch n.name {
+	case "<":
+		return left < right, nil
+	case "<=":
+		return left <= right, nil
+	case ">":
+		return left > right, nil
+	case ">=":
+		return left >= right, nil
+	case "+":
+		if (right > 0 && left > math.MaxInt64-right) || (right < 0 && left < math.MinInt64-right) {
+			return nil, fmt.Errorf("integer overflow")
+		}
+		return left + right, nil
+	case "-":
+		if (right < 0 && left > math.MaxInt64+right) || (right > 0 && left < math.MinInt64+right) {
+			return nil, fmt.Errorf("integer overflow")
+		}
+		return left - right, nil
+	default: // "*"
+		product := left * right
+		if left != 0 && (product/left != right || (left == -1 && right == math.MinInt64)) {
+			return nil, fmt.Errorf("integer overflow")
+		}
+		return product, nil
+	}
+}
+
+// evalIn reports whether an entity is in the entity, or one of the set of
+// entities, that the expression evaluates to
+func (env *cedarEnv) evalIn(e Entity, n *cedarNode) (interface{}, error) {
+	v, err := env.eval(n)
+	if err != nil {
+		return nil, err
+	}
+	switch x := v.(type) {
+	case Entity:
+		return env.in(e, x), nil
+	case cedarSet:
+		for _, elem := range x {
+			a, ok := elem.(Entity)
+			if !ok {
+				return nil, fmt.Errorf("in: expected set of entities, found %s in the set", cedarTypeName(elem))
+			}
+			if err := env.charge(1); err != nil {
+				return nil, err
+			}
+			if env.in(e, a) {
+				return true, nil
+			}
+		}
+		return false, nil
+	}
+	return nil, fmt.Errorf("in: expected entity or set, found %s", cedarTypeName(v))
+}
+
+// call evaluates a call of an extension function or method
+func (env *cedarEnv) call(n *cedarNode) (interface{}, error) {
+	args := make([]interface{}, len(n.args))
+	for i, arg := range n.args {
+		v, err := env.eval(arg)
+		if err != nil {
+			return nil, err
+		}
+		args[i] = v
+	}
+	if !n.method {
+		return callCedarFunction(n.name, args)
+	}
+
+	want, ok := cedarMethods[n.name]
+	if !ok {
+		return nil, fmt.Errorf("unknown method %s", n.name)
+	}
+	if len(args)-1 != want {
+		return nil, fmt.Errorf("%s: expected %d arguments, found %d", n.name, want, len(args)-1)
+	}
+
+	switch x := args[0].(type) {
+	case cedarSet:
+		return env.callSetMethod(n.name, x, args[1:])
+	case cedarIP:
+		return callIPMethod(n.name, x, args[1:])
+	case cedarDecimal:
+		other, ok := args[len(args)-1].(cedarDecimal)
+		if !ok || len(args) != 2 {
+			break
+		}
+		switch n.name {
+		case "lessThan":
+			return x < other, nil
+		case "lessThanOrEqual":
+			return x <= other, nil
+		case "greaterThan":
+			return x > other, nil
+		case "greaterThanOrEqual":
+			return x >= other, nil
+		}
+	}
+	return nil, fmt.Errorf("%s: unsupported for %s", n.name, cedarTypeName(args[0]))
+}
+
+// cedarMethods maps the methods of sets, IP addresses and decimals to their number of arguments
+var cedarMethods = map[string]int{
+	"contains": 1, "containsAll": 1, "containsAny": 1, "isEmpty": 0,
+	"isIpv4": 0, "isIpv6": 0, "isLoopback": 0, "isMulticast": 0, "isInRange": 1,
+	"lessThan": 1, "lessThanOrEqual": 1, "greaterThan": 1, "greaterThanOrEqual": 1,
+}
+
+func (env *cedarEnv) callSetMethod(name string, set cedarSet, args []interface{}) (interface{}, error) {
+	switch name {
+	case "isEmpty":
+		return len(set) == 0, nil
+	case "contains":
+		if err := env.charge(len(set)); err != nil {
+			return nil, err
+		}
+		return cedarSetContains(set, args[0]), nil
+	case "containsAll", "containsAny":
+		other, ok := args[0].(cedarSet)
+		if !ok {
+			return nil, fmt.Errorf("%s: expected set, found %s", name, cedarTypeName(args[0]))
+		}
+		if err := env.charge(len(set) * len(other)); err != nil {
+			return nil, err
+		}
+		all := name == "containsAll"
+		for _, v := range other {
+			if cedarSetContains(set, v) != all {
+				return !all, nil
+			}
+		}
+		return all, nil
+	}
+	return nil, fmt.Errorf("%s: unsupported for set", name)
+}
+
+func callIPMethod(name string, ip cedarIP, args []interface{}) (interface{}, error) {
+	p := netip.Prefix(ip)
+	switch name {
+	case "isIpv4":
+		return p.Addr().Is4(), nil
+	case "isIpv6":
+		return p.Addr().Is6(), nil
+	case "isLoopback":
+		return p.Addr().IsLoopback(), nil
+	case "isMulticast":
+		return p.Addr().IsMulticast(), nil
+	case "isInRange":
+		r, ok := args[0].(cedarIP)
+		if !ok {
+			return nil, fmt.Errorf("isInRange: expected ipaddr, found %s", cedarTypeName(args[0]))
+		}
+		outer := netip.Prefix(r)
+		return outer.Bits() <= p.Bits() && outer.Contains(p.Masked().Addr()), nil
+	}
+	return nil, fmt.Errorf("%s: unsupported for ipaddr", name)
+}
+
+// callCedarFunction calls an extension function, ip or decimal
+func callCedarFunction(name string, args []interface{}) (interface{}, error) {
+	if name != "ip" && name != "decimal" {
+		return nil, fmt.Errorf("unknown function %s", name)
+	}
+	if len(args) != 1 {
+		return nil, fmt.Errorf("%s: expected 1 argument, found %d", name, len(args))
+	}
+	s, ok := args[0].(string)
+	if !ok {
+		return nil, fmt.Errorf("%s: expected string, found %s", name, cedarTypeName(args[0]))
+	}
+	if name == "ip" {
+		return parseCedarIP(s)
+	}
+	return parseCedarDecimal(s)
+}
+
+// parseCedarIP parses an IP address or CIDR range
+func parseCedarIP(s string) (cedarIP, error) {
+	if strings.Contains(s, "/") {
+		p, err := netip.ParsePrefix(s)
+		if err != nil {
+			return cedarIP{}, fmt.Errorf("ip: invalid range %q", s)
+		}
+		return cedarIP(p), nil
+	}
+	a, err := netip.ParseAddr(s)
+	if err != nil || a.Zone() != "" {
+		return cedarIP{}, fmt.Errorf("ip: invalid address %q", s)
+	}
+	return cedarIP(netip.PrefixFrom(a, a.BitLen())), nil
+}
+
+// parseCedarDecimal parses a decimal with one to four digits after the point
+func parseCedarDecimal(s string) (cedarDecimal, error) {
+	whole, frac, ok := strings.Cut(s, ".")
+	if !ok || len(frac) == 0 || len(frac) > 4 || strings.HasPrefix(whole, "+") {
+		return 0, fmt.Errorf("decimal: invalid value %q", s)
+	}
+	w, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("decimal: invalid value %q", s)
+	}
+	f, err := strconv.ParseUint(frac+strings.Repeat("0", 4-len(frac)), 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("decimal: invalid value %q", s)
+	}
+	if w > math.MaxInt64/10000 || w < math.MinInt64/10000 {
+		return 0, fmt.Errorf("decimal: %q is out of range", s)
+	}
+	if strings.HasPrefix(whole, "-") {
+		return cedarDecimal(w*10000 - int64(f)), nil
+	}
+	return cedarDecimal(w*10000 + int64(f)), nil
+}
+
+// likeMatch reports whether a string matches a like pattern, given as the
+// segments between its wildcards
+func likeMatch(segments []string, s string) bool {
+	if len(segments) == 1 {
+		return s == segments[0]
+	}
+	first, last := segments[0], segments[len(segments)-1]
+	if !strings.HasPrefix(s, first) {
+		return false
+	}
+	s = s[len(first):]
+	for _, seg := range segments[1 : len(segments)-1] {
+		i := strings.Index(s, seg)
+		if i < 0 {
+			return false
+		}
+		s = s[i+len(seg):]
+	}
+	return strings.HasSuffix(s, last)
+}
+
+// cedarEntityEqual compares entities. Actions compare by ID, so that the
+// request's Action::"read" equals PhotoApp::Action::"read".
+func cedarEntityEqual(a, b Entity) bool {
+	if isCedarAction(a.Type) && isCedarAction(b.Type) {
+		return a.ID == b.ID
+	}
+	return a == b
+}
+
+// cedarEqual compares values. Values of different types are not equal.
+func cedarEqual(a, b interface{}) bool {
+	switch x := a.(type) {
+	case Entity:
+		y, ok := b.(Entity)
+		return ok && cedarEntityEqual(x, y)
+	case cedarSet:
+		y, ok := b.(cedarSet)
+		if !ok {
+			return false
+		}
+		for _, v := range x {
+			if !cedarSetContains(y, v) {
+				return false
+			}
+		}
+		for _, v := range y {
+			if !cedarSetContains(x, v) {
+				return false
+			}
+		}
+		return true
+	case cedarRecord:
+		y, ok := b.(cedarRecord)
+		if !ok || len(x) != len(y) {
+			return false
+		}
+		for k, v := range x {
+			w, ok := y[k]
+			if !ok || !cedarEqual(v, w) {
+				return false
+			}
+		}
+		return true
+	}
+	switch b.(type) {
+	case cedarSet, cedarRecord:
+		return false
+	}
+	return a == b
+}
+
+func cedarSetContains(set cedarSet, v interface{}) bool {
+	for _, elem := range set {
+		if cedarEqual(elem, v) {
+			return true
+		}
+	}
+	return false
+}
+
+// cedarValue converts an attribute or context value to a Cedar value. A
+// whole number is a long, a list a set and an object a record, except for
+// {"__entity": {"type": ..., "id": ...}}, an entity, and {"__extn": {"fn":
+// "ip" or "decimal", "arg": ...}}, an extension value.
+func cedarValue(v interface{}) (interface{}, error) {
+	switch x := normalize(v).(type) {
+	case bool, string, int64, Entity:
+		return x, nil
+	case float64:
+		if x != math.Trunc(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+			return nil, fmt.Errorf("number %v is not a long", x)
+		}
+		return int64(x), nil
+	case []interface{}:
+		set := make(cedarSet, len(x))
+		for i, elem := range x {
+			c, err := cedarValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			set[i] = c
+		}
+		return set, nil
+	case map[string]interface{}:
+		if e, ok := x["__entity"].(map[string]interface{}); ok {
+			typ, _ := e["type"].(string)
+			id, _ := e["id"].(string)
+			return Entity{Type: typ, ID: id}, nil
+		}
+		if e, ok := x["__extn"].(map[string]interface{}); ok {
+			fn, _ := e["fn"].(string)
+			return callCedarFunction(fn, []interface{}{e["arg"]})
+		}
+		record := make(cedarRecord, len(x))
+		for k, elem := range x {
+			c, err := cedarValue(elem)
+			if err != nil {
+				return nil, err
+			}
+			record[k] = c
+		}
+		return record, nil
+	}
+	return nil, fmt.Errorf("%s is not a Cedar value", typeName(v))
+}
+
+// cedarTypeName returns the Cedar type of a value
+func cedarTypeName(v interface{}) string {
+	switch v.(type) {
+	case bool:
+		return "bool"
+	case int64:
+		return "long"
+	case string:
+		return "string"
+	case Entity:
+		return "entity"
+	case cedarSet:
+		return "set"
+	case cedarRecord:
+		return "record"
+	case cedarIP:
+		return "ipaddr"
+	case cedarDecimal:
+		return "decimal"
+	}
+	return fmt.Sprintf("%T", v)
+}
@@ -0,0 +1,611 @@
+package policy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// cedarToken is a lexical token of a Cedar policy
+type cedarToken struct {
+	kind tokenKind
+	pos  int    // Byte offset in the source
+	text string // Source text; the unquoted value of a string
+	raw  string // Source text of a string between its quotes, for like patterns
+	err  error  // Invalid escape in a string, unless it is a like pattern
+}
+
+func (t cedarToken) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of input"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// cedarPunctuation lists Cedar's operators and delimiters, longer ones first
+var cedarPunctuation = []string{
+	"::", "==", "!=", "<=", ">=", "&&", "||",
+	"<", ">", "!", "+", "-", "*", "(", ")", "[", "]", "{", "}", ",", ";", ".", ":", "@", "?",
+}
+
+// lexCedar splits Cedar source into tokens, ending with a tokenEOF
+func lexCedar(src string) ([]cedarToken, error) {
+	var tokens []cedarToken
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case strings.HasPrefix(src[i:], "//"):
+			for i < len(src) && src[i] != '\n' {
+				i++
+			}
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, cedarToken{kind: tokenIdent, pos: start, text: src[start:i]})
+		case isDigit(c):
+			start := i
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			tokens = append(tokens, cedarToken{kind: tokenInt, pos: start, text: src[start:i]})
+		case c == '"':
+			end := i + 1
+			for end < len(src) && src[end] != '"' {
+				if src[end] == '\\' {
+					end++
+				}
+				end++
+			}
+			if end >= len(src) {
+				return nil, positionOf(src, i).errorf("unterminated string")
+			}
+			raw := src[i+1 : end]
+			s, err := unescapeCedar(raw, false)
+			tokens = append(tokens, cedarToken{kind: tokenString, pos: i, text: s, raw: raw, err: err})
+			i = end + 1
+		default:
+			punct := ""
+			for _, p := range cedarPunctuation {
+				if strings.HasPrefix(src[i:], p) {
+					punct = p
+					break
+				}
+			}
+			if punct == "" {
+				r, _ := utf8.DecodeRuneInString(src[i:])
+				return nil, positionOf(src, i).errorf("unexpected character %q", r)
+			}
+			tokens = append(tokens, cedarToken{kind: tokenPunct, pos: i, text: punct})
+			i += len(punct)
+		}
+	}
+	return append(tokens, cedarToken{kind: tokenEOF, pos: len(src)}), nil
+}
+
+// unescapeCedar resolves the escape sequences of a Cedar string. In a
+// segment of a like pattern, \* stands for a literal star.
+func unescapeCedar(raw string, pattern bool) (string, error) {
+	if !strings.Contains(raw, `\`) {
+		return raw, nil
+	}
+	var b strings.Builder
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			b.WriteByte(raw[i])
+			continue
+		}
+		i++
+		if i == len(raw) {
+			return "", fmt.Errorf("invalid escape at end of string")
+		}
+		switch e := raw[i]; e {
+		case 'n':
+			b.WriteByte('\n')
+		case 't':
+			b.WriteByte('\t')
+		case 'r':
+			b.WriteByte('\r')
+		case '0':
+			b.WriteByte(0)
+		case '\\', '"', '\'':
+			b.WriteByte(e)
+		case '*':
+			if !pattern {
+				return "", fmt.Errorf("invalid escape \\* outside a like pattern")
+			}
+			b.WriteByte('*')
+		case 'u':
+			end := strings.IndexByte(raw[i:], '}')
+			if !strings.HasPrefix(raw[i:], "u{") || end < 0 {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(raw[i+2:i+end], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid unicode escape")
+			}
+			b.WriteRune(rune(code))
+			i += end
+		default:
+			return "", fmt.Errorf("invalid escape \\%c", e)
+		}
+	}
+	return b.String(), nil
+}
+
+// likePattern splits the source of a like pattern at its wildcards, the
+// stars that are not escaped, and unescapes the segments between them
+func likePattern(raw string) ([]string, error) {
+	var segments []string
+	start := 0
+	for i := 0; i < len(raw); i++ {
+		switch raw[i] {
+		case '\\':
+			i++
+		case '*':
+			s, err := unescapeCedar(raw[start:i], true)
+			if err != nil {
+				return nil, err
+			}
+			segments = append(segments, s)
+			start = i + 1
+		}
+	}
+	s, err := unescapeCedar(raw[start:], true)
+	if err != nil {
+		return nil, err
+	}
+	return append(segments, s), nil
+}
+
+// cedarNode is a node of a Cedar expression's syntax tree. It reuses the
+// node kinds of condition expressions, with a few of Cedar's own.
+type cedarNode struct {
+	kind   nodeKind
+	pos    int
+	name   string      // Variable, attribute, method, function, operator or entity type
+	value  interface{} // Value of a literal or entity, or the segments of a like pattern
+	args   []*cedarNode
+	method bool // Whether a call has a receiver
+}
+
+// Cedar node kinds in addition to those of condition expressions
+const (
+	cedarNodeHas  nodeKind = iota + 100 // args[0] has name
+	cedarNodeLike                       // args[0] like value
+	cedarNodeIs                         // args[0] is name [in args[1]]
+)
+
+// cedarParser parses Cedar policies by recursive descent
+type cedarParser struct {
+	src    string
+	tokens []cedarToken
+	next   int
+	depth  int
+}
+
+// parseCedar parses the policies of a Cedar policy set
+func parseCedar(src string) (policies []*cedarPolicy, err error) {
+	tokens, err := lexCedar(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &cedarParser{src: src, tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(parseFailure)
+			if !ok {
+				panic(r)
+			}
+			policies, err = nil, f.err
+		}
+	}()
+	for p.peek().kind != tokenEOF {
+		policies = append(policies, p.policy())
+	}
+	return policies, nil
+}
+
+func (p *cedarParser) fail(pos int, format string, args ...interface{}) {
+	panic(parseFailure{positionOf(p.src, pos).errorf(format, args...)})
+}
+
+func (p *cedarParser) peek() cedarToken { return p.tokens[p.next] }
+
+func (p *cedarParser) advance() cedarToken {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the punctuation or keyword
+func (p *cedarParser) accept(text string) bool {
+	if t := p.peek(); (t.kind == tokenPunct || t.kind == tokenIdent) && t.text == text {
+		p.next++
+		return true
+	}
+	return false
+}
+
+func (p *cedarParser) expect(text string) cedarToken {
+	t := p.peek()
+	if !p.accept(text) {
+		p.fail(t.pos, "expected '%s', found %s", text, t)
+	}
+	return t
+}
+
+func (p *cedarParser) ident() cedarToken {
+	t := p.advance()
+	if t.kind != tokenIdent {
+		p.fail(t.pos, "expected identifier, found %s", t)
+	}
+	return t
+}
+
+func (p *cedarParser) str() cedarToken {
+	t := p.advance()
+	if t.kind != tokenString {
+		p.fail(t.pos, "expected string, found %s", t)
+	}
+	if t.err != nil {
+		p.fail(t.pos, "%v", t.err)
+	}
+	return t
+}
+
+// policy parses: {annotation} effect "(" scope ")" {condition} ";"
+func (p *cedarParser) policy() *cedarPolicy {
+	start := p.peek().pos
+	c := &cedarPolicy{}
+	for p.accept("@") {
+		name := p.ident()
+		p.expect("(")
+		value := p.str()
+		p.expect(")")
+		if c.annotations == nil {
+			c.annotations = make(map[string]string)
+		}
+		if _, ok := c.annotations[name.text]; ok {
+			p.fail(name.pos, "duplicate annotation @%s", name.text)
+		}
+		c.annotations[name.text] = value.text
+	}
+
+	effect := p.ident()
+	switch effect.text {
+	case "permit":
+		c.permit = true
+	case "forbid":
+	default:
+		p.fail(effect.pos, "expected permit or forbid, found %s", effect)
+	}
+
+	p.expect("(")
+	c.principal = p.scope("principal")
+	p.expect(",")
+	c.action = p.actionScope()
+	p.expect(",")
+	c.resource = p.scope("resource")
+	p.expect(")")
+
+	for {
+		t := p.peek()
+		if !p.accept("when") && !p.accept("unless") {
+			break
+		}
+		p.expect("{")
+		c.conditions = append(c.conditions, cedarCondition{when: t.text == "when", expr: p.expr()})
+		p.expect("}")
+	}
+	end := p.expect(";")
+	c.text = p.src[start : end.pos+1]
+	c.pos = start
+	return c
+}
+
+// scope parses the principal or resource of a policy's scope:
+// variable ["==" entity | "in" entity | "is" path ["in" entity]]
+func (p *cedarParser) scope(variable string) cedarScope {
+	if t := p.peek(); p.accept("?") {
+		p.fail(t.pos, "policy templates are not supported")
+	}
+	p.expect(variable)
+	switch {
+	case p.accept("=="):
+		return cedarScope{op: "==", entity: p.scopeEntity()}
+	case p.accept("in"):
+		return cedarScope{op: "in", entity: p.scopeEntity()}
+	case p.accept("is"):
+		s := cedarScope{op: "is", typ: p.path()}
+		if p.accept("in") {
+			s.entity = p.scopeEntity()
+		}
+		return s
+	}
+	return cedarScope{}
+}
+
+// scopeEntity parses an entity in a policy's scope
+func (p *cedarParser) scopeEntity() *Entity {
+	if t := p.peek(); t.kind == tokenPunct && t.text == "?" {
+		p.fail(t.pos, "policy templates are not supported")
+	}
+	e := p.entity(p.path())
+	return &e
+}
+
+// actionScope parses: "action" ["==" entity | "in" (entity | "[" entity {"," entity} "]")]
+func (p *cedarParser) actionScope() cedarActionScope {
+	p.expect("action")
+	switch {
+	case p.accept("=="):
+		return cedarActionScope{op: "==", entities: []Entity{p.entity(p.path())}}
+	case p.accept("in"):
+		if !p.accept("[") {
+			return cedarActionScope{op: "in", entities: []Entity{p.entity(p.path())}}
+		}
+		s := cedarActionScope{op: "in"}
+		for !p.accept("]") {
+			if len(s.entities) > 0 {
+				p.expect(",")
+			}
+			s.entities = append(s.entities, p.entity(p.path()))
+		}
+		return s
+	}
+	return cedarActionScope{}
+}
+
+// attribute parses an attribute name, an identifier or a string
+func (p *cedarParser) attribute() cedarToken {
+	if p.peek().kind == tokenString {
+		return p.str()
+	}
+	return p.ident()
+}
+
+// path parses a possibly namespaced name, e.g. PhotoApp::Photo
+func (p *cedarParser) path() string {
+	path := p.ident().text
+	for p.peek().kind == tokenPunct && p.peek().text == "::" && p.tokens[p.next+1].kind == tokenIdent {
+		p.advance()
+		path += "::" + p.advance().text
+	}
+	return path
+}
+
+// entity parses the "::" and ID that follow an entity type
+func (p *cedarParser) entity(typ string) Entity {
+	p.expect("::")
+	return Entity{Type: typ, ID: p.str().text}
+}
+
+// nest guards against expressions nested too deeply to evaluate safely
+func (p *cedarParser) nest() func() {
+	if p.depth++; p.depth > expressionDepthLimit {
+		p.fail(p.peek().pos, "expression is nested deeper than %d", expressionDepthLimit)
+	}
+	return func() { p.depth-- }
+}
+
+// expr parses: "if" expr "then" expr "else" expr | or
+func (p *cedarParser) expr() *cedarNode {
+	defer p.nest()()
+
+	t := p.peek()
+	if !p.accept("if") {
+		return p.or()
+	}
+	cond := p.expr()
+	p.expect("then")
+	then := p.expr()
+	p.expect("else")
+	otherwise := p.expr()
+	return &cedarNode{kind: nodeConditional, pos: t.pos, args: []*cedarNode{cond, then, otherwise}}
+}
+
+func (p *cedarParser) or() *cedarNode {
+	left := p.and()
+	for t := p.peek(); p.accept("||"); t = p.peek() {
+		left = &cedarNode{kind: nodeBinary, pos: t.pos, name: "||", args: []*cedarNode{left, p.and()}}
+	}
+	return left
+}
+
+func (p *cedarParser) and() *cedarNode {
+	left := p.relation()
+	for t := p.peek(); p.accept("&&"); t = p.peek() {
+		left = &cedarNode{kind: nodeBinary, pos: t.pos, name: "&&", args: []*cedarNode{left, p.relation()}}
+	}
+	return left
+}
+
+// relation parses: add [relop add | "has" (ident | string) | "like" string | "is" path ["in" add]]
+func (p *cedarParser) relation() *cedarNode {
+	left := p.add()
+	t := p.peek()
+	switch {
+	case p.accept("<"), p.accept("<="), p.accept(">"), p.accept(">="), p.accept("=="), p.accept("!="), p.accept("in"):
+		return &cedarNode{kind: nodeBinary, pos: t.pos, name: t.text, args: []*cedarNode{left, p.add()}}
+	case p.accept("has"):
+		attr := p.attribute()
+		return &cedarNode{kind: cedarNodeHas, pos: t.pos, name: attr.text, args: []*cedarNode{left}}
+	case p.accept("like"):
+		pattern := p.advance()
+		if pattern.kind != tokenString {
+			p.fail(pattern.pos, "expected pattern string, found %s", pattern)
+		}
+		segments, err := likePattern(pattern.raw)
+		if err != nil {
+			p.fail(pattern.pos, "%v", err)
+		}
+		return &cedarNode{kind: cedarNodeLike, pos: t.pos, value: segments, args: []*cedarNode{left}}
+	case p.accept("is"):
+		n := &cedarNode{kind: cedarNodeIs, pos: t.pos, name: p.path(), args: []*cedarNode{left}}
+		if p.accept("in") {
+			n.args = append(n.args, p.add())
+		}
+		return n
+	}
+	return left
+}
+
+func (p *cedarParser) add() *cedarNode {
+	left := p.mult()
+	for t := p.peek(); p.accept("+") || p.accept("-"); t = p.peek() {
+		left = &cedarNode{kind: nodeBinary, pos: t.pos, name: t.text, args: []*cedarNode{left, p.mult()}}
+	}
+	return left
+}
+
+func (p *cedarParser) mult() *cedarNode {
+	left := p.unary()
+	for t := p.peek(); p.accept("*"); t = p.peek() {
+		left = &cedarNode{kind: nodeBinary, pos: t.pos, name: "*", args: []*cedarNode{left, p.unary()}}
+	}
+	return left
+}
+
+// unary parses: {"!" | "-"} member
+func (p *cedarParser) unary() *cedarNode {
+	t := p.peek()
+	if !p.accept("!") && !p.accept("-") {
+		return p.member()
+	}
+	defer p.nest()()
+
+	// A negative literal, which may be the smallest long
+	if next := p.peek(); t.text == "-" && next.kind == tokenInt {
+		p.advance()
+		v, err := strconv.ParseInt("-"+next.text, 10, 64)
+		if err != nil {
+			p.fail(t.pos, "integer -%s is out of range", next.text)
+		}
+		return p.access(&cedarNode{kind: nodeLiteral, pos: t.pos, value: v})
+	}
+	return &cedarNode{kind: nodeUnary, pos: t.pos, name: t.text, args: []*cedarNode{p.unary()}}
+}
+
+// member parses a primary followed by attribute accesses and method calls
+func (p *cedarParser) member() *cedarNode {
+	return p.access(p.primary())
+}
+
+// access parses: {"." ident ["(" args ")"] | "[" string "]"}
+func (p *cedarParser) access(n *cedarNode) *cedarNode {
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name := p.ident()
+			if p.accept("(") {
+				n = &cedarNode{kind: nodeCall, pos: name.pos, name: name.text, args: append([]*cedarNode{n}, p.args(")")...), method: true}
+			} else {
+				n = &cedarNode{kind: nodeSelect, pos: name.pos, name: name.text, args: []*cedarNode{n}}
+			}
+		case p.accept("["):
+			key := p.str()
+			p.expect("]")
+			n = &cedarNode{kind: nodeSelect, pos: t.pos, name: key.text, args: []*cedarNode{n}}
+		default:
+			return n
+		}
+	}
+}
+
+// primary parses a literal, variable, entity, extension function call,
+// parenthesized expression, set or record
+func (p *cedarParser) primary() *cedarNode {
+	t := p.peek()
+	switch t.kind {
+	case tokenInt:
+		p.advance()
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			p.fail(t.pos, "integer %s is out of range", t.text)
+		}
+		return &cedarNode{kind: nodeLiteral, pos: t.pos, value: v}
+	case tokenString:
+		p.str()
+		return &cedarNode{kind: nodeLiteral, pos: t.pos, value: t.text}
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			p.advance()
+			return &cedarNode{kind: nodeLiteral, pos: t.pos, value: t.text == "true"}
+		case "principal", "action", "resource", "context":
+			p.advance()
+			return &cedarNode{kind: nodeIdent, pos: t.pos, name: t.text}
+		case "if", "then", "else", "in", "has", "like", "is":
+			p.fail(t.pos, "unexpected %s", t)
+		}
+		path := p.path()
+		if p.accept("(") {
+			return &cedarNode{kind: nodeCall, pos: t.pos, name: path, args: p.args(")")}
+		}
+		if next := p.peek(); next.kind != tokenPunct || next.text != "::" {
+			p.fail(t.pos, "unknown variable '%s'", path)
+		}
+		e := p.entity(path)
+		return &cedarNode{kind: nodeLiteral, pos: t.pos, value: e}
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			p.advance()
+			n := p.expr()
+			p.expect(")")
+			return n
+		case "[":
+			p.advance()
+			return &cedarNode{kind: nodeList, pos: t.pos, args: p.args("]")}
+		case "{":
+			p.advance()
+			return &cedarNode{kind: nodeMap, pos: t.pos, args: p.record()}
+		}
+	}
+	p.fail(t.pos, "unexpected %s", t)
+	return nil
+}
+
+// args parses a comma-separated list of expressions up to the closing delimiter
+func (p *cedarParser) args(closing string) []*cedarNode {
+	var args []*cedarNode
+	for !p.accept(closing) {
+		if len(args) > 0 {
+			p.expect(",")
+		}
+		args = append(args, p.expr())
+	}
+	return args
+}
+
+// record parses the attributes of a record literal up to the closing brace,
+// as alternating keys, which are string literals, and values
+func (p *cedarParser) record() []*cedarNode {
+	var entries []*cedarNode
+	seen := make(map[string]bool)
+	for !p.accept("}") {
+		if len(entries) > 0 {
+			p.expect(",")
+		}
+		key := p.attribute()
+		if seen[key.text] {
+			p.fail(key.pos, "duplicate attribute '%s'", key.text)
+		}
+		seen[key.text] = true
+		p.expect(":")
+		entries = append(entries, &cedarNode{kind: nodeLiteral, pos: key.pos, value: key.text}, p.expr())
+	}
+	return entries
+}
@@ -0,0 +1,149 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// cedarCorpusCase is the JSON file next to a Cedar policy set in
+// testdata/cedar: the groups and resource hierarchy to load with it and
+// requests with their expected decisions
+type cedarCorpusCase struct {
+	Groups    []Group        `json:"groups"`
+	Resources []ResourceNode `json:"resources"`
+	Requests  []struct {
+		Name               string                 `json:"name"`
+		Subject            Entity                 `json:"subject"`
+		Resource           Entity                 `json:"resource"`
+		Action             string                 `json:"action"`
+		SubjectProperties  map[string]interface{} `json:"subject_properties"`
+		ResourceProperties map[string]interface{} `json:"resource_properties"`
+		ActionProperties   map[string]interface{} `json:"action_properties"`
+		Context            map[string]interface{} `json:"context"`
+		Allow              bool                   `json:"allow"`
+		Policy             string                 `json:"policy"` // ID of the deciding policy; empty for none
+	} `json:"requests"`
+}
+
+func TestCedarCorpus(t *testing.T) {
+	files, err := filepath.Glob(filepath.Join("testdata", "cedar", "*.cedar"))
+	if err != nil || len(files) == 0 {
+		t.Fatalf("no Cedar corpus found: %v", err)
+	}
+
+	for _, file := range files {
+		file := file
+		t.Run(strings.TrimSuffix(filepath.Base(file), ".cedar"), func(t *testing.T) {
+			src, err := os.ReadFile(file)
+			if err != nil {
+				t.Fatal(err)
+			}
+			pf, err := ParsePolicyFile(file, src)
+			if err != nil {
+				t.Fatalf("parse: %v", err)
+			}
+			data, err := os.ReadFile(strings.TrimSuffix(file, ".cedar") + ".json")
+			if err != nil {
+				t.Fatal(err)
+			}
+			var c cedarCorpusCase
+			if err := json.Unmarshal(data, &c); err != nil {
+				t.Fatalf("expectations: %v", err)
+			}
+
+			s := NewMemoryStore()
+			if err := s.ReplaceGroups(nil, c.Groups); err != nil {
+				t.Fatal(err)
+			}
+			for _, n := range c.Resources {
+				if err := s.PutResourceNode(n); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if _, err := s.UpsertPolicies(pf.Policies); err != nil {
+				t.Fatal(err)
+			}
+
+			for _, r := range c.Requests {
+				d := s.Evaluate(Request{
+					Subject:            r.Subject,
+					Resource:           r.Resource,
+					Action:             r.Action,
+					SubjectProperties:  r.SubjectProperties,
+					ResourceProperties: r.ResourceProperties,
+					ActionProperties:   r.ActionProperties,
+					Context:            r.Context,
+				})
+				policy := ""
+				if d.Policy != nil {
+					policy = d.Policy.ID
+				}
+				if d.Allow != r.Allow || policy != r.Policy {
+					t.Errorf("%s: got allow=%v policy=%q, want allow=%v policy=%q", r.Name, d.Allow, policy, r.Allow, r.Policy)
+				}
+			}
+		})
+	}
+}
+
+func TestParseCedarErrors(t *testing.T) {
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{`permit (principal, action, resource)`, `1:37: expected ';'`},
+		{`permit (principal == ?principal, action, resource);`, `1:22: policy templates are not supported`},
+		{`allow (principal, action, resource);`, `1:1: expected permit or forbid`},
+		{`permit (principal, action, resource) when { user.id == "a" };`, `1:45: unknown variable 'user'`},
+		{`permit (principal, action, resource) when { "a\*" == "a" };`, `1:45: invalid escape \* outside a like pattern`},
+		{`permit (principal, action, resource) when { 9223372036854775808 > 0 };`, `1:45: integer 9223372036854775808 is out of range`},
+		{`permit (principal, action, resource) when { {a: 1, a: 2} == {} };`, `1:52: duplicate attribute 'a'`},
+		{"@id(\"x\") permit (principal, action, resource);\n@id(\"x\") forbid (principal, action, resource);", `2:1: duplicate policy id "x"`},
+	}
+	for _, tt := range tests {
+		_, err := ParseCedar(tt.src)
+		if err == nil || !errors.Is(err, ErrInvalidPolicy) || !strings.Contains(err.Error(), tt.want) {
+			t.Errorf("ParseCedar(%q) = %v, want %q", tt.src, err, tt.want)
+		}
+	}
+}
+
+func TestCedarPolicyValidation(t *testing.T) {
+	policies, err := ParseCedar(`permit (principal == User::"alice", action == Action::"read", resource is Document);`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := policies[0]
+	want := Policy{
+		ID:       p.ID,
+		Subject:  Entity{Type: "User", ID: "alice"},
+		Resource: Entity{Type: "Document", ID: Wildcard},
+		Action:   "read",
+		Allow:    true,
+		Set:      CedarPolicySet,
+		Cedar:    p.Cedar,
+	}
+	if !strings.HasPrefix(p.ID, "cedar-") || p.Subject != want.Subject || p.Resource != want.Resource || p.Action != want.Action || !p.Allow || p.Set != want.Set {
+		t.Fatalf("ParseCedar = %+v, want %+v", p, want)
+	}
+	if err := p.Validate(); err != nil {
+		t.Fatalf("Validate: %v", err)
+	}
+
+	widened := p
+	widened.Subject = Entity{Type: "User", ID: Wildcard}
+	if err := widened.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Validate with a subject other than the scope's = %v, want ErrInvalidPolicy", err)
+	}
+	native := Policy{Subject: want.Subject, Resource: want.Resource, Action: "read", Allow: true, Set: CedarPolicySet}
+	if err := native.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Validate of a native policy in the cedar set = %v, want ErrInvalidPolicy", err)
+	}
+	if err := (PolicySet{Name: CedarPolicySet, Algorithm: PermitOverrides}).Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Validate of the cedar policy set = %v, want ErrInvalidPolicy", err)
+	}
+}
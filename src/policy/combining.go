@@ -0,0 +1,248 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// ErrPolicySetNotFound is returned when a policy set does not exist
+var ErrPolicySetNotFound = errors.New("policy set not found")
+
+// CombiningAlgorithm decides between several policies that apply to a request
+type CombiningAlgorithm string
+
+// Combining algorithms
+const (
+	DenyOverrides     CombiningAlgorithm = "deny-overrides"      // Any deny wins, then any allow
+	PermitOverrides   CombiningAlgorithm = "permit-overrides"    // Any allow wins, then any deny
+	FirstApplicable   CombiningAlgorithm = "first-applicable"    // The first policy in evaluation order wins
+	PriorityOrdered   CombiningAlgorithm = "priority-ordered"    // The policy with the highest priority wins
+	OnlyOneApplicable CombiningAlgorithm = "only-one-applicable" // More than one applicable policy is a conflict and denies
+
+	// DefaultCombiningAlgorithm combines policies unless configured otherwise
+	DefaultCombiningAlgorithm = DenyOverrides
+)
+
+// Validate checks that the algorithm is known
+func (a CombiningAlgorithm) Validate() error {
+	switch a {
+	case DenyOverrides, PermitOverrides, FirstApplicable, PriorityOrdered, OnlyOneApplicable:
+		return nil
+	}
+	return fmt.Errorf("%w: unknown combining algorithm %q", ErrInvalidPolicy, a)
+}
+
+// PolicySet names the combining algorithm of the policies that refer to it.
+// A policy set is combined into a single result, which is then combined with
+// the other sets and the policies without a set using the store's algorithm.
+type PolicySet struct {
+	Name      string             `json:"name"`
+	Algorithm CombiningAlgorithm `json:"algorithm"`
+}
+
+// Validate checks that the policy set is complete
+func (ps PolicySet) Validate() error {
+	if ps.Name == "" {
+		return fmt.Errorf("%w: policy set name is required", ErrInvalidPolicy)
+	}
+	if ps.Name == CedarPolicySet {
+		return fmt.Errorf("%w: policy set %q is reserved for cedar policies", ErrInvalidPolicy, CedarPolicySet)
+	}
+	return ps.Algorithm.Validate()
+}
+
+// outcome is a policy, or the combined result of a policy set, taking part in a combination
+type outcome struct {
+	entry     *stored   // Deciding policy; nil for a conflict
+	entries   []*stored // Policies whose obligations and advice the outcome carries, in evaluation order; nil for entry alone
+	allow     bool
+	priority  int
+	set       string             // Policy set of the outcome; empty for a policy without a set
+	algorithm CombiningAlgorithm // Algorithm that combined the set
+}
+
+// applicable returns the policies that match the request for one of the
+// subject's principals, i.e. the subject and its groups, on the resource or
+// one of the ancestors in its lineage and whose conditions the request
+// satisfies, in evaluation order: the resource's own policies before those
+// of its ancestors, nearest first, and on each resource policies without
+// wildcards, the subject's before its groups' and each by insertion, then
+// policies with wildcards from most to least specific. If the condition of
+// a matching policy cannot be evaluated, it returns that policy and the error.
+// Policies that are not valid at the time of v do not apply. The matching
+// policies are recorded on t.
+func (st *state) applicable(req Request, principals, lineage []Entity, v *validity, t *tracer) ([]*stored, *stored, error) {
+	var entries []*stored
+	for _, resource := range lineage {
+		for _, principal := range principals {
+			for _, entry := range st.byTriple.lookup(tripleKey{principal, resource, req.Action}) {
+				ok, err := entry.applies(st, req, v)
+				t.policy(st, entry, resource, req, v, ok)
+				if err != nil {
+					return nil, entry, err
+				}
+				if ok {
+					entries = append(entries, entry)
+				}
+			}
+		}
+
+		exact := len(entries)
+		for _, patterns := range st.patternsFor(resource.Type) {
+			for _, entry := range patterns {
+				p := entry.policy
+				if !p.Resource.Matches(resource) || !matchPattern(p.Action, req.Action) || !p.Subject.matchesSome(principals) {
+					continue
+				}
+				ok, err := entry.applies(st, req, v)
+				t.policy(st, entry, resource, req, v, ok)
+				if err != nil {
+					return nil, entry, err
+				}
+				if ok {
+					entries = append(entries, entry)
+				}
+			}
+		}
+		patterns := entries[exact:]
+		sort.Slice(patterns, func(i, j int) bool {
+			a, b := patterns[i], patterns[j]
+			if moreSpecific(a.policy, b.policy) {
+				return true
+			}
+			return !moreSpecific(b.policy, a.policy) && a.seq < b.seq
+		})
+	}
+	return entries, nil, nil
+}
+
+// combine decides between the applicable policies. The policies of each
+// policy set are combined with the set's algorithm first; a set that is not
+// defined uses the store's algorithm, except that the policies of the
+// CedarPolicySet use deny-overrides. It reports false if no policy applies.
+// The combining steps are recorded on t.
+func (st *state) combine(entries []*stored, t *tracer) (Decision, bool) {
+	if len(entries) == 0 {
+		return Decision{}, false
+	}
+
+	var (
+		outcomes []outcome
+		members  map[string][]outcome
+		at       map[string]int // Position of each set's outcome, that of its first policy
+	)
+	for _, entry := range entries {
+		o := outcome{entry: entry, allow: entry.policy.Allow, priority: entry.policy.Priority}
+		set := entry.policy.Set
+		if set == "" {
+			outcomes = append(outcomes, o)
+			continue
+		}
+		if members == nil {
+			members, at = make(map[string][]outcome), make(map[string]int)
+		}
+		if _, ok := at[set]; !ok {
+			at[set] = len(outcomes)
+			outcomes = append(outcomes, outcome{})
+		}
+		members[set] = append(members[set], o)
+	}
+	for set, i := range at {
+		algorithm := st.algorithm
+		if ps, ok := st.policySets[set]; ok {
+			algorithm = ps.Algorithm
+		} else if set == CedarPolicySet {
+			algorithm = DenyOverrides
+		}
+		o := combineOutcomes(algorithm, members[set])
+		o.set, o.algorithm = set, algorithm
+		outcomes[i] = o
+	}
+
+	o := combineOutcomes(st.algorithm, outcomes)
+	t.combined(st.algorithm, outcomes, members, o)
+	d := Decision{Allow: o.allow, Set: o.set, Algorithm: st.algorithm, Conflict: o.entry == nil}
+	if o.set != "" {
+		d.Algorithm = o.algorithm
+	}
+	if o.entry != nil {
+		p := o.entry.policy
+		d.Policy = &p
+		contributors := o.contributors()
+		d.Obligations = mergeDirectives(contributors, obligationsOf)
+		d.Advice = mergeDirectives(contributors, adviceOf)
+	}
+	return d, true
+}
+
+// combineOutcomes applies a combining algorithm to outcomes in evaluation
+// order. Under deny-overrides and permit-overrides, the result carries the
+// obligations and advice of all outcomes with its effect; under the other
+// algorithms, those of the selected outcome.
+func combineOutcomes(algorithm CombiningAlgorithm, outcomes []outcome) outcome {
+	o := selectOutcome(algorithm, outcomes)
+	switch algorithm {
+	case FirstApplicable, PriorityOrdered, OnlyOneApplicable:
+		return o
+	}
+	if o.entry == nil || len(outcomes) == 1 {
+		return o
+	}
+
+	var entries []*stored
+	for _, other := range outcomes {
+		if other.entry != nil && other.allow == o.allow {
+			entries = append(entries, other.contributors()...)
+		}
+	}
+	o.entries = entries
+	return o
+}
+
+// contributors returns the policies whose obligations and advice the outcome carries
+func (o outcome) contributors() []*stored {
+	if o.entries == nil && o.entry != nil {
+		return []*stored{o.entry}
+	}
+	return o.entries
+}
+
+// selectOutcome selects the outcome that decides under a combining algorithm
+func selectOutcome(algorithm CombiningAlgorithm, outcomes []outcome) outcome {
+	switch algorithm {
+	case PermitOverrides:
+		for _, o := range outcomes {
+			if o.allow {
+				return o
+			}
+		}
+	case FirstApplicable:
+	case PriorityOrdered:
+		best := outcomes[0]
+		for _, o := range outcomes[1:] {
+			if o.priority > best.priority {
+				best = o
+			}
+		}
+		return best
+	case OnlyOneApplicable:
+		if len(outcomes) == 1 {
+			return outcomes[0]
+		}
+		conflict := outcome{priority: outcomes[0].priority}
+		for _, o := range outcomes[1:] {
+			if o.priority > conflict.priority {
+				conflict.priority = o.priority
+			}
+		}
+		return conflict
+	default: // DenyOverrides
+		for _, o := range outcomes {
+			if !o.allow {
+				return o
+			}
+		}
+	}
+	return outcomes[0]
+}
@@ -0,0 +1,335 @@
+package policy
+
+import (
+	"reflect"
+	"testing"
+)
+
+// combined returns a policy of alice reading doc:1 for the combining tests
+func combined(id string, allow bool, priority int, set string) Policy {
+	return Policy{ID: id, Subject: user("alice"), Resource: doc("1"), Action: "read", Allow: allow, Priority: priority, Set: set}
+}
+
+func TestCombiningAlgorithms(t *testing.T) {
+	tests := []struct {
+		name      string
+		algorithm CombiningAlgorithm
+		sets      []PolicySet
+		policies  []Policy
+
+		allow     bool
+		policy    string // ID of the deciding policy; empty for none
+		set       string
+		decidedBy CombiningAlgorithm
+		conflict  bool
+		err       bool
+	}{
+		{
+			name:      "deny overrides an allow",
+			algorithm: DenyOverrides,
+			policies:  []Policy{combined("a", true, 0, ""), combined("b", false, 0, "")},
+			policy:    "b", decidedBy: DenyOverrides,
+		},
+		{
+			name:      "deny overrides without a deny",
+			algorithm: DenyOverrides,
+			policies:  []Policy{combined("a", true, 0, ""), combined("b", true, 0, "")},
+			allow:     true, policy: "a", decidedBy: DenyOverrides,
+		},
+		{
+			name:      "permit overrides a deny",
+			algorithm: PermitOverrides,
+			policies:  []Policy{combined("a", false, 0, ""), combined("b", true, 0, "")},
+			allow:     true, policy: "b", decidedBy: PermitOverrides,
+		},
+		{
+			name:      "permit overrides without an allow",
+			algorithm: PermitOverrides,
+			policies:  []Policy{combined("a", false, 0, ""), combined("b", false, 0, "")},
+			policy:    "a", decidedBy: PermitOverrides,
+		},
+		{
+			name:      "first applicable deny",
+			algorithm: FirstApplicable,
+			policies:  []Policy{combined("a", false, 0, ""), combined("b", true, 0, "")},
+			policy:    "a", decidedBy: FirstApplicable,
+		},
+		{
+			name:      "first applicable allow",
+			algorithm: FirstApplicable,
+			policies:  []Policy{combined("a", true, 0, ""), combined("b", false, 0, "")},
+			allow:     true, policy: "a", decidedBy: FirstApplicable,
+		},
+		{
+			name:      "priority ordered",
+			algorithm: PriorityOrdered,
+			policies:  []Policy{combined("a", true, 1, ""), combined("b", false, 5, ""), combined("c", true, 3, "")},
+			policy:    "b", decidedBy: PriorityOrdered,
+		},
+		{
+			name:      "priority ordered tie",
+			algorithm: PriorityOrdered,
+			policies:  []Policy{combined("a", true, 3, ""), combined("b", false, 3, "")},
+			allow:     true, policy: "a", decidedBy: PriorityOrdered,
+		},
+		{
+			name:      "only one applicable",
+			algorithm: OnlyOneApplicable,
+			policies:  []Policy{combined("a", true, 0, "")},
+			allow:     true, policy: "a", decidedBy: OnlyOneApplicable,
+		},
+		{
+			name:      "only one applicable conflict",
+			algorithm: OnlyOneApplicable,
+			policies:  []Policy{combined("a", true, 0, ""), combined("b", true, 0, "")},
+			decidedBy: OnlyOneApplicable, conflict: true,
+		},
+		{
+			name:      "set algorithm",
+			algorithm: DenyOverrides,
+			sets:      []PolicySet{{Name: "s", Algorithm: PermitOverrides}},
+			policies:  []Policy{combined("s1", false, 0, "s"), combined("s2", true, 0, "s")},
+			allow:     true, policy: "s2", set: "s", decidedBy: PermitOverrides,
+		},
+		{
+			name:      "set combined with a policy without a set",
+			algorithm: DenyOverrides,
+			sets:      []PolicySet{{Name: "s", Algorithm: PermitOverrides}},
+			policies:  []Policy{combined("a", true, 0, ""), combined("s1", false, 0, "s"), combined("s2", true, 0, "s")},
+			allow:     true, policy: "a", decidedBy: DenyOverrides,
+		},
+		{
+			name:      "undefined set uses the store's algorithm",
+			algorithm: PermitOverrides,
+			policies:  []Policy{combined("u1", false, 0, "u"), combined("u2", true, 0, "u")},
+			allow:     true, policy: "u2", set: "u", decidedBy: PermitOverrides,
+		},
+		{
+			name:      "conflict within a set",
+			algorithm: DenyOverrides,
+			sets:      []PolicySet{{Name: "s", Algorithm: OnlyOneApplicable}},
+			policies:  []Policy{combined("a", true, 0, ""), combined("s1", true, 0, "s"), combined("s2", true, 0, "s")},
+			set:       "s", decidedBy: OnlyOneApplicable, conflict: true,
+		},
+		{
+			name:      "priority of a set's result",
+			algorithm: PriorityOrdered,
+			sets:      []PolicySet{{Name: "s", Algorithm: DenyOverrides}},
+			policies:  []Policy{combined("s1", true, 1, "s"), combined("a", true, 5, ""), combined("s2", false, 9, "s")},
+			policy:    "s2", set: "s", decidedBy: DenyOverrides,
+		},
+		{
+			name:      "condition error within a set",
+			algorithm: DenyOverrides,
+			sets:      []PolicySet{{Name: "s", Algorithm: PermitOverrides}},
+			policies: []Policy{
+				combined("s1", true, 0, "s"),
+				func() Policy {
+					p := combined("s2", true, 0, "s")
+					p.Condition = "resource.properties.missing == 1"
+					return p
+				}(),
+			},
+			policy: "s2", err: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if err := s.SetCombiningAlgorithm(tt.algorithm); err != nil {
+				t.Fatal(err)
+			}
+			for _, ps := range tt.sets {
+				if err := s.PutPolicySet(ps); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, p := range tt.policies {
+				if _, err := s.AddPolicy(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := s.Evaluate(Request{Subject: user("alice"), Resource: doc("1"), Action: "read"})
+			if d.Allow != tt.allow {
+				t.Errorf("Allow = %v, want %v", d.Allow, tt.allow)
+			}
+			var policy string
+			if d.Policy != nil {
+				policy = d.Policy.ID
+			}
+			if policy != tt.policy {
+				t.Errorf("Policy = %q, want %q", policy, tt.policy)
+			}
+			if (d.Error != nil) != tt.err {
+				t.Errorf("Error = %v, want an error: %v", d.Error, tt.err)
+			}
+			if tt.err {
+				return
+			}
+			if d.Set != tt.set || d.Algorithm != tt.decidedBy || d.Conflict != tt.conflict {
+				t.Errorf("Set, Algorithm, Conflict = %q, %s, %v, want %q, %s, %v", d.Set, d.Algorithm, d.Conflict, tt.set, tt.decidedBy, tt.conflict)
+			}
+		})
+	}
+}
+
+func TestCombiningAlgorithmValidate(t *testing.T) {
+	for _, a := range []CombiningAlgorithm{DenyOverrides, PermitOverrides, FirstApplicable, PriorityOrdered, OnlyOneApplicable} {
+		if err := a.Validate(); err != nil {
+			t.Errorf("%s: %v", a, err)
+		}
+	}
+	if err := CombiningAlgorithm("majority").Validate(); err == nil {
+		t.Error("unknown algorithm accepted")
+	}
+	if err := (PolicySet{Name: CedarPolicySet, Algorithm: DenyOverrides}).Validate(); err == nil {
+		t.Error("reserved policy set accepted")
+	}
+}
+
+func TestMergeDirectives(t *testing.T) {
+	first := &stored{policy: Policy{Obligations: []Directive{
+		{ID: "mask_fields", Attributes: map[string]interface{}{"fields": []interface{}{"ssn", "dob"}, "char": "*"}},
+		{ID: "log_access"},
+	}}}
+	second := &stored{policy: Policy{Obligations: []Directive{
+		{ID: "require_mfa", Attributes: map[string]interface{}{"max_age": 300.0}},
+		{ID: "mask_fields", Attributes: map[string]interface{}{"fields": []interface{}{"dob", "salary"}, "char": "#", "reason": "hr"}},
+		{ID: "log_access", Attributes: map[string]interface{}{"level": "info"}},
+	}}}
+	third := &stored{policy: Policy{Obligations: []Directive{
+		{ID: "mask_fields", Attributes: map[string]interface{}{"fields": "all", "reason": "audit"}},
+		{ID: "require_mfa", Attributes: map[string]interface{}{"max_age": 60.0}},
+	}}}
+
+	got := mergeDirectives([]*stored{first, second, third}, obligationsOf)
+	want := []Directive{
+		{ID: "mask_fields", Attributes: map[string]interface{}{"fields": []interface{}{"ssn", "dob", "salary"}, "char": "*", "reason": "hr"}},
+		{ID: "log_access", Attributes: map[string]interface{}{"level": "info"}},
+		{ID: "require_mfa", Attributes: map[string]interface{}{"max_age": 300.0}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("mergeDirectives = %v, want %v", got, want)
+	}
+
+	if fields := first.policy.Obligations[0].Attributes["fields"]; !reflect.DeepEqual(fields, []interface{}{"ssn", "dob"}) {
+		t.Errorf("merging modified the stored policy: fields = %v", fields)
+	}
+	if first.policy.Obligations[1].Attributes != nil {
+		t.Errorf("merging modified the stored policy: attributes = %v", first.policy.Obligations[1].Attributes)
+	}
+	if got := mergeDirectives([]*stored{first, second}, adviceOf); got != nil {
+		t.Errorf("mergeDirectives without advice = %v, want nil", got)
+	}
+}
+
+// TestDirectiveContributors checks which applicable policies contribute
+// their obligations and advice under each combining algorithm. Every policy
+// adds its ID to the list attribute of one obligation and sets the scalar
+// attribute of one advice to it.
+func TestDirectiveContributors(t *testing.T) {
+	directed := func(id string, allow bool, priority int, set string) Policy {
+		p := combined(id, allow, priority, set)
+		p.Obligations = []Directive{{ID: "log_access", Attributes: map[string]interface{}{"policies": []interface{}{id}}}}
+		p.Advice = []Directive{{ID: "notify", Attributes: map[string]interface{}{"policy": id}}}
+		return p
+	}
+
+	tests := []struct {
+		name      string
+		algorithm CombiningAlgorithm
+		sets      []PolicySet
+		policies  []Policy
+
+		contributors []interface{} // IDs of the contributing policies in evaluation order; nil for none
+	}{
+		{
+			name:         "deny overrides: all denies",
+			algorithm:    DenyOverrides,
+			policies:     []Policy{directed("a", true, 0, ""), directed("b", false, 0, ""), directed("c", false, 0, "")},
+			contributors: []interface{}{"b", "c"},
+		},
+		{
+			name:         "deny overrides: all allows",
+			algorithm:    DenyOverrides,
+			policies:     []Policy{directed("a", true, 0, ""), directed("b", true, 0, "")},
+			contributors: []interface{}{"a", "b"},
+		},
+		{
+			name:         "permit overrides: all allows",
+			algorithm:    PermitOverrides,
+			policies:     []Policy{directed("a", false, 0, ""), directed("b", true, 0, ""), directed("c", true, 0, "")},
+			contributors: []interface{}{"b", "c"},
+		},
+		{
+			name:         "first applicable: the first policy",
+			algorithm:    FirstApplicable,
+			policies:     []Policy{directed("a", true, 0, ""), directed("b", true, 0, "")},
+			contributors: []interface{}{"a"},
+		},
+		{
+			name:         "priority ordered: the highest priority",
+			algorithm:    PriorityOrdered,
+			policies:     []Policy{directed("a", true, 1, ""), directed("b", true, 5, ""), directed("c", true, 3, "")},
+			contributors: []interface{}{"b"},
+		},
+		{
+			name:         "only one applicable: the single policy",
+			algorithm:    OnlyOneApplicable,
+			policies:     []Policy{directed("a", true, 0, "")},
+			contributors: []interface{}{"a"},
+		},
+		{
+			name:      "only one applicable: none on a conflict",
+			algorithm: OnlyOneApplicable,
+			policies:  []Policy{directed("a", true, 0, ""), directed("b", true, 0, "")},
+		},
+		{
+			name:         "a set contributes what its algorithm selects",
+			algorithm:    DenyOverrides,
+			sets:         []PolicySet{{Name: "s", Algorithm: FirstApplicable}},
+			policies:     []Policy{directed("s1", true, 0, "s"), directed("s2", true, 0, "s"), directed("a", true, 0, "")},
+			contributors: []interface{}{"s1", "a"},
+		},
+		{
+			name:         "a set combined with deny overrides contributes all its allows",
+			algorithm:    FirstApplicable,
+			sets:         []PolicySet{{Name: "s", Algorithm: DenyOverrides}},
+			policies:     []Policy{directed("s1", true, 0, "s"), directed("a", true, 0, ""), directed("s2", true, 0, "s")},
+			contributors: []interface{}{"s1", "s2"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			if err := s.SetCombiningAlgorithm(tt.algorithm); err != nil {
+				t.Fatal(err)
+			}
+			for _, ps := range tt.sets {
+				if err := s.PutPolicySet(ps); err != nil {
+					t.Fatal(err)
+				}
+			}
+			for _, p := range tt.policies {
+				if _, err := s.AddPolicy(p); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			d := s.Evaluate(Request{Subject: user("alice"), Resource: doc("1"), Action: "read"})
+			if tt.contributors == nil {
+				if d.Obligations != nil || d.Advice != nil {
+					t.Errorf("Obligations, Advice = %v, %v, want none", d.Obligations, d.Advice)
+				}
+				return
+			}
+			if len(d.Obligations) != 1 || !reflect.DeepEqual(d.Obligations[0].Attributes["policies"], tt.contributors) {
+				t.Errorf("Obligations = %v, want log_access of %v", d.Obligations, tt.contributors)
+			}
+			if len(d.Advice) != 1 || d.Advice[0].Attributes["policy"] != tt.contributors[0] {
+				t.Errorf("Advice = %v, want notify of %v", d.Advice, tt.contributors[0])
+			}
+		})
+	}
+}
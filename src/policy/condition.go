@@ -0,0 +1,458 @@
+package policy
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Request is an authorization request together with the attributes that
+// policy conditions are evaluated against
+type Request struct {
+	Subject  Entity
+	Resource Entity
+	Action   string
+
+	SubjectProperties  map[string]interface{}
+	ResourceProperties map[string]interface{}
+	ActionProperties   map[string]interface{}
+	Context            map[string]interface{}
+}
+
+// Condition operators
+const (
+	OpEquals    = "eq"       // Attribute equals the operand
+	OpNotEquals = "ne"       // Attribute does not equal the operand
+	OpIn        = "in"       // Attribute equals one of the operand's elements
+	OpNotIn     = "not_in"   // Attribute equals none of the operand's elements
+	OpContains  = "contains" // Attribute is a list holding the operand, or a string containing it
+	OpExists    = "exists"   // Attribute is present; takes no operand
+	OpLess      = "lt"       // Attribute is less than the operand
+	OpLessEq    = "le"       // Attribute is less than or equal to the operand
+	OpGreater   = "gt"       // Attribute is greater than the operand
+	OpGreaterEq = "ge"       // Attribute is greater than or equal to the operand
+	OpWithin    = "within"   // Attribute is an RFC 3339 time within the operand's time window
+)
+
+// Condition restricts a policy to requests whose attributes satisfy it.
+// Attributes are addressed by dotted paths rooted at subject, resource,
+// action or context, e.g. "resource.properties.owner" or "context.time".
+type Condition struct {
+	Attribute string      `json:"attribute"`            // Path of the attribute to test
+	Operator  string      `json:"operator"`             // One of the Op* operators
+	Value     interface{} `json:"value,omitempty"`      // Literal operand
+	ValueFrom string      `json:"value_from,omitempty"` // Path of an attribute used as the operand instead of a literal
+}
+
+// TimeWindow is the operand of the within operator, e.g. business hours
+type TimeWindow struct {
+	Start    string   `json:"start"`              // Start of the window, "15:04"
+	End      string   `json:"end"`                // End of the window, exclusive; may be earlier than start to span midnight
+	Days     []string `json:"days,omitempty"`     // Weekdays of the window ("mon".."sun"); all days if empty
+	Timezone string   `json:"timezone,omitempty"` // IANA time zone of start, end and days; UTC if empty
+}
+
+// Validate checks that the condition is well-formed
+func (c Condition) Validate() error {
+	if err := validatePath(c.Attribute); err != nil {
+		return fmt.Errorf("%w: condition attribute: %v", ErrInvalidPolicy, err)
+	}
+	if c.ValueFrom != "" {
+		if err := validatePath(c.ValueFrom); err != nil {
+			return fmt.Errorf("%w: condition value_from: %v", ErrInvalidPolicy, err)
+		}
+	}
+
+	switch c.Operator {
+	case OpExists:
+		if c.Value != nil || c.ValueFrom != "" {
+			return fmt.Errorf("%w: operator %q takes no value", ErrInvalidPolicy, c.Operator)
+		}
+		return nil
+	case OpEquals, OpNotEquals, OpIn, OpNotIn, OpContains, OpLess, OpLessEq, OpGreater, OpGreaterEq, OpWithin:
+	default:
+		return fmt.Errorf("%w: unknown condition operator %q", ErrInvalidPolicy, c.Operator)
+	}
+
+	if (c.Value == nil) == (c.ValueFrom == "") {
+		return fmt.Errorf("%w: operator %q requires exactly one of value and value_from", ErrInvalidPolicy, c.Operator)
+	}
+	if c.Value == nil {
+		return nil
+	}
+
+	switch c.Operator {
+	case OpIn, OpNotIn:
+		if _, ok := toList(c.Value); !ok {
+			return fmt.Errorf("%w: operator %q requires a list value", ErrInvalidPolicy, c.Operator)
+		}
+	case OpWithin:
+		if _, err := parseTimeWindow(c.Value); err != nil {
+			return fmt.Errorf("%w: operator %q: %v", ErrInvalidPolicy, c.Operator, err)
+		}
+	}
+	return nil
+}
+
+// Matches reports whether the request satisfies the condition. A missing
+// attribute or an operand of the wrong type does not satisfy it.
+func (c Condition) Matches(req Request) bool {
+	return c.matches(req, c.window())
+}
+
+// window returns the parsed literal operand of a within condition; nil for
+// other conditions and for an operand taken from an attribute
+func (c Condition) window() *timeWindow {
+	if c.Operator != OpWithin || c.Value == nil {
+		return nil
+	}
+	window, _ := parseTimeWindow(c.Value)
+	return window
+}
+
+// matches is Matches with the literal operand of a within condition already
+// parsed, so that evaluation neither parses it nor loads its time zone
+func (c Condition) matches(req Request, window *timeWindow) bool {
+	attr, ok := req.Attribute(c.Attribute)
+	if c.Operator == OpExists {
+		return ok
+	}
+	if !ok {
+		return false
+	}
+
+	operand := c.Value
+	if c.ValueFrom != "" {
+		if operand, ok = req.Attribute(c.ValueFrom); !ok {
+			return false
+		}
+	}
+
+	switch c.Operator {
+	case OpEquals:
+		return equal(attr, operand)
+	case OpNotEquals:
+		return !equal(attr, operand)
+	case OpIn, OpNotIn:
+		list, ok := toList(operand)
+		if !ok {
+			return false
+		}
+		found := false
+		for _, v := range list {
+			if equal(attr, v) {
+				found = true
+				break
+			}
+		}
+		return found == (c.Operator == OpIn)
+	case OpContains:
+		if s, ok := attr.(string); ok {
+			sub, ok := operand.(string)
+			return ok && strings.Contains(s, sub)
+		}
+		list, ok := toList(attr)
+		if !ok {
+			return false
+		}
+		for _, v := range list {
+			if equal(v, operand) {
+				return true
+			}
+		}
+		return false
+	case OpLess, OpLessEq, OpGreater, OpGreaterEq:
+		cmp, ok := compare(attr, operand)
+		if !ok {
+			return false
+		}
+		switch c.Operator {
+		case OpLess:
+			return cmp < 0
+		case OpLessEq:
+			return cmp <= 0
+		case OpGreater:
+			return cmp > 0
+		default:
+			return cmp >= 0
+		}
+	case OpWithin:
+		s, ok := attr.(string)
+		if !ok {
+			return false
+		}
+		t, err := time.Parse(time.RFC3339, s)
+		if err != nil {
+			return false
+		}
+		if c.ValueFrom != "" {
+			if window, err = parseTimeWindow(operand); err != nil {
+				return false
+			}
+		}
+		return window != nil && window.contains(t)
+	}
+	return false
+}
+
+// Attribute returns the value at a dotted attribute path, e.g.
+// "subject.id", "resource.properties.owner" or "context.network.ip"
+func (req Request) Attribute(path string) (interface{}, bool) {
+	parts := strings.Split(path, ".")
+
+	var root map[string]interface{}
+	switch parts[0] {
+	case "subject":
+		root = entityAttributes(req.Subject, req.SubjectProperties)
+	case "resource":
+		root = entityAttributes(req.Resource, req.ResourceProperties)
+	case "action":
+		root = entityAttributes(Entity{}, req.ActionProperties)
+		if req.Action != "" {
+			root["name"] = req.Action
+		}
+	case "context":
+		root = req.Context
+	default:
+		return nil, false
+	}
+
+	var value interface{} = root
+	for _, part := range parts[1:] {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		if value, ok = m[part]; !ok {
+			return nil, false
+		}
+	}
+	if value == nil || len(parts) == 1 {
+		return nil, false
+	}
+	return value, true
+}
+
+// entityAttributes returns the attributes of a subject or resource.
+// An empty type or ID, as in a search request, is absent.
+func entityAttributes(e Entity, properties map[string]interface{}) map[string]interface{} {
+	attrs := make(map[string]interface{}, 3)
+	if properties != nil {
+		attrs["properties"] = properties
+	}
+	if e.Type != "" {
+		attrs["type"] = e.Type
+	}
+	if e.ID != "" {
+		attrs["id"] = e.ID
+	}
+	return attrs
+}
+
+// validatePath checks that an attribute path is rooted at subject, resource, action or context
+func validatePath(path string) error {
+	parts := strings.Split(path, ".")
+	switch parts[0] {
+	case "subject", "resource", "action", "context":
+	default:
+		return fmt.Errorf("path %q must start with subject, resource, action or context", path)
+	}
+	if len(parts) < 2 {
+		return fmt.Errorf("path %q does not name an attribute", path)
+	}
+	for _, part := range parts[1:] {
+		if part == "" {
+			return fmt.Errorf("path %q has an empty segment", path)
+		}
+	}
+	return nil
+}
+
+// equal compares two attribute values. Numbers compare by value regardless of their Go type.
+func equal(a, b interface{}) bool {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		return ok && x == y
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+// compare orders two numbers or two strings
+func compare(a, b interface{}) (int, bool) {
+	if x, ok := toNumber(a); ok {
+		y, ok := toNumber(b)
+		switch {
+		case !ok:
+			return 0, false
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+
+	x, ok := a.(string)
+	if !ok {
+		return 0, false
+	}
+	y, ok := b.(string)
+	if !ok {
+		return 0, false
+	}
+	return strings.Compare(x, y), true
+}
+
+// toNumber converts a numeric value to float64
+func toNumber(v interface{}) (float64, bool) {
+	switch n := v.(type) {
+	case float64:
+		return n, true
+	case float32:
+		return float64(n), true
+	case int:
+		return float64(n), true
+	case int32:
+		return float64(n), true
+	case int64:
+		return float64(n), true
+	case uint:
+		return float64(n), true
+	case uint32:
+		return float64(n), true
+	case uint64:
+		return float64(n), true
+	}
+	return 0, false
+}
+
+// toList converts a list value to a slice
+func toList(v interface{}) ([]interface{}, bool) {
+	switch list := v.(type) {
+	case []interface{}:
+		return list, true
+	case []string:
+		values := make([]interface{}, len(list))
+		for i, s := range list {
+			values[i] = s
+		}
+		return values, true
+	}
+	return nil, false
+}
+
+// weekdays maps day names to weekdays
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// timeWindow is a parsed TimeWindow
+type timeWindow struct {
+	start, end time.Duration // Offsets from midnight
+	days       map[time.Weekday]bool
+	location   *time.Location
+}
+
+// timeWindows holds the parsed literal operands of a policy's within
+// conditions by condition index
+type timeWindows []*timeWindow
+
+// parseTimeWindows parses the literal operands of the within conditions; it
+// returns nil if there are none
+func parseTimeWindows(conditions []Condition) timeWindows {
+	var windows timeWindows
+	for i, c := range conditions {
+		if w := c.window(); w != nil {
+			if windows == nil {
+				windows = make(timeWindows, len(conditions))
+			}
+			windows[i] = w
+		}
+	}
+	return windows
+}
+
+// at returns the parsed operand of the i-th condition, or nil
+func (ws timeWindows) at(i int) *timeWindow {
+	if i < len(ws) {
+		return ws[i]
+	}
+	return nil
+}
+
+// parseTimeWindow parses the operand of the within operator, which is a
+// TimeWindow or its JSON object form
+func parseTimeWindow(v interface{}) (*timeWindow, error) {
+	var tw TimeWindow
+	switch w := v.(type) {
+	case TimeWindow:
+		tw = w
+	case map[string]interface{}:
+		tw.Start, _ = w["start"].(string)
+		tw.End, _ = w["end"].(string)
+		tw.Timezone, _ = w["timezone"].(string)
+		if days, ok := toList(w["days"]); ok {
+			for _, d := range days {
+				day, _ := d.(string)
+				tw.Days = append(tw.Days, day)
+			}
+		}
+	default:
+		return nil, fmt.Errorf("value must be an object with start and end")
+	}
+
+	window := &timeWindow{location: time.UTC}
+	var err error
+	if window.start, err = parseClock(tw.Start); err != nil {
+		return nil, fmt.Errorf("start: %v", err)
+	}
+	if window.end, err = parseClock(tw.End); err != nil {
+		return nil, fmt.Errorf("end: %v", err)
+	}
+	if tw.Timezone != "" {
+		if window.location, err = time.LoadLocation(tw.Timezone); err != nil {
+			return nil, fmt.Errorf("timezone: %v", err)
+		}
+	}
+	if len(tw.Days) > 0 {
+		window.days = make(map[time.Weekday]bool, len(tw.Days))
+		for _, d := range tw.Days {
+			day, ok := weekdays[strings.ToLower(d)]
+			if !ok {
+				return nil, fmt.Errorf("unknown day %q", d)
+			}
+			window.days[day] = true
+		}
+	}
+	return window, nil
+}
+
+// parseClock parses a time of day in "15:04" form as an offset from midnight
+func parseClock(s string) (time.Duration, error) {
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, fmt.Errorf("invalid time of day %q, want HH:MM", s)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// contains reports whether t falls within the window
+func (w *timeWindow) contains(t time.Time) bool {
+	t = t.In(w.location)
+	clock := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute + time.Duration(t.Second())*time.Second
+
+	day := t.Weekday()
+	var inside bool
+	if w.start <= w.end {
+		inside = clock >= w.start && clock < w.end
+	} else {
+		// The window spans midnight; the part after midnight belongs to the previous day
+		inside = clock >= w.start || clock < w.end
+		if clock < w.end {
+			day = (day + 6) % 7
+		}
+	}
+	return inside && (w.days == nil || w.days[day])
+}
@@ -0,0 +1,203 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+// conditionRequest returns a request of alice reading doc:1 with the given context
+func conditionRequest(ctx map[string]interface{}) Request {
+	return Request{
+		Subject:            user("alice"),
+		Resource:           doc("1"),
+		Action:             "read",
+		SubjectProperties:  map[string]interface{}{"department": "eng", "level": 3.0, "tags": []interface{}{"admin", "oncall"}},
+		ResourceProperties: map[string]interface{}{"owner": "alice", "department": "eng", "classification": "internal", "min_level": 2},
+		Context:            ctx,
+	}
+}
+
+func TestConditionMatches(t *testing.T) {
+	// 2024-01-01 is a Monday
+	const monday10 = "2024-01-01T10:00:00Z"
+	business := map[string]interface{}{"start": "09:00", "end": "17:00", "days": []interface{}{"mon", "tue", "wed", "thu", "fri"}}
+	tests := []struct {
+		name      string
+		condition Condition
+		ctx       map[string]interface{}
+		want      bool
+	}{
+		{name: "eq", condition: Condition{Attribute: "resource.properties.owner", Operator: OpEquals, Value: "alice"}, want: true},
+		{name: "eq other value", condition: Condition{Attribute: "resource.properties.owner", Operator: OpEquals, Value: "bob"}},
+		{name: "eq numbers of different types", condition: Condition{Attribute: "subject.properties.level", Operator: OpEquals, Value: 3}, want: true},
+		{name: "eq number and string", condition: Condition{Attribute: "subject.properties.level", Operator: OpEquals, Value: "3"}},
+		{name: "eq subject id", condition: Condition{Attribute: "subject.id", Operator: OpEquals, Value: "alice"}, want: true},
+		{name: "eq action name", condition: Condition{Attribute: "action.name", Operator: OpEquals, Value: "read"}, want: true},
+		{name: "ne", condition: Condition{Attribute: "resource.properties.owner", Operator: OpNotEquals, Value: "bob"}, want: true},
+		{name: "ne same value", condition: Condition{Attribute: "resource.properties.owner", Operator: OpNotEquals, Value: "alice"}},
+		{name: "ne missing attribute", condition: Condition{Attribute: "resource.properties.missing", Operator: OpNotEquals, Value: "bob"}},
+		{name: "in", condition: Condition{Attribute: "resource.properties.classification", Operator: OpIn, Value: []interface{}{"public", "internal"}}, want: true},
+		{name: "in string list", condition: Condition{Attribute: "resource.properties.classification", Operator: OpIn, Value: []string{"public", "internal"}}, want: true},
+		{name: "in absent", condition: Condition{Attribute: "resource.properties.classification", Operator: OpIn, Value: []interface{}{"public"}}},
+		{name: "not_in", condition: Condition{Attribute: "resource.properties.classification", Operator: OpNotIn, Value: []interface{}{"secret"}}, want: true},
+		{name: "not_in present", condition: Condition{Attribute: "resource.properties.classification", Operator: OpNotIn, Value: []interface{}{"internal"}}},
+		{name: "contains list", condition: Condition{Attribute: "subject.properties.tags", Operator: OpContains, Value: "oncall"}, want: true},
+		{name: "contains list without", condition: Condition{Attribute: "subject.properties.tags", Operator: OpContains, Value: "guest"}},
+		{name: "contains string", condition: Condition{Attribute: "resource.properties.classification", Operator: OpContains, Value: "tern"}, want: true},
+		{name: "contains string and number", condition: Condition{Attribute: "resource.properties.classification", Operator: OpContains, Value: 1}},
+		{name: "exists", condition: Condition{Attribute: "resource.properties.owner", Operator: OpExists}, want: true},
+		{name: "exists missing", condition: Condition{Attribute: "resource.properties.missing", Operator: OpExists}},
+		{name: "exists below a scalar", condition: Condition{Attribute: "resource.properties.owner.name", Operator: OpExists}},
+		{name: "exists null", condition: Condition{Attribute: "context.null", Operator: OpExists}, ctx: map[string]interface{}{"null": nil}},
+		{name: "lt", condition: Condition{Attribute: "subject.properties.level", Operator: OpLess, Value: 4}, want: true},
+		{name: "lt equal", condition: Condition{Attribute: "subject.properties.level", Operator: OpLess, Value: 3}},
+		{name: "le", condition: Condition{Attribute: "subject.properties.level", Operator: OpLessEq, Value: 3}, want: true},
+		{name: "gt", condition: Condition{Attribute: "subject.properties.level", Operator: OpGreater, Value: 2.5}, want: true},
+		{name: "gt equal", condition: Condition{Attribute: "subject.properties.level", Operator: OpGreater, Value: 3}},
+		{name: "ge", condition: Condition{Attribute: "subject.properties.level", Operator: OpGreaterEq, Value: 3}, want: true},
+		{name: "lt strings", condition: Condition{Attribute: "resource.properties.owner", Operator: OpLess, Value: "bob"}, want: true},
+		{name: "lt string and number", condition: Condition{Attribute: "resource.properties.owner", Operator: OpLess, Value: 1}},
+		{name: "value_from eq", condition: Condition{Attribute: "resource.properties.owner", Operator: OpEquals, ValueFrom: "subject.id"}, want: true},
+		{name: "value_from eq nested", condition: Condition{Attribute: "subject.properties.department", Operator: OpEquals, ValueFrom: "resource.properties.department"}, want: true},
+		{name: "value_from ge", condition: Condition{Attribute: "subject.properties.level", Operator: OpGreaterEq, ValueFrom: "resource.properties.min_level"}, want: true},
+		{name: "value_from in", condition: Condition{Attribute: "context.role", Operator: OpIn, ValueFrom: "subject.properties.tags"}, ctx: map[string]interface{}{"role": "admin"}, want: true},
+		{name: "value_from missing", condition: Condition{Attribute: "resource.properties.owner", Operator: OpEquals, ValueFrom: "context.missing"}},
+		{name: "value_from not a list", condition: Condition{Attribute: "context.role", Operator: OpIn, ValueFrom: "subject.id"}, ctx: map[string]interface{}{"role": "alice"}},
+		{name: "within", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": monday10}, want: true},
+		{name: "within at the end", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": "2024-01-01T17:00:00Z"}},
+		{name: "within before the start", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": "2024-01-01T08:59:59Z"}},
+		{name: "within on another day", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": "2024-01-06T10:00:00Z"}},
+		{name: "within another offset", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": "2024-01-01T19:00:00+02:00"}},
+		{
+			name:      "within a time zone",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "09:00", End: "17:00", Timezone: "Asia/Tokyo"}},
+			ctx:       map[string]interface{}{"time": "2024-01-01T01:00:00Z"},
+			want:      true,
+		},
+		{
+			name:      "within a time zone, outside",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "09:00", End: "17:00", Timezone: "Asia/Tokyo"}},
+			ctx:       map[string]interface{}{"time": monday10},
+		},
+		{
+			name:      "within across midnight, after midnight",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "22:00", End: "06:00", Days: []string{"sun"}}},
+			ctx:       map[string]interface{}{"time": "2024-01-01T02:00:00Z"},
+			want:      true,
+		},
+		{
+			name:      "within across midnight, on the following day",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "22:00", End: "06:00", Days: []string{"sun"}}},
+			ctx:       map[string]interface{}{"time": "2024-01-01T23:00:00Z"},
+		},
+		{name: "within not a time", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": "10:00"}},
+		{name: "within not a string", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: business}, ctx: map[string]interface{}{"time": 10}},
+		{
+			name:      "within value_from",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, ValueFrom: "subject.properties.shift"},
+			ctx:       map[string]interface{}{"time": monday10},
+			want:      true,
+		},
+		{
+			name:      "within value_from invalid window",
+			condition: Condition{Attribute: "context.time", Operator: OpWithin, ValueFrom: "subject.properties.department"},
+			ctx:       map[string]interface{}{"time": monday10},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.condition.Validate(); err != nil {
+				t.Fatalf("Validate = %v", err)
+			}
+			req := conditionRequest(tt.ctx)
+			req.SubjectProperties["shift"] = map[string]interface{}{"start": "08:00", "end": "12:00"}
+			if got := tt.condition.Matches(req); got != tt.want {
+				t.Errorf("Matches = %v, want %v", got, tt.want)
+			}
+
+			// A stored policy evaluates its conditions with the windows parsed when it was put
+			s := NewMemoryStore()
+			if _, err := s.AddPolicy(Policy{ID: "p", Subject: user("alice"), Resource: doc("1"), Action: "read", Allow: true, Conditions: []Condition{tt.condition}}); err != nil {
+				t.Fatal(err)
+			}
+			if d := s.Evaluate(req); d.Allow != tt.want {
+				t.Errorf("Evaluate = %v, want %v", d.Allow, tt.want)
+			}
+		})
+	}
+}
+
+func TestConditionValidate(t *testing.T) {
+	tests := []struct {
+		name      string
+		condition Condition
+	}{
+		{name: "unknown root", condition: Condition{Attribute: "request.id", Operator: OpEquals, Value: "1"}},
+		{name: "root only", condition: Condition{Attribute: "subject", Operator: OpExists}},
+		{name: "empty segment", condition: Condition{Attribute: "subject..id", Operator: OpExists}},
+		{name: "invalid value_from", condition: Condition{Attribute: "subject.id", Operator: OpEquals, ValueFrom: "other.id"}},
+		{name: "unknown operator", condition: Condition{Attribute: "subject.id", Operator: "like", Value: "a"}},
+		{name: "exists with a value", condition: Condition{Attribute: "subject.id", Operator: OpExists, Value: "a"}},
+		{name: "no operand", condition: Condition{Attribute: "subject.id", Operator: OpEquals}},
+		{name: "both operands", condition: Condition{Attribute: "subject.id", Operator: OpEquals, Value: "a", ValueFrom: "resource.id"}},
+		{name: "in without a list", condition: Condition{Attribute: "subject.id", Operator: OpIn, Value: "a"}},
+		{name: "within without a window", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: "09:00-17:00"}},
+		{name: "within invalid start", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "9", End: "17:00"}}},
+		{name: "within invalid day", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "09:00", End: "17:00", Days: []string{"monday"}}}},
+		{name: "within unknown time zone", condition: Condition{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "09:00", End: "17:00", Timezone: "Mars/Olympus"}}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.condition.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+				t.Errorf("Validate = %v, want %v", err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+func TestParseTimeWindows(t *testing.T) {
+	conditions := []Condition{
+		{Attribute: "subject.id", Operator: OpEquals, Value: "alice"},
+		{Attribute: "context.time", Operator: OpWithin, Value: TimeWindow{Start: "09:00", End: "17:00", Timezone: "Asia/Tokyo"}},
+		{Attribute: "context.time", Operator: OpWithin, ValueFrom: "subject.properties.shift"},
+	}
+	windows := parseTimeWindows(conditions)
+	if len(windows) != len(conditions) || windows[0] != nil || windows[1] == nil || windows[2] != nil {
+		t.Fatalf("windows = %v, want only the literal window parsed", windows)
+	}
+	if windows[1].location.String() != "Asia/Tokyo" {
+		t.Errorf("location = %v, want Asia/Tokyo", windows[1].location)
+	}
+	if windows := parseTimeWindows(conditions[:1]); windows != nil {
+		t.Errorf("windows without a within condition = %v, want nil", windows)
+	}
+}
+
+// TestConditionsAndConditionExclusive checks that a policy cannot combine
+// conditions with a condition expression
+func TestConditionsAndConditionExclusive(t *testing.T) {
+	p := Policy{
+		ID:         "combined",
+		Subject:    user("alice"),
+		Resource:   doc("1"),
+		Action:     "read",
+		Allow:      true,
+		Conditions: []Condition{{Attribute: "subject.properties.department", Operator: OpEquals, Value: "eng"}},
+		Condition:  `resource.properties.owner == subject.id`,
+	}
+	if err := p.Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Validate = %v, want %v", err, ErrInvalidPolicy)
+	}
+	if _, err := NewMemoryStore().AddPolicy(p); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("AddPolicy = %v, want %v", err, ErrInvalidPolicy)
+	}
+
+	for _, only := range []Policy{
+		{ID: "conditions", Subject: user("alice"), Resource: doc("1"), Action: "read", Conditions: p.Conditions},
+		{ID: "condition", Subject: user("alice"), Resource: doc("1"), Action: "read", Condition: p.Condition},
+	} {
+		if err := only.Validate(); err != nil {
+			t.Errorf("Validate(%s) = %v, want nil", only.ID, err)
+		}
+	}
+}
@@ -0,0 +1,330 @@
+package policy
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Expression limits
+const (
+	expressionLengthLimit = 4096   // Maximum length of an expression's source, in bytes
+	expressionDepthLimit  = 64     // Maximum nesting of an expression
+	expressionCacheLimit  = 10000  // Maximum number of compiled expressions shared between policies
+	ExpressionCostLimit   = 100000 // Maximum cost of one evaluation; roughly one unit per operation and per element or byte processed
+)
+
+// ExpressionError is an error in a condition expression. Line and Column,
+// both starting at 1, locate where in the source the error occurred.
+type ExpressionError struct {
+	Line    int
+	Column  int
+	Message string
+}
+
+func (e *ExpressionError) Error() string {
+	return fmt.Sprintf("%d:%d: %s", e.Line, e.Column, e.Message)
+}
+
+// position is a line and column in an expression's source
+type position struct{ line, column int }
+
+// positionOf converts a byte offset in the source to a position
+func positionOf(src string, offset int) position {
+	before := src[:offset]
+	line := strings.Count(before, "\n") + 1
+	return position{line, offset - strings.LastIndex(before, "\n")}
+}
+
+func (p position) errorf(format string, args ...interface{}) *ExpressionError {
+	return &ExpressionError{Line: p.line, Column: p.column, Message: fmt.Sprintf(format, args...)}
+}
+
+// Expression is a compiled condition expression over the request's
+// attributes, with a syntax and semantics modelled on CEL, e.g.
+//
+//	resource.properties.owner == subject.id && context.ip.startsWith("10.")
+//
+// The variables subject and resource hold type, id and properties, action
+// holds name and properties, and context holds the request's context.
+// Expressions are type-checked when compiled; an attribute whose type is
+// only known at evaluation, such as a property, is checked then. Selecting
+// a missing key is an error; has() tests for one. An Expression is safe for
+// concurrent use.
+type Expression struct {
+	source string
+	eval   evalFunc
+	slots  int      // Number of variables bound by comprehensions
+	pos    position // Position of the root of the syntax tree
+	paths  []string // Attribute paths the expression reads, sorted, without those that another one extends
+}
+
+// CompileExpression parses and type-checks an expression, which must be
+// of type bool, and compiles it for evaluation
+func CompileExpression(src string) (*Expression, error) {
+	root, err := parseExpression(src)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &compiler{src: src, scope: make(map[string]int)}
+	t, eval, err := c.compileRoot(root)
+	if err != nil {
+		return nil, err
+	}
+	if t.kind != kindBool && t.kind != kindDyn {
+		return nil, c.position(root).errorf("expression must be of type bool, not %s", t)
+	}
+	return &Expression{source: src, eval: eval, slots: c.slots, pos: c.position(root), paths: leafPaths(c.paths)}, nil
+}
+
+// leafPaths returns the paths that no other path extends, sorted
+func leafPaths(paths map[string]bool) []string {
+	var leaves []string
+	for path := range paths {
+		leaf := true
+		for other := range paths {
+			if strings.HasPrefix(other, path+".") {
+				leaf = false
+				break
+			}
+		}
+		if leaf {
+			leaves = append(leaves, path)
+		}
+	}
+	sort.Strings(leaves)
+	return leaves
+}
+
+// String returns the expression's source
+func (e *Expression) String() string {
+	return e.source
+}
+
+// Evaluate reports whether the request satisfies the expression. It fails
+// if evaluation fails or costs more than ExpressionCostLimit.
+func (e *Expression) Evaluate(req Request) (bool, error) {
+	a := &activation{req: req, vars: make([]interface{}, e.slots)}
+	v, err := e.eval(a)
+	if err != nil {
+		return false, err
+	}
+	b, ok := v.(bool)
+	if !ok {
+		return false, e.pos.errorf("expression evaluated to %s, not bool", typeName(v))
+	}
+	return b, nil
+}
+
+// expressionCache shares compiled expressions between policies and between
+// validating a policy and storing it, so each expression compiles once
+var expressionCache struct {
+	sync.Map       // Source → *Expression
+	size     int64 // Number of cached expressions, updated atomically
+}
+
+// compileShared compiles an expression, or returns the same expression compiled earlier
+func compileShared(src string) (*Expression, error) {
+	if v, ok := expressionCache.Load(src); ok {
+		return v.(*Expression), nil
+	}
+
+	e, err := CompileExpression(src)
+	if err != nil {
+		return nil, err
+	}
+	if atomic.AddInt64(&expressionCache.size, 1) <= expressionCacheLimit {
+		expressionCache.Store(src, e)
+	} else {
+		atomic.AddInt64(&expressionCache.size, -1)
+	}
+	return e, nil
+}
+
+// compileCondition returns the compiled condition expression of a policy,
+// or nil if it has none. An expression that does not compile, which policy
+// validation rules out, fails every evaluation with its compile error.
+func compileCondition(src string) *Expression {
+	if src == "" {
+		return nil
+	}
+	e, err := compileShared(src)
+	if err != nil {
+		return &Expression{source: src, eval: func(*activation) (interface{}, error) { return nil, err }}
+	}
+	return e
+}
+
+// exprKind is the static type of an expression
+type exprKind int
+
+const (
+	kindDyn exprKind = iota // Known only at evaluation
+	kindNull
+	kindBool
+	kindInt
+	kindDouble
+	kindString
+	kindList
+	kindMap
+	kindTimestamp
+	kindDuration
+)
+
+var kindNames = [...]string{"dyn", "null", "bool", "int", "double", "string", "list", "map", "timestamp", "duration"}
+
+func (k exprKind) String() string { return kindNames[k] }
+
+// exprType is the static type of an expression. The variables are maps with known fields.
+type exprType struct {
+	kind   exprKind
+	fields map[string]*exprType // Fields of a variable; nil for any other map
+}
+
+func (t *exprType) String() string { return t.kind.String() }
+
+// numeric reports whether values of the type may be numbers
+func (t *exprType) numeric() bool {
+	return t.kind == kindInt || t.kind == kindDouble || t.kind == kindDyn
+}
+
+var (
+	dynType       = &exprType{kind: kindDyn}
+	nullType      = &exprType{kind: kindNull}
+	boolType      = &exprType{kind: kindBool}
+	intType       = &exprType{kind: kindInt}
+	doubleType    = &exprType{kind: kindDouble}
+	stringType    = &exprType{kind: kindString}
+	listType      = &exprType{kind: kindList}
+	mapType       = &exprType{kind: kindMap}
+	timestampType = &exprType{kind: kindTimestamp}
+	durationType  = &exprType{kind: kindDuration}
+
+	entityType = &exprType{kind: kindMap, fields: map[string]*exprType{
+		"type": stringType, "id": stringType, "properties": mapType,
+	}}
+	actionType = &exprType{kind: kindMap, fields: map[string]*exprType{
+		"name": stringType, "properties": mapType,
+	}}
+)
+
+// Variables of an expression, in activation order
+var variables = []struct {
+	name string
+	typ  *exprType
+}{
+	{"subject", entityType},
+	{"resource", entityType},
+	{"action", actionType},
+	{"context", mapType},
+}
+
+// activation holds the state of one evaluation
+type activation struct {
+	req   Request
+	roots [4]map[string]interface{} // Variables, built on first use
+	vars  []interface{}             // Values of the comprehension variables
+	cost  int
+}
+
+// root returns the value of the i-th variable
+func (a *activation) root(i int) map[string]interface{} {
+	if a.roots[i] != nil {
+		return a.roots[i]
+	}
+
+	req := a.req
+	switch i {
+	case 0:
+		a.roots[i] = map[string]interface{}{"type": req.Subject.Type, "id": req.Subject.ID, "properties": nonNil(req.SubjectProperties)}
+	case 1:
+		a.roots[i] = map[string]interface{}{"type": req.Resource.Type, "id": req.Resource.ID, "properties": nonNil(req.ResourceProperties)}
+	case 2:
+		a.roots[i] = map[string]interface{}{"name": req.Action, "properties": nonNil(req.ActionProperties)}
+	default:
+		a.roots[i] = nonNil(req.Context)
+	}
+	return a.roots[i]
+}
+
+// charge adds to the cost of the evaluation and fails once it exceeds the limit
+func (a *activation) charge(p position, cost int) error {
+	if a.cost += cost; a.cost > ExpressionCostLimit {
+		return p.errorf("evaluation exceeds the cost limit of %d", ExpressionCostLimit)
+	}
+	return nil
+}
+
+func nonNil(m map[string]interface{}) map[string]interface{} {
+	if m == nil {
+		return map[string]interface{}{}
+	}
+	return m
+}
+
+// normalize converts an attribute value to the representation expressions
+// evaluate, int64, float64 or []interface{}, if it is a number or list of
+// another Go type
+func normalize(v interface{}) interface{} {
+	switch x := v.(type) {
+	case int:
+		return int64(x)
+	case int32:
+		return int64(x)
+	case uint:
+		return int64(x)
+	case uint32:
+		return int64(x)
+	case uint64:
+		if x > 1<<63-1 {
+			return float64(x)
+		}
+		return int64(x)
+	case float32:
+		return float64(x)
+	case []string:
+		list, _ := toList(x)
+		return list
+	}
+	return v
+}
+
+// typeName returns the expression type of a value
+func typeName(v interface{}) string {
+	switch v.(type) {
+	case nil:
+		return "null"
+	case bool:
+		return "bool"
+	case int64:
+		return "int"
+	case float64:
+		return "double"
+	case string:
+		return "string"
+	case []interface{}:
+		return "list"
+	case map[string]interface{}:
+		return "map"
+	case time.Time:
+		return "timestamp"
+	case time.Duration:
+		return "duration"
+	}
+	return fmt.Sprintf("%T", v)
+}
+
+// mapKeys returns the keys of a map value in order, so that
+// comprehensions over maps are deterministic
+func mapKeys(m map[string]interface{}) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,1052 @@
+package policy
+
+import (
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// evalFunc evaluates a compiled node
+type evalFunc func(a *activation) (interface{}, error)
+
+// compiler type-checks a syntax tree and compiles it into evalFuncs
+type compiler struct {
+	src   string
+	scope map[string]int // Comprehension variables in scope, by name, to their slots
+	slots int
+	paths map[string]bool // Attribute paths the expression selects, e.g. "resource.properties.owner"
+}
+
+// compileFailure carries a type error out of the compiler
+type compileFailure struct{ err *ExpressionError }
+
+// compileRoot compiles the syntax tree of an expression
+func (c *compiler) compileRoot(n *node) (t *exprType, eval evalFunc, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(compileFailure)
+			if !ok {
+				panic(r)
+			}
+			t, eval, err = nil, nil, f.err
+		}
+	}()
+	t, eval = c.compile(n)
+	return t, eval, nil
+}
+
+func (c *compiler) position(n *node) position { return positionOf(c.src, n.pos) }
+
+// fail aborts compilation with an error at the node
+func (c *compiler) fail(n *node, format string, args ...interface{}) {
+	panic(compileFailure{c.position(n).errorf(format, args...)})
+}
+
+// expect fails unless the type is one of the kinds or only known at evaluation
+func (c *compiler) expect(n *node, what string, t *exprType, kinds ...exprKind) {
+	if t.kind == kindDyn {
+		return
+	}
+	names := make([]string, len(kinds))
+	for i, k := range kinds {
+		if t.kind == k {
+			return
+		}
+		names[i] = k.String()
+	}
+	want := names[len(names)-1]
+	if len(names) > 1 {
+		want = strings.Join(names[:len(names)-1], ", ") + " or " + want
+	}
+	c.fail(n, "%s must be %s, not %s", what, want, t)
+}
+
+// compile compiles a node; every evaluated node costs one unit
+func (c *compiler) compile(n *node) (*exprType, evalFunc) {
+	t, eval := c.compileNode(n)
+	p := c.position(n)
+	return t, func(a *activation) (interface{}, error) {
+		if err := a.charge(p, 1); err != nil {
+			return nil, err
+		}
+		return eval(a)
+	}
+}
+
+func (c *compiler) compileNode(n *node) (*exprType, evalFunc) {
+	switch n.kind {
+	case nodeLiteral:
+		v := n.value
+		return literalType(v), func(*activation) (interface{}, error) { return v, nil }
+	case nodeIdent:
+		return c.compileIdent(n)
+	case nodeSelect:
+		return c.compileSelect(n)
+	case nodeIndex:
+		return c.compileIndex(n)
+	case nodeCall:
+		return c.compileCall(n)
+	case nodeUnary:
+		return c.compileUnary(n)
+	case nodeBinary:
+		if n.name == "&&" || n.name == "||" {
+			return c.compileLogical(n)
+		}
+		return c.compileBinary(n)
+	case nodeConditional:
+		return c.compileConditional(n)
+	case nodeList:
+		return c.compileList(n)
+	case nodeMap:
+		return c.compileMap(n)
+	default: // nodeComprehension
+		return c.compileComprehension(n)
+	}
+}
+
+// literalType returns the type of a literal value
+func literalType(v interface{}) *exprType {
+	switch v.(type) {
+	case bool:
+		return boolType
+	case int64:
+		return intType
+	case float64:
+		return doubleType
+	case string:
+		return stringType
+	}
+	return nullType
+}
+
+func (c *compiler) compileIdent(n *node) (*exprType, evalFunc) {
+	if slot, ok := c.scope[n.name]; ok {
+		return dynType, func(a *activation) (interface{}, error) { return a.vars[slot], nil }
+	}
+	for i, v := range variables {
+		if v.name == n.name {
+			i := i
+			return v.typ, func(a *activation) (interface{}, error) { return a.root(i), nil }
+		}
+	}
+	c.fail(n, "undeclared reference to '%s'", n.name)
+	return nil, nil
+}
+
+// selectType returns the type of a field of a value of type t
+func (c *compiler) selectType(n *node, t *exprType) *exprType {
+	switch {
+	case t.fields != nil:
+		field, ok := t.fields[n.name]
+		if !ok {
+			c.fail(n, "undefined field '%s'", n.name)
+		}
+		return field
+	case t.kind == kindMap || t.kind == kindDyn:
+		return dynType
+	}
+	c.fail(n, "type %s has no fields", t)
+	return nil
+}
+
+// recordPath records the attribute path of a field selection on a variable
+func (c *compiler) recordPath(n *node) {
+	path, ok := c.staticPath(n)
+	if !ok || !strings.Contains(path, ".") {
+		return
+	}
+	if c.paths == nil {
+		c.paths = make(map[string]bool)
+	}
+	c.paths[path] = true
+}
+
+// staticPath returns the attribute path of a chain of field selections
+// rooted at a variable other than a comprehension variable
+func (c *compiler) staticPath(n *node) (string, bool) {
+	switch n.kind {
+	case nodeIdent:
+		if _, ok := c.scope[n.name]; ok {
+			return "", false
+		}
+		return n.name, true
+	case nodeSelect:
+		path, ok := c.staticPath(n.args[0])
+		return path + "." + n.name, ok
+	}
+	return "", false
+}
+
+func (c *compiler) compileSelect(n *node) (*exprType, evalFunc) {
+	c.recordPath(n)
+	t, operand := c.compile(n.args[0])
+	result := c.selectType(n, t)
+	p, name := c.position(n), n.name
+	return result, func(a *activation) (interface{}, error) {
+		v, err := operand(a)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, p.errorf("cannot select field '%s' of %s", name, typeName(v))
+		}
+		field, ok := m[name]
+		if !ok {
+			return nil, p.errorf("no such key: '%s'", name)
+		}
+		return normalize(field), nil
+	}
+}
+
+func (c *compiler) compileIndex(n *node) (*exprType, evalFunc) {
+	t, operand := c.compile(n.args[0])
+	it, index := c.compile(n.args[1])
+	switch t.kind {
+	case kindList:
+		c.expect(n.args[1], "list index", it, kindInt)
+	case kindMap:
+		c.expect(n.args[1], "map key", it, kindString)
+	case kindDyn:
+	default:
+		c.fail(n, "type %s cannot be indexed", t)
+	}
+
+	p := c.position(n)
+	return dynType, func(a *activation) (interface{}, error) {
+		v, err := operand(a)
+		if err != nil {
+			return nil, err
+		}
+		k, err := index(a)
+		if err != nil {
+			return nil, err
+		}
+		switch x := v.(type) {
+		case []interface{}:
+			i, ok := k.(int64)
+			if !ok {
+				return nil, p.errorf("list index must be int, not %s", typeName(k))
+			}
+			if i < 0 || i >= int64(len(x)) {
+				return nil, p.errorf("index %d out of range for list of size %d", i, len(x))
+			}
+			return normalize(x[i]), nil
+		case map[string]interface{}:
+			key, ok := k.(string)
+			if !ok {
+				return nil, p.errorf("map key must be string, not %s", typeName(k))
+			}
+			e, ok := x[key]
+			if !ok {
+				return nil, p.errorf("no such key: '%s'", key)
+			}
+			return normalize(e), nil
+		}
+		return nil, p.errorf("cannot index %s", typeName(v))
+	}
+}
+
+func (c *compiler) compileUnary(n *node) (*exprType, evalFunc) {
+	t, operand := c.compile(n.args[0])
+	p, op := c.position(n), n.name
+	if op == "!" {
+		c.expect(n.args[0], "operand of '!'", t, kindBool)
+	} else if !t.numeric() {
+		c.fail(n, "no such overload: -%s", t)
+	}
+
+	result := t
+	if op == "!" {
+		result = boolType
+	}
+	return result, func(a *activation) (interface{}, error) {
+		v, err := operand(a)
+		if err != nil {
+			return nil, err
+		}
+		switch x := v.(type) {
+		case bool:
+			if op == "!" {
+				return !x, nil
+			}
+		case int64:
+			if op == "-" && x != math.MinInt64 {
+				return -x, nil
+			}
+			if op == "-" {
+				return nil, p.errorf("integer overflow")
+			}
+		case float64:
+			if op == "-" {
+				return -x, nil
+			}
+		}
+		return nil, p.errorf("no such overload: %s%s", op, typeName(v))
+	}
+}
+
+// compileLogical compiles && and ||. As in CEL, an operand that decides the
+// result does so even if the other operand fails to evaluate.
+func (c *compiler) compileLogical(n *node) (*exprType, evalFunc) {
+	lt, left := c.compile(n.args[0])
+	rt, right := c.compile(n.args[1])
+	c.expect(n.args[0], "operand of '"+n.name+"'", lt, kindBool)
+	c.expect(n.args[1], "operand of '"+n.name+"'", rt, kindBool)
+
+	p := c.position(n)
+	decisive := n.name == "||" // Operand value that decides the result
+	operand := func(a *activation, eval evalFunc) (bool, error) {
+		v, err := eval(a)
+		if err != nil {
+			return false, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return false, p.errorf("no such overload: %s %s", typeName(v), n.name)
+		}
+		return b, nil
+	}
+	return boolType, func(a *activation) (interface{}, error) {
+		x, lerr := operand(a, left)
+		if lerr == nil && x == decisive {
+			return decisive, nil
+		}
+		if a.cost > ExpressionCostLimit {
+			return nil, lerr
+		}
+		y, rerr := operand(a, right)
+		switch {
+		case rerr == nil && y == decisive:
+			return decisive, nil
+		case lerr != nil:
+			return nil, lerr
+		case rerr != nil:
+			return nil, rerr
+		}
+		return !decisive, nil
+	}
+}
+
+// overloads lists the operand and result types of the arithmetic and ordering operators
+var overloads = func() map[string][][3]exprKind {
+	numeric := [][3]exprKind{
+		{kindInt, kindInt, kindInt}, {kindDouble, kindDouble, kindDouble},
+		{kindInt, kindDouble, kindDouble}, {kindDouble, kindInt, kindDouble},
+	}
+	ordered := [][3]exprKind{
+		{kindInt, kindInt, kindBool}, {kindDouble, kindDouble, kindBool},
+		{kindInt, kindDouble, kindBool}, {kindDouble, kindInt, kindBool},
+		{kindString, kindString, kindBool},
+		{kindTimestamp, kindTimestamp, kindBool}, {kindDuration, kindDuration, kindBool},
+	}
+	return map[string][][3]exprKind{
+		"+": append(numeric[:4:4],
+			[3]exprKind{kindString, kindString, kindString}, [3]exprKind{kindList, kindList, kindList},
+			[3]exprKind{kindTimestamp, kindDuration, kindTimestamp}, [3]exprKind{kindDuration, kindTimestamp, kindTimestamp},
+			[3]exprKind{kindDuration, kindDuration, kindDuration}),
+		"-": append(numeric[:4:4],
+			[3]exprKind{kindTimestamp, kindTimestamp, kindDuration}, [3]exprKind{kindTimestamp, kindDuration, kindTimestamp},
+			[3]exprKind{kindDuration, kindDuration, kindDuration}),
+		"*":  numeric,
+		"/":  numeric,
+		"%":  {{kindInt, kindInt, kindInt}},
+		"<":  ordered,
+		"<=": ordered,
+		">":  ordered,
+		">=": ordered,
+	}
+}()
+
+// binaryType returns the result type of a binary operator other than && and ||
+func (c *compiler) binaryType(n *node, l, r *exprType) *exprType {
+	switch n.name {
+	case "==", "!=":
+		if l.kind != r.kind && l.kind != kindDyn && r.kind != kindDyn && l.kind != kindNull && r.kind != kindNull &&
+			!(l.numeric() && r.numeric()) {
+			c.fail(n, "cannot compare %s and %s", l, r)
+		}
+		return boolType
+	case "in":
+		if r.kind != kindList && r.kind != kindMap && r.kind != kindDyn {
+			c.fail(n, "no such overload: %s in %s", l, r)
+		}
+		return boolType
+	}
+
+	var result *exprType
+	for _, o := range overloads[n.name] {
+		if (l.kind == o[0] || l.kind == kindDyn) && (r.kind == o[1] || r.kind == kindDyn) {
+			t := kindTypes[o[2]]
+			if result != nil && result != t {
+				return dynType // Several overloads apply until evaluation
+			}
+			result = t
+		}
+	}
+	if result == nil {
+		c.fail(n, "no such overload: %s %s %s", l, n.name, r)
+	}
+	return result
+}
+
+// kindTypes maps the kinds of operator results to their types
+var kindTypes = map[exprKind]*exprType{
+	kindBool: boolType, kindInt: intType, kindDouble: doubleType, kindString: stringType,
+	kindList: listType, kindTimestamp: timestampType, kindDuration: durationType,
+}
+
+func (c *compiler) compileBinary(n *node) (*exprType, evalFunc) {
+	lt, left := c.compile(n.args[0])
+	rt, right := c.compile(n.args[1])
+	t := c.binaryType(n, lt, rt)
+
+	p, op := c.position(n), n.name
+	return t, func(a *activation) (interface{}, error) {
+		x, err := left(a)
+		if err != nil {
+			return nil, err
+		}
+		y, err := right(a)
+		if err != nil {
+			return nil, err
+		}
+		return binaryOp(a, p, op, x, y)
+	}
+}
+
+// binaryOp applies a binary operator other than && and || to two values
+func binaryOp(a *activation, p position, op string, x, y interface{}) (interface{}, error) {
+	switch op {
+	case "==":
+		return valueEqual(x, y), nil
+	case "!=":
+		return !valueEqual(x, y), nil
+	case "in":
+		switch c := y.(type) {
+		case []interface{}:
+			if err := a.charge(p, len(c)); err != nil {
+				return nil, err
+			}
+			for _, e := range c {
+				if valueEqual(x, normalize(e)) {
+					return true, nil
+				}
+			}
+			return false, nil
+		case map[string]interface{}:
+			key, ok := x.(string)
+			if !ok {
+				return false, nil
+			}
+			_, ok = c[key]
+			return ok, nil
+		}
+	case "<", "<=", ">", ">=":
+		if cmp, ok := compareValues(x, y); ok {
+			switch op {
+			case "<":
+				return cmp < 0, nil
+			case "<=":
+				return cmp <= 0, nil
+			case ">":
+				return cmp > 0, nil
+			}
+			return cmp >= 0, nil
+		}
+	default:
+		return arithmetic(a, p, op, x, y)
+	}
+	return nil, p.errorf("no such overload: %s %s %s", typeName(x), op, typeName(y))
+}
+
+// arithmetic applies an arithmetic operator to two values
+func arithmetic(a *activation, p position, op string, x, y interface{}) (interface{}, error) {
+	switch l := x.(type) {
+	case int64:
+		switch r := y.(type) {
+		case int64:
+			return intArithmetic(p, op, l, r)
+		case float64:
+			return floatArithmetic(p, op, float64(l), r)
+		}
+	case float64:
+		switch r := y.(type) {
+		case int64:
+			return floatArithmetic(p, op, l, float64(r))
+		case float64:
+			return floatArithmetic(p, op, l, r)
+		}
+	case string:
+		if r, ok := y.(string); ok && op == "+" {
+			if err := a.charge(p, len(l)+len(r)); err != nil {
+				return nil, err
+			}
+			return l + r, nil
+		}
+	case []interface{}:
+		if r, ok := y.([]interface{}); ok && op == "+" {
+			if err := a.charge(p, len(l)+len(r)); err != nil {
+				return nil, err
+			}
+			return append(append(make([]interface{}, 0, len(l)+len(r)), l...), r...), nil
+		}
+	case time.Time:
+		switch r := y.(type) {
+		case time.Duration:
+			if op == "+" {
+				return l.Add(r), nil
+			}
+			if op == "-" {
+				return l.Add(-r), nil
+			}
+		case time.Time:
+			if op == "-" {
+				return l.Sub(r), nil
+			}
+		}
+	case time.Duration:
+		switch r := y.(type) {
+		case time.Duration:
+			if op == "+" {
+				return l + r, nil
+			}
+			if op == "-" {
+				return l - r, nil
+			}
+		case time.Time:
+			if op == "+" {
+				return r.Add(l), nil
+			}
+		}
+	}
+	return nil, p.errorf("no such overload: %s %s %s", typeName(x), op, typeName(y))
+}
+
+// intArithmetic applies an arithmetic operator to two integers, failing on overflow
+func intArithmetic(p position, op string, l, r int64) (interface{}, error) {
+	switch op {
+	case "+":
+		if s := l + r; (l > 0 && r > 0 && s < 0) || (l < 0 && r < 0 && s >= 0) {
+			return nil, p.errorf("integer overflow")
+		}
+		return l + r, nil
+	case "-":
+		if d := l - r; (l >= 0 && r < 0 && d < 0) || (l < 0 && r > 0 && d >= 0) {
+			return nil, p.errorf("integer overflow")
+		}
+		return l - r, nil
+	case "*":
+		if l != 0 && ((l*r)/l != r || (l == -1 && r == math.MinInt64) || (r == -1 && l == math.MinInt64)) {
+			return nil, p.errorf("integer overflow")
+		}
+		return l * r, nil
+	case "/":
+		if r == 0 {
+			return nil, p.errorf("division by zero")
+		}
+		if l == math.MinInt64 && r == -1 {
+			return nil, p.errorf("integer overflow")
+		}
+		return l / r, nil
+	default: // %
+		if r == 0 {
+			return nil, p.errorf("modulus by zero")
+		}
+		return l % r, nil
+	}
+}
+
+// floatArithmetic applies an arithmetic operator to two doubles
+func floatArithmetic(p position, op string, l, r float64) (interface{}, error) {
+	switch op {
+	case "+":
+		return l + r, nil
+	case "-":
+		return l - r, nil
+	case "*":
+		return l * r, nil
+	case "/":
+		return l / r, nil
+	}
+	return nil, p.errorf("no such overload: double %s double", op)
+}
+
+// valueEqual compares two values. Numbers compare by value, lists and maps by their elements.
+func valueEqual(x, y interface{}) bool {
+	switch a := x.(type) {
+	case []interface{}:
+		b, ok := y.([]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for i := range a {
+			if !valueEqual(normalize(a[i]), normalize(b[i])) {
+				return false
+			}
+		}
+		return true
+	case map[string]interface{}:
+		b, ok := y.(map[string]interface{})
+		if !ok || len(a) != len(b) {
+			return false
+		}
+		for k, v := range a {
+			w, ok := b[k]
+			if !ok || !valueEqual(normalize(v), normalize(w)) {
+				return false
+			}
+		}
+		return true
+	case time.Time:
+		b, ok := y.(time.Time)
+		return ok && a.Equal(b)
+	}
+	return equal(x, y)
+}
+
+// compareValues orders two numbers, strings, timestamps or durations
+func compareValues(x, y interface{}) (int, bool) {
+	switch a := x.(type) {
+	case time.Time:
+		b, ok := y.(time.Time)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case a.Before(b):
+			return -1, true
+		case a.After(b):
+			return 1, true
+		}
+		return 0, true
+	case time.Duration:
+		b, ok := y.(time.Duration)
+		if !ok {
+			return 0, false
+		}
+		return compare(int64(a), int64(b))
+	}
+	return compare(x, y)
+}
+
+func (c *compiler) compileConditional(n *node) (*exprType, evalFunc) {
+	ct, cond := c.compile(n.args[0])
+	tt, then := c.compile(n.args[1])
+	et, otherwise := c.compile(n.args[2])
+	c.expect(n.args[0], "condition of '?'", ct, kindBool)
+
+	t := tt
+	switch {
+	case tt == et:
+	case tt.kind == et.kind:
+		t = &exprType{kind: tt.kind}
+	default:
+		t = dynType
+	}
+
+	p := c.position(n)
+	return t, func(a *activation) (interface{}, error) {
+		v, err := cond(a)
+		if err != nil {
+			return nil, err
+		}
+		b, ok := v.(bool)
+		if !ok {
+			return nil, p.errorf("condition of '?' must be bool, not %s", typeName(v))
+		}
+		if b {
+			return then(a)
+		}
+		return otherwise(a)
+	}
+}
+
+func (c *compiler) compileList(n *node) (*exprType, evalFunc) {
+	elems := make([]evalFunc, len(n.args))
+	for i, arg := range n.args {
+		_, elems[i] = c.compile(arg)
+	}
+	return listType, func(a *activation) (interface{}, error) {
+		list := make([]interface{}, len(elems))
+		for i, elem := range elems {
+			v, err := elem(a)
+			if err != nil {
+				return nil, err
+			}
+			list[i] = v
+		}
+		return list, nil
+	}
+}
+
+func (c *compiler) compileMap(n *node) (*exprType, evalFunc) {
+	keys := make([]evalFunc, 0, len(n.args)/2)
+	values := make([]evalFunc, 0, len(n.args)/2)
+	literal := make(map[string]bool)
+	for i := 0; i < len(n.args); i += 2 {
+		key := n.args[i]
+		kt, k := c.compile(key)
+		c.expect(key, "map key", kt, kindString)
+		if s, ok := key.value.(string); ok && key.kind == nodeLiteral {
+			if literal[s] {
+				c.fail(key, "duplicate key '%s'", s)
+			}
+			literal[s] = true
+		}
+		_, v := c.compile(n.args[i+1])
+		keys, values = append(keys, k), append(values, v)
+	}
+
+	p := c.position(n)
+	return mapType, func(a *activation) (interface{}, error) {
+		m := make(map[string]interface{}, len(keys))
+		for i := range keys {
+			k, err := keys[i](a)
+			if err != nil {
+				return nil, err
+			}
+			key, ok := k.(string)
+			if !ok {
+				return nil, p.errorf("map key must be string, not %s", typeName(k))
+			}
+			if m[key], err = values[i](a); err != nil {
+				return nil, err
+			}
+		}
+		return m, nil
+	}
+}
+
+// compileComprehension compiles the exists and all macros, which test a
+// predicate on the elements of a list or the keys of a map
+func (c *compiler) compileComprehension(n *node) (*exprType, evalFunc) {
+	tt, target := c.compile(n.args[0])
+	c.expect(n.args[0], "target of "+n.name+"()", tt, kindList, kindMap)
+
+	slot := c.slots
+	c.slots++
+	outer, shadowed := c.scope[n.variable]
+	c.scope[n.variable] = slot
+	pt, predicate := c.compile(n.args[1])
+	if shadowed {
+		c.scope[n.variable] = outer
+	} else {
+		delete(c.scope, n.variable)
+	}
+	c.expect(n.args[1], "predicate of "+n.name+"()", pt, kindBool)
+
+	p, all := c.position(n), n.name == "all"
+	return boolType, func(a *activation) (interface{}, error) {
+		v, err := target(a)
+		if err != nil {
+			return nil, err
+		}
+		var elems []interface{}
+		switch x := v.(type) {
+		case []interface{}:
+			elems = x
+		case map[string]interface{}:
+			for _, k := range mapKeys(x) {
+				elems = append(elems, k)
+			}
+		default:
+			return nil, p.errorf("cannot iterate over %s", typeName(v))
+		}
+
+		for _, e := range elems {
+			a.vars[slot] = normalize(e)
+			r, err := predicate(a)
+			if err != nil {
+				return nil, err
+			}
+			b, ok := r.(bool)
+			if !ok {
+				return nil, p.errorf("predicate of %s() must be bool, not %s", n.name, typeName(r))
+			}
+			if b != all {
+				return b, nil
+			}
+		}
+		return all, nil
+	}
+}
+
+// function is a function or method that expressions may call
+type function struct {
+	params [][]exprKind // Accepted types of each parameter; a method's receiver comes first
+	result *exprType
+	call   func(a *activation, p position, args []interface{}) (interface{}, error)
+}
+
+// functionKey names a function, or a method if it is called on a receiver
+type functionKey struct {
+	name   string
+	method bool
+}
+
+// functions are the functions and methods expressions may call besides has() and matches()
+var functions = map[functionKey]function{
+	{"size", false}:      {[][]exprKind{{kindString, kindList, kindMap}}, intType, size},
+	{"size", true}:       {[][]exprKind{{kindString, kindList, kindMap}}, intType, size},
+	{"startsWith", true}: {[][]exprKind{{kindString}, {kindString}}, boolType, stringTest(strings.HasPrefix)},
+	{"endsWith", true}:   {[][]exprKind{{kindString}, {kindString}}, boolType, stringTest(strings.HasSuffix)},
+	{"contains", true}:   {[][]exprKind{{kindString}, {kindString}}, boolType, stringTest(strings.Contains)},
+	{"lowerAscii", true}: {[][]exprKind{{kindString}}, stringType, stringMap(strings.ToLower)},
+	{"upperAscii", true}: {[][]exprKind{{kindString}}, stringType, stringMap(strings.ToUpper)},
+	{"int", false}:       {[][]exprKind{{kindInt, kindDouble, kindString, kindTimestamp}}, intType, toInt},
+	{"double", false}:    {[][]exprKind{{kindInt, kindDouble, kindString}}, doubleType, toDouble},
+	{"string", false}:    {[][]exprKind{{kindString, kindInt, kindDouble, kindBool, kindTimestamp, kindDuration}}, stringType, toString},
+	{"timestamp", false}: {[][]exprKind{{kindString, kindTimestamp}}, timestampType, toTimestamp},
+	{"duration", false}:  {[][]exprKind{{kindString, kindDuration}}, durationType, toDuration},
+}
+
+func (c *compiler) compileCall(n *node) (*exprType, evalFunc) {
+	switch {
+	case n.name == "has" && !n.method:
+		return c.compileHas(n)
+	case n.name == "matches" && n.method:
+		return c.compileMatches(n)
+	}
+	f, ok := functions[functionKey{n.name, n.method}]
+	if !ok && n.method {
+		c.fail(n, "undeclared method '%s'", n.name)
+	}
+	if !ok {
+		c.fail(n, "undeclared function '%s'", n.name)
+	}
+	if len(n.args) != len(f.params) {
+		want := len(f.params)
+		if n.method {
+			want--
+		}
+		c.fail(n, "%s() takes %d arguments", n.name, want)
+	}
+
+	args := make([]evalFunc, len(n.args))
+	constant := true
+	for i, arg := range n.args {
+		var t *exprType
+		t, args[i] = c.compile(arg)
+		c.expect(arg, "argument of "+n.name+"()", t, f.params[i]...)
+		constant = constant && arg.kind == nodeLiteral
+	}
+
+	p := c.position(n)
+	call := func(a *activation) (interface{}, error) {
+		values := make([]interface{}, len(args))
+		for i, arg := range args {
+			v, err := arg(a)
+			if err != nil {
+				return nil, err
+			}
+			values[i] = v
+		}
+		return f.call(a, p, values)
+	}
+	if !constant {
+		return f.result, call
+	}
+
+	// Evaluate calls on literals, such as timestamp("2024-01-01T00:00:00Z"),
+	// once, so that invalid arguments are reported when compiling
+	v, err := call(&activation{})
+	if err != nil {
+		panic(compileFailure{err.(*ExpressionError)})
+	}
+	return f.result, func(*activation) (interface{}, error) { return v, nil }
+}
+
+// compileHas compiles the has() macro, which tests whether a map has a field
+func (c *compiler) compileHas(n *node) (*exprType, evalFunc) {
+	if len(n.args) != 1 || n.args[0].kind != nodeSelect {
+		c.fail(n, "has() takes a field selection, e.g. has(resource.properties.owner)")
+	}
+	sel := n.args[0]
+	c.recordPath(sel)
+	t, operand := c.compile(sel.args[0])
+	c.selectType(sel, t)
+
+	p, name := c.position(sel), sel.name
+	return boolType, func(a *activation) (interface{}, error) {
+		v, err := operand(a)
+		if err != nil {
+			return nil, err
+		}
+		m, ok := v.(map[string]interface{})
+		if !ok {
+			return nil, p.errorf("cannot test field '%s' of %s", name, typeName(v))
+		}
+		_, ok = m[name]
+		return ok, nil
+	}
+}
+
+// compileMatches compiles the matches() method, whose regular expression
+// must be a literal so that it compiles once
+func (c *compiler) compileMatches(n *node) (*exprType, evalFunc) {
+	if len(n.args) != 2 {
+		c.fail(n, "matches() takes 1 argument")
+	}
+	t, operand := c.compile(n.args[0])
+	c.expect(n.args[0], "receiver of matches()", t, kindString)
+	pattern, ok := n.args[1].value.(string)
+	if !ok || n.args[1].kind != nodeLiteral {
+		c.fail(n.args[1], "argument of matches() must be a string literal")
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		c.fail(n.args[1], "invalid regular expression: %v", err)
+	}
+
+	p := c.position(n)
+	return boolType, func(a *activation) (interface{}, error) {
+		v, err := operand(a)
+		if err != nil {
+			return nil, err
+		}
+		s, ok := v.(string)
+		if !ok {
+			return nil, p.errorf("no such overload: %s.matches()", typeName(v))
+		}
+		if err := a.charge(p, len(s)); err != nil {
+			return nil, err
+		}
+		return re.MatchString(s), nil
+	}
+}
+
+// noOverload reports arguments of the wrong types for a function
+func noOverload(p position, name string, args []interface{}) error {
+	types := make([]string, len(args))
+	for i, arg := range args {
+		types[i] = typeName(arg)
+	}
+	return p.errorf("no such overload: %s(%s)", name, strings.Join(types, ", "))
+}
+
+func size(a *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case string:
+		return int64(len([]rune(x))), nil
+	case []interface{}:
+		return int64(len(x)), nil
+	case map[string]interface{}:
+		return int64(len(x)), nil
+	}
+	return nil, noOverload(p, "size", args)
+}
+
+// stringTest returns a method that tests a string against another
+func stringTest(test func(s, arg string) bool) func(*activation, position, []interface{}) (interface{}, error) {
+	return func(a *activation, p position, args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		arg, ok2 := args[1].(string)
+		if !ok || !ok2 {
+			return nil, noOverload(p, "string method", args)
+		}
+		if err := a.charge(p, len(s)); err != nil {
+			return nil, err
+		}
+		return test(s, arg), nil
+	}
+}
+
+// stringMap returns a method that transforms a string
+func stringMap(transform func(string) string) func(*activation, position, []interface{}) (interface{}, error) {
+	return func(a *activation, p position, args []interface{}) (interface{}, error) {
+		s, ok := args[0].(string)
+		if !ok {
+			return nil, noOverload(p, "string method", args)
+		}
+		if err := a.charge(p, len(s)); err != nil {
+			return nil, err
+		}
+		return transform(s), nil
+	}
+}
+
+func toInt(_ *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case int64:
+		return x, nil
+	case float64:
+		if math.IsNaN(x) || x < math.MinInt64 || x >= math.MaxInt64 {
+			return nil, p.errorf("double %v is out of the int range", x)
+		}
+		return int64(x), nil
+	case string:
+		n, err := strconv.ParseInt(x, 10, 64)
+		if err != nil {
+			return nil, p.errorf("cannot convert %q to int", x)
+		}
+		return n, nil
+	case time.Time:
+		return x.Unix(), nil
+	}
+	return nil, noOverload(p, "int", args)
+}
+
+func toDouble(_ *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case int64:
+		return float64(x), nil
+	case float64:
+		return x, nil
+	case string:
+		f, err := strconv.ParseFloat(x, 64)
+		if err != nil {
+			return nil, p.errorf("cannot convert %q to double", x)
+		}
+		return f, nil
+	}
+	return nil, noOverload(p, "double", args)
+}
+
+func toString(_ *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case string:
+		return x, nil
+	case int64:
+		return strconv.FormatInt(x, 10), nil
+	case float64:
+		return strconv.FormatFloat(x, 'g', -1, 64), nil
+	case bool:
+		return strconv.FormatBool(x), nil
+	case time.Time:
+		return x.Format(time.RFC3339Nano), nil
+	case time.Duration:
+		return x.String(), nil
+	}
+	return nil, noOverload(p, "string", args)
+}
+
+func toTimestamp(_ *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case time.Time:
+		return x, nil
+	case string:
+		t, err := time.Parse(time.RFC3339, x)
+		if err != nil {
+			return nil, p.errorf("cannot convert %q to timestamp, want RFC 3339", x)
+		}
+		return t, nil
+	}
+	return nil, noOverload(p, "timestamp", args)
+}
+
+func toDuration(_ *activation, p position, args []interface{}) (interface{}, error) {
+	switch x := args[0].(type) {
+	case time.Duration:
+		return x, nil
+	case string:
+		d, err := time.ParseDuration(x)
+		if err != nil {
+			return nil, p.errorf("cannot convert %q to duration, e.g. \"1h30m\"", x)
+		}
+		return d, nil
+	}
+	return nil, noOverload(p, "duration", args)
+}
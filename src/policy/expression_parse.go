@@ -0,0 +1,445 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+)
+
+// tokenKind classifies the tokens of an expression
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenIdent
+	tokenInt
+	tokenDouble
+	tokenString
+	tokenPunct // Operators and delimiters
+)
+
+// token is a lexical token of an expression
+type token struct {
+	kind tokenKind
+	pos  int    // Byte offset in the source
+	text string // Source text; the unquoted value of a string
+}
+
+// String describes the token for error messages
+func (t token) String() string {
+	switch t.kind {
+	case tokenEOF:
+		return "end of expression"
+	case tokenString:
+		return strconv.Quote(t.text)
+	}
+	return "'" + t.text + "'"
+}
+
+// punctuation lists the operators and delimiters, longer ones first
+var punctuation = []string{
+	"&&", "||", "==", "!=", "<=", ">=",
+	"<", ">", "!", "+", "-", "*", "/", "%", "?", ":", ".", ",", "(", ")", "[", "]", "{", "}",
+}
+
+// lex splits an expression into tokens, ending with a tokenEOF
+func lex(src string) ([]token, error) {
+	var tokens []token
+	for i := 0; i < len(src); {
+		c := src[i]
+		switch {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r':
+			i++
+		case isIdentStart(c):
+			start := i
+			for i < len(src) && (isIdentStart(src[i]) || isDigit(src[i])) {
+				i++
+			}
+			tokens = append(tokens, token{tokenIdent, start, src[start:i]})
+		case isDigit(c):
+			start, kind := i, tokenInt
+			for i < len(src) && isDigit(src[i]) {
+				i++
+			}
+			if i+1 < len(src) && src[i] == '.' && isDigit(src[i+1]) {
+				kind = tokenDouble
+				for i++; i < len(src) && isDigit(src[i]); i++ {
+				}
+			}
+			if i < len(src) && (src[i] == 'e' || src[i] == 'E') {
+				j := i + 1
+				if j < len(src) && (src[j] == '+' || src[j] == '-') {
+					j++
+				}
+				if j < len(src) && isDigit(src[j]) {
+					kind = tokenDouble
+					for i = j; i < len(src) && isDigit(src[i]); i++ {
+					}
+				}
+			}
+			tokens = append(tokens, token{kind, start, src[start:i]})
+		case c == '"' || c == '\'':
+			s, end, err := lexString(src, i)
+			if err != nil {
+				return nil, err
+			}
+			tokens = append(tokens, token{tokenString, i, s})
+			i = end
+		default:
+			punct := ""
+			for _, p := range punctuation {
+				if strings.HasPrefix(src[i:], p) {
+					punct = p
+					break
+				}
+			}
+			if punct == "" {
+				return nil, positionOf(src, i).errorf("unexpected character %q", c)
+			}
+			tokens = append(tokens, token{tokenPunct, i, punct})
+			i += len(punct)
+		}
+	}
+	return append(tokens, token{tokenEOF, len(src), ""}), nil
+}
+
+// lexString reads the quoted string starting at src[start] and returns its
+// value and the offset following the closing quote
+func lexString(src string, start int) (string, int, error) {
+	quote := src[start]
+	var b strings.Builder
+	for i := start + 1; i < len(src); i++ {
+		switch c := src[i]; {
+		case c == quote:
+			return b.String(), i + 1, nil
+		case c == '\n':
+			return "", 0, positionOf(src, start).errorf("unterminated string")
+		case c == '\\' && i+1 < len(src):
+			i++
+			switch e := src[i]; e {
+			case 'n':
+				b.WriteByte('\n')
+			case 't':
+				b.WriteByte('\t')
+			case 'r':
+				b.WriteByte('\r')
+			case '\\', '"', '\'':
+				b.WriteByte(e)
+			default:
+				return "", 0, positionOf(src, i-1).errorf("unknown escape sequence \\%c", e)
+			}
+		default:
+			b.WriteByte(c)
+		}
+	}
+	return "", 0, positionOf(src, start).errorf("unterminated string")
+}
+
+func isIdentStart(c byte) bool {
+	return c == '_' || c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z'
+}
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+
+// nodeKind classifies the nodes of an expression's syntax tree
+type nodeKind int
+
+const (
+	nodeLiteral       nodeKind = iota // value
+	nodeIdent                         // name
+	nodeSelect                        // args[0].name
+	nodeIndex                         // args[0][args[1]]
+	nodeCall                          // name(args...), or args[0].name(args[1:]...) for a method
+	nodeUnary                         // name args[0]
+	nodeBinary                        // args[0] name args[1]
+	nodeConditional                   // args[0] ? args[1] : args[2]
+	nodeList                          // [args...]
+	nodeMap                           // {args[0]: args[1], ...}
+	nodeComprehension                 // args[0].name(variable, args[1]), for the exists and all macros
+)
+
+// node is a node of an expression's syntax tree
+type node struct {
+	kind     nodeKind
+	pos      int         // Byte offset of the node in the source
+	name     string      // Identifier, field, function or operator
+	value    interface{} // Value of a literal
+	args     []*node
+	method   bool   // Whether a call has a receiver
+	variable string // Variable bound by a comprehension
+}
+
+// binaryOperators lists the binary operators from the lowest precedence to the highest
+var binaryOperators = [][]string{
+	{"||"},
+	{"&&"},
+	{"==", "!=", "<", "<=", ">", ">=", "in"},
+	{"+", "-"},
+	{"*", "/", "%"},
+}
+
+// parser builds the syntax tree of an expression by recursive descent
+type parser struct {
+	src    string
+	tokens []token
+	next   int
+	depth  int // Current nesting of the expression
+}
+
+// parseFailure carries a syntax error out of the parser
+type parseFailure struct{ err *ExpressionError }
+
+// parseExpression parses an expression into its syntax tree
+func parseExpression(src string) (n *node, err error) {
+	if len(src) > expressionLengthLimit {
+		return nil, positionOf(src, 0).errorf("expression is longer than %d bytes", expressionLengthLimit)
+	}
+	tokens, err := lex(src)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &parser{src: src, tokens: tokens}
+	defer func() {
+		if r := recover(); r != nil {
+			f, ok := r.(parseFailure)
+			if !ok {
+				panic(r)
+			}
+			n, err = nil, f.err
+		}
+	}()
+	n = p.expr()
+	if t := p.peek(); t.kind != tokenEOF {
+		p.fail(t.pos, "unexpected %s", t)
+	}
+	return n, nil
+}
+
+// fail aborts parsing with an error at the offset
+func (p *parser) fail(pos int, format string, args ...interface{}) {
+	panic(parseFailure{positionOf(p.src, pos).errorf(format, args...)})
+}
+
+func (p *parser) peek() token { return p.tokens[p.next] }
+
+func (p *parser) advance() token {
+	t := p.tokens[p.next]
+	if t.kind != tokenEOF {
+		p.next++
+	}
+	return t
+}
+
+// accept consumes the next token if it is the punctuation
+func (p *parser) accept(punct string) bool {
+	if t := p.peek(); t.kind == tokenPunct && t.text == punct {
+		p.next++
+		return true
+	}
+	return false
+}
+
+// expect consumes the punctuation or fails
+func (p *parser) expect(punct string) {
+	if t := p.peek(); !p.accept(punct) {
+		p.fail(t.pos, "expected '%s', found %s", punct, t)
+	}
+}
+
+// nest guards against expressions nested too deeply to evaluate safely
+func (p *parser) nest() func() {
+	if p.depth++; p.depth > expressionDepthLimit {
+		p.fail(p.peek().pos, "expression is nested deeper than %d", expressionDepthLimit)
+	}
+	return func() { p.depth-- }
+}
+
+// expr parses a conditional expression: or ["?" expr ":" expr]
+func (p *parser) expr() *node {
+	defer p.nest()()
+
+	cond := p.binary(0)
+	t := p.peek()
+	if !p.accept("?") {
+		return cond
+	}
+	then := p.binary(0)
+	p.expect(":")
+	otherwise := p.expr()
+	return &node{kind: nodeConditional, pos: t.pos, args: []*node{cond, then, otherwise}}
+}
+
+// binary parses the left-associative operators of a precedence level and above
+func (p *parser) binary(level int) *node {
+	if level == len(binaryOperators) {
+		return p.unary()
+	}
+
+	left := p.binary(level + 1)
+	for {
+		t := p.peek()
+		if !isOperator(t, binaryOperators[level]) {
+			return left
+		}
+		p.advance()
+		right := p.binary(level + 1)
+		left = &node{kind: nodeBinary, pos: t.pos, name: t.text, args: []*node{left, right}}
+	}
+}
+
+// isOperator reports whether the token is one of the operators
+func isOperator(t token, operators []string) bool {
+	if t.kind != tokenPunct && (t.kind != tokenIdent || t.text != "in") {
+		return false
+	}
+	for _, op := range operators {
+		if t.text == op {
+			return true
+		}
+	}
+	return false
+}
+
+// unary parses a negation: {"!" | "-"} member
+func (p *parser) unary() *node {
+	t := p.peek()
+	if !p.accept("!") && !p.accept("-") {
+		return p.member()
+	}
+	defer p.nest()()
+
+	operand := p.unary()
+	if t.text == "-" && operand.kind == nodeLiteral {
+		switch v := operand.value.(type) {
+		case int64:
+			return &node{kind: nodeLiteral, pos: t.pos, value: -v}
+		case float64:
+			return &node{kind: nodeLiteral, pos: t.pos, value: -v}
+		}
+	}
+	return &node{kind: nodeUnary, pos: t.pos, name: t.text, args: []*node{operand}}
+}
+
+// member parses field selections, indexes and method calls: primary {"." ident ["(" args ")"] | "[" expr "]"}
+func (p *parser) member() *node {
+	n := p.primary()
+	for {
+		t := p.peek()
+		switch {
+		case p.accept("."):
+			name := p.advance()
+			if name.kind != tokenIdent {
+				p.fail(name.pos, "expected field or method name, found %s", name)
+			}
+			if !p.accept("(") {
+				n = &node{kind: nodeSelect, pos: name.pos, name: name.text, args: []*node{n}}
+				continue
+			}
+			args := p.args(")")
+			if name.text == "exists" || name.text == "all" {
+				n = p.comprehension(name, n, args)
+				continue
+			}
+			n = &node{kind: nodeCall, pos: name.pos, name: name.text, args: append([]*node{n}, args...), method: true}
+		case p.accept("["):
+			index := p.expr()
+			p.expect("]")
+			n = &node{kind: nodeIndex, pos: t.pos, args: []*node{n, index}}
+		default:
+			return n
+		}
+	}
+}
+
+// comprehension builds an exists or all macro, e.g. list.exists(x, x > 1)
+func (p *parser) comprehension(name token, target *node, args []*node) *node {
+	if len(args) != 2 || args[0].kind != nodeIdent {
+		p.fail(name.pos, "%s takes a variable name and a predicate", name.text)
+	}
+	return &node{
+		kind:     nodeComprehension,
+		pos:      name.pos,
+		name:     name.text,
+		variable: args[0].name,
+		args:     []*node{target, args[1]},
+	}
+}
+
+// primary parses a literal, identifier, function call, parenthesized expression, list or map
+func (p *parser) primary() *node {
+	t := p.advance()
+	switch t.kind {
+	case tokenInt:
+		v, err := strconv.ParseInt(t.text, 10, 64)
+		if err != nil {
+			p.fail(t.pos, "integer %s is out of range", t.text)
+		}
+		return &node{kind: nodeLiteral, pos: t.pos, value: v}
+	case tokenDouble:
+		v, err := strconv.ParseFloat(t.text, 64)
+		if err != nil {
+			p.fail(t.pos, "number %s is out of range", t.text)
+		}
+		return &node{kind: nodeLiteral, pos: t.pos, value: v}
+	case tokenString:
+		return &node{kind: nodeLiteral, pos: t.pos, value: t.text}
+	case tokenIdent:
+		switch t.text {
+		case "true", "false":
+			return &node{kind: nodeLiteral, pos: t.pos, value: t.text == "true"}
+		case "null":
+			return &node{kind: nodeLiteral, pos: t.pos, value: nil}
+		case "in":
+			p.fail(t.pos, "unexpected %s", t)
+		}
+		if p.accept("(") {
+			return &node{kind: nodeCall, pos: t.pos, name: t.text, args: p.args(")")}
+		}
+		return &node{kind: nodeIdent, pos: t.pos, name: t.text}
+	case tokenPunct:
+		switch t.text {
+		case "(":
+			n := p.expr()
+			p.expect(")")
+			return n
+		case "[":
+			return &node{kind: nodeList, pos: t.pos, args: p.args("]")}
+		case "{":
+			return &node{kind: nodeMap, pos: t.pos, args: p.entries()}
+		}
+	}
+	p.fail(t.pos, "unexpected %s", t)
+	return nil
+}
+
+// args parses a comma-separated list of expressions up to the closing delimiter
+func (p *parser) args(closing string) []*node {
+	var args []*node
+	for !p.accept(closing) {
+		if len(args) > 0 {
+			p.expect(",")
+			if p.accept(closing) {
+				break // Trailing comma
+			}
+		}
+		args = append(args, p.expr())
+	}
+	return args
+}
+
+// entries parses the key-value pairs of a map up to the closing brace
+func (p *parser) entries() []*node {
+	var entries []*node
+	for !p.accept("}") {
+		if len(entries) > 0 {
+			p.expect(",")
+			if p.accept("}") {
+				break
+			}
+		}
+		key := p.expr()
+		p.expect(":")
+		entries = append(entries, key, p.expr())
+	}
+	return entries
+}
@@ -0,0 +1,230 @@
+package policy
+
+import (
+	"errors"
+	"math"
+	"strings"
+	"testing"
+)
+
+// expressionRequest is the request the expression tests evaluate against
+var expressionRequest = Request{
+	Subject:  Entity{Type: "user", ID: "alice"},
+	Resource: Entity{Type: "doc", ID: "1"},
+	Action:   "read",
+	SubjectProperties: map[string]interface{}{
+		"max":  int64(math.MaxInt64),
+		"min":  int64(math.MinInt64),
+		"zero": int64(0),
+	},
+	Context: map[string]interface{}{"pattern": "a.*"},
+}
+
+func TestExpressionPrecedence(t *testing.T) {
+	tests := []string{
+		"1 + 2 * 3 == 7",
+		"(1 + 2) * 3 == 9",
+		"10 - 2 - 3 == 5",
+		"2 * 3 % 4 == 2",
+		"-2 * 3 == -6",
+		"7 / 2 == 3",
+		"!(!false && false)",
+		"true || false && false",
+		"!(false && false || false)",
+		"1 < 2 == true",
+		"1 == 1 == true",
+		"1 in [1, 2] && !(3 in [1, 2])",
+		"true ? 1 + 1 == 2 : false",
+		"false ? false : true ? true : false",
+		"subject.id + \"@\" + resource.type == \"alice@doc\"",
+	}
+	for _, src := range tests {
+		t.Run(src, func(t *testing.T) {
+			e, err := CompileExpression(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if ok, err := e.Evaluate(expressionRequest); !ok || err != nil {
+				t.Errorf("Evaluate = %v, %v, want true", ok, err)
+			}
+		})
+	}
+}
+
+func TestExpressionCompileErrors(t *testing.T) {
+	tests := []struct {
+		src          string
+		line, column int
+		message      string
+	}{
+		{"1 +", 1, 4, "unexpected end of expression"},
+		{"(1 + 2", 1, 7, "expected ')'"},
+		{"'abc", 1, 1, "unterminated string"},
+		{"1 + 2", 1, 3, "must be of type bool, not int"},
+		{"1 + \"a\" == 2", 1, 3, "no such overload: int + string"},
+		{"subject.id + 1 == 2", 1, 12, "no such overload: string + int"},
+		{"1 < 2 < 3", 1, 7, "no such overload: bool < int"},
+		{"\n  subject.nope == 1", 2, 11, "undefined field 'nope'"},
+		{"nope == 1", 1, 1, "undeclared reference to 'nope'"},
+		{"foo(1)", 1, 1, "undeclared function 'foo'"},
+		{"subject.id.matches(context.pattern)", 1, 28, "must be a string literal"},
+		{"subject.id.matches(\"[\")", 1, 20, "invalid regular expression"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			_, err := CompileExpression(tt.src)
+			var ee *ExpressionError
+			if !errors.As(err, &ee) {
+				t.Fatalf("error = %v, want an ExpressionError", err)
+			}
+			if ee.Line != tt.line || ee.Column != tt.column || !strings.Contains(ee.Message, tt.message) {
+				t.Errorf("error = %v, want %d:%d: ...%s...", err, tt.line, tt.column, tt.message)
+			}
+		})
+	}
+}
+
+func TestExpressionEvaluation(t *testing.T) {
+	tests := []struct {
+		src  string
+		want bool
+		err  string // Part of the expected evaluation error; empty for none
+	}{
+		// Short-circuit evaluation: a decisive operand hides an error in the other
+		{src: "true || subject.properties.missing == 1", want: true},
+		{src: "subject.properties.missing == 1 || true", want: true},
+		{src: "false && subject.properties.missing == 1"},
+		{src: "subject.properties.missing == 1 && false"},
+		{src: "true ? true : subject.properties.missing == 1", want: true},
+		{src: "subject.properties.missing == 1 || false", err: "no such key: 'missing'"},
+		{src: "has(subject.properties.missing) && subject.properties.missing == 1"},
+
+		// Integer arithmetic
+		{src: "subject.properties.max + 1 > 0", err: "integer overflow"},
+		{src: "subject.properties.max * 2 > 0", err: "integer overflow"},
+		{src: "subject.properties.min - 1 < 0", err: "integer overflow"},
+		{src: "-subject.properties.min > 0", err: "integer overflow"},
+		{src: "subject.properties.min / -1 < 0", err: "integer overflow"},
+		{src: "9223372036854775807 + 1 > 0", err: "integer overflow"},
+		{src: "subject.properties.max - 1 > 0", want: true},
+		{src: "1 / subject.properties.zero == 0", err: "division by zero"},
+		{src: "1 % subject.properties.zero == 0", err: "modulus by zero"},
+		{src: "1.0 / 0.0 > 1.0", want: true},
+
+		// Regular expressions
+		{src: "subject.id.matches(\"^a\")", want: true},
+		{src: "resource.id.matches(\"^a\")"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			e, err := CompileExpression(tt.src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := e.Evaluate(expressionRequest)
+			switch {
+			case tt.err != "" && (err == nil || !strings.Contains(err.Error(), tt.err)):
+				t.Errorf("error = %v, want %s", err, tt.err)
+			case tt.err == "" && err != nil:
+				t.Errorf("error = %v", err)
+			case got != tt.want:
+				t.Errorf("Evaluate = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpressionCostLimit(t *testing.T) {
+	list := "[" + strings.Repeat("1, ", 9) + "1]"
+	tests := []struct {
+		name  string
+		depth int
+		err   bool
+	}{
+		{name: "below the limit", depth: 4},
+		{name: "above the limit", depth: 5, err: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			// Nested comprehensions over ten elements each, 10^depth iterations
+			src := "true"
+			for i := 0; i < tt.depth; i++ {
+				src = list + ".all(x, " + src + ")"
+			}
+			e, err := CompileExpression(src)
+			if err != nil {
+				t.Fatal(err)
+			}
+			ok, err := e.Evaluate(expressionRequest)
+			if tt.err {
+				if err == nil || !strings.Contains(err.Error(), "cost limit") {
+					t.Errorf("Evaluate = %v, %v, want a cost limit error", ok, err)
+				}
+			} else if !ok || err != nil {
+				t.Errorf("Evaluate = %v, %v, want true", ok, err)
+			}
+		})
+	}
+}
+
+func TestExpressionCostLimitWithShortCircuit(t *testing.T) {
+	// The limit holds even when a decisive operand would hide the error
+	list := "[" + strings.Repeat("1, ", 9) + "1]"
+	src := "true"
+	for i := 0; i < 5; i++ {
+		src = list + ".all(x, " + src + ")"
+	}
+	e, err := CompileExpression(src + " || true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if ok, err := e.Evaluate(expressionRequest); err == nil {
+		t.Errorf("Evaluate = %v, nil, want a cost limit error", ok)
+	}
+}
+
+func TestExpressionLimits(t *testing.T) {
+	tests := []struct {
+		name string
+		src  string
+		err  string
+	}{
+		{name: "length", src: strings.Repeat(" ", expressionLengthLimit) + "true", err: "longer than"},
+		{name: "depth", src: strings.Repeat("(", expressionDepthLimit+1) + "true" + strings.Repeat(")", expressionDepthLimit+1), err: "nested deeper than"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := CompileExpression(tt.src); err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("error = %v, want %s", err, tt.err)
+			}
+		})
+	}
+}
+
+func TestRedactExpression(t *testing.T) {
+	sensitive := func(path string) bool {
+		return strings.Contains(path, "token") || strings.Contains(path, "api_key") || strings.Contains(path, "password")
+	}
+	tests := []struct {
+		src  string
+		want string
+	}{
+		{src: `context.api_key == "s3cr3t"`, want: `context.api_key == "***"`},
+		{src: `'s3cr3t' != context.api_key`, want: `"***" != context.api_key`},
+		{src: `context.token in ["a", "b"] && resource.id == "doc"`, want: `context.token in ["***", "***"] && resource.id == "doc"`},
+		{src: `context.token.startsWith("tok-") || subject.id == "alice"`, want: `context.token.startsWith("***") || subject.id == "alice"`},
+		{src: `context["password"] == "hunter2"`, want: `context["password"] == "***"`},
+		{src: `size(context.password + "pepper") > 8`, want: `size(context.password + "***") > 8`},
+		{src: `has(context.token) && context.user == "bob"`, want: `has(context.token) && context.user == "bob"`},
+		{src: `subject.id == "alice" ? context.api_key == "k1" : context.api_key == "k2"`, want: `subject.id == "alice" ? context.api_key == "***" : context.api_key == "***"`},
+		{src: `resource.properties.owner == subject.id`, want: `resource.properties.owner == subject.id`},
+		{src: `context.token == "unterminated`, want: `***`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.src, func(t *testing.T) {
+			if got := RedactExpression(tt.src, sensitive, "***"); got != tt.want {
+				t.Errorf("RedactExpression = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
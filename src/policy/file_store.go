@@ -0,0 +1,929 @@
+package policy
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// Files kept in a FileStore directory
+const (
+	snapshotFile = "snapshot.json"
+	logFile      = "policies.log"
+)
+
+// Operations recorded in the append-only log
+const (
+	opPut     = "put"     // Add or replace policies by ID
+	opDelete  = "delete"  // Remove a policy by ID
+	opReplace = "replace" // Remove policies by ID, then add or replace policies
+
+	// Remove policies and groups by ID, then add or replace policies and groups
+	opReplaceAll = "replace_policies_groups"
+
+	opPutRole       = "put_role"       // Add or replace a role
+	opDeleteRole    = "delete_role"    // Remove a role by ID
+	opPutGroup      = "put_group"      // Add or replace a group
+	opDeleteGroup   = "delete_group"   // Remove a group by ID
+	opReplaceGroups = "replace_groups" // Remove groups by ID, then add or replace groups
+	opPutBinding    = "put_binding"    // Add or replace a role binding
+	opDeleteBinding = "delete_binding" // Remove a role binding by ID
+
+	opPutNamespace    = "put_namespace"    // Add or replace a relation namespace
+	opDeleteNamespace = "delete_namespace" // Remove a relation namespace by name
+	opWriteTuples     = "write_tuples"     // Remove and add relation tuples
+
+	opPutPolicySet    = "put_policy_set"    // Add or replace a policy set
+	opDeletePolicySet = "delete_policy_set" // Remove a policy set by name
+
+	opPutResourceNode    = "put_resource_node"    // Add or replace a resource's place in the hierarchy
+	opDeleteResourceNode = "delete_resource_node" // Remove a resource from the hierarchy
+)
+
+// logEntry is a single line of the append-only log
+type logEntry struct {
+	Op       string   `json:"op"`
+	Policies []Policy `json:"policies,omitempty"`
+	ID       string   `json:"id,omitempty"`
+	IDs      []string `json:"ids,omitempty"`
+
+	Role     *Role        `json:"role,omitempty"`
+	Group    *Group       `json:"group,omitempty"`
+	Groups   []Group      `json:"groups,omitempty"`
+	GroupIDs []string     `json:"group_ids,omitempty"` // IDs of the groups to remove by a replace_policies_groups entry
+	Binding  *RoleBinding `json:"binding,omitempty"`
+
+	Namespace *Namespace      `json:"namespace,omitempty"`
+	Writes    []RelationTuple `json:"writes,omitempty"`
+	Deletes   []RelationTuple `json:"deletes,omitempty"`
+
+	PolicySet *PolicySet `json:"policy_set,omitempty"`
+
+	ResourceNode *ResourceNode `json:"resource_node,omitempty"`
+	Resource     *Entity       `json:"resource,omitempty"`
+}
+
+// snapshot is the content of the snapshot file
+type snapshot struct {
+	Policies     []Policy        `json:"policies"`
+	Roles        []Role          `json:"roles,omitempty"`
+	Groups       []Group         `json:"groups,omitempty"`
+	RoleBindings []RoleBinding   `json:"role_bindings,omitempty"`
+	Namespaces   []Namespace     `json:"namespaces,omitempty"`
+	Tuples       []RelationTuple `json:"tuples,omitempty"`
+	PolicySets   []PolicySet     `json:"policy_sets,omitempty"`
+	Hierarchy    []ResourceNode  `json:"hierarchy,omitempty"`
+	Time         time.Time       `json:"time"`
+}
+
+// FileStoreOptions configures a FileStore
+type FileStoreOptions struct {
+	SnapshotInterval time.Duration // How often to compact the log into a snapshot; 0 disables periodic snapshots
+	SnapshotEvery    int           // Number of logged operations that triggers a snapshot; 0 disables the threshold
+}
+
+// FileStore is a durable policy store. Every change is appended to a log
+// file before it is applied in memory, and the log is periodically compacted
+// into a snapshot. On open, the snapshot is loaded and the log replayed.
+type FileStore struct {
+	mem  *MemoryStore // Serves all reads
+	dir  string
+	opts FileStoreOptions
+
+	mu   sync.Mutex // Serializes changes and log writes
+	log  *os.File
+	ops  int // Operations logged since the last snapshot
+	stop chan struct{}
+	done chan struct{}
+}
+
+// OpenFileStore opens or creates a file store in the given directory
+func OpenFileStore(dir string, opts FileStoreOptions) (*FileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("create store directory: %w", err)
+	}
+
+	s := &FileStore{
+		mem:  NewMemoryStore(),
+		dir:  dir,
+		opts: opts,
+		stop: make(chan struct{}),
+		done: make(chan struct{}),
+	}
+
+	if err := s.loadSnapshot(); err != nil {
+		return nil, err
+	}
+	if err := s.replayLog(); err != nil {
+		return nil, err
+	}
+
+	f, err := os.OpenFile(filepath.Join(dir, logFile), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return nil, fmt.Errorf("open policy log: %w", err)
+	}
+	s.log = f
+
+	go s.snapshotLoop()
+	return s, nil
+}
+
+// loadSnapshot loads the snapshot file, if one exists
+func (s *FileStore) loadSnapshot() error {
+	data, err := os.ReadFile(filepath.Join(s.dir, snapshotFile))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("read snapshot: %w", err)
+	}
+
+	var snap snapshot
+	if err := json.Unmarshal(data, &snap); err != nil {
+		return fmt.Errorf("parse snapshot: %w", err)
+	}
+	if _, err := s.mem.UpsertPolicies(snap.Policies); err != nil {
+		return fmt.Errorf("load snapshot: %w", err)
+	}
+	if err := s.mem.restoreRBAC(snap.Roles, snap.Groups, snap.RoleBindings); err != nil {
+		return fmt.Errorf("load snapshot: %w", err)
+	}
+	s.mem.update(func(st *state) error {
+		for _, ns := range snap.Namespaces {
+			st.putNamespace(ns)
+		}
+		st.writeTuples(snap.Tuples, nil)
+		for _, ps := range snap.PolicySets {
+			st.putPolicySet(ps)
+		}
+		for _, n := range snap.Hierarchy {
+			st.putResourceNode(n)
+		}
+		return nil
+	})
+	return nil
+}
+
+// replayLog applies the operations logged since the last snapshot. Replaying
+// is idempotent, so operations already contained in the snapshot are harmless.
+// A torn final line, left by a crash during a write, is discarded.
+func (s *FileStore) replayLog() error {
+	path := filepath.Join(s.dir, logFile)
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("open policy log: %w", err)
+	}
+	defer f.Close()
+
+	var valid int64
+	reader := bufio.NewReader(f)
+	for line := 1; ; line++ {
+		data, err := reader.ReadBytes('\n')
+		if len(data) > 0 && data[len(data)-1] != '\n' {
+			log.Printf("Discarding incomplete policy log entry at line %d", line)
+			break
+		}
+		if len(data) > 0 {
+			var entry logEntry
+			if jsonErr := json.Unmarshal(data, &entry); jsonErr != nil {
+				return fmt.Errorf("policy log line %d: %w", line, jsonErr)
+			}
+			if applyErr := s.apply(entry); applyErr != nil {
+				return fmt.Errorf("policy log line %d: %w", line, applyErr)
+			}
+			s.ops++
+			valid += int64(len(data))
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	// Drop any torn tail so that new entries start on a clean line
+	return os.Truncate(path, valid)
+}
+
+// apply applies a log entry to the in-memory state
+func (s *FileStore) apply(entry logEntry) error {
+	switch entry.Op {
+	case opPut:
+		_, err := s.mem.UpsertPolicies(entry.Policies)
+		return err
+	case opDelete:
+		if err := s.mem.RemovePolicy(entry.ID); err != nil && !errors.Is(err, ErrPolicyNotFound) {
+			return err
+		}
+		return nil
+	case opReplace:
+		return s.mem.ReplacePolicies(entry.IDs, entry.Policies)
+
+	// Role changes were checked before they were logged. They are applied
+	// unchecked because a replay over a newer snapshot passes through states
+	// that never existed, e.g. a binding whose role the snapshot has removed.
+	case opPutRole, opPutGroup, opPutBinding:
+		if entry.Role == nil && entry.Group == nil && entry.Binding == nil {
+			return fmt.Errorf("%s entry without a value", entry.Op)
+		}
+		s.mem.applyRBAC(func(rs *rbacState) {
+			switch {
+			case entry.Role != nil:
+				rs.roles[entry.Role.ID] = *entry.Role
+			case entry.Group != nil:
+				rs.groups[entry.Group.ID] = *entry.Group
+			default:
+				rs.bindings[entry.Binding.ID] = *entry.Binding
+			}
+		})
+		return nil
+	case opReplaceGroups:
+		s.mem.applyRBAC(func(rs *rbacState) {
+			replaceGroups(entry.IDs, entry.Groups)(rs)
+		})
+		return nil
+	case opReplaceAll:
+		if err := validateReplacement(entry.Policies, entry.Groups); err != nil {
+			return err
+		}
+		return s.mem.update(func(st *state) error {
+			rs := st.rbac.clone()
+			replaceGroups(entry.GroupIDs, entry.Groups)(rs)
+			rs.reindex()
+			st.rbac = rs
+			st.replace(entry.IDs, entry.Policies)
+			return nil
+		})
+	case opDeleteRole, opDeleteGroup, opDeleteBinding:
+		s.mem.applyRBAC(func(rs *rbacState) {
+			switch entry.Op {
+			case opDeleteRole:
+				delete(rs.roles, entry.ID)
+			case opDeleteGroup:
+				delete(rs.groups, entry.ID)
+			default:
+				delete(rs.bindings, entry.ID)
+			}
+		})
+		return nil
+
+	// Relation changes are applied unchecked for the same reason
+	case opPutNamespace, opDeleteNamespace, opWriteTuples:
+		if entry.Op == opPutNamespace && entry.Namespace == nil {
+			return fmt.Errorf("%s entry without a value", entry.Op)
+		}
+		s.mem.update(func(st *state) error {
+			switch entry.Op {
+			case opPutNamespace:
+				st.putNamespace(*entry.Namespace)
+			case opDeleteNamespace:
+				st.removeNamespace(entry.ID)
+			default:
+				st.writeTuples(entry.Writes, entry.Deletes)
+			}
+			return nil
+		})
+		return nil
+
+	case opPutPolicySet:
+		if entry.PolicySet == nil {
+			return fmt.Errorf("%s entry without a value", entry.Op)
+		}
+		s.mem.update(func(st *state) error {
+			st.putPolicySet(*entry.PolicySet)
+			return nil
+		})
+		return nil
+	case opDeletePolicySet:
+		s.mem.update(func(st *state) error {
+			st.removePolicySet(entry.ID)
+			return nil
+		})
+		return nil
+
+	// Hierarchy changes were checked for cycles before they were logged
+	case opPutResourceNode:
+		if entry.ResourceNode == nil {
+			return fmt.Errorf("%s entry without a value", entry.Op)
+		}
+		s.mem.update(func(st *state) error {
+			st.putResourceNode(*entry.ResourceNode)
+			return nil
+		})
+		return nil
+	case opDeleteResourceNode:
+		if entry.Resource == nil {
+			return fmt.Errorf("%s entry without a value", entry.Op)
+		}
+		s.mem.update(func(st *state) error {
+			st.removeResourceNode(*entry.Resource)
+			return nil
+		})
+		return nil
+	default:
+		return fmt.Errorf("unknown operation %q", entry.Op)
+	}
+}
+
+// commit appends an entry to the log, syncs it to disk and applies it in
+// memory. The caller must hold s.mu.
+func (s *FileStore) commit(entry logEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	info, err := s.log.Stat()
+	if err != nil {
+		return fmt.Errorf("stat policy log: %w", err)
+	}
+	if _, err := s.log.Write(append(data, '\n')); err != nil {
+		s.rollback(info.Size())
+		return fmt.Errorf("write policy log: %w", err)
+	}
+	if err := s.log.Sync(); err != nil {
+		s.rollback(info.Size())
+		return fmt.Errorf("sync policy log: %w", err)
+	}
+	if err := s.apply(entry); err != nil {
+		// Drop the entry, or replaying it would fail on the next open
+		s.rollback(info.Size())
+		return err
+	}
+
+	s.ops++
+	if s.opts.SnapshotEvery > 0 && s.ops >= s.opts.SnapshotEvery {
+		if err := s.snapshotLocked(); err != nil {
+			log.Printf("Failed to write policy snapshot: %v", err)
+		}
+	}
+	return nil
+}
+
+// rollback truncates the log to the given size, dropping an entry that was
+// not applied. The caller must hold s.mu.
+func (s *FileStore) rollback(size int64) {
+	if err := s.log.Truncate(size); err != nil {
+		log.Printf("Failed to drop unapplied policy log entry: %v", err)
+		return
+	}
+	if err := s.log.Sync(); err != nil {
+		log.Printf("Failed to drop unapplied policy log entry: %v", err)
+	}
+}
+
+// Snapshot writes the current policies to the snapshot file and truncates the log
+func (s *FileStore) Snapshot() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.snapshotLocked()
+}
+
+// snapshotLocked writes a snapshot. The caller must hold s.mu.
+func (s *FileStore) snapshotLocked() error {
+	data, err := json.Marshal(snapshot{
+		Policies:     s.mem.ListPolicies(PolicyFilter{}),
+		Roles:        s.mem.ListRoles(),
+		Groups:       s.mem.ListGroups(),
+		RoleBindings: s.mem.ListRoleBindings(),
+		Namespaces:   s.mem.ListNamespaces(),
+		Tuples:       s.mem.ReadRelationTuples(TupleFilter{}),
+		PolicySets:   s.mem.ListPolicySets(),
+		Hierarchy:    s.mem.load().resourceNodes(),
+		Time:         time.Now().UTC(),
+	})
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that a crash never leaves a partial snapshot
+	tmp := filepath.Join(s.dir, snapshotFile+".tmp")
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("write snapshot: %w", err)
+	}
+	if err := os.Rename(tmp, filepath.Join(s.dir, snapshotFile)); err != nil {
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+	if err := syncDir(s.dir); err != nil {
+		return fmt.Errorf("install snapshot: %w", err)
+	}
+
+	if err := s.log.Truncate(0); err != nil {
+		return fmt.Errorf("truncate policy log: %w", err)
+	}
+	s.ops = 0
+	return nil
+}
+
+// snapshotLoop takes periodic snapshots until the store is closed
+func (s *FileStore) snapshotLoop() {
+	defer close(s.done)
+
+	if s.opts.SnapshotInterval <= 0 {
+		<-s.stop
+		return
+	}
+
+	ticker := time.NewTicker(s.opts.SnapshotInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			s.mu.Lock()
+			if s.ops > 0 {
+				if err := s.snapshotLocked(); err != nil {
+					log.Printf("Failed to write policy snapshot: %v", err)
+				}
+			}
+			s.mu.Unlock()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// Close writes a final snapshot and closes the log
+func (s *FileStore) Close() error {
+	close(s.stop)
+	<-s.done
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var err error
+	if s.ops > 0 {
+		err = s.snapshotLocked()
+	}
+	if closeErr := s.log.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// AddPolicy validates a policy, logs it and adds it to the store
+func (s *FileStore) AddPolicy(p Policy) (Policy, error) {
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if p.ID == "" {
+		p.ID = newPolicyID()
+	} else if _, err := s.mem.GetPolicy(p.ID); err == nil {
+		return Policy{}, fmt.Errorf("%w: %s", ErrPolicyExists, p.ID)
+	}
+
+	if err := s.commit(logEntry{Op: opPut, Policies: []Policy{p}}); err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// UpdatePolicy validates a policy, logs it and replaces the stored policy with the same ID
+func (s *FileStore) UpdatePolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetPolicy(p.ID); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opPut, Policies: []Policy{p}})
+}
+
+// UpsertPolicies validates a batch of policies, logs it as a single entry and stores it
+func (s *FileStore) UpsertPolicies(policies []Policy) ([]Policy, error) {
+	stored := make([]Policy, len(policies))
+	for i, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("policy %d: %w", i, err)
+		}
+		if p.ID == "" {
+			p.ID = newPolicyID()
+		}
+		stored[i] = p
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.commit(logEntry{Op: opPut, Policies: stored}); err != nil {
+		return nil, err
+	}
+	return stored, nil
+}
+
+// RemovePolicy logs the removal of a policy and removes it from the store
+func (s *FileStore) RemovePolicy(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetPolicy(id); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDelete, ID: id})
+}
+
+// ReplacePolicies logs and applies an atomic removal and upsert of policies as a single entry
+func (s *FileStore) ReplacePolicies(remove []string, policies []Policy) error {
+	if err := validateReplacement(policies, nil); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commit(logEntry{Op: opReplace, IDs: remove, Policies: policies})
+}
+
+// RemoveExpiredPolicies logs the removal of the policies whose validity has
+// ended at the given time, removes them and returns them
+func (s *FileStore) RemoveExpiredPolicies(now time.Time) ([]Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expired := s.mem.load().expired(now)
+	if len(expired) == 0 {
+		return nil, nil
+	}
+	ids := make([]string, len(expired))
+	for i, p := range expired {
+		ids[i] = p.ID
+	}
+	if err := s.commit(logEntry{Op: opReplace, IDs: ids}); err != nil {
+		return nil, err
+	}
+	return expired, nil
+}
+
+// CheckPolicy checks if the subject may perform the action on the resource
+func (s *FileStore) CheckPolicy(subject, resource Entity, action string) bool {
+	return s.mem.CheckPolicy(subject, resource, action)
+}
+
+// Evaluate returns the decision for the request
+func (s *FileStore) Evaluate(req Request) Decision {
+	return s.mem.Evaluate(req)
+}
+
+// Explain returns the decision for the request and how it was reached
+func (s *FileStore) Explain(req Request) (Decision, Trace) {
+	return s.mem.Explain(req)
+}
+
+// ListPolicies returns the policies that match the filter
+func (s *FileStore) ListPolicies(filter PolicyFilter) []Policy {
+	return s.mem.ListPolicies(filter)
+}
+
+// CountPolicies returns the number of stored policies
+func (s *FileStore) CountPolicies() int {
+	return s.mem.CountPolicies()
+}
+
+// Generation returns a number that changes with every change to the store's content
+func (s *FileStore) Generation() uint64 {
+	return s.mem.Generation()
+}
+
+// GetPolicy returns the policy with the given ID
+func (s *FileStore) GetPolicy(id string) (Policy, error) {
+	return s.mem.GetPolicy(id)
+}
+
+// FindSubjectsForResource returns a page of subjects allowed to perform the action on the resource
+func (s *FileStore) FindSubjectsForResource(req Request, page Page, opts SubjectSearch) ([]Entity, string) {
+	return s.mem.FindSubjectsForResource(req, page, opts)
+}
+
+// FindResourcesForSubject returns a page of resources the subject may perform the action on
+func (s *FileStore) FindResourcesForSubject(req Request, page Page) ([]Entity, string) {
+	return s.mem.FindResourcesForSubject(req, page)
+}
+
+// FindActionsForSubjectAndResource returns a page of actions the subject may perform on the resource
+func (s *FileStore) FindActionsForSubjectAndResource(req Request, page Page) ([]string, string) {
+	return s.mem.FindActionsForSubjectAndResource(req, page)
+}
+
+// ListRoles returns all roles, ordered by ID
+func (s *FileStore) ListRoles() []Role {
+	return s.mem.ListRoles()
+}
+
+// GetRole returns the role with the given ID
+func (s *FileStore) GetRole(id string) (Role, error) {
+	return s.mem.GetRole(id)
+}
+
+// PutRole validates a role, logs it and adds or replaces it
+func (s *FileStore) PutRole(r Role) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(putRole(r)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opPutRole, Role: &r})
+}
+
+// RemoveRole logs the removal of a role and removes it from the store
+func (s *FileStore) RemoveRole(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(removeRole(id)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeleteRole, ID: id})
+}
+
+// ListGroups returns all groups, ordered by ID
+func (s *FileStore) ListGroups() []Group {
+	return s.mem.ListGroups()
+}
+
+// GetGroup returns the group with the given ID
+func (s *FileStore) GetGroup(id string) (Group, error) {
+	return s.mem.GetGroup(id)
+}
+
+// PutGroup validates a group, logs it and adds or replaces it
+func (s *FileStore) PutGroup(g Group) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(putGroup(g)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opPutGroup, Group: &g})
+}
+
+// ReplaceGroups validates a batch of groups, then logs and applies the removal and the batch as a single entry
+func (s *FileStore) ReplaceGroups(remove []string, groups []Group) error {
+	if err := validateReplacement(nil, groups); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(replaceGroups(remove, groups)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opReplaceGroups, IDs: remove, Groups: groups})
+}
+
+// ReplacePoliciesAndGroups validates batches of policies and groups, then
+// logs and applies the replacement of both as a single entry
+func (s *FileStore) ReplacePoliciesAndGroups(removePolicies []string, policies []Policy, removeGroups []string, groups []Group) error {
+	if err := validateReplacement(policies, groups); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(replaceGroups(removeGroups, groups)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opReplaceAll, IDs: removePolicies, Policies: policies, GroupIDs: removeGroups, Groups: groups})
+}
+
+// RemoveGroup logs the removal of a group and removes it from the store
+func (s *FileStore) RemoveGroup(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(removeGroup(id)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeleteGroup, ID: id})
+}
+
+// ListRoleBindings returns all role bindings, ordered by ID
+func (s *FileStore) ListRoleBindings() []RoleBinding {
+	return s.mem.ListRoleBindings()
+}
+
+// GetRoleBinding returns the role binding with the given ID
+func (s *FileStore) GetRoleBinding(id string) (RoleBinding, error) {
+	return s.mem.GetRoleBinding(id)
+}
+
+// CountRoleBindings returns the number of stored role bindings
+func (s *FileStore) CountRoleBindings() int {
+	return s.mem.CountRoleBindings()
+}
+
+// AddRoleBinding validates a role binding, logs it and adds it to the store
+func (s *FileStore) AddRoleBinding(b RoleBinding) (RoleBinding, error) {
+	if err := b.Validate(); err != nil {
+		return RoleBinding{}, err
+	}
+	if b.ID == "" {
+		b.ID = newPolicyID()
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(addRoleBinding(b)); err != nil {
+		return RoleBinding{}, err
+	}
+	if err := s.commit(logEntry{Op: opPutBinding, Binding: &b}); err != nil {
+		return RoleBinding{}, err
+	}
+	return b, nil
+}
+
+// RemoveRoleBinding logs the removal of a role binding and removes it from the store
+func (s *FileStore) RemoveRoleBinding(id string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.checkRBAC(removeRoleBinding(id)); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeleteBinding, ID: id})
+}
+
+// CombiningAlgorithm returns the algorithm that combines policy sets and policies without a set
+func (s *FileStore) CombiningAlgorithm() CombiningAlgorithm {
+	return s.mem.CombiningAlgorithm()
+}
+
+// SetCombiningAlgorithm changes the algorithm that combines policy sets and
+// policies without a set. It is configuration rather than content and is not logged.
+func (s *FileStore) SetCombiningAlgorithm(a CombiningAlgorithm) error {
+	return s.mem.SetCombiningAlgorithm(a)
+}
+
+// ListPolicySets returns all policy sets, ordered by name
+func (s *FileStore) ListPolicySets() []PolicySet {
+	return s.mem.ListPolicySets()
+}
+
+// GetPolicySet returns the policy set with the given name
+func (s *FileStore) GetPolicySet(name string) (PolicySet, error) {
+	return s.mem.GetPolicySet(name)
+}
+
+// PutPolicySet validates a policy set, logs it and adds or replaces it
+func (s *FileStore) PutPolicySet(ps PolicySet) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commit(logEntry{Op: opPutPolicySet, PolicySet: &ps})
+}
+
+// RemovePolicySet logs the removal of a policy set and removes it from the store
+func (s *FileStore) RemovePolicySet(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetPolicySet(name); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeletePolicySet, ID: name})
+}
+
+// GetResourceNode returns the place of a resource in the hierarchy
+func (s *FileStore) GetResourceNode(resource Entity) (ResourceNode, error) {
+	return s.mem.GetResourceNode(resource)
+}
+
+// ListResourceChildren returns the resources registered directly below the parent, ordered by resource
+func (s *FileStore) ListResourceChildren(parent Entity) []ResourceNode {
+	return s.mem.ListResourceChildren(parent)
+}
+
+// PutResourceNode validates a resource node, logs it and adds or replaces the resource's place in the hierarchy
+func (s *FileStore) PutResourceNode(n ResourceNode) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.load().checkResourceNode(n); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opPutResourceNode, ResourceNode: &n})
+}
+
+// RemoveResourceNode logs the removal of a resource from the hierarchy and removes it from the store
+func (s *FileStore) RemoveResourceNode(resource Entity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetResourceNode(resource); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeleteResourceNode, Resource: &resource})
+}
+
+// ListNamespaces returns all relation namespaces, ordered by name
+func (s *FileStore) ListNamespaces() []Namespace {
+	return s.mem.ListNamespaces()
+}
+
+// GetNamespace returns the relation namespace of the given object type
+func (s *FileStore) GetNamespace(name string) (Namespace, error) {
+	return s.mem.GetNamespace(name)
+}
+
+// PutNamespace validates a relation namespace, logs it and adds or replaces it
+func (s *FileStore) PutNamespace(ns Namespace) error {
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.commit(logEntry{Op: opPutNamespace, Namespace: &ns})
+}
+
+// RemoveNamespace logs the removal of a relation namespace and removes it from the store
+func (s *FileStore) RemoveNamespace(name string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, err := s.mem.GetNamespace(name); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opDeleteNamespace, ID: name})
+}
+
+// WriteRelationTuples validates relation tuples, then logs and applies their removal and addition as a single entry
+func (s *FileStore) WriteRelationTuples(writes, deletes []RelationTuple) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.mem.load().checkTuples(writes); err != nil {
+		return err
+	}
+	return s.commit(logEntry{Op: opWriteTuples, Writes: writes, Deletes: deletes})
+}
+
+// ReadRelationTuples returns the relation tuples that match the filter
+func (s *FileStore) ReadRelationTuples(filter TupleFilter) []RelationTuple {
+	return s.mem.ReadRelationTuples(filter)
+}
+
+// writeFileSync writes data to a file and syncs it to disk
+func writeFileSync(path string, data []byte) error {
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0o644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Sync(); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// syncDir syncs a directory to disk, making a rename within it durable
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return err
+	}
+	if err := d.Sync(); err != nil {
+		d.Close()
+		return err
+	}
+	return d.Close()
+}
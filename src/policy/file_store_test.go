@@ -0,0 +1,61 @@
+package policy
+
+import "testing"
+
+// TestFileStoreDropsUnappliedEntry checks that an entry whose apply fails is
+// removed from the log, so that the store still opens afterwards
+func TestFileStoreDropsUnappliedEntry(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := Policy{ID: "p1", Subject: Entity{Type: "user", ID: "alice"}, Resource: Entity{Type: "doc", ID: "1"}, Action: "read", Allow: true}
+	if _, err := s.AddPolicy(p); err != nil {
+		t.Fatal(err)
+	}
+	s.mu.Lock()
+	err = s.commit(logEntry{Op: "unknown"})
+	s.mu.Unlock()
+	if err == nil {
+		t.Fatal("commit of an unknown operation succeeded")
+	}
+
+	// Reopen the directory as after a crash, without the snapshot Close takes
+	reopened, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatalf("reopen after a failed apply: %v", err)
+	}
+	defer reopened.Close()
+	if _, err := reopened.GetPolicy("p1"); err != nil {
+		t.Fatalf("policy logged before the failed apply: %v", err)
+	}
+}
+
+// TestFileStoreReplacesPoliciesAndGroups checks that a replacement of
+// policies and groups is replayed from the log
+func TestFileStoreReplacesPoliciesAndGroups(t *testing.T) {
+	dir := t.TempDir()
+	s, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+
+	p := Policy{ID: "p1", Subject: Entity{Type: GroupType, ID: "eng"}, Resource: Entity{Type: "doc", ID: "1"}, Action: "read", Allow: true}
+	g := Group{ID: "eng", Members: []Entity{{Type: "user", ID: "alice"}}}
+	if err := s.ReplacePoliciesAndGroups(nil, []Policy{p}, nil, []Group{g}); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := OpenFileStore(dir, FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	if d := reopened.Evaluate(Request{Subject: Entity{Type: "user", ID: "alice"}, Resource: p.Resource, Action: "read"}); !d.Allow {
+		t.Errorf("replayed replacement does not allow a group member")
+	}
+}
@@ -0,0 +1,124 @@
+package policy
+
+import (
+	"sync"
+	"sync/atomic"
+)
+
+// membershipCacheLimit bounds the number of resolved memberships a cache holds
+const membershipCacheLimit = 100000
+
+// membershipCache holds the transitive group memberships resolved for one
+// version of the groups. A change to any group's members replaces the cache;
+// changes to roles and bindings keep it.
+type membershipCache struct {
+	principals sync.Map // Subject → []Entity, the subject and its groups, nearest first
+	members    sync.Map // Group ID → []Entity, the members that are not groups themselves
+	size       int64    // Number of cached entries, updated atomically
+}
+
+// store caches a resolved membership unless the cache is full
+func (c *membershipCache) store(m *sync.Map, k, v interface{}) {
+	if atomic.AddInt64(&c.size, 1) > membershipCacheLimit {
+		atomic.AddInt64(&c.size, -1)
+		return
+	}
+	m.Store(k, v)
+}
+
+// principals returns the subject followed by the groups it is a member of,
+// directly or through nested groups, nearest first. Cycles between groups
+// are harmless; each group appears once.
+func (rs *rbacState) principals(subject Entity) []Entity {
+	if len(rs.memberOf[subject]) == 0 {
+		return []Entity{subject}
+	}
+	if v, ok := rs.membership.principals.Load(subject); ok {
+		return v.([]Entity)
+	}
+
+	principals := []Entity{subject}
+	seen := map[Entity]bool{subject: true}
+	for i := 0; i < len(principals); i++ {
+		for _, id := range rs.memberOf[principals[i]] {
+			group := Entity{Type: GroupType, ID: id}
+			if !seen[group] {
+				seen[group] = true
+				principals = append(principals, group)
+			}
+		}
+	}
+	rs.membership.store(&rs.membership.principals, subject, principals)
+	return principals
+}
+
+// groupMembers returns the members of a group and of the groups nested in
+// it that are not groups themselves
+func (rs *rbacState) groupMembers(id string) []Entity {
+	if v, ok := rs.membership.members.Load(id); ok {
+		return v.([]Entity)
+	}
+
+	var members []Entity
+	seen := map[Entity]bool{{Type: GroupType, ID: id}: true}
+	queue := []string{id}
+	for len(queue) > 0 {
+		group := queue[0]
+		queue = queue[1:]
+		for _, m := range rs.groups[group].Members {
+			if seen[m] {
+				continue
+			}
+			seen[m] = true
+			if m.Type == GroupType {
+				queue = append(queue, m.ID)
+			} else {
+				members = append(members, m)
+			}
+		}
+	}
+	rs.membership.store(&rs.membership.members, id, members)
+	return members
+}
+
+// members returns the subjects of the given type that a binding grants its
+// role to. A group's nested members are included unless direct is set.
+func (rs *rbacState) members(b RoleBinding, subjectType string, direct bool) []Entity {
+	if b.Subject.Type == subjectType {
+		return []Entity{b.Subject}
+	}
+	if b.Subject.Type != GroupType || direct {
+		return nil
+	}
+	return ofType(rs.groupMembers(b.Subject.ID), subjectType)
+}
+
+// ofType returns the entities of the given type
+func ofType(entities []Entity, entityType string) []Entity {
+	var matching []Entity
+	for _, e := range entities {
+		if e.Type == entityType {
+			matching = append(matching, e)
+		}
+	}
+	return matching
+}
+
+// sameMembership reports whether two versions of the group index are equal
+func sameMembership(a, b map[Entity][]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for member, groups := range a {
+		other, ok := b[member]
+		if !ok || len(groups) != len(other) {
+			return false
+		}
+		for i := range groups {
+			if groups[i] != other[i] {
+				return false
+			}
+		}
+	}
+	return true
+}
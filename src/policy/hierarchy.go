@@ -0,0 +1,245 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ErrResourceNodeNotFound is returned when a resource has no registered place in the hierarchy
+var ErrResourceNodeNotFound = errors.New("resource not found in hierarchy")
+
+// Resource properties that place a resource in the hierarchy for a single request
+const (
+	ParentProperty           = "parent"            // Parent resource, as "type:id" or {"type": ..., "id": ...}
+	BlockInheritanceProperty = "block_inheritance" // true to inherit no grants from ancestors
+)
+
+// hierarchyDepthLimit bounds the number of ancestors a resource inherits from
+const hierarchyDepthLimit = 32
+
+// ResourceNode places a resource below a parent in the resource hierarchy,
+// e.g. a document in a folder. Grants on an ancestor apply to its
+// descendants unless a resource on the way blocks inheritance.
+type ResourceNode struct {
+	Resource         Entity  `json:"resource"`
+	Parent           *Entity `json:"parent,omitempty"`            // Parent resource; nil for a root
+	BlockInheritance bool    `json:"block_inheritance,omitempty"` // Whether grants on the ancestors stop applying here
+}
+
+// Validate checks that the node is complete
+func (n ResourceNode) Validate() error {
+	if n.Resource.Type == "" || n.Resource.ID == "" {
+		return fmt.Errorf("%w: resource type and id are required", ErrInvalidPolicy)
+	}
+	if n.Resource.IsPattern() {
+		return fmt.Errorf("%w: resource %s cannot contain %q", ErrInvalidPolicy, n.Resource, Wildcard)
+	}
+	if n.Parent == nil {
+		return nil
+	}
+	if n.Parent.Type == "" || n.Parent.ID == "" {
+		return fmt.Errorf("%w: parent type and id are required", ErrInvalidPolicy)
+	}
+	if n.Parent.IsPattern() {
+		return fmt.Errorf("%w: parent %s cannot contain %q", ErrInvalidPolicy, *n.Parent, Wildcard)
+	}
+	if *n.Parent == n.Resource {
+		return fmt.Errorf("%w: resource %s cannot be its own parent", ErrInvalidPolicy, n.Resource)
+	}
+	return nil
+}
+
+func (n ResourceNode) same(o ResourceNode) bool   { return n.Resource == o.Resource }
+func (n ResourceNode) before(o ResourceNode) bool { return n.Resource.String() < o.Resource.String() }
+
+// entityKey indexes resource nodes by resource or parent
+type entityKey Entity
+
+func (k entityKey) shard() uint32 { return fnvEntity(fnvOffset, Entity(k)) }
+
+// lineage returns the request's resource followed by the ancestors whose
+// grants it inherits, nearest first. A parent in the resource's properties
+// takes precedence over its registered parent.
+func (st *state) lineage(req Request) []Entity {
+	lineage := []Entity{req.Resource}
+	parent, blocked := propertyParent(req.ResourceProperties)
+	if st.nodeCount == 0 && parent == nil {
+		return lineage
+	}
+
+	if node, ok := st.nodes.get(entityKey(req.Resource)); ok {
+		if parent == nil {
+			parent = node.Parent
+		}
+		blocked = blocked || node.BlockInheritance
+	}
+	for parent != nil && !blocked && len(lineage) <= hierarchyDepthLimit {
+		for _, e := range lineage {
+			if e == *parent {
+				return lineage // A cycle through the request's properties
+			}
+		}
+		lineage = append(lineage, *parent)
+
+		node, ok := st.nodes.get(entityKey(*parent))
+		if !ok {
+			break
+		}
+		parent, blocked = node.Parent, node.BlockInheritance
+	}
+	return lineage
+}
+
+// propertyParent returns the parent and block-inheritance marker in a
+// resource's properties. A malformed parent is ignored.
+func propertyParent(props map[string]interface{}) (*Entity, bool) {
+	blocked, _ := props[BlockInheritanceProperty].(bool)
+
+	var parent Entity
+	switch v := props[ParentProperty].(type) {
+	case string:
+		typ, id, _ := strings.Cut(v, ":")
+		parent = Entity{Type: typ, ID: id}
+	case map[string]interface{}:
+		parent.Type, _ = v["type"].(string)
+		parent.ID, _ = v["id"].(string)
+	}
+	if parent.Type == "" || parent.ID == "" {
+		return nil, blocked
+	}
+	return &parent, blocked
+}
+
+// descendants calls fn for every resource registered below the ancestor
+func (st *state) descendants(ancestor Entity, fn func(Entity)) {
+	seen := map[Entity]bool{ancestor: true}
+	queue := []Entity{ancestor}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+		for _, child := range st.children.lookup(entityKey(parent)) {
+			if !seen[child.Resource] {
+				seen[child.Resource] = true
+				fn(child.Resource)
+				queue = append(queue, child.Resource)
+			}
+		}
+	}
+}
+
+// checkResourceNode verifies that placing the node does not make the resource its own ancestor
+func (st *state) checkResourceNode(n ResourceNode) error {
+	depth := 0
+	for parent := n.Parent; parent != nil; depth++ {
+		if *parent == n.Resource {
+			return fmt.Errorf("%w: parent %s is a descendant of %s", ErrInvalidPolicy, *n.Parent, n.Resource)
+		}
+		if depth == hierarchyDepthLimit {
+			return fmt.Errorf("%w: hierarchy of %s is deeper than %d", ErrInvalidPolicy, n.Resource, hierarchyDepthLimit)
+		}
+		node, ok := st.nodes.get(entityKey(*parent))
+		if !ok {
+			break
+		}
+		parent = node.Parent
+	}
+	return nil
+}
+
+// putResourceNode adds or replaces a resource node in the transaction
+func (st *state) putResourceNode(n ResourceNode) {
+	st.removeResourceNode(n.Resource)
+
+	gen := st.generation
+	st.nodes.put(gen, entityKey(n.Resource), n)
+	st.nodesByType.add(gen, n.Resource.Type, n.Resource.ID, n)
+	if n.Parent != nil {
+		st.children.add(gen, entityKey(*n.Parent), n)
+		st.countParentType(n.Parent.Type, 1)
+	}
+	st.nodeCount++
+}
+
+// removeResourceNode removes a resource node in the transaction and reports whether it was registered
+func (st *state) removeResourceNode(resource Entity) bool {
+	old, ok := st.nodes.get(entityKey(resource))
+	if !ok {
+		return false
+	}
+
+	gen := st.generation
+	st.nodes.del(gen, entityKey(resource))
+	st.nodesByType.remove(gen, resource.Type, resource.ID, old)
+	if old.Parent != nil {
+		st.children.remove(gen, entityKey(*old.Parent), old)
+		st.countParentType(old.Parent.Type, -1)
+	}
+	st.nodeCount--
+	return true
+}
+
+// countParentType adjusts the number of children of parents of the type
+func (st *state) countParentType(parentType string, delta int) {
+	counts := make(map[string]int, len(st.parentTypes)+1)
+	for t, n := range st.parentTypes {
+		counts[t] = n
+	}
+	if counts[parentType] += delta; counts[parentType] == 0 {
+		delete(counts, parentType)
+	}
+	st.parentTypes = counts
+}
+
+// resourceNodes returns all resource nodes, ordered by resource
+func (st *state) resourceNodes() []ResourceNode {
+	nodes := make([]ResourceNode, 0, st.nodeCount)
+	st.nodes.each(func(_ entityKey, n ResourceNode) {
+		nodes = append(nodes, n)
+	})
+	sort.Slice(nodes, func(i, j int) bool { return nodes[i].before(nodes[j]) })
+	return nodes
+}
+
+// GetResourceNode returns the place of a resource in the hierarchy
+func (s *MemoryStore) GetResourceNode(resource Entity) (ResourceNode, error) {
+	n, ok := s.load().nodes.get(entityKey(resource))
+	if !ok {
+		return ResourceNode{}, fmt.Errorf("%w: %s", ErrResourceNodeNotFound, resource)
+	}
+	return n, nil
+}
+
+// ListResourceChildren returns the resources registered directly below the parent, ordered by resource
+func (s *MemoryStore) ListResourceChildren(parent Entity) []ResourceNode {
+	children := s.load().children.lookup(entityKey(parent))
+	return append(make([]ResourceNode, 0, len(children)), children...)
+}
+
+// PutResourceNode validates a resource node and adds it or replaces the
+// resource's place in the hierarchy. The parent need not be registered itself.
+func (s *MemoryStore) PutResourceNode(n ResourceNode) error {
+	if err := n.Validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		if err := st.checkResourceNode(n); err != nil {
+			return err
+		}
+		st.putResourceNode(n)
+		return nil
+	})
+}
+
+// RemoveResourceNode removes a resource from the hierarchy. Its children
+// keep it as their parent but no longer inherit from its ancestors.
+func (s *MemoryStore) RemoveResourceNode(resource Entity) error {
+	return s.update(func(st *state) error {
+		if !st.removeResourceNode(resource) {
+			return fmt.Errorf("%w: %s", ErrResourceNodeNotFound, resource)
+		}
+		return nil
+	})
+}
@@ -0,0 +1,187 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+func folder(id string) Entity { return Entity{Type: "folder", ID: id} }
+
+// newHierarchyStore returns a store with the hierarchy
+//
+//	folder:root
+//	├── folder:shared ── doc:1
+//	└── folder:private (blocks inheritance) ── doc:2
+//
+// where alice may read folder:root by policy and bob by a role binding
+func newHierarchyStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	s := newRBACStore(t)
+	root, shared, private := folder("root"), folder("shared"), folder("private")
+	nodes := []ResourceNode{
+		{Resource: root},
+		{Resource: shared, Parent: &root},
+		{Resource: private, Parent: &root, BlockInheritance: true},
+		{Resource: doc("1"), Parent: &shared},
+		{Resource: doc("2"), Parent: &private},
+	}
+	for _, n := range nodes {
+		if err := s.PutResourceNode(n); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.AddPolicy(Policy{ID: "alice-root", Subject: user("alice"), Resource: root, Action: "read", Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddRoleBinding(RoleBinding{Subject: user("bob"), Role: "viewer", Resource: &root}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestHierarchyInheritance(t *testing.T) {
+	s := newHierarchyStore(t)
+	tests := []struct {
+		name  string
+		req   Request
+		allow bool
+	}{
+		{
+			name:  "policy on the resource",
+			req:   Request{Subject: user("alice"), Resource: folder("root"), Action: "read"},
+			allow: true,
+		},
+		{
+			name:  "policy on an ancestor",
+			req:   Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow: true,
+		},
+		{
+			name:  "role binding on an ancestor",
+			req:   Request{Subject: user("bob"), Resource: doc("1"), Action: "read"},
+			allow: true,
+		},
+		{
+			name: "below a blocking folder",
+			req:  Request{Subject: user("alice"), Resource: doc("2"), Action: "read"},
+		},
+		{
+			name: "role binding below a blocking folder",
+			req:  Request{Subject: user("bob"), Resource: doc("2"), Action: "read"},
+		},
+		{
+			name: "unregistered resource",
+			req:  Request{Subject: user("alice"), Resource: doc("3"), Action: "read"},
+		},
+		{
+			name:  "parent property",
+			req:   Request{Subject: user("alice"), Resource: doc("3"), Action: "read", ResourceProperties: map[string]interface{}{ParentProperty: "folder:shared"}},
+			allow: true,
+		},
+		{
+			name:  "parent property overriding the registered parent",
+			req:   Request{Subject: user("alice"), Resource: doc("2"), Action: "read", ResourceProperties: map[string]interface{}{ParentProperty: map[string]interface{}{"type": "folder", "id": "shared"}}},
+			allow: true,
+		},
+		{
+			name: "block inheritance property",
+			req:  Request{Subject: user("alice"), Resource: doc("1"), Action: "read", ResourceProperties: map[string]interface{}{BlockInheritanceProperty: true}},
+		},
+		{
+			name: "malformed parent property",
+			req:  Request{Subject: user("alice"), Resource: doc("3"), Action: "read", ResourceProperties: map[string]interface{}{ParentProperty: "folder"}},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if d := s.Evaluate(tt.req); d.Allow != tt.allow {
+				t.Errorf("Allow = %v, want %v", d.Allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestHierarchyCycleThroughProperties(t *testing.T) {
+	s := newHierarchyStore(t)
+	x := doc("x")
+	if err := s.PutResourceNode(ResourceNode{Resource: folder("x"), Parent: &x}); err != nil {
+		t.Fatal(err)
+	}
+	req := Request{Subject: user("alice"), Resource: x, Action: "read", ResourceProperties: map[string]interface{}{ParentProperty: "folder:x"}}
+	if got, want := s.load().lineage(req), []Entity{x, folder("x")}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("lineage = %v, want %v", got, want)
+	}
+	if d := s.Evaluate(req); d.Allow {
+		t.Errorf("Allow = true, want false")
+	}
+}
+
+func TestResourceNodesRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *MemoryStore) error
+	}{
+		{
+			name: "own parent",
+			change: func(s *MemoryStore) error {
+				root := folder("root")
+				return s.PutResourceNode(ResourceNode{Resource: root, Parent: &root})
+			},
+		},
+		{
+			name: "cycle",
+			change: func(s *MemoryStore) error {
+				d := doc("1")
+				return s.PutResourceNode(ResourceNode{Resource: folder("root"), Parent: &d})
+			},
+		},
+		{
+			name: "wildcard parent",
+			change: func(s *MemoryStore) error {
+				return s.PutResourceNode(ResourceNode{Resource: doc("3"), Parent: &Entity{Type: "folder", ID: Wildcard}})
+			},
+		},
+		{
+			name: "deeper than the limit",
+			change: func(s *MemoryStore) error {
+				for i := 0; i < hierarchyDepthLimit; i++ {
+					parent := folder(fmt.Sprint("f", i+1))
+					if err := s.PutResourceNode(ResourceNode{Resource: folder(fmt.Sprint("f", i)), Parent: &parent}); err != nil {
+						return fmt.Errorf("within the limit: %v", err)
+					}
+				}
+				parent := folder("f0")
+				return s.PutResourceNode(ResourceNode{Resource: doc("3"), Parent: &parent})
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(newHierarchyStore(t)); !errors.Is(err, ErrInvalidPolicy) {
+				t.Fatalf("error = %v, want %v", err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+func TestFindResourcesThroughHierarchy(t *testing.T) {
+	s := newHierarchyStore(t)
+	tests := []struct {
+		subject string
+		want    []Entity
+	}{
+		{subject: "alice", want: []Entity{doc("1")}},
+		{subject: "bob", want: []Entity{doc("1")}},
+		{subject: "carol", want: []Entity{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject, func(t *testing.T) {
+			req := Request{Subject: user(tt.subject), Resource: Entity{Type: "doc"}, Action: "read"}
+			if got, next := s.FindResourcesForSubject(req, Page{}); !reflect.DeepEqual(got, tt.want) || next != "" {
+				t.Errorf("FindResourcesForSubject = %v, %q, want %v", got, next, tt.want)
+			}
+		})
+	}
+}
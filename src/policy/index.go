@@ -0,0 +1,251 @@
+package policy
+
+// indexShards is the number of shards of each index. A change copies only the
+// shards it touches, so the cost of a write is proportional to the shard size
+// rather than to the number of policies.
+const indexShards = 1024
+
+// indexKey is a key of an index. shard spreads keys across the index shards.
+type indexKey interface {
+	comparable
+	shard() uint32
+}
+
+// index is a copy-on-write hash map split into shards. A published index is
+// never modified; a transaction copies a shard the first time it writes to it.
+type index[K indexKey, V interface{}] struct {
+	shards [indexShards]map[K]V
+	owner  [indexShards]uint64 // Generation of the transaction that created each shard
+}
+
+// get returns the value stored for the key
+func (ix *index[K, V]) get(k K) (V, bool) {
+	v, ok := ix.shards[k.shard()%indexShards][k]
+	return v, ok
+}
+
+// put stores a value in the transaction with the given generation
+func (ix *index[K, V]) put(gen uint64, k K, v V) {
+	ix.mutable(gen, k)[k] = v
+}
+
+// del removes a key in the transaction with the given generation
+func (ix *index[K, V]) del(gen uint64, k K) {
+	delete(ix.mutable(gen, k), k)
+}
+
+// each calls fn for every entry of the index
+func (ix *index[K, V]) each(fn func(K, V)) {
+	for _, shard := range ix.shards {
+		for k, v := range shard {
+			fn(k, v)
+		}
+	}
+}
+
+// mutable returns the shard holding the key, copying it if it was created
+// by an earlier transaction and may therefore be shared with readers
+func (ix *index[K, V]) mutable(gen uint64, k K) map[K]V {
+	i := k.shard() % indexShards
+	if ix.owner[i] != gen || ix.shards[i] == nil {
+		shard := make(map[K]V, len(ix.shards[i])+1)
+		for key, value := range ix.shards[i] {
+			shard[key] = value
+		}
+		ix.shards[i] = shard
+		ix.owner[i] = gen
+	}
+	return ix.shards[i]
+}
+
+// posting is a value held in a multiIndex
+type posting[V interface{}] interface {
+	same(V) bool   // Reports whether two values are the same entry, e.g. have the same ID
+	before(V) bool // Orders the entries under a key
+}
+
+// multiIndex maps a key to the list of values that share it
+type multiIndex[K indexKey, V posting[V]] struct {
+	index[K, *postings[V]]
+}
+
+// postings is the list of values stored under one key of a multiIndex
+type postings[V interface{}] struct {
+	gen     uint64 // Generation of the transaction that created the list
+	entries []V
+}
+
+// lookup returns the values stored under the key
+func (ix *multiIndex[K, V]) lookup(k K) []V {
+	if p, ok := ix.get(k); ok {
+		return p.entries
+	}
+	return nil
+}
+
+// mutablePostings returns the list under the key for modification in the
+// transaction, copying it if an earlier transaction created it
+func (ix *multiIndex[K, V]) mutablePostings(gen uint64, k K) *postings[V] {
+	current, ok := ix.get(k)
+	if ok && current.gen == gen {
+		return current
+	}
+
+	p := &postings[V]{gen: gen}
+	if ok {
+		p.entries = make([]V, len(current.entries), len(current.entries)+1)
+		copy(p.entries, current.entries)
+	}
+	ix.put(gen, k, p)
+	return p
+}
+
+// add adds a value under the key
+func (ix *multiIndex[K, V]) add(gen uint64, k K, v V) {
+	p := ix.mutablePostings(gen, k)
+
+	// Keep entries ordered; new values normally go last
+	i := len(p.entries)
+	for i > 0 && v.before(p.entries[i-1]) {
+		i--
+	}
+	p.entries = append(p.entries, v)
+	copy(p.entries[i+1:], p.entries[i:])
+	p.entries[i] = v
+}
+
+// remove removes the entries that are the same as v from the key
+func (ix *multiIndex[K, V]) remove(gen uint64, k K, v V) {
+	if _, ok := ix.get(k); !ok {
+		return
+	}
+
+	p := ix.mutablePostings(gen, k)
+	entries := p.entries[:0]
+	for _, e := range p.entries {
+		if !e.same(v) {
+			entries = append(entries, e)
+		}
+	}
+	p.entries = entries
+
+	if len(p.entries) == 0 {
+		ix.del(gen, k)
+	}
+}
+
+// typeBuckets is the number of postings the entries of one type are spread
+// across, so that a change copies one bucket rather than every entry of the type
+const typeBuckets = 256
+
+// typeIndex maps an entity type to the entries of that type, split into
+// buckets by ID. The entries of a type are not ordered.
+type typeIndex[V posting[V]] struct {
+	multiIndex[typeBucketKey, V]
+}
+
+// add adds a value with the given ID under the type
+func (ix *typeIndex[V]) add(gen uint64, typ, id string, v V) {
+	ix.multiIndex.add(gen, newTypeBucketKey(typ, id), v)
+}
+
+// remove removes the entries that are the same as v from the type
+func (ix *typeIndex[V]) remove(gen uint64, typ, id string, v V) {
+	ix.multiIndex.remove(gen, newTypeBucketKey(typ, id), v)
+}
+
+// each calls fn for every value stored under the type
+func (ix *typeIndex[V]) each(typ string, fn func(V)) {
+	for bucket := uint32(0); bucket < typeBuckets; bucket++ {
+		for _, v := range ix.lookup(typeBucketKey{typ, bucket}) {
+			fn(v)
+		}
+	}
+}
+
+// Index keys
+
+// idKey indexes policies by ID
+type idKey string
+
+// tripleKey indexes policies by subject, resource and action
+type tripleKey struct {
+	subject, resource Entity
+	action            string
+}
+
+// typeKey indexes policies with wildcards by resource type
+type typeKey string
+
+// typeBucketKey indexes entries by type and a bucket of their ID, for enumerating the entities of a type
+type typeBucketKey struct {
+	typ    string
+	bucket uint32
+}
+
+func newTypeBucketKey(typ, id string) typeBucketKey {
+	return typeBucketKey{typ, fnv(fnvOffset, id) % typeBuckets}
+}
+
+// pairKey indexes policies by subject and resource, for action search
+type pairKey struct {
+	subject, resource Entity
+}
+
+// subjectSearchKey indexes policies by resource, action and subject type, for subject search
+type subjectSearchKey struct {
+	resource    Entity
+	action      string
+	subjectType string
+}
+
+// resourceSearchKey indexes policies by subject, action and resource type, for resource search
+type resourceSearchKey struct {
+	subject      Entity
+	action       string
+	resourceType string
+}
+
+func (k idKey) shard() uint32 { return fnv(fnvOffset, string(k)) }
+
+func (k typeKey) shard() uint32 { return fnv(fnvOffset, string(k)) }
+
+func (k typeBucketKey) shard() uint32 { return fnv(fnvOffset, k.typ) + k.bucket }
+
+func (k tripleKey) shard() uint32 {
+	return fnv(fnvEntity(fnvEntity(fnvOffset, k.subject), k.resource), k.action)
+}
+
+func (k pairKey) shard() uint32 {
+	return fnvEntity(fnvEntity(fnvOffset, k.subject), k.resource)
+}
+
+func (k subjectSearchKey) shard() uint32 {
+	return fnv(fnv(fnvEntity(fnvOffset, k.resource), k.action), k.subjectType)
+}
+
+func (k resourceSearchKey) shard() uint32 {
+	return fnv(fnv(fnvEntity(fnvOffset, k.subject), k.action), k.resourceType)
+}
+
+// FNV-1a hashing of key fields
+const (
+	fnvOffset = 2166136261
+	fnvPrime  = 16777619
+)
+
+// fnv hashes a string, followed by a separator so that field boundaries matter
+func fnv(h uint32, s string) uint32 {
+	for i := 0; i < len(s); i++ {
+		h ^= uint32(s[i])
+		h *= fnvPrime
+	}
+	h ^= 0xff
+	h *= fnvPrime
+	return h
+}
+
+// fnvEntity hashes an entity's type and ID
+func fnvEntity(h uint32, e Entity) uint32 {
+	return fnv(fnv(h, e.Type), e.ID)
+}
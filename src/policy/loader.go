@@ -0,0 +1,281 @@
+package policy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// policyFileVersion is the schema version of declarative policy files
+const policyFileVersion = 1
+
+// PolicyFile is the schema of a declarative policy file. YAML files use the
+// same field names as JSON files.
+type PolicyFile struct {
+	Version  int      `json:"version"`          // Schema version, must be 1
+	Policies []Policy `json:"policies"`         // Policies; each must have a unique id
+	Groups   []Group  `json:"groups,omitempty"` // Groups; each must have a unique id
+}
+
+// ReloadStatus reports the state of a policy file loader
+type ReloadStatus struct {
+	Path        string    `json:"path"`                 // Watched policy file
+	Checksum    string    `json:"checksum,omitempty"`   // SHA-256 of the file content being served
+	Policies    int       `json:"policies"`             // Number of policies being served from the file
+	Groups      int       `json:"groups"`               // Number of groups being served from the file
+	LoadedAt    time.Time `json:"loaded_at,omitempty"`  // When the served content was loaded
+	LastAttempt time.Time `json:"last_attempt"`         // When the file was last read
+	LastError   string    `json:"last_error,omitempty"` // Why the last attempt was rejected, if it was
+	Reloads     int       `json:"reloads"`              // Number of successful loads
+	Failures    int       `json:"failures"`             // Number of rejected loads
+}
+
+// Loader loads policies from a declarative YAML, JSON or Cedar file into a
+// store and reloads them when the file changes. An invalid file is rejected
+// as a whole, and the policies from the last valid version keep being served.
+type Loader struct {
+	path   string
+	source string // Source of the loaded policies and groups, the file's absolute path
+	store  Store
+
+	mu       sync.Mutex
+	ids      []string // IDs of the policies loaded from the file; nil before the first load
+	groupIDs []string // IDs of the groups loaded from the file; nil before the first load
+	rejected string   // Checksum of the last rejected content, so it is not retried on every poll
+	status   ReloadStatus
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewLoader creates a loader for the given policy file
+func NewLoader(path string, store Store) *Loader {
+	source, err := filepath.Abs(path)
+	if err != nil {
+		source = path
+	}
+	return &Loader{
+		path:   path,
+		source: source,
+		store:  store,
+		status: ReloadStatus{Path: path},
+	}
+}
+
+// Load reads the policy file and, if it is valid and has changed since the
+// last load, atomically replaces the previously loaded policies in the store
+func (l *Loader) Load() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.status.LastAttempt = time.Now().UTC()
+
+	data, err := os.ReadFile(l.path)
+	if err != nil {
+		return l.fail(fmt.Errorf("read policy file: %w", err), "")
+	}
+
+	sum := sha256.Sum256(data)
+	checksum := hex.EncodeToString(sum[:])
+	switch checksum {
+	case l.status.Checksum:
+		// Unchanged, or reverted to the version being served
+		l.status.LastError = ""
+		l.rejected = ""
+		return nil
+	case l.rejected:
+		return errors.New(l.status.LastError)
+	}
+
+	file, err := ParsePolicyFile(l.path, data)
+	if err != nil {
+		return l.fail(err, checksum)
+	}
+
+	for i := range file.Policies {
+		file.Policies[i].Source = l.source
+	}
+	for i := range file.Groups {
+		file.Groups[i].Source = l.source
+	}
+
+	// A durable store may still hold what an earlier process loaded from the
+	// file, including policies and groups deleted from it since
+	if l.ids == nil {
+		l.ids, l.groupIDs = l.loaded()
+	}
+
+	// Groups and policies in one change, so that policies granting access to
+	// a group apply to its members at once and a failure leaves both as they were
+	if err := l.store.ReplacePoliciesAndGroups(l.ids, file.Policies, l.groupIDs, file.Groups); err != nil {
+		return l.fail(fmt.Errorf("apply policy file: %w", err), checksum)
+	}
+
+	l.ids = make([]string, len(file.Policies))
+	for i, p := range file.Policies {
+		l.ids[i] = p.ID
+	}
+	l.groupIDs = make([]string, len(file.Groups))
+	for i, g := range file.Groups {
+		l.groupIDs[i] = g.ID
+	}
+	l.status.Checksum = checksum
+	l.status.Policies = len(file.Policies)
+	l.status.Groups = len(file.Groups)
+	l.status.LoadedAt = l.status.LastAttempt
+	l.status.LastError = ""
+	l.rejected = ""
+	l.status.Reloads++
+	log.Printf("Loaded %d policies and %d groups from %s", len(file.Policies), len(file.Groups), l.path)
+	return nil
+}
+
+// loaded returns the IDs of the policies and groups in the store that were
+// loaded from the file
+func (l *Loader) loaded() (ids, groupIDs []string) {
+	ids = []string{}
+	for _, p := range l.store.ListPolicies(PolicyFilter{Source: l.source}) {
+		ids = append(ids, p.ID)
+	}
+	groupIDs = []string{}
+	for _, g := range l.store.ListGroups() {
+		if g.Source == l.source {
+			groupIDs = append(groupIDs, g.ID)
+		}
+	}
+	return ids, groupIDs
+}
+
+// fail records a rejected load of the content with the given checksum. The caller must hold l.mu.
+func (l *Loader) fail(err error, checksum string) error {
+	l.rejected = checksum
+	l.status.LastError = err.Error()
+	l.status.Failures++
+	log.Printf("Rejected policy file %s, still serving the last valid version: %v", l.path, err)
+	return err
+}
+
+// Watch polls the policy file at the given interval and reloads it when it changes
+func (l *Loader) Watch(interval time.Duration) {
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+
+	go func() {
+		defer close(l.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				l.Load()
+			case <-l.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops watching the policy file
+func (l *Loader) Close() {
+	if l.stop != nil {
+		close(l.stop)
+		<-l.done
+	}
+}
+
+// Status returns the current reload status
+func (l *Loader) Status() ReloadStatus {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.status
+}
+
+// ParsePolicyFile parses and validates a policy file. Files with a .yaml or
+// .yml extension are parsed as YAML, files with a .cedar extension as a Cedar
+// policy set (see ParseCedar), all others as JSON. Unknown fields are rejected.
+func ParsePolicyFile(path string, data []byte) (*PolicyFile, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".cedar":
+		policies, err := ParseCedar(string(data))
+		if err != nil {
+			return nil, fmt.Errorf("parse policy file: %w", err)
+		}
+		file := &PolicyFile{Version: policyFileVersion, Policies: policies}
+		if err := file.Validate(); err != nil {
+			return nil, err
+		}
+		return file, nil
+	case ".yaml", ".yml":
+		// Convert YAML to JSON so that both formats share one schema
+		var doc interface{}
+		if err := yaml.Unmarshal(data, &doc); err != nil {
+			return nil, fmt.Errorf("parse policy file: %w", err)
+		}
+		converted, err := json.Marshal(doc)
+		if err != nil {
+			return nil, fmt.Errorf("parse policy file: %w", err)
+		}
+		data = converted
+	}
+
+	var file PolicyFile
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&file); err != nil {
+		return nil, fmt.Errorf("parse policy file: %w", err)
+	}
+
+	if err := file.Validate(); err != nil {
+		return nil, err
+	}
+	return &file, nil
+}
+
+// Validate checks the policy file against its schema
+func (f *PolicyFile) Validate() error {
+	if f.Version != policyFileVersion {
+		return fmt.Errorf("%w: unsupported policy file version %d", ErrInvalidPolicy, f.Version)
+	}
+
+	seen := make(map[string]bool, len(f.Policies))
+	for i, p := range f.Policies {
+		if p.ID == "" {
+			return fmt.Errorf("policies[%d]: %w: id is required", i, ErrInvalidPolicy)
+		}
+		if seen[p.ID] {
+			return fmt.Errorf("policies[%d]: %w: duplicate id %q", i, ErrInvalidPolicy, p.ID)
+		}
+		seen[p.ID] = true
+
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policies[%d]: %w", i, err)
+		}
+	}
+
+	groups := make(map[string]bool, len(f.Groups))
+	for i, g := range f.Groups {
+		if groups[g.ID] {
+			return fmt.Errorf("groups[%d]: %w: duplicate id %q", i, ErrInvalidPolicy, g.ID)
+		}
+		groups[g.ID] = true
+
+		if err := g.Validate(); err != nil {
+			return fmt.Errorf("groups[%d]: %w", i, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,146 @@
+package policy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// TestLoaderRejectsFileAsAWhole checks that a policy file whose policies
+// cannot be stored leaves the groups of the previous version in place
+func TestLoaderRejectsFileAsAWhole(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "policies.yaml")
+	store := &quotaStore{Store: NewMemoryStore(), quota: Quota{MaxPolicies: 1}}
+	l := NewLoader(path, store)
+
+	write := func(content string) {
+		t.Helper()
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	members := func(id string) []Entity {
+		t.Helper()
+		g, err := store.GetGroup(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return g.Members
+	}
+
+	write(`version: 1
+groups:
+  - {id: eng, members: [{type: user, id: alice}]}
+policies:
+  - {id: p1, subject: {type: group, id: eng}, resource: {type: doc, id: "1"}, action: read, allow: true}
+`)
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	write(`version: 1
+groups:
+  - {id: eng, members: [{type: user, id: bob}]}
+policies:
+  - {id: p1, subject: {type: group, id: eng}, resource: {type: doc, id: "1"}, action: read, allow: true}
+  - {id: p2, subject: {type: group, id: eng}, resource: {type: doc, id: "2"}, action: read, allow: true}
+`)
+	if err := l.Load(); !errors.Is(err, ErrQuotaExceeded) {
+		t.Fatalf("Load = %v, want %v", err, ErrQuotaExceeded)
+	}
+	if got, want := members("eng"), []Entity{user("alice")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members after a rejected load = %v, want %v", got, want)
+	}
+
+	write(`version: 1
+groups:
+  - {id: ops, members: [{type: user, id: bob}]}
+policies:
+  - {id: p2, subject: {type: group, id: ops}, resource: {type: doc, id: "2"}, action: read, allow: true}
+`)
+	if err := l.Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := store.GetGroup("eng"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("group removed from the file: %v", err)
+	}
+	if got, want := members("ops"), []Entity{user("bob")}; !reflect.DeepEqual(got, want) {
+		t.Errorf("members = %v, want %v", got, want)
+	}
+}
+
+// TestLoaderRemovesWhatARestartedFileStoreKeeps checks that policies and
+// groups deleted from the file while the server was down are removed on the
+// first load, and that those added through the API are kept
+func TestLoaderRemovesWhatARestartedFileStoreKeeps(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "policies.yaml")
+	if err := os.WriteFile(path, []byte(`version: 1
+groups:
+  - {id: eng, members: [{type: user, id: alice}]}
+  - {id: ops, members: [{type: user, id: bob}]}
+policies:
+  - {id: p1, subject: {type: group, id: eng}, resource: {type: doc, id: "1"}, action: read, allow: true}
+  - {id: p2, subject: {type: group, id: ops}, resource: {type: doc, id: "1"}, action: read, allow: true}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err := OpenFileStore(filepath.Join(dir, "data"), FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := NewLoader(path, s).Load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPolicy(Policy{ID: "api", Subject: user("carol"), Resource: doc("1"), Action: "read", Allow: true}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutGroup(Group{ID: "api-group", Members: []Entity{user("carol")}}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// While the server is down, p2 and ops are deleted from the file
+	if err := os.WriteFile(path, []byte(`version: 1
+groups:
+  - {id: eng, members: [{type: user, id: alice}]}
+policies:
+  - {id: p1, subject: {type: group, id: eng}, resource: {type: doc, id: "1"}, action: read, allow: true}
+`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	s, err = OpenFileStore(filepath.Join(dir, "data"), FileStoreOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer s.Close()
+	if err := NewLoader(path, s).Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := s.GetPolicy("p2"); !errors.Is(err, ErrPolicyNotFound) {
+		t.Errorf("policy removed from the file: %v", err)
+	}
+	if _, err := s.GetGroup("ops"); !errors.Is(err, ErrGroupNotFound) {
+		t.Errorf("group removed from the file: %v", err)
+	}
+	if d := s.Evaluate(Request{Subject: user("bob"), Resource: doc("1"), Action: "read"}); d.Allow {
+		t.Error("a policy removed from the file still allows access")
+	}
+	for _, id := range []string{"p1", "api"} {
+		if _, err := s.GetPolicy(id); err != nil {
+			t.Errorf("policy %s: %v", id, err)
+		}
+	}
+	for _, id := range []string{"eng", "api-group"} {
+		if _, err := s.GetGroup(id); err != nil {
+			t.Errorf("group %s: %v", id, err)
+		}
+	}
+}
@@ -0,0 +1,1241 @@
+package policy
+
+import (
+	"container/heap"
+	"fmt"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// MemoryStore is a policy store that keeps policies, roles and relation
+// tuples in memory. Policies are held in hash indexes keyed by (subject,
+// resource, action) and by each search axis, relation tuples by object and
+// by subject. The indexes are immutable once published: a change builds a new
+// version, copying only the index shards it touches, and swaps it in
+// atomically, so reads never take a lock.
+type MemoryStore struct {
+	mu      sync.Mutex   // Serializes writers
+	current atomic.Value // *state being served
+}
+
+// stored is a policy held by the store
+type stored struct {
+	seq       uint64 // Insertion sequence; earlier policies take precedence
+	policy    Policy
+	condition *Expression  // Compiled condition expression; nil if the policy has none
+	windows   timeWindows  // Parsed time windows of the within conditions; nil if the policy has none
+	cedar     *cedarPolicy // Parsed Cedar policy; nil for a native policy
+}
+
+// applies reports whether the policy is valid at the evaluation's time and
+// the request satisfies its conditions
+func (s *stored) applies(st *state, req Request, v *validity) (bool, error) {
+	if !v.active(s.policy) {
+		return false, nil
+	}
+	if s.cedar != nil {
+		return s.cedar.applies(st, req), nil
+	}
+	return s.policy.applies(req, s.condition, s.windows)
+}
+
+func (s *stored) same(o *stored) bool   { return s.policy.ID == o.policy.ID }
+func (s *stored) before(o *stored) bool { return s.seq < o.seq }
+
+// validity tracks when the outcome of an evaluation changes because the
+// validity period of a policy it considered starts or ends
+type validity struct {
+	now     time.Time // Time of the evaluation
+	changes time.Time // Earliest start or end of a considered policy's validity after now; zero if none
+}
+
+// active reports whether the policy is valid at the evaluation's time and
+// records when its validity starts or ends
+func (v *validity) active(p Policy) bool {
+	if p.NotBefore == nil && p.NotAfter == nil {
+		return true
+	}
+	for _, t := range [...]*time.Time{p.NotBefore, p.NotAfter} {
+		if t != nil && t.After(v.now) && (v.changes.IsZero() || t.Before(v.changes)) {
+			v.changes = *t
+		}
+	}
+	return p.ActiveAt(v.now)
+}
+
+// state is one immutable version of the store's content
+type state struct {
+	generation uint64 // Incremented by every change
+	nextSeq    uint64
+	count      int
+
+	byID             index[idKey, *stored]
+	byTriple         multiIndex[tripleKey, *stored]
+	byPair           multiIndex[pairKey, *stored]
+	bySubjectSearch  multiIndex[subjectSearchKey, *stored]
+	byResourceSearch multiIndex[resourceSearchKey, *stored]
+	bySubjectType    typeIndex[*stored]           // Policies with a literal subject
+	byResourceType   typeIndex[*stored]           // Policies with a literal resource
+	patterns         multiIndex[typeKey, *stored] // Policies with wildcards, by resource type or "*"
+	expiring         index[idKey, *stored]        // Policies with an end of validity
+
+	algorithm  CombiningAlgorithm   // Combines policy sets and policies without a set
+	policySets map[string]PolicySet // Policy sets by name; copied on change
+
+	rbac *rbacState // Roles, groups and role bindings
+
+	nodes       index[entityKey, ResourceNode]      // Resource hierarchy by resource
+	children    multiIndex[entityKey, ResourceNode] // Resource hierarchy by parent
+	nodesByType typeIndex[ResourceNode]             // Resource hierarchy by resource type
+	parentTypes map[string]int                      // Number of children of parents of each type; copied on change
+	nodeCount   int
+
+	namespaces      map[string]Namespace // Relation schemas by object type; copied on change
+	tuplesByObject  multiIndex[objectRelationKey, RelationTuple]
+	tuplesBySubject multiIndex[subjectKey, RelationTuple]
+	tupleCount      int
+}
+
+// NewMemoryStore creates a new in-memory policy store
+func NewMemoryStore() *MemoryStore {
+	s := &MemoryStore{}
+	s.current.Store(&state{algorithm: DefaultCombiningAlgorithm, rbac: newRBACState()})
+	return s
+}
+
+// Close releases the store's resources. A memory store holds none.
+func (s *MemoryStore) Close() error {
+	return nil
+}
+
+// load returns the state being served
+func (s *MemoryStore) load() *state {
+	return s.current.Load().(*state)
+}
+
+// update applies fn to a new version of the state and publishes it if fn succeeds
+func (s *MemoryStore) update(fn func(st *state) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	next := *s.load()
+	next.generation++
+	if err := fn(&next); err != nil {
+		return err
+	}
+
+	s.current.Store(&next)
+	return nil
+}
+
+// put adds or replaces a policy by ID. A replaced policy keeps its position.
+func (st *state) put(p Policy) {
+	entry := &stored{policy: p, condition: compileCondition(p.Condition), windows: parseTimeWindows(p.Conditions), cedar: compileCedar(p.Cedar)}
+	if old, ok := st.byID.get(idKey(p.ID)); ok {
+		entry.seq = old.seq
+		st.unindex(old)
+	} else {
+		st.nextSeq++
+		entry.seq = st.nextSeq
+		st.count++
+	}
+
+	gen := st.generation
+	st.byID.put(gen, idKey(p.ID), entry)
+	if p.IsPattern() {
+		st.patterns.add(gen, typeKey(p.Resource.Type), entry)
+	} else {
+		st.byTriple.add(gen, tripleKey{p.Subject, p.Resource, p.Action}, entry)
+		st.byPair.add(gen, pairKey{p.Subject, p.Resource}, entry)
+		st.bySubjectSearch.add(gen, subjectSearchKey{p.Resource, p.Action, p.Subject.Type}, entry)
+		st.byResourceSearch.add(gen, resourceSearchKey{p.Subject, p.Action, p.Resource.Type}, entry)
+	}
+	if !p.Subject.IsPattern() {
+		st.bySubjectType.add(gen, p.Subject.Type, p.ID, entry)
+	}
+	if !p.Resource.IsPattern() {
+		st.byResourceType.add(gen, p.Resource.Type, p.ID, entry)
+	}
+	if p.NotAfter != nil {
+		st.expiring.put(gen, idKey(p.ID), entry)
+	}
+}
+
+// remove removes a policy by ID and reports whether it was stored
+func (st *state) remove(id string) bool {
+	old, ok := st.byID.get(idKey(id))
+	if !ok {
+		return false
+	}
+
+	st.unindex(old)
+	st.byID.del(st.generation, idKey(id))
+	st.count--
+	return true
+}
+
+// unindex removes a policy from the secondary indexes
+func (st *state) unindex(old *stored) {
+	p, gen := old.policy, st.generation
+	if p.IsPattern() {
+		st.patterns.remove(gen, typeKey(p.Resource.Type), old)
+	} else {
+		st.byTriple.remove(gen, tripleKey{p.Subject, p.Resource, p.Action}, old)
+		st.byPair.remove(gen, pairKey{p.Subject, p.Resource}, old)
+		st.bySubjectSearch.remove(gen, subjectSearchKey{p.Resource, p.Action, p.Subject.Type}, old)
+		st.byResourceSearch.remove(gen, resourceSearchKey{p.Subject, p.Action, p.Resource.Type}, old)
+	}
+	if !p.Subject.IsPattern() {
+		st.bySubjectType.remove(gen, p.Subject.Type, p.ID, old)
+	}
+	if !p.Resource.IsPattern() {
+		st.byResourceType.remove(gen, p.Resource.Type, p.ID, old)
+	}
+	if p.NotAfter != nil {
+		st.expiring.del(gen, idKey(p.ID))
+	}
+}
+
+// expired returns the policies whose validity has ended at the given time, in insertion order
+func (st *state) expired(now time.Time) []Policy {
+	var entries []*stored
+	st.expiring.each(func(_ idKey, entry *stored) {
+		if entry.policy.ExpiredAt(now) {
+			entries = append(entries, entry)
+		}
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	policies := make([]Policy, len(entries))
+	for i, entry := range entries {
+		policies[i] = entry.policy
+	}
+	return policies
+}
+
+// decide returns the decision of the policies that match the request's
+// subject, resource, and action and whose conditions the request satisfies,
+// as combined by the store's and the policy sets' algorithms. Without such a
+// policy, access is allowed if a role binding grants it or if the subject
+// holds the action as a relation on the resource. Policies and role bindings
+// of the groups the subject is a member of, directly or through nested
+// groups, apply to it as well, and so do policies, role bindings and
+// relations on the resource's ancestors. A matching policy whose condition
+// expression cannot be evaluated denies access.
+func (st *state) decide(req Request) Decision {
+	v := validity{now: time.Now()}
+	d := st.decideAt(req, &v, nil)
+	d.Expires = v.changes
+	return d
+}
+
+// explain decides a request like decide and traces how it was decided
+func (st *state) explain(req Request) (Decision, Trace) {
+	v := validity{now: time.Now()}
+	t := &tracer{}
+	d := st.decideAt(req, &v, t)
+	d.Expires = v.changes
+	return d, t.finish(d)
+}
+
+// decideAt decides a request at the time of v, recording how on t unless
+// it is nil
+func (st *state) decideAt(req Request, v *validity, t *tracer) Decision {
+	principals, lineage := st.rbac.principals(req.Subject), st.lineage(req)
+	t.start(principals, lineage, v)
+	entries, failed, err := st.applicable(req, principals, lineage, v, t)
+	if err != nil {
+		p := failed.policy
+		return Decision{Allow: false, Policy: &p, Error: err}
+	}
+	if d, ok := st.combine(entries, t); ok {
+		return d
+	}
+	if b := st.rbac.grant(req, principals, lineage); b != nil {
+		return Decision{Allow: true, Binding: b}
+	}
+	for _, resource := range lineage {
+		inherited := req
+		inherited.Resource = resource
+		if st.decideRelation(inherited) {
+			return Decision{Allow: true, Relation: resource.String() + "#" + req.Action}
+		}
+	}
+	return Decision{Allow: false}
+}
+
+// patternsFor returns the policies with wildcards that may match resources of the type
+func (st *state) patternsFor(resourceType string) [2][]*stored {
+	return [2][]*stored{
+		st.patterns.lookup(typeKey(resourceType)),
+		st.patterns.lookup(Wildcard),
+	}
+}
+
+// subjectsOfType calls fn for every subject of the type that a policy, role
+// binding or group names. Policies with wildcard subjects are expanded
+// against these known subjects.
+func (st *state) subjectsOfType(subjectType string, fn func(Entity)) {
+	st.bySubjectType.each(subjectType, func(entry *stored) {
+		fn(entry.policy.Subject)
+	})
+	for _, b := range st.rbac.bindings {
+		if b.Subject.Type == subjectType {
+			fn(b.Subject)
+		}
+	}
+	for _, g := range st.rbac.groups {
+		for _, m := range g.Members {
+			if m.Type == subjectType {
+				fn(m)
+			}
+		}
+	}
+}
+
+// actionsOfType calls fn for every action on resources of the type that a
+// policy, role or relation namespace names. Policies with wildcard actions
+// are expanded against these known actions.
+func (st *state) actionsOfType(resourceType string, fn func(string)) {
+	st.byResourceType.each(resourceType, func(entry *stored) {
+		switch {
+		case entry.cedar != nil && len(entry.cedar.action.entities) > 1:
+			for _, a := range entry.cedar.action.entities {
+				fn(a.ID)
+			}
+		case !isPattern(entry.policy.Action):
+			fn(entry.policy.Action)
+		}
+	})
+	for _, perms := range st.rbac.permissions {
+		for perm := range perms {
+			if perm.ResourceType == resourceType {
+				fn(perm.Action)
+			}
+		}
+	}
+	for relation := range st.namespaces[resourceType].Relations {
+		fn(relation)
+	}
+}
+
+// resourcesOfType calls fn for every resource of the type that a policy,
+// role binding or the resource hierarchy names. Role bindings that cover all
+// resources and policies with wildcard resources are expanded against these
+// known resources.
+func (st *state) resourcesOfType(resourceType string, fn func(Entity)) {
+	st.byResourceType.each(resourceType, func(entry *stored) {
+		fn(entry.policy.Resource)
+	})
+	for _, b := range st.rbac.bindings {
+		if b.Resource != nil && b.Resource.Type == resourceType {
+			fn(*b.Resource)
+		}
+	}
+	st.nodesByType.each(resourceType, func(n ResourceNode) {
+		fn(n.Resource)
+	})
+}
+
+// AddPolicy validates a policy and adds it to the store. A policy without
+// an ID is assigned a generated one. It returns the stored policy.
+func (s *MemoryStore) AddPolicy(p Policy) (Policy, error) {
+	if err := p.Validate(); err != nil {
+		return Policy{}, err
+	}
+
+	err := s.update(func(st *state) error {
+		if p.ID == "" {
+			p.ID = newPolicyID()
+		} else if _, ok := st.byID.get(idKey(p.ID)); ok {
+			return fmt.Errorf("%w: %s", ErrPolicyExists, p.ID)
+		}
+		st.put(p)
+		return nil
+	})
+	if err != nil {
+		return Policy{}, err
+	}
+	return p, nil
+}
+
+// UpdatePolicy validates a policy and replaces the stored policy with the same ID
+func (s *MemoryStore) UpdatePolicy(p Policy) error {
+	if err := p.Validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		if _, ok := st.byID.get(idKey(p.ID)); !ok {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, p.ID)
+		}
+		st.put(p)
+		return nil
+	})
+}
+
+// UpsertPolicies validates a batch of policies and adds or replaces them by ID.
+// Either all policies are stored or, if any is invalid, none is. It returns the stored policies.
+func (s *MemoryStore) UpsertPolicies(policies []Policy) ([]Policy, error) {
+	stored := make([]Policy, len(policies))
+	for i, p := range policies {
+		if err := p.Validate(); err != nil {
+			return nil, fmt.Errorf("policy %d: %w", i, err)
+		}
+		if p.ID == "" {
+			p.ID = newPolicyID()
+		}
+		stored[i] = p
+	}
+
+	s.update(func(st *state) error {
+		for _, p := range stored {
+			st.put(p)
+		}
+		return nil
+	})
+	return stored, nil
+}
+
+// RemovePolicy removes the policy with the given ID
+func (s *MemoryStore) RemovePolicy(id string) error {
+	return s.update(func(st *state) error {
+		if !st.remove(id) {
+			return fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+		}
+		return nil
+	})
+}
+
+// ReplacePolicies validates a batch of policies, then atomically removes the
+// policies with the given IDs and adds or replaces the batch. IDs that are not
+// stored are ignored. Readers observe either the old or the new set of policies.
+func (s *MemoryStore) ReplacePolicies(remove []string, policies []Policy) error {
+	if err := validateReplacement(policies, nil); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		st.replace(remove, policies)
+		return nil
+	})
+}
+
+// ReplacePoliciesAndGroups validates batches of policies and groups, then
+// replaces both as ReplacePolicies and ReplaceGroups do in one change.
+// Readers observe either the old or the new policies and groups.
+func (s *MemoryStore) ReplacePoliciesAndGroups(removePolicies []string, policies []Policy, removeGroups []string, groups []Group) error {
+	if err := validateReplacement(policies, groups); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		rs, err := st.rbac.try(replaceGroups(removeGroups, groups))
+		if err != nil {
+			return err
+		}
+		st.rbac = rs
+		st.replace(removePolicies, policies)
+		return nil
+	})
+}
+
+// validateReplacement validates the batches of a replacement. Replaced policies need an ID.
+func validateReplacement(policies []Policy, groups []Group) error {
+	for i, p := range policies {
+		if err := p.Validate(); err != nil {
+			return fmt.Errorf("policy %d: %w", i, err)
+		}
+		if p.ID == "" {
+			return fmt.Errorf("policy %d: %w: id is required", i, ErrInvalidPolicy)
+		}
+	}
+	for i, g := range groups {
+		if err := g.Validate(); err != nil {
+			return fmt.Errorf("group %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// replace removes the policies with the given IDs that are not in the batch
+// and adds or replaces the batch in the transaction
+func (st *state) replace(remove []string, policies []Policy) {
+	kept := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		kept[p.ID] = true
+	}
+	for _, id := range remove {
+		if !kept[id] {
+			st.remove(id)
+		}
+	}
+	for _, p := range policies {
+		st.put(p)
+	}
+}
+
+// RemoveExpiredPolicies removes the policies whose validity has ended at the
+// given time and returns them
+func (s *MemoryStore) RemoveExpiredPolicies(now time.Time) ([]Policy, error) {
+	if len(s.load().expired(now)) == 0 {
+		return nil, nil
+	}
+
+	var expired []Policy
+	err := s.update(func(st *state) error {
+		expired = st.expired(now)
+		for _, p := range expired {
+			st.remove(p.ID)
+		}
+		return nil
+	})
+	return expired, err
+}
+
+// GetPolicy returns the policy with the given ID
+func (s *MemoryStore) GetPolicy(id string) (Policy, error) {
+	entry, ok := s.load().byID.get(idKey(id))
+	if !ok {
+		return Policy{}, fmt.Errorf("%w: %s", ErrPolicyNotFound, id)
+	}
+	return entry.policy, nil
+}
+
+// CountPolicies returns the number of stored policies
+func (s *MemoryStore) CountPolicies() int {
+	return s.load().count
+}
+
+// Generation returns a number that changes with every change to the store's content
+func (s *MemoryStore) Generation() uint64 {
+	return s.load().generation
+}
+
+// CheckPolicy checks if a policy exists for the given subject, resource, and action
+// If a policy exists, it returns the Allow value of that policy.
+// If no policy exists, it returns false.
+func (s *MemoryStore) CheckPolicy(subject, resource Entity, action string) bool {
+	return s.Evaluate(Request{Subject: subject, Resource: resource, Action: action}).Allow
+}
+
+// Evaluate finds the policy that applies to the request and returns the
+// decision together with the policy that made it
+func (s *MemoryStore) Evaluate(req Request) Decision {
+	return s.load().decide(req)
+}
+
+// Explain evaluates the request like Evaluate and traces how the decision
+// was reached
+func (s *MemoryStore) Explain(req Request) (Decision, Trace) {
+	return s.load().explain(req)
+}
+
+// ListPolicies returns the policies that match the filter, in insertion order
+func (s *MemoryStore) ListPolicies(filter PolicyFilter) []Policy {
+	st := s.load()
+
+	entries := make([]*stored, 0, st.count)
+	st.byID.each(func(_ idKey, entry *stored) {
+		if filter.Matches(entry.policy) {
+			entries = append(entries, entry)
+		}
+	})
+	sort.Slice(entries, func(i, j int) bool { return entries[i].seq < entries[j].seq })
+
+	policies := make([]Policy, len(entries))
+	for i, entry := range entries {
+		policies[i] = entry.policy
+	}
+	return policies
+}
+
+// FindSubjectsForResource finds subjects of type req.Subject.Type that are allowed to perform the given action on the given resource,
+// either by a policy, through a role binding of the subject or one of its groups, or by a relation with groups expanded transitively.
+// A policy with a wildcard subject yields the matching subjects known to the store, never the pattern itself.
+// Grants on the resource's ancestors count as grants on the resource. Grants to a group yield its members, including those of
+// nested groups, unless opts.DirectOnly is set.
+// Conditions are evaluated with the request's attributes; the properties of the subjects found are unknown.
+// It returns one page of subjects and the cursor of the next page, which is empty on the last page.
+func (s *MemoryStore) FindSubjectsForResource(req Request, page Page, opts SubjectSearch) ([]Entity, string) {
+	st := s.load()
+
+	subjects := newCandidates[Entity](page)
+	consider := func(subject Entity) {
+		subjects.add(subject.String(), subject)
+	}
+
+	expandGroups := !opts.DirectOnly && req.Subject.Type != GroupType
+	considerGroup := func(id string) {
+		for _, member := range ofType(st.rbac.groupMembers(id), req.Subject.Type) {
+			consider(member)
+		}
+	}
+
+	lineage := st.lineage(req)
+	for _, resource := range lineage {
+		for _, entry := range st.bySubjectSearch.lookup(subjectSearchKey{resource, req.Action, req.Subject.Type}) {
+			consider(entry.policy.Subject)
+		}
+		if expandGroups {
+			for _, entry := range st.bySubjectSearch.lookup(subjectSearchKey{resource, req.Action, GroupType}) {
+				considerGroup(entry.policy.Subject.ID)
+			}
+		}
+	}
+
+	perm := Permission{ResourceType: req.Resource.Type, Action: req.Action}
+	for _, b := range st.rbac.bindings {
+		if b.appliesToAny(lineage) && st.rbac.permissions[b.Role][perm] {
+			for _, subject := range st.rbac.members(b, req.Subject.Type, !expandGroups) {
+				consider(subject)
+			}
+		}
+	}
+
+	var patterns, groupPatterns []Entity
+	for _, resource := range lineage {
+		for _, entries := range st.patternsFor(resource.Type) {
+			for _, entry := range entries {
+				p := entry.policy
+				if !p.Allow || !p.Resource.Matches(resource) || !matchPattern(p.Action, req.Action) {
+					continue
+				}
+				if matchPattern(p.Subject.Type, req.Subject.Type) {
+					patterns = append(patterns, p.Subject)
+				}
+				if expandGroups && matchPattern(p.Subject.Type, GroupType) {
+					groupPatterns = append(groupPatterns, p.Subject)
+				}
+			}
+		}
+	}
+	if len(patterns) > 0 {
+		st.subjectsOfType(req.Subject.Type, func(subject Entity) {
+			if matchesAny(patterns, subject) {
+				consider(subject)
+			}
+		})
+	}
+	if len(groupPatterns) > 0 {
+		for _, id := range sortedKeys(st.rbac.groups) {
+			if matchesAny(groupPatterns, Entity{Type: GroupType, ID: id}) {
+				considerGroup(id)
+			}
+		}
+	}
+
+	if st.tupleCount > 0 {
+		for _, resource := range lineage {
+			st.expandSubjects(resource, req.Action, func(subject Entity) {
+				if subject.Type == req.Subject.Type {
+					consider(subject)
+				}
+			})
+		}
+	}
+
+	return subjects.page(func(subject Entity) bool {
+		candidate := req
+		candidate.Subject, candidate.SubjectProperties = subject, nil
+		return st.decide(candidate).Allow
+	})
+}
+
+// FindResourcesForSubject finds resources of type req.Resource.Type that the given subject is allowed to perform the given action on,
+// either by a policy or role binding of the subject or one of its groups, or by a relation. A role binding that covers all resources, or a policy with a wildcard
+// resource, yields the matching resources known to the store. A grant on a resource in the hierarchy yields its descendants.
+// Conditions are evaluated with the request's attributes; the properties of the resources found are unknown.
+// It returns one page of resources and the cursor of the next page, which is empty on the last page.
+func (s *MemoryStore) FindResourcesForSubject(req Request, page Page) ([]Entity, string) {
+	st := s.load()
+
+	resources := newCandidates[Entity](page)
+	consider := func(resource Entity) {
+		resources.add(resource.String(), resource)
+	}
+
+	st.grantedResources(req, req.Resource.Type, consider)
+
+	// Resources inherit the grants on their ancestors
+	for _, parentType := range sortedKeys(st.parentTypes) {
+		st.grantedResources(req, parentType, func(ancestor Entity) {
+			st.descendants(ancestor, func(resource Entity) {
+				if resource.Type == req.Resource.Type {
+					consider(resource)
+				}
+			})
+		})
+	}
+
+	return resources.page(func(resource Entity) bool {
+		candidate := req
+		candidate.Resource, candidate.ResourceProperties = resource, nil
+		return st.decide(candidate).Allow
+	})
+}
+
+// grantedResources calls fn for the resources of the type on which a policy,
+// a role binding or a relation may allow the request's subject or one of its
+// groups the action. The caller verifies each candidate.
+func (st *state) grantedResources(req Request, resourceType string, fn func(Entity)) {
+	principals := st.rbac.principals(req.Subject)
+	for _, principal := range principals {
+		for _, entry := range st.byResourceSearch.lookup(resourceSearchKey{principal, req.Action, resourceType}) {
+			fn(entry.policy.Resource)
+		}
+	}
+
+	perm := Permission{ResourceType: req.Resource.Type, Action: req.Action}
+	unscoped := false
+	for _, principal := range principals {
+		for _, id := range st.rbac.bySubject[principal] {
+			b := st.rbac.bindings[id]
+			switch {
+			case !st.rbac.permissions[b.Role][perm]:
+			case b.Resource == nil:
+				unscoped = true
+			case b.Resource.Type == resourceType:
+				fn(*b.Resource)
+			}
+		}
+	}
+	var patterns []Entity
+	for _, entries := range st.patternsFor(resourceType) {
+		for _, entry := range entries {
+			p := entry.policy
+			if p.Allow && p.Subject.matchesSome(principals) && matchPattern(p.Action, req.Action) {
+				patterns = append(patterns, p.Resource)
+			}
+		}
+	}
+
+	switch {
+	case unscoped && resourceType == req.Resource.Type:
+		st.resourcesOfType(resourceType, fn)
+	case len(patterns) > 0:
+		st.resourcesOfType(resourceType, func(resource Entity) {
+			if matchesAny(patterns, resource) {
+				fn(resource)
+			}
+		})
+	}
+
+	if st.tupleCount > 0 {
+		st.reachableObjects(req.Subject, func(object Entity) {
+			if object.Type == resourceType {
+				fn(object)
+			}
+		})
+	}
+}
+
+// FindActionsForSubjectAndResource finds actions that the given subject is allowed to perform on the given resource,
+// either by a policy or role binding of the subject or one of its groups, or as relations the subject holds on the resource. A policy with a wildcard action
+// yields the matching actions known for the resource type. Grants on the resource's ancestors count as grants on the resource.
+// Conditions are evaluated with the request's attributes; action properties are unknown.
+// It returns one page of actions and the cursor of the next page, which is empty on the last page.
+func (s *MemoryStore) FindActionsForSubjectAndResource(req Request, page Page) ([]string, string) {
+	st := s.load()
+
+	actions := newCandidates[string](page)
+	consider := func(action string) {
+		actions.add(action, action)
+	}
+
+	principals, lineage := st.rbac.principals(req.Subject), st.lineage(req)
+	for _, resource := range lineage {
+		for _, principal := range principals {
+			for _, entry := range st.byPair.lookup(pairKey{principal, resource}) {
+				consider(entry.policy.Action)
+			}
+		}
+	}
+
+	for _, principal := range principals {
+		for _, id := range st.rbac.bySubject[principal] {
+			b := st.rbac.bindings[id]
+			if !b.appliesToAny(lineage) {
+				continue
+			}
+			for perm := range st.rbac.permissions[b.Role] {
+				if perm.ResourceType == req.Resource.Type {
+					consider(perm.Action)
+				}
+			}
+		}
+	}
+
+	expand := false
+	for _, resource := range lineage {
+		for _, entries := range st.patternsFor(resource.Type) {
+			for _, entry := range entries {
+				p := entry.policy
+				if p.Allow && p.Subject.matchesSome(principals) && p.Resource.Matches(resource) {
+					switch {
+					case entry.cedar != nil && len(entry.cedar.action.entities) > 1:
+						for _, a := range entry.cedar.action.entities {
+							consider(a.ID)
+						}
+					case isPattern(p.Action):
+						expand = true
+					default:
+						consider(p.Action)
+					}
+				}
+			}
+		}
+	}
+	if expand {
+		for _, resource := range lineage {
+			st.actionsOfType(resource.Type, consider)
+		}
+	}
+
+	if st.tupleCount > 0 {
+		for _, resource := range lineage {
+			st.relationsOf(resource, req.Subject, consider)
+		}
+	}
+
+	return actions.page(func(action string) bool {
+		candidate := req
+		candidate.Action, candidate.ActionProperties = action, nil
+		return st.decide(candidate).Allow
+	})
+}
+
+// updateRBAC applies a change to a copy of the roles, groups and role
+// bindings and publishes it if the change succeeds and the result is consistent
+func (s *MemoryStore) updateRBAC(change func(rs *rbacState) error) error {
+	return s.update(func(st *state) error {
+		rs, err := st.rbac.try(change)
+		if err != nil {
+			return err
+		}
+		st.rbac = rs
+		return nil
+	})
+}
+
+// checkRBAC reports whether a change would succeed, without applying it
+func (s *MemoryStore) checkRBAC(change func(rs *rbacState) error) error {
+	_, err := s.load().rbac.try(change)
+	return err
+}
+
+// applyRBAC applies a change without checking its result, e.g. while
+// replaying a log whose intermediate states may be inconsistent
+func (s *MemoryStore) applyRBAC(change func(rs *rbacState)) {
+	s.update(func(st *state) error {
+		rs := st.rbac.clone()
+		change(rs)
+		rs.reindex()
+		st.rbac = rs
+		return nil
+	})
+}
+
+// ListRoles returns all roles, ordered by ID
+func (s *MemoryStore) ListRoles() []Role {
+	rs := s.load().rbac
+	roles := make([]Role, 0, len(rs.roles))
+	for _, id := range sortedKeys(rs.roles) {
+		roles = append(roles, rs.roles[id])
+	}
+	return roles
+}
+
+// GetRole returns the role with the given ID
+func (s *MemoryStore) GetRole(id string) (Role, error) {
+	r, ok := s.load().rbac.roles[id]
+	if !ok {
+		return Role{}, fmt.Errorf("%w: %s", ErrRoleNotFound, id)
+	}
+	return r, nil
+}
+
+// PutRole validates a role and adds it or replaces the role with the same ID.
+// Inherited roles must exist, and inheritance must not form a cycle.
+func (s *MemoryStore) PutRole(r Role) error {
+	if err := r.Validate(); err != nil {
+		return err
+	}
+	return s.updateRBAC(putRole(r))
+}
+
+// RemoveRole removes the role with the given ID. A role that is inherited or bound cannot be removed.
+func (s *MemoryStore) RemoveRole(id string) error {
+	return s.updateRBAC(removeRole(id))
+}
+
+// ListGroups returns all groups, ordered by ID
+func (s *MemoryStore) ListGroups() []Group {
+	rs := s.load().rbac
+	groups := make([]Group, 0, len(rs.groups))
+	for _, id := range sortedKeys(rs.groups) {
+		groups = append(groups, rs.groups[id])
+	}
+	return groups
+}
+
+// GetGroup returns the group with the given ID
+func (s *MemoryStore) GetGroup(id string) (Group, error) {
+	g, ok := s.load().rbac.groups[id]
+	if !ok {
+		return Group{}, fmt.Errorf("%w: %s", ErrGroupNotFound, id)
+	}
+	return g, nil
+}
+
+// PutGroup validates a group and adds it or replaces the group with the same ID
+func (s *MemoryStore) PutGroup(g Group) error {
+	if err := g.Validate(); err != nil {
+		return err
+	}
+	return s.updateRBAC(putGroup(g))
+}
+
+// RemoveGroup removes the group with the given ID. Bindings of the group remain but grant nothing.
+func (s *MemoryStore) RemoveGroup(id string) error {
+	return s.updateRBAC(removeGroup(id))
+}
+
+// ReplaceGroups validates a batch of groups, then atomically removes the
+// groups with the given IDs and adds or replaces the batch. IDs that are not
+// stored are ignored.
+func (s *MemoryStore) ReplaceGroups(remove []string, groups []Group) error {
+	if err := validateReplacement(nil, groups); err != nil {
+		return err
+	}
+	return s.updateRBAC(replaceGroups(remove, groups))
+}
+
+// ListRoleBindings returns all role bindings, ordered by ID
+func (s *MemoryStore) ListRoleBindings() []RoleBinding {
+	rs := s.load().rbac
+	bindings := make([]RoleBinding, 0, len(rs.bindings))
+	for _, id := range sortedKeys(rs.bindings) {
+		bindings = append(bindings, rs.bindings[id])
+	}
+	return bindings
+}
+
+// GetRoleBinding returns the role binding with the given ID
+func (s *MemoryStore) GetRoleBinding(id string) (RoleBinding, error) {
+	b, ok := s.load().rbac.bindings[id]
+	if !ok {
+		return RoleBinding{}, fmt.Errorf("%w: %s", ErrBindingNotFound, id)
+	}
+	return b, nil
+}
+
+// CountRoleBindings returns the number of stored role bindings
+func (s *MemoryStore) CountRoleBindings() int {
+	return len(s.load().rbac.bindings)
+}
+
+// AddRoleBinding validates a role binding and adds it to the store. A binding
+// without an ID is assigned a generated one. The role must exist. It returns the stored binding.
+func (s *MemoryStore) AddRoleBinding(b RoleBinding) (RoleBinding, error) {
+	if err := b.Validate(); err != nil {
+		return RoleBinding{}, err
+	}
+	if b.ID == "" {
+		b.ID = newPolicyID()
+	}
+
+	if err := s.updateRBAC(addRoleBinding(b)); err != nil {
+		return RoleBinding{}, err
+	}
+	return b, nil
+}
+
+// RemoveRoleBinding removes the role binding with the given ID
+func (s *MemoryStore) RemoveRoleBinding(id string) error {
+	return s.updateRBAC(removeRoleBinding(id))
+}
+
+// restoreRBAC replaces all roles, groups and role bindings, e.g. from a snapshot.
+// The set is checked as a whole, so roles may appear in any order.
+func (s *MemoryStore) restoreRBAC(roles []Role, groups []Group, bindings []RoleBinding) error {
+	return s.updateRBAC(func(rs *rbacState) error {
+		*rs = *newRBACState()
+		for _, r := range roles {
+			rs.roles[r.ID] = r
+		}
+		for _, g := range groups {
+			rs.groups[g.ID] = g
+		}
+		for _, b := range bindings {
+			rs.bindings[b.ID] = b
+		}
+		return nil
+	})
+}
+
+// ListNamespaces returns all relation namespaces, ordered by name
+func (s *MemoryStore) ListNamespaces() []Namespace {
+	st := s.load()
+	namespaces := make([]Namespace, 0, len(st.namespaces))
+	for _, name := range sortedKeys(st.namespaces) {
+		namespaces = append(namespaces, st.namespaces[name])
+	}
+	return namespaces
+}
+
+// GetNamespace returns the relation namespace of the given object type
+func (s *MemoryStore) GetNamespace(name string) (Namespace, error) {
+	ns, ok := s.load().namespaces[name]
+	if !ok {
+		return Namespace{}, fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+	}
+	return ns, nil
+}
+
+// PutNamespace validates a relation namespace and adds it or replaces the namespace with the same name
+func (s *MemoryStore) PutNamespace(ns Namespace) error {
+	if err := ns.Validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		st.putNamespace(ns)
+		return nil
+	})
+}
+
+// RemoveNamespace removes the relation namespace with the given name. Its
+// object types' tuples remain and are then evaluated as direct tuples only.
+func (s *MemoryStore) RemoveNamespace(name string) error {
+	return s.update(func(st *state) error {
+		if _, ok := st.namespaces[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrNamespaceNotFound, name)
+		}
+		st.removeNamespace(name)
+		return nil
+	})
+}
+
+// putNamespace adds or replaces a namespace in the transaction
+func (st *state) putNamespace(ns Namespace) {
+	namespaces := make(map[string]Namespace, len(st.namespaces)+1)
+	for name, existing := range st.namespaces {
+		namespaces[name] = existing
+	}
+	namespaces[ns.Name] = ns
+	st.namespaces = namespaces
+}
+
+// removeNamespace removes a namespace in the transaction
+func (st *state) removeNamespace(name string) {
+	namespaces := make(map[string]Namespace, len(st.namespaces))
+	for n, existing := range st.namespaces {
+		if n != name {
+			namespaces[n] = existing
+		}
+	}
+	st.namespaces = namespaces
+}
+
+// CombiningAlgorithm returns the algorithm that combines policy sets and policies without a set
+func (s *MemoryStore) CombiningAlgorithm() CombiningAlgorithm {
+	return s.load().algorithm
+}
+
+// SetCombiningAlgorithm changes the algorithm that combines policy sets and policies without a set
+func (s *MemoryStore) SetCombiningAlgorithm(a CombiningAlgorithm) error {
+	if err := a.Validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		st.algorithm = a
+		return nil
+	})
+}
+
+// ListPolicySets returns all policy sets, ordered by name
+func (s *MemoryStore) ListPolicySets() []PolicySet {
+	st := s.load()
+	sets := make([]PolicySet, 0, len(st.policySets))
+	for _, name := range sortedKeys(st.policySets) {
+		sets = append(sets, st.policySets[name])
+	}
+	return sets
+}
+
+// GetPolicySet returns the policy set with the given name
+func (s *MemoryStore) GetPolicySet(name string) (PolicySet, error) {
+	ps, ok := s.load().policySets[name]
+	if !ok {
+		return PolicySet{}, fmt.Errorf("%w: %s", ErrPolicySetNotFound, name)
+	}
+	return ps, nil
+}
+
+// PutPolicySet validates a policy set and adds it or replaces the set with the same name
+func (s *MemoryStore) PutPolicySet(ps PolicySet) error {
+	if err := ps.Validate(); err != nil {
+		return err
+	}
+
+	return s.update(func(st *state) error {
+		st.putPolicySet(ps)
+		return nil
+	})
+}
+
+// RemovePolicySet removes the policy set with the given name. Its policies
+// remain and are then combined with the store's algorithm.
+func (s *MemoryStore) RemovePolicySet(name string) error {
+	return s.update(func(st *state) error {
+		if _, ok := st.policySets[name]; !ok {
+			return fmt.Errorf("%w: %s", ErrPolicySetNotFound, name)
+		}
+		st.removePolicySet(name)
+		return nil
+	})
+}
+
+// putPolicySet adds or replaces a policy set in the transaction
+func (st *state) putPolicySet(ps PolicySet) {
+	sets := make(map[string]PolicySet, len(st.policySets)+1)
+	for name, existing := range st.policySets {
+		sets[name] = existing
+	}
+	sets[ps.Name] = ps
+	st.policySets = sets
+}
+
+// removePolicySet removes a policy set in the transaction
+func (st *state) removePolicySet(name string) {
+	sets := make(map[string]PolicySet, len(st.policySets))
+	for n, existing := range st.policySets {
+		if n != name {
+			sets[n] = existing
+		}
+	}
+	st.policySets = sets
+}
+
+// WriteRelationTuples atomically removes and adds relation tuples. Tuples
+// are validated against the namespaces of their objects. Adding a stored
+// tuple or removing a missing one has no effect.
+func (s *MemoryStore) WriteRelationTuples(writes, deletes []RelationTuple) error {
+	return s.update(func(st *state) error {
+		if err := st.checkTuples(writes); err != nil {
+			return err
+		}
+		st.writeTuples(writes, deletes)
+		return nil
+	})
+}
+
+// ReadRelationTuples returns the relation tuples that match the filter,
+// ordered by their text form
+func (s *MemoryStore) ReadRelationTuples(filter TupleFilter) []RelationTuple {
+	st := s.load()
+
+	tuples := make([]RelationTuple, 0)
+	collect := func(t RelationTuple) {
+		if filter.Matches(t) {
+			tuples = append(tuples, t)
+		}
+	}
+
+	switch {
+	case filter.Object.Type != "" && filter.Object.ID != "" && filter.Relation != "":
+		for _, t := range st.tuplesByObject.lookup(objectRelationKey{filter.Object, filter.Relation}) {
+			collect(t)
+		}
+	case filter.Subject.Type != "" && filter.Subject.ID != "":
+		for _, t := range st.tuplesBySubject.lookup(subjectKey(filter.Subject)) {
+			collect(t)
+		}
+	default:
+		st.tuplesByObject.each(func(_ objectRelationKey, p *postings[RelationTuple]) {
+			for _, t := range p.entries {
+				collect(t)
+			}
+		})
+	}
+
+	sort.Slice(tuples, func(i, j int) bool { return tuples[i].String() < tuples[j].String() })
+	return tuples
+}
+
+// matchesAny reports whether any of the patterns matches the entity
+func matchesAny(patterns []Entity, e Entity) bool {
+	for _, p := range patterns {
+		if p.Matches(e) {
+			return true
+		}
+	}
+	return false
+}
+
+// candidates collects the results a search may return, keyed by "type:id"
+// for entities and the name for actions. Candidates on pages already
+// returned are dropped as they are added; the rest are decided one at a time
+// in key order, and only until the page is full.
+type candidates[T interface{}] struct {
+	cursor string
+	limit  int
+	keys   keyHeap
+	items  map[string]T
+}
+
+func newCandidates[T interface{}](page Page) *candidates[T] {
+	return &candidates[T]{cursor: page.Cursor, limit: page.Limit, items: make(map[string]T)}
+}
+
+// add adds a candidate unless it is on an earlier page or already added
+func (c *candidates[T]) add(key string, item T) {
+	if c.cursor != "" && key <= c.cursor {
+		return
+	}
+	if _, ok := c.items[key]; ok {
+		return
+	}
+	c.items[key] = item
+	c.keys = append(c.keys, key)
+}
+
+// page returns the first page.Limit candidates that allowed accepts, in key
+// order, along with the cursor of the next page, which is empty if no
+// further candidate is accepted
+func (c *candidates[T]) page(allowed func(T) bool) ([]T, string) {
+	heap.Init(&c.keys)
+	results := make([]T, 0)
+	last := ""
+	for c.keys.Len() > 0 {
+		key := heap.Pop(&c.keys).(string)
+		item := c.items[key]
+		if !allowed(item) {
+			continue
+		}
+		if c.limit > 0 && len(results) == c.limit {
+			return results, last
+		}
+		results = append(results, item)
+		last = key
+	}
+	return results, ""
+}
+
+// keyHeap is a min-heap of candidate keys
+type keyHeap []string
+
+func (h keyHeap) Len() int            { return len(h) }
+func (h keyHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h keyHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *keyHeap) Push(x interface{}) { *h = append(*h, x.(string)) }
+func (h *keyHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
@@ -0,0 +1,188 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// benchmarkPolicies is the number of policies in the benchmark store
+const benchmarkPolicies = 1000000
+
+var (
+	benchmarkOnce  sync.Once
+	benchmarkStore *MemoryStore
+)
+
+// loadBenchmarkStore returns a store with 1M policies: 10,000 users with
+// read and write policies on 50 documents each, every tenth one a deny
+func loadBenchmarkStore(b *testing.B) *MemoryStore {
+	benchmarkOnce.Do(func() {
+		policies := make([]Policy, 0, benchmarkPolicies)
+		for i := 0; len(policies) < benchmarkPolicies; i++ {
+			user := Entity{Type: "user", ID: fmt.Sprintf("user%d", i/100)}
+			doc := Entity{Type: "document", ID: fmt.Sprintf("doc%d", i%5000)}
+			action := "read"
+			if i%2 == 1 {
+				action = "write"
+			}
+			policies = append(policies, Policy{
+				ID:       fmt.Sprintf("p%d", i),
+				Subject:  user,
+				Resource: doc,
+				Action:   action,
+				Allow:    i%10 != 0,
+			})
+		}
+
+		benchmarkStore = NewMemoryStore()
+		if _, err := benchmarkStore.UpsertPolicies(policies); err != nil {
+			panic(err)
+		}
+	})
+
+	b.ResetTimer()
+	return benchmarkStore
+}
+
+func BenchmarkEvaluate(b *testing.B) {
+	s := loadBenchmarkStore(b)
+
+	for i := 0; i < b.N; i++ {
+		n := i % benchmarkPolicies
+		s.Evaluate(Request{
+			Subject:  Entity{Type: "user", ID: fmt.Sprintf("user%d", n/100)},
+			Resource: Entity{Type: "document", ID: fmt.Sprintf("doc%d", n%5000)},
+			Action:   "read",
+		})
+	}
+}
+
+func BenchmarkEvaluateParallel(b *testing.B) {
+	s := loadBenchmarkStore(b)
+
+	b.RunParallel(func(pb *testing.PB) {
+		req := Request{
+			Subject:  Entity{Type: "user", ID: "user42"},
+			Resource: Entity{Type: "document", ID: "doc4200"},
+			Action:   "read",
+		}
+		for pb.Next() {
+			s.Evaluate(req)
+		}
+	})
+}
+
+func BenchmarkFindSubjectsForResource(b *testing.B) {
+	s := loadBenchmarkStore(b)
+	req := Request{
+		Subject:  Entity{Type: "user"},
+		Resource: Entity{Type: "document", ID: "doc4200"},
+		Action:   "read",
+	}
+
+	for i := 0; i < b.N; i++ {
+		s.FindSubjectsForResource(req, Page{Limit: 100}, SubjectSearch{})
+	}
+}
+
+func BenchmarkFindResourcesForSubject(b *testing.B) {
+	s := loadBenchmarkStore(b)
+	req := Request{
+		Subject:  Entity{Type: "user", ID: "user42"},
+		Resource: Entity{Type: "document"},
+		Action:   "read",
+	}
+
+	for i := 0; i < b.N; i++ {
+		s.FindResourcesForSubject(req, Page{Limit: 100})
+	}
+}
+
+func BenchmarkFindActionsForSubjectAndResource(b *testing.B) {
+	s := loadBenchmarkStore(b)
+	req := Request{
+		Subject:  Entity{Type: "user", ID: "user42"},
+		Resource: Entity{Type: "document", ID: "doc4200"},
+	}
+
+	for i := 0; i < b.N; i++ {
+		s.FindActionsForSubjectAndResource(req, Page{})
+	}
+}
+
+func BenchmarkUpdatePolicy(b *testing.B) {
+	s := loadBenchmarkStore(b)
+
+	for i := 0; i < b.N; i++ {
+		err := s.UpdatePolicy(Policy{
+			ID:       "p4200",
+			Subject:  Entity{Type: "user", ID: "user42"},
+			Resource: Entity{Type: "document", ID: "doc4200"},
+			Action:   "read",
+			Allow:    i%2 == 0,
+		})
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+// TestValidityPeriod checks that evaluation and the searches ignore policies
+// outside their validity period and that decisions expire when it changes
+func TestValidityPeriod(t *testing.T) {
+	now := time.Now()
+	past, soon, later := now.Add(-time.Hour), now.Add(time.Hour), now.Add(2*time.Hour)
+
+	s := NewMemoryStore()
+	for _, p := range []Policy{
+		{ID: "expired", Subject: user("alice"), Resource: doc("1"), Action: "read", Allow: true, NotAfter: &past},
+		{ID: "pending", Subject: user("alice"), Resource: doc("2"), Action: "read", Allow: true, NotBefore: &soon},
+		{ID: "active", Subject: user("alice"), Resource: doc("3"), Action: "read", Allow: true, NotBefore: &past, NotAfter: &later},
+		{ID: "permanent", Subject: user("alice"), Resource: doc("4"), Action: "read", Allow: true},
+		{ID: "temporary deny", Subject: user("alice"), Resource: doc("4"), Action: "read", NotBefore: &soon, NotAfter: &later},
+	} {
+		if _, err := s.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		resource string
+		allow    bool
+		expires  time.Time
+	}{
+		{resource: "1"},
+		{resource: "2", expires: soon},
+		{resource: "3", allow: true, expires: later},
+		{resource: "4", allow: true, expires: soon}, // Denied once the temporary deny starts
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			d := s.Evaluate(Request{Subject: user("alice"), Resource: doc(tt.resource), Action: "read"})
+			if d.Allow != tt.allow || !d.Expires.Equal(tt.expires) {
+				t.Errorf("Allow = %v, Expires = %s, want %v, %s", d.Allow, d.Expires, tt.allow, tt.expires)
+			}
+		})
+	}
+
+	resources, _ := s.FindResourcesForSubject(Request{Subject: user("alice"), Resource: Entity{Type: "doc"}, Action: "read"}, Page{})
+	if want := []Entity{doc("3"), doc("4")}; !reflect.DeepEqual(resources, want) {
+		t.Errorf("FindResourcesForSubject = %v, want %v", resources, want)
+	}
+	for _, id := range []string{"1", "2"} {
+		if subjects, _ := s.FindSubjectsForResource(Request{Subject: Entity{Type: "user"}, Resource: doc(id), Action: "read"}, Page{}, SubjectSearch{}); len(subjects) != 0 {
+			t.Errorf("FindSubjectsForResource(%s) = %v, want none", id, subjects)
+		}
+		if actions, _ := s.FindActionsForSubjectAndResource(Request{Subject: user("alice"), Resource: doc(id)}, Page{}); len(actions) != 0 {
+			t.Errorf("FindActionsForSubjectAndResource(%s) = %v, want none", id, actions)
+		}
+	}
+
+	if err := (Policy{ID: "inverted", Subject: user("alice"), Resource: doc("5"), Action: "read", NotBefore: &later, NotAfter: &soon}).Validate(); !errors.Is(err, ErrInvalidPolicy) {
+		t.Errorf("Validate with not_before after not_after = %v, want %v", err, ErrInvalidPolicy)
+	}
+}
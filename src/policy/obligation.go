@@ -0,0 +1,95 @@
+package policy
+
+import "fmt"
+
+// Directive is an obligation or advice that a policy attaches to the
+// decisions it takes part in, e.g. {ID: "mask_fields", Attributes:
+// {"fields": ["ssn"]}}. A PEP must fulfil the obligations of a decision and
+// may follow or ignore its advice.
+type Directive struct {
+	ID         string                 `json:"id"`                   // What the PEP is asked to do, e.g. "log_access" or "require_mfa"
+	Attributes map[string]interface{} `json:"attributes,omitempty"` // Parameters of the directive
+}
+
+// Validate checks that the directive is complete
+func (d Directive) Validate() error {
+	if d.ID == "" {
+		return fmt.Errorf("%w: directive id is required", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// mergeDirectives merges the directives of the policies that contributed
+// to a decision, given in evaluation order. Directives with the same ID
+// become one, in order of first appearance. Their list attributes are
+// combined without duplicates; for any other attribute, the value of the
+// policy earliest in evaluation order, i.e. the most specific one, wins.
+func mergeDirectives(entries []*stored, directives func(Policy) []Directive) []Directive {
+	var (
+		merged []Directive
+		at     map[string]int // Position of each directive ID in merged
+	)
+	for _, entry := range entries {
+		for _, d := range directives(entry.policy) {
+			if at == nil {
+				at = make(map[string]int)
+			}
+			i, ok := at[d.ID]
+			if !ok {
+				at[d.ID] = len(merged)
+				merged = append(merged, Directive{ID: d.ID, Attributes: copyAttributes(d.Attributes)})
+				continue
+			}
+			if merged[i].Attributes == nil && len(d.Attributes) > 0 {
+				merged[i].Attributes = make(map[string]interface{}, len(d.Attributes))
+			}
+			for k, v := range d.Attributes {
+				current, ok := merged[i].Attributes[k]
+				if !ok {
+					merged[i].Attributes[k] = v
+					continue
+				}
+				if list, ok := current.([]interface{}); ok {
+					if more, ok := v.([]interface{}); ok {
+						merged[i].Attributes[k] = unionList(list, more)
+					}
+				}
+			}
+		}
+	}
+	return merged
+}
+
+// copyAttributes copies the attributes of a directive so that merging
+// never modifies a stored policy
+func copyAttributes(attrs map[string]interface{}) map[string]interface{} {
+	if attrs == nil {
+		return nil
+	}
+	c := make(map[string]interface{}, len(attrs))
+	for k, v := range attrs {
+		c[k] = v
+	}
+	return c
+}
+
+// unionList appends the elements of more that list does not hold
+func unionList(list, more []interface{}) []interface{} {
+	union := append([]interface{}(nil), list...)
+	for _, v := range more {
+		found := false
+		for _, w := range union {
+			if equal(v, w) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			union = append(union, v)
+		}
+	}
+	return union
+}
+
+func obligationsOf(p Policy) []Directive { return p.Obligations }
+func adviceOf(p Policy) []Directive      { return p.Advice }
@@ -0,0 +1,107 @@
+package policy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Wildcard matches any sequence of characters in a policy's subject ID,
+// resource ID or action, e.g. "*" for any value or "report-*" for a prefix.
+// An entity type is either literal or a lone wildcard, which requires the
+// ID to be a lone wildcard too.
+const Wildcard = "*"
+
+// isPattern reports whether a policy field contains a wildcard
+func isPattern(s string) bool {
+	return strings.Contains(s, Wildcard)
+}
+
+// matchPattern reports whether the value matches the pattern
+func matchPattern(pattern, value string) bool {
+	if !isPattern(pattern) {
+		return pattern == value
+	}
+
+	parts := strings.Split(pattern, Wildcard)
+	if !strings.HasPrefix(value, parts[0]) {
+		return false
+	}
+	value = value[len(parts[0]):]
+
+	last := parts[len(parts)-1]
+	for _, part := range parts[1 : len(parts)-1] {
+		i := strings.Index(value, part)
+		if i < 0 {
+			return false
+		}
+		value = value[i+len(part):]
+	}
+	return len(value) >= len(last) && strings.HasSuffix(value, last)
+}
+
+// IsPattern reports whether the entity matches more than one entity
+func (e Entity) IsPattern() bool {
+	return isPattern(e.Type) || isPattern(e.ID)
+}
+
+// Matches reports whether the entity, which may be a pattern, matches another entity
+func (e Entity) Matches(other Entity) bool {
+	return matchPattern(e.Type, other.Type) && matchPattern(e.ID, other.ID)
+}
+
+// matchesSome reports whether the entity, which may be a pattern, matches any of the entities
+func (e Entity) matchesSome(others []Entity) bool {
+	for _, other := range others {
+		if e.Matches(other) {
+			return true
+		}
+	}
+	return false
+}
+
+// validatePattern checks the wildcards of an entity
+func validatePattern(e Entity) error {
+	if isPattern(e.Type) && (e.Type != Wildcard || e.ID != Wildcard) {
+		return fmt.Errorf("%w: entity type %q must be literal or %q with id %q", ErrInvalidPolicy, e.Type, Wildcard, Wildcard)
+	}
+	return nil
+}
+
+// IsPattern reports whether the policy's subject, resource or action contains a wildcard
+func (p Policy) IsPattern() bool {
+	return p.Subject.IsPattern() || p.Resource.IsPattern() || isPattern(p.Action)
+}
+
+// Matches reports whether the policy's subject, resource and action, which may be patterns, match the request
+func (p Policy) Matches(req Request) bool {
+	return p.Subject.Matches(req.Subject) && p.Resource.Matches(req.Resource) && matchPattern(p.Action, req.Action)
+}
+
+// specificity ranks how narrowly a pattern matches. A literal value ranks
+// above any pattern; among patterns, more literal characters rank higher,
+// so "report-*" ranks above "r*", which ranks above "*".
+func specificity(pattern string) int {
+	if !isPattern(pattern) {
+		return 1 << 30
+	}
+	return len(pattern) - strings.Count(pattern, Wildcard)
+}
+
+// moreSpecific reports whether policy a matches more narrowly than policy b.
+// Subjects are compared first, then resources, then actions; within an
+// entity the type is compared before the ID.
+func moreSpecific(a, b Policy) bool {
+	ranks := [...][2]int{
+		{specificity(a.Subject.Type), specificity(b.Subject.Type)},
+		{specificity(a.Subject.ID), specificity(b.Subject.ID)},
+		{specificity(a.Resource.Type), specificity(b.Resource.Type)},
+		{specificity(a.Resource.ID), specificity(b.Resource.ID)},
+		{specificity(a.Action), specificity(b.Action)},
+	}
+	for _, r := range ranks {
+		if r[0] != r[1] {
+			return r[0] > r[1]
+		}
+	}
+	return false
+}
@@ -0,0 +1,171 @@
+package policy
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestMatchPattern(t *testing.T) {
+	tests := []struct {
+		pattern string
+		value   string
+		want    bool
+	}{
+		{pattern: "report", value: "report", want: true},
+		{pattern: "report", value: "reports"},
+		{pattern: "*", value: "", want: true},
+		{pattern: "*", value: "anything", want: true},
+		{pattern: "report-*", value: "report-q1", want: true},
+		{pattern: "report-*", value: "report-", want: true},
+		{pattern: "report-*", value: "report"},
+		{pattern: "*-q1", value: "report-q1", want: true},
+		{pattern: "*-q1", value: "report-q2"},
+		{pattern: "a*a", value: "a"},
+		{pattern: "a*a", value: "aa", want: true},
+		{pattern: "a*a", value: "aba", want: true},
+		{pattern: "a*a", value: "ab"},
+		{pattern: "a*b*c", value: "abc", want: true},
+		{pattern: "a*b*c", value: "axbyc", want: true},
+		{pattern: "a*b*c", value: "acb"},
+		{pattern: "a*b*b", value: "ab"},
+		{pattern: "a*b*b", value: "abb", want: true},
+		{pattern: "**", value: "x", want: true},
+		{pattern: "a**a", value: "a"},
+		{pattern: "*x*", value: "x", want: true},
+		{pattern: "*x*", value: "y"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+" "+tt.value, func(t *testing.T) {
+			if got := matchPattern(tt.pattern, tt.value); got != tt.want {
+				t.Errorf("matchPattern(%q, %q) = %v, want %v", tt.pattern, tt.value, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSpecificity(t *testing.T) {
+	// From the most to the least specific
+	ordered := []string{"report-q1", "report-*", "r*", "*"}
+	for i := 1; i < len(ordered); i++ {
+		if specificity(ordered[i-1]) <= specificity(ordered[i]) {
+			t.Errorf("specificity(%q) = %d, not above specificity(%q) = %d",
+				ordered[i-1], specificity(ordered[i-1]), ordered[i], specificity(ordered[i]))
+		}
+	}
+	if specificity("a") <= specificity("a-very-long-pattern-*") {
+		t.Error("a short literal does not rank above a long pattern")
+	}
+	if specificity("r**") != specificity("r*") {
+		t.Error("repeated wildcards change the specificity")
+	}
+}
+
+func TestMoreSpecific(t *testing.T) {
+	base := Policy{Subject: user("alice"), Resource: doc("1"), Action: "read"}
+	with := func(change func(p *Policy)) Policy {
+		p := base
+		change(&p)
+		return p
+	}
+	tests := []struct {
+		name string
+		a, b Policy
+	}{
+		{name: "literal subject id", a: base, b: with(func(p *Policy) { p.Subject.ID = "a*" })},
+		{name: "longer subject id pattern", a: with(func(p *Policy) { p.Subject.ID = "al*" }), b: with(func(p *Policy) { p.Subject.ID = "*" })},
+		{name: "literal subject type", a: with(func(p *Policy) { p.Subject.ID = "*" }), b: with(func(p *Policy) { p.Subject = Entity{Type: Wildcard, ID: Wildcard} })},
+		{name: "literal resource id", a: base, b: with(func(p *Policy) { p.Resource.ID = "*" })},
+		{name: "literal action", a: base, b: with(func(p *Policy) { p.Action = "*" })},
+		{name: "action pattern", a: with(func(p *Policy) { p.Action = "re*" }), b: with(func(p *Policy) { p.Action = "*" })},
+		{
+			name: "subject before resource",
+			a:    with(func(p *Policy) { p.Resource.ID = "*" }),
+			b:    with(func(p *Policy) { p.Subject.ID = "a*" }),
+		},
+		{
+			name: "resource before action",
+			a:    with(func(p *Policy) { p.Action = "*" }),
+			b:    with(func(p *Policy) { p.Resource.ID = "1*" }),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if !moreSpecific(tt.a, tt.b) {
+				t.Error("moreSpecific(a, b) = false, want true")
+			}
+			if moreSpecific(tt.b, tt.a) {
+				t.Error("moreSpecific(b, a) = true, want false")
+			}
+		})
+	}
+	if moreSpecific(base, base) {
+		t.Error("a policy is more specific than itself")
+	}
+}
+
+// TestSpecificityOrdersFirstApplicable checks that the most specific policy
+// is evaluated first regardless of the order the policies were added in
+func TestSpecificityOrdersFirstApplicable(t *testing.T) {
+	s := NewMemoryStore()
+	if err := s.SetCombiningAlgorithm(FirstApplicable); err != nil {
+		t.Fatal(err)
+	}
+	report := Entity{Type: "doc", ID: "report-q1"}
+	for _, p := range []Policy{
+		{ID: "any", Subject: user("alice"), Resource: Entity{Type: "doc", ID: "*"}, Action: "read", Allow: true},
+		{ID: "r", Subject: user("alice"), Resource: Entity{Type: "doc", ID: "r*"}, Action: "read"},
+		{ID: "reports", Subject: user("alice"), Resource: Entity{Type: "doc", ID: "report-*"}, Action: "read", Allow: true},
+		{ID: "literal", Subject: user("alice"), Resource: report, Action: "read"},
+	} {
+		if _, err := s.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		resource string
+		policy   string
+	}{
+		{resource: "report-q1", policy: "literal"},
+		{resource: "report-q2", policy: "reports"},
+		{resource: "readme", policy: "r"},
+		{resource: "notes", policy: "any"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.resource, func(t *testing.T) {
+			d := s.Evaluate(Request{Subject: user("alice"), Resource: Entity{Type: "doc", ID: tt.resource}, Action: "read"})
+			if d.Policy == nil || d.Policy.ID != tt.policy {
+				t.Errorf("Policy = %v, want %s", d.Policy, tt.policy)
+			}
+		})
+	}
+}
+
+func TestValidatePattern(t *testing.T) {
+	tests := []struct {
+		entity Entity
+		valid  bool
+	}{
+		{entity: Entity{Type: "user", ID: "alice"}, valid: true},
+		{entity: Entity{Type: "user", ID: "*"}, valid: true},
+		{entity: Entity{Type: "user", ID: "a*"}, valid: true},
+		{entity: Entity{Type: "*", ID: "*"}, valid: true},
+		{entity: Entity{Type: "us*", ID: "*"}},
+		{entity: Entity{Type: "us*", ID: "alice"}},
+		{entity: Entity{Type: "*er", ID: "*"}},
+		{entity: Entity{Type: "**", ID: "*"}},
+		{entity: Entity{Type: "*", ID: "alice"}},
+		{entity: Entity{Type: "*", ID: "a*"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.entity.String(), func(t *testing.T) {
+			err := validatePattern(tt.entity)
+			if tt.valid && err != nil {
+				t.Errorf("validatePattern = %v, want nil", err)
+			}
+			if !tt.valid && !errors.Is(err, ErrInvalidPolicy) {
+				t.Errorf("validatePattern = %v, want %v", err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
@@ -0,0 +1,300 @@
+package policy
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"time"
+)
+
+// Errors returned by policy store operations
+var (
+	ErrPolicyNotFound = errors.New("policy not found")
+	ErrPolicyExists   = errors.New("policy already exists")
+	ErrInvalidPolicy  = errors.New("invalid policy")
+)
+
+// Entity identifies a typed subject or resource, e.g. {Type: "user", ID: "alice"}
+type Entity struct {
+	Type string `json:"type"` // Entity type
+	ID   string `json:"id"`   // Entity identifier, unique within its type
+}
+
+// String returns the entity in "type:id" form
+func (e Entity) String() string {
+	return e.Type + ":" + e.ID
+}
+
+// Policy represents an authorization policy. The subject and resource IDs
+// and the action may contain wildcards (see Wildcard).
+type Policy struct {
+	ID       string  `json:"id"`                 // Stable policy identifier
+	Subject  Entity  `json:"subject"`            // Subject (user, etc.)
+	Resource Entity  `json:"resource"`           // Resource
+	Action   string  `json:"action"`             // Action
+	Allow    bool    `json:"allow"`              // Whether to allow or deny
+	Reason   *Reason `json:"reason,omitempty"`   // Optional explanation returned with the decision
+	Set      string  `json:"set,omitempty"`      // Policy set the policy is combined in; empty for none
+	Priority int     `json:"priority,omitempty"` // Precedence under the priority-ordered algorithm; higher wins
+
+	// Conditions that the request's attributes must all satisfy for the
+	// policy to apply; a policy has either Conditions or Condition
+	Conditions []Condition `json:"conditions,omitempty"`
+
+	// Expression that the request must satisfy for the policy to apply (see
+	// Expression), e.g. "resource.properties.owner == subject.id"
+	Condition string `json:"condition,omitempty"`
+
+	// Text of the Cedar policy the policy was parsed from (see ParseCedar),
+	// which decides whether it applies; empty for a native policy
+	Cedar string `json:"cedar,omitempty"`
+
+	// Policy file the policy was loaded from (see Loader); empty for a policy
+	// managed through the API
+	Source string `json:"source,omitempty"`
+
+	NotBefore *time.Time `json:"not_before,omitempty"` // Time from which the policy applies; nil for always
+	NotAfter  *time.Time `json:"not_after,omitempty"`  // Time from which the policy no longer applies and is removed; nil for never
+
+	// Obligations and advice returned with the decisions the policy takes
+	// part in, i.e. when its effect is the decision (see Decision)
+	Obligations []Directive `json:"obligations,omitempty"`
+	Advice      []Directive `json:"advice,omitempty"`
+}
+
+// Validate checks that the policy is complete. The ID may be empty; the store assigns one.
+func (p Policy) Validate() error {
+	if p.Subject.Type == "" || p.Subject.ID == "" {
+		return fmt.Errorf("%w: subject type and id are required", ErrInvalidPolicy)
+	}
+	if p.Resource.Type == "" || p.Resource.ID == "" {
+		return fmt.Errorf("%w: resource type and id are required", ErrInvalidPolicy)
+	}
+	if p.Action == "" {
+		return fmt.Errorf("%w: action is required", ErrInvalidPolicy)
+	}
+	if err := validatePattern(p.Subject); err != nil {
+		return fmt.Errorf("subject: %w", err)
+	}
+	if err := validatePattern(p.Resource); err != nil {
+		return fmt.Errorf("resource: %w", err)
+	}
+	if p.Reason != nil && p.Reason.ID == "" {
+		return fmt.Errorf("%w: reason id is required", ErrInvalidPolicy)
+	}
+	for i, c := range p.Conditions {
+		if err := c.Validate(); err != nil {
+			return fmt.Errorf("conditions[%d]: %w", i, err)
+		}
+	}
+	for i, d := range p.Obligations {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("obligations[%d]: %w", i, err)
+		}
+	}
+	for i, d := range p.Advice {
+		if err := d.Validate(); err != nil {
+			return fmt.Errorf("advice[%d]: %w", i, err)
+		}
+	}
+	if p.NotBefore != nil && p.NotAfter != nil && !p.NotBefore.Before(*p.NotAfter) {
+		return fmt.Errorf("%w: not_before must be before not_after", ErrInvalidPolicy)
+	}
+	if p.Condition != "" {
+		if len(p.Conditions) > 0 {
+			return fmt.Errorf("%w: conditions and condition cannot be combined", ErrInvalidPolicy)
+		}
+		if _, err := compileShared(p.Condition); err != nil {
+			return fmt.Errorf("%w: condition: %v", ErrInvalidPolicy, err)
+		}
+	}
+	if p.Cedar != "" {
+		return p.validateCedar()
+	}
+	if p.Set == CedarPolicySet {
+		return fmt.Errorf("%w: policy set %q is reserved for cedar policies", ErrInvalidPolicy, CedarPolicySet)
+	}
+	return nil
+}
+
+// ActiveAt reports whether the policy is within its validity period at the given time
+func (p Policy) ActiveAt(t time.Time) bool {
+	return (p.NotBefore == nil || !t.Before(*p.NotBefore)) && (p.NotAfter == nil || t.Before(*p.NotAfter))
+}
+
+// ExpiredAt reports whether the policy's validity period has ended at the given time
+func (p Policy) ExpiredAt(t time.Time) bool {
+	return p.NotAfter != nil && !t.Before(*p.NotAfter)
+}
+
+// Applies reports whether the request satisfies all of the policy's
+// conditions and its condition expression. It fails if the expression
+// cannot be evaluated. A Cedar policy applies if the request satisfies its
+// scope and conditions; outside a store, an entity is only in itself.
+func (p Policy) Applies(req Request) (bool, error) {
+	if p.Cedar != "" {
+		return compileCedar(p.Cedar).applies(nil, req), nil
+	}
+	return p.applies(req, compileCondition(p.Condition), parseTimeWindows(p.Conditions))
+}
+
+// applies is Applies with the condition expression already compiled and the
+// time windows already parsed
+func (p Policy) applies(req Request, condition *Expression, windows timeWindows) (bool, error) {
+	for i, c := range p.Conditions {
+		if !c.matches(req, windows.at(i)) {
+			return false, nil
+		}
+	}
+	if condition == nil {
+		return true, nil
+	}
+	return condition.Evaluate(req)
+}
+
+// Reason explains why a policy allows or denies access.
+// Admin and User map language tags (e.g. "en") to messages.
+type Reason struct {
+	ID    string            `json:"id"`                     // Reason identifier
+	Admin map[string]string `json:"reason_admin,omitempty"` // Reason for administrators, never shown to end users
+	User  map[string]string `json:"reason_user,omitempty"`  // Reason that may be shown to end users
+}
+
+// PolicyFilter selects policies by their fields. Empty fields match any value.
+type PolicyFilter struct {
+	SubjectType  string
+	SubjectID    string
+	ResourceType string
+	ResourceID   string
+	Action       string
+	Source       string
+}
+
+// Matches reports whether the policy satisfies the filter
+func (f PolicyFilter) Matches(p Policy) bool {
+	return (f.SubjectType == "" || f.SubjectType == p.Subject.Type) &&
+		(f.SubjectID == "" || f.SubjectID == p.Subject.ID) &&
+		(f.ResourceType == "" || f.ResourceType == p.Resource.Type) &&
+		(f.ResourceID == "" || f.ResourceID == p.Resource.ID) &&
+		(f.Action == "" || f.Action == p.Action) &&
+		(f.Source == "" || f.Source == p.Source)
+}
+
+// Decision represents the outcome of evaluating a policy check
+type Decision struct {
+	Allow   bool         // Whether access is allowed
+	Policy  *Policy      // Policy that decided, or nil if no policy matched or policies conflicted
+	Binding *RoleBinding // Role binding that allowed access when no policy matched
+
+	Set       string             // Policy set whose result decided; empty if a policy without a set decided
+	Algorithm CombiningAlgorithm // Algorithm that selected the deciding policy, if any policy applied
+	Conflict  bool               // Whether several policies applied under the only-one-applicable algorithm
+
+	// Relation that allowed access when neither a policy nor a role binding did, e.g. "document:123#read"
+	Relation string
+
+	// Error evaluating the condition expression of Policy, which denies access
+	Error error
+
+	// Obligations the PEP must fulfil and advice it may follow, merged from
+	// the policies whose effect is the decision: all of them under the
+	// deny-overrides and permit-overrides algorithms, the deciding one under
+	// the others. A policy set contributes the policies its own algorithm
+	// selects. Decisions not taken by a policy carry none.
+	Obligations []Directive
+	Advice      []Directive
+
+	// Time at which the decision may change without a change to the store,
+	// because the validity period of a matching policy starts or ends; zero
+	// if the decision does not depend on time
+	Expires time.Time
+}
+
+// Page selects a window of search results. Results are ordered by key
+// ("type:id" for entities, the name for actions), so a cursor stays valid
+// while policies are added or removed.
+type Page struct {
+	Cursor string // Key of the last result of the previous page; empty for the first page
+	Limit  int    // Maximum number of results; 0 means no limit
+}
+
+// SubjectSearch adjusts a subject search
+type SubjectSearch struct {
+	DirectOnly bool // Whether to omit subjects that are only granted access as members of a group
+}
+
+// Default reasons used when the deciding policy does not carry its own
+var (
+	reasonNoPolicy = Reason{
+		ID:    "no_matching_policy",
+		Admin: map[string]string{"en": "No policy matched the request"},
+		User:  map[string]string{"en": "Access denied by policy"},
+	}
+	reasonAllowed = Reason{
+		ID:    "policy_allow",
+		Admin: map[string]string{"en": "Access allowed by a matching policy"},
+		User:  map[string]string{"en": "Access granted"},
+	}
+	reasonRole = Reason{
+		ID:    "role_allow",
+		Admin: map[string]string{"en": "Access allowed by a role binding"},
+		User:  map[string]string{"en": "Access granted"},
+	}
+	reasonRelation = Reason{
+		ID:    "relation_allow",
+		Admin: map[string]string{"en": "Access allowed by a relation to the resource"},
+		User:  map[string]string{"en": "Access granted"},
+	}
+	reasonConflict = Reason{
+		ID:    "policy_conflict",
+		Admin: map[string]string{"en": "More than one policy applied under the only-one-applicable algorithm"},
+		User:  map[string]string{"en": "Access denied by policy"},
+	}
+	reasonConditionError = Reason{
+		ID:    "condition_error",
+		Admin: map[string]string{"en": "The condition of a matching policy could not be evaluated"},
+		User:  map[string]string{"en": "Access denied by policy"},
+	}
+	reasonDenied = Reason{
+		ID:    "policy_deny",
+		Admin: map[string]string{"en": "Access denied by a matching policy"},
+		User:  map[string]string{"en": "Access denied by policy"},
+	}
+)
+
+// Reason returns the reason for the decision. If the deciding policy has no
+// reason of its own, a default reason describing the outcome is returned.
+func (d Decision) Reason() Reason {
+	switch {
+	case d.Error != nil:
+		return Reason{
+			ID:    reasonConditionError.ID,
+			Admin: map[string]string{"en": fmt.Sprintf("%s: policy %s: %v", reasonConditionError.Admin["en"], d.Policy.ID, d.Error)},
+			User:  reasonConditionError.User,
+		}
+	case d.Conflict:
+		return reasonConflict
+	case d.Policy == nil && d.Binding != nil:
+		return reasonRole
+	case d.Policy == nil && d.Relation != "":
+		return reasonRelation
+	case d.Policy == nil:
+		return reasonNoPolicy
+	case d.Policy.Reason != nil:
+		return *d.Policy.Reason
+	case d.Allow:
+		return reasonAllowed
+	default:
+		return reasonDenied
+	}
+}
+
+// newPolicyID generates a random policy identifier
+func newPolicyID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		panic("cannot generate policy id: " + err.Error())
+	}
+	return hex.EncodeToString(b[:])
+}
@@ -0,0 +1,379 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Errors returned by role, group and role binding operations
+var (
+	ErrRoleNotFound    = errors.New("role not found")
+	ErrGroupNotFound   = errors.New("group not found")
+	ErrBindingNotFound = errors.New("role binding not found")
+	ErrRoleInUse       = errors.New("role is in use")
+)
+
+// GroupType is the entity type that refers to a group, e.g. {Type: "group", ID: "eng"}
+const GroupType = "group"
+
+// Permission allows an action on resources of a type
+type Permission struct {
+	ResourceType string `json:"resource_type"`
+	Action       string `json:"action"`
+}
+
+// Role is a named set of permissions. A role also grants the permissions of
+// the roles it inherits from.
+type Role struct {
+	ID          string       `json:"id"`
+	Permissions []Permission `json:"permissions,omitempty"`
+	Inherits    []string     `json:"inherits,omitempty"` // IDs of the roles whose permissions this role includes
+}
+
+// Group is a named set of subjects that can be bound to roles or granted
+// access by policies together. A member {Type: "group"} nests another
+// group, whose members are members of this group as well.
+type Group struct {
+	ID      string   `json:"id"`
+	Members []Entity `json:"members"`
+	Source  string   `json:"source,omitempty"` // Policy file the group was loaded from (see Loader); empty for a group managed through the API
+}
+
+// RoleBinding grants a role to a subject or group, optionally only on a single resource
+type RoleBinding struct {
+	ID       string  `json:"id"`
+	Subject  Entity  `json:"subject"`            // Subject, or {type: group, id: <group id>} for a group
+	Role     string  `json:"role"`               // ID of the role
+	Resource *Entity `json:"resource,omitempty"` // Resource the binding is limited to; nil for all resources
+}
+
+// Validate checks that the role is complete
+func (r Role) Validate() error {
+	if r.ID == "" {
+		return fmt.Errorf("%w: role id is required", ErrInvalidPolicy)
+	}
+	for i, p := range r.Permissions {
+		if p.ResourceType == "" || p.Action == "" {
+			return fmt.Errorf("permissions[%d]: %w: resource_type and action are required", i, ErrInvalidPolicy)
+		}
+	}
+	for _, parent := range r.Inherits {
+		if parent == r.ID {
+			return fmt.Errorf("%w: role %s cannot inherit from itself", ErrInvalidPolicy, r.ID)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the group is complete
+func (g Group) Validate() error {
+	if g.ID == "" {
+		return fmt.Errorf("%w: group id is required", ErrInvalidPolicy)
+	}
+	for i, m := range g.Members {
+		if m.Type == "" || m.ID == "" {
+			return fmt.Errorf("members[%d]: %w: type and id are required", i, ErrInvalidPolicy)
+		}
+		if m.Type == GroupType && m.ID == g.ID {
+			return fmt.Errorf("members[%d]: %w: group %s cannot contain itself", i, ErrInvalidPolicy, g.ID)
+		}
+	}
+	return nil
+}
+
+// Validate checks that the role binding is complete. The ID may be empty; the store assigns one.
+func (b RoleBinding) Validate() error {
+	if b.Subject.Type == "" || b.Subject.ID == "" {
+		return fmt.Errorf("%w: subject type and id are required", ErrInvalidPolicy)
+	}
+	if b.Role == "" {
+		return fmt.Errorf("%w: role is required", ErrInvalidPolicy)
+	}
+	if b.Resource != nil && (b.Resource.Type == "" || b.Resource.ID == "") {
+		return fmt.Errorf("%w: resource type and id are required", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+// appliesTo reports whether the binding covers the resource
+func (b RoleBinding) appliesTo(resource Entity) bool {
+	return b.Resource == nil || *b.Resource == resource
+}
+
+// appliesToAny reports whether the binding covers any of the resources
+func (b RoleBinding) appliesToAny(resources []Entity) bool {
+	for _, resource := range resources {
+		if b.appliesTo(resource) {
+			return true
+		}
+	}
+	return false
+}
+
+// rbacState holds the roles, groups and role bindings of one version of a
+// MemoryStore. Like the rest of the state it is never modified once published;
+// a change works on a clone.
+type rbacState struct {
+	roles    map[string]Role
+	groups   map[string]Group
+	bindings map[string]RoleBinding
+
+	// Derived by reindex
+	permissions map[string]map[Permission]bool // Effective permissions of each role, including inherited ones
+	bySubject   map[Entity][]string            // Sorted IDs of the bindings of each subject or group
+	memberOf    map[Entity][]string            // Sorted IDs of the groups each member, or nested group, is directly in
+	membership  *membershipCache               // Transitive memberships; replaced when memberOf changes
+}
+
+// newRBACState creates an empty state
+func newRBACState() *rbacState {
+	rs := &rbacState{
+		roles:    make(map[string]Role),
+		groups:   make(map[string]Group),
+		bindings: make(map[string]RoleBinding),
+	}
+	rs.reindex()
+	return rs
+}
+
+// clone returns a copy of the state that can be modified
+func (rs *rbacState) clone() *rbacState {
+	c := &rbacState{
+		roles:    make(map[string]Role, len(rs.roles)),
+		groups:   make(map[string]Group, len(rs.groups)),
+		bindings: make(map[string]RoleBinding, len(rs.bindings)),
+	}
+	for id, r := range rs.roles {
+		c.roles[id] = r
+	}
+	for id, g := range rs.groups {
+		c.groups[id] = g
+	}
+	for id, b := range rs.bindings {
+		c.bindings[id] = b
+	}
+	c.memberOf, c.membership = rs.memberOf, rs.membership
+	return c
+}
+
+// try applies a change to a clone of the state and returns the clone if the
+// change succeeds and the result is consistent
+func (rs *rbacState) try(change func(rs *rbacState) error) (*rbacState, error) {
+	c := rs.clone()
+	if err := change(c); err != nil {
+		return nil, err
+	}
+	if err := c.check(); err != nil {
+		return nil, err
+	}
+	c.reindex()
+	return c, nil
+}
+
+// Changes to an rbacState, shared by the store backends
+
+// putRole adds or replaces a role
+func putRole(r Role) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		rs.roles[r.ID] = r
+		return nil
+	}
+}
+
+// removeRole removes a role that is neither inherited nor bound
+func removeRole(id string) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		if _, ok := rs.roles[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrRoleNotFound, id)
+		}
+		for _, r := range rs.roles {
+			for _, parent := range r.Inherits {
+				if parent == id {
+					return fmt.Errorf("%w: %s is inherited by role %s", ErrRoleInUse, id, r.ID)
+				}
+			}
+		}
+		for _, b := range rs.bindings {
+			if b.Role == id {
+				return fmt.Errorf("%w: %s is bound by role binding %s", ErrRoleInUse, id, b.ID)
+			}
+		}
+		delete(rs.roles, id)
+		return nil
+	}
+}
+
+// putGroup adds or replaces a group
+func putGroup(g Group) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		rs.groups[g.ID] = g
+		return nil
+	}
+}
+
+// removeGroup removes a group
+func removeGroup(id string) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		if _, ok := rs.groups[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrGroupNotFound, id)
+		}
+		delete(rs.groups, id)
+		return nil
+	}
+}
+
+// replaceGroups removes the groups with the given IDs that are not in the
+// batch and adds or replaces the batch
+func replaceGroups(remove []string, groups []Group) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		for _, id := range remove {
+			delete(rs.groups, id)
+		}
+		for _, g := range groups {
+			rs.groups[g.ID] = g
+		}
+		return nil
+	}
+}
+
+// addRoleBinding adds a role binding with a new ID
+func addRoleBinding(b RoleBinding) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		if _, ok := rs.bindings[b.ID]; ok {
+			return fmt.Errorf("%w: role binding %s", ErrPolicyExists, b.ID)
+		}
+		rs.bindings[b.ID] = b
+		return nil
+	}
+}
+
+// removeRoleBinding removes a role binding
+func removeRoleBinding(id string) func(rs *rbacState) error {
+	return func(rs *rbacState) error {
+		if _, ok := rs.bindings[id]; !ok {
+			return fmt.Errorf("%w: %s", ErrBindingNotFound, id)
+		}
+		delete(rs.bindings, id)
+		return nil
+	}
+}
+
+// check verifies that every inherited or bound role exists and that role
+// inheritance has no cycles
+func (rs *rbacState) check() error {
+	for _, r := range rs.roles {
+		for _, parent := range r.Inherits {
+			if _, ok := rs.roles[parent]; !ok {
+				return fmt.Errorf("%w: role %s inherits from unknown role %s", ErrInvalidPolicy, r.ID, parent)
+			}
+		}
+	}
+	for _, b := range rs.bindings {
+		if _, ok := rs.roles[b.Role]; !ok {
+			return fmt.Errorf("%w: role binding %s refers to unknown role %s", ErrInvalidPolicy, b.ID, b.Role)
+		}
+	}
+
+	// Depth-first search for a path that returns to a role on the current path
+	const (
+		visiting = 1
+		done     = 2
+	)
+	marks := make(map[string]int, len(rs.roles))
+	var visit func(id string, path []string) error
+	visit = func(id string, path []string) error {
+		switch marks[id] {
+		case visiting:
+			return fmt.Errorf("%w: role inheritance cycle %s", ErrInvalidPolicy, strings.Join(append(path, id), " -> "))
+		case done:
+			return nil
+		}
+		marks[id] = visiting
+		for _, parent := range rs.roles[id].Inherits {
+			if err := visit(parent, append(path, id)); err != nil {
+				return err
+			}
+		}
+		marks[id] = done
+		return nil
+	}
+	for _, id := range sortedKeys(rs.roles) {
+		if err := visit(id, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// reindex rebuilds the derived indexes. An inheritance cycle, which check
+// rejects but a log replay may pass through, does not make it loop. The
+// membership cache is kept unless group membership changed.
+func (rs *rbacState) reindex() {
+	rs.permissions = make(map[string]map[Permission]bool, len(rs.roles))
+	var effective func(id string) map[Permission]bool
+	effective = func(id string) map[Permission]bool {
+		if perms, ok := rs.permissions[id]; ok {
+			return perms
+		}
+		role := rs.roles[id]
+		perms := make(map[Permission]bool, len(role.Permissions))
+		rs.permissions[id] = perms
+		for _, p := range role.Permissions {
+			perms[p] = true
+		}
+		for _, parent := range role.Inherits {
+			for p := range effective(parent) {
+				perms[p] = true
+			}
+		}
+		return perms
+	}
+	for id := range rs.roles {
+		effective(id)
+	}
+
+	rs.bySubject = make(map[Entity][]string)
+	for _, id := range sortedKeys(rs.bindings) {
+		b := rs.bindings[id]
+		rs.bySubject[b.Subject] = append(rs.bySubject[b.Subject], id)
+	}
+
+	previous := rs.memberOf
+	rs.memberOf = make(map[Entity][]string)
+	for _, id := range sortedKeys(rs.groups) {
+		for _, m := range rs.groups[id].Members {
+			rs.memberOf[m] = append(rs.memberOf[m], id)
+		}
+	}
+	if rs.membership == nil || !sameMembership(previous, rs.memberOf) {
+		rs.membership = &membershipCache{}
+	}
+}
+
+// grant returns the first role binding of one of the subject's principals
+// that allows the request on the resource or one of the ancestors it
+// inherits from, or nil
+func (rs *rbacState) grant(req Request, principals, lineage []Entity) *RoleBinding {
+	perm := Permission{ResourceType: req.Resource.Type, Action: req.Action}
+	for _, principal := range principals {
+		for _, id := range rs.bySubject[principal] {
+			b := rs.bindings[id]
+			if b.appliesToAny(lineage) && rs.permissions[b.Role][perm] {
+				return &b
+			}
+		}
+	}
+	return nil
+}
+
+// sortedKeys returns the keys of a map in ascending order
+func sortedKeys[V interface{}](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,231 @@
+package policy
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+)
+
+// newRBACStore returns a store with viewer and editor roles, where editor
+// inherits viewer, and the groups given
+func newRBACStore(t *testing.T, groups ...Group) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore()
+	roles := []Role{
+		{ID: "viewer", Permissions: []Permission{{ResourceType: "doc", Action: "read"}}},
+		{ID: "editor", Permissions: []Permission{{ResourceType: "doc", Action: "write"}}, Inherits: []string{"viewer"}},
+	}
+	for _, r := range roles {
+		if err := s.PutRole(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for _, g := range groups {
+		if err := s.PutGroup(g); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return s
+}
+
+func user(id string) Entity  { return Entity{Type: "user", ID: id} }
+func group(id string) Entity { return Entity{Type: GroupType, ID: id} }
+func doc(id string) Entity   { return Entity{Type: "doc", ID: id} }
+
+func TestRoleBindingGroupExpansion(t *testing.T) {
+	tests := []struct {
+		name    string
+		groups  []Group
+		binding RoleBinding
+		req     Request
+		allow   bool
+	}{
+		{
+			name:    "direct binding",
+			binding: RoleBinding{Subject: user("alice"), Role: "viewer"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow:   true,
+		},
+		{
+			name:    "permission outside the role",
+			binding: RoleBinding{Subject: user("alice"), Role: "viewer"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "write"},
+		},
+		{
+			name:    "inherited permission",
+			binding: RoleBinding{Subject: user("alice"), Role: "editor"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow:   true,
+		},
+		{
+			name:    "binding limited to another resource",
+			binding: RoleBinding{Subject: user("alice"), Role: "viewer", Resource: &Entity{Type: "doc", ID: "2"}},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+		},
+		{
+			name:    "group member",
+			groups:  []Group{{ID: "eng", Members: []Entity{user("alice")}}},
+			binding: RoleBinding{Subject: group("eng"), Role: "viewer"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow:   true,
+		},
+		{
+			name:    "not a group member",
+			groups:  []Group{{ID: "eng", Members: []Entity{user("alice")}}},
+			binding: RoleBinding{Subject: group("eng"), Role: "viewer"},
+			req:     Request{Subject: user("bob"), Resource: doc("1"), Action: "read"},
+		},
+		{
+			name: "nested group member",
+			groups: []Group{
+				{ID: "staff", Members: []Entity{group("eng")}},
+				{ID: "eng", Members: []Entity{group("backend")}},
+				{ID: "backend", Members: []Entity{user("alice")}},
+			},
+			binding: RoleBinding{Subject: group("staff"), Role: "viewer"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow:   true,
+		},
+		{
+			name: "member of a group cycle",
+			groups: []Group{
+				{ID: "a", Members: []Entity{group("b")}},
+				{ID: "b", Members: []Entity{group("a"), user("alice")}},
+			},
+			binding: RoleBinding{Subject: group("a"), Role: "viewer"},
+			req:     Request{Subject: user("alice"), Resource: doc("1"), Action: "read"},
+			allow:   true,
+		},
+		{
+			name: "outside a group cycle",
+			groups: []Group{
+				{ID: "a", Members: []Entity{group("b")}},
+				{ID: "b", Members: []Entity{group("a"), user("alice")}},
+			},
+			binding: RoleBinding{Subject: group("a"), Role: "viewer"},
+			req:     Request{Subject: user("bob"), Resource: doc("1"), Action: "read"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRBACStore(t, tt.groups...)
+			b, err := s.AddRoleBinding(tt.binding)
+			if err != nil {
+				t.Fatal(err)
+			}
+			d := s.Evaluate(tt.req)
+			if d.Allow != tt.allow {
+				t.Fatalf("Allow = %v, want %v", d.Allow, tt.allow)
+			}
+			if tt.allow && (d.Binding == nil || d.Binding.ID != b.ID) {
+				t.Errorf("Binding = %v, want %s", d.Binding, b.ID)
+			}
+		})
+	}
+}
+
+func TestPolicyDenyOverridesRoleBinding(t *testing.T) {
+	s := newRBACStore(t)
+	if _, err := s.AddRoleBinding(RoleBinding{Subject: user("alice"), Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddPolicy(Policy{ID: "deny", Subject: user("alice"), Resource: doc("1"), Action: "read"}); err != nil {
+		t.Fatal(err)
+	}
+	if d := s.Evaluate(Request{Subject: user("alice"), Resource: doc("1"), Action: "read"}); d.Allow || d.Policy == nil {
+		t.Errorf("Evaluate = %+v, want a deny by policy", d)
+	}
+}
+
+func TestRBACChangesRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *MemoryStore) error
+		want   error
+	}{
+		{
+			name:   "role inheriting itself",
+			change: func(s *MemoryStore) error { return s.PutRole(Role{ID: "r", Inherits: []string{"r"}}) },
+			want:   ErrInvalidPolicy,
+		},
+		{
+			name: "role inheritance cycle",
+			change: func(s *MemoryStore) error {
+				return s.PutRole(Role{ID: "viewer", Inherits: []string{"editor"}})
+			},
+			want: ErrInvalidPolicy,
+		},
+		{
+			name:   "unknown inherited role",
+			change: func(s *MemoryStore) error { return s.PutRole(Role{ID: "r", Inherits: []string{"missing"}}) },
+			want:   ErrInvalidPolicy,
+		},
+		{
+			name: "binding of an unknown role",
+			change: func(s *MemoryStore) error {
+				_, err := s.AddRoleBinding(RoleBinding{Subject: user("alice"), Role: "missing"})
+				return err
+			},
+			want: ErrInvalidPolicy,
+		},
+		{
+			name:   "removing an inherited role",
+			change: func(s *MemoryStore) error { return s.RemoveRole("viewer") },
+			want:   ErrRoleInUse,
+		},
+		{
+			name: "removing a bound role",
+			change: func(s *MemoryStore) error {
+				if _, err := s.AddRoleBinding(RoleBinding{Subject: user("alice"), Role: "editor"}); err != nil {
+					return err
+				}
+				return s.RemoveRole("editor")
+			},
+			want: ErrRoleInUse,
+		},
+		{
+			name:   "group containing itself",
+			change: func(s *MemoryStore) error { return s.PutGroup(Group{ID: "g", Members: []Entity{group("g")}}) },
+			want:   ErrInvalidPolicy,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newRBACStore(t)
+			if err := tt.change(s); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+		})
+	}
+}
+
+func TestFindSubjectsExpandsGroups(t *testing.T) {
+	s := newRBACStore(t,
+		Group{ID: "a", Members: []Entity{group("b"), user("alice")}},
+		Group{ID: "b", Members: []Entity{group("a"), user("bob")}},
+	)
+	if _, err := s.AddRoleBinding(RoleBinding{Subject: group("a"), Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.AddRoleBinding(RoleBinding{Subject: user("carol"), Role: "viewer"}); err != nil {
+		t.Fatal(err)
+	}
+	req := Request{Subject: Entity{Type: "user"}, Resource: doc("1"), Action: "read"}
+
+	tests := []struct {
+		name string
+		opts SubjectSearch
+		want []Entity
+	}{
+		{name: "expanded", want: []Entity{user("alice"), user("bob"), user("carol")}},
+		{name: "direct only", opts: SubjectSearch{DirectOnly: true}, want: []Entity{user("carol")}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, next := s.FindSubjectsForResource(req, Page{}, tt.opts)
+			if !reflect.DeepEqual(got, tt.want) || next != "" {
+				t.Errorf("FindSubjectsForResource = %v, %q, want %v", got, next, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package policy
+
+import (
+	"log"
+	"time"
+)
+
+// Reaper removes policies from a store once their validity has ended.
+// Expired policies never apply, so removing them only keeps the store small
+// and the list of policies current.
+type Reaper struct {
+	each func(fn func(tenant string, s Store)) // Calls fn with every store to reap
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+// NewReaper creates a reaper for the given store
+func NewReaper(store Store) *Reaper {
+	return &Reaper{each: func(fn func(string, Store)) { fn("", store) }}
+}
+
+// NewTenantReaper creates a reaper for the stores of all tenants of a registry
+func NewTenantReaper(reg *TenantRegistry) *Reaper {
+	return &Reaper{each: reg.Each}
+}
+
+// Reap removes the policies that have expired and returns them. It reaps
+// every store even if one fails and returns the first error.
+func (r *Reaper) Reap() ([]Policy, error) {
+	var (
+		removed  []Policy
+		firstErr error
+	)
+	now := time.Now()
+	r.each(func(tenant string, s Store) {
+		where := ""
+		if tenant != "" {
+			where = " of tenant " + tenant
+		}
+		expired, err := s.RemoveExpiredPolicies(now)
+		if err != nil {
+			log.Printf("Failed to remove expired policies%s: %v", where, err)
+			if firstErr == nil {
+				firstErr = err
+			}
+			return
+		}
+		for _, p := range expired {
+			log.Printf("Removed policy %s%s, which expired at %s", p.ID, where, p.NotAfter.Format(time.RFC3339))
+		}
+		removed = append(removed, expired...)
+	})
+	return removed, firstErr
+}
+
+// Watch removes expired policies at the given interval
+func (r *Reaper) Watch(interval time.Duration) {
+	r.stop = make(chan struct{})
+	r.done = make(chan struct{})
+
+	go func() {
+		defer close(r.done)
+
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ticker.C:
+				r.Reap()
+			case <-r.stop:
+				return
+			}
+		}
+	}()
+}
+
+// Close stops removing expired policies
+func (r *Reaper) Close() {
+	if r.stop != nil {
+		close(r.stop)
+		<-r.done
+	}
+}
@@ -0,0 +1,70 @@
+package policy
+
+import (
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+func TestReaper(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	now := time.Now()
+	past, later := now.Add(-time.Minute), now.Add(time.Hour)
+
+	s := NewMemoryStore()
+	for _, p := range []Policy{
+		{ID: "expired", Subject: user("alice"), Resource: doc("1"), Action: "read", Allow: true, NotAfter: &past},
+		{ID: "active", Subject: user("alice"), Resource: doc("2"), Action: "read", Allow: true, NotAfter: &later},
+		{ID: "permanent", Subject: user("alice"), Resource: doc("3"), Action: "read", Allow: true},
+	} {
+		if _, err := s.AddPolicy(p); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	r := NewReaper(s)
+	removed, err := r.Reap()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(removed) != 1 || removed[0].ID != "expired" {
+		t.Errorf("Reap removed %v, want only the expired policy", removed)
+	}
+	var ids []string
+	for _, p := range s.ListPolicies(PolicyFilter{}) {
+		ids = append(ids, p.ID)
+	}
+	if len(ids) != 2 || ids[0] != "active" || ids[1] != "permanent" {
+		t.Errorf("policies after Reap = %v, want [active permanent]", ids)
+	}
+
+	if removed, err := r.Reap(); err != nil || len(removed) != 0 {
+		t.Errorf("second Reap = %v, %v, want nothing removed", removed, err)
+	}
+}
+
+func TestReaperWatch(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	s := NewMemoryStore()
+	soon := time.Now().Add(20 * time.Millisecond)
+	if _, err := s.AddPolicy(Policy{ID: "short", Subject: user("alice"), Resource: doc("1"), Action: "read", Allow: true, NotAfter: &soon}); err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewReaper(s)
+	r.Watch(5 * time.Millisecond)
+	defer r.Close()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for len(s.ListPolicies(PolicyFilter{})) > 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("the watching reaper did not remove the expired policy")
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
@@ -0,0 +1,504 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// Errors returned by relation operations
+var (
+	ErrNamespaceNotFound = errors.New("namespace not found")
+	errDepthExceeded     = errors.New("relation graph depth limit exceeded")
+)
+
+// relationDepthLimit bounds how many relations a check or search follows
+// from the requested relation, e.g. nested groups or folders
+const relationDepthLimit = 32
+
+// RelationTuple states that a subject, or the set of subjects holding a
+// relation on an object, has a relation to an object. Its text form is
+// "document:123#viewer@user:alice" or "document:123#viewer@group:eng#member".
+type RelationTuple struct {
+	Object          Entity
+	Relation        string
+	Subject         Entity
+	SubjectRelation string // Set when the subject is a userset, e.g. "member" of group:eng
+}
+
+// ParseRelationTuple parses a tuple in "type:id#relation@type:id[#relation]" form
+func ParseRelationTuple(s string) (RelationTuple, error) {
+	var t RelationTuple
+	object, subject, ok := strings.Cut(s, "@")
+	if !ok {
+		return t, fmt.Errorf("%w: relation tuple %q has no @subject", ErrInvalidPolicy, s)
+	}
+
+	object, t.Relation, ok = strings.Cut(object, "#")
+	if !ok {
+		return t, fmt.Errorf("%w: relation tuple %q has no #relation", ErrInvalidPolicy, s)
+	}
+	subject, t.SubjectRelation, _ = strings.Cut(subject, "#")
+
+	var err error
+	if t.Object, err = parseEntity(object); err != nil {
+		return t, fmt.Errorf("%w: relation tuple %q: %v", ErrInvalidPolicy, s, err)
+	}
+	if t.Subject, err = parseEntity(subject); err != nil {
+		return t, fmt.Errorf("%w: relation tuple %q: %v", ErrInvalidPolicy, s, err)
+	}
+	return t, t.Validate()
+}
+
+// parseEntity parses an entity in "type:id" form
+func parseEntity(s string) (Entity, error) {
+	typ, id, ok := strings.Cut(s, ":")
+	if !ok || typ == "" || id == "" {
+		return Entity{}, fmt.Errorf("%q is not in type:id form", s)
+	}
+	return Entity{Type: typ, ID: id}, nil
+}
+
+// String returns the tuple in text form
+func (t RelationTuple) String() string {
+	s := t.Object.String() + "#" + t.Relation + "@" + t.Subject.String()
+	if t.SubjectRelation != "" {
+		s += "#" + t.SubjectRelation
+	}
+	return s
+}
+
+// MarshalText encodes the tuple in text form, which is also its JSON form
+func (t RelationTuple) MarshalText() ([]byte, error) {
+	return []byte(t.String()), nil
+}
+
+// UnmarshalText decodes a tuple from text form
+func (t *RelationTuple) UnmarshalText(data []byte) error {
+	parsed, err := ParseRelationTuple(string(data))
+	if err != nil {
+		return err
+	}
+	*t = parsed
+	return nil
+}
+
+// Validate checks that the tuple is complete
+func (t RelationTuple) Validate() error {
+	if t.Object.Type == "" || t.Object.ID == "" || t.Subject.Type == "" || t.Subject.ID == "" {
+		return fmt.Errorf("%w: relation tuple object and subject need a type and id", ErrInvalidPolicy)
+	}
+	if t.Relation == "" {
+		return fmt.Errorf("%w: relation tuple relation is required", ErrInvalidPolicy)
+	}
+	return nil
+}
+
+func (t RelationTuple) same(o RelationTuple) bool   { return t == o }
+func (t RelationTuple) before(o RelationTuple) bool { return false }
+
+// TupleFilter selects relation tuples. Empty fields match any value.
+type TupleFilter struct {
+	Object   Entity // Object; its type, its ID or both may be empty
+	Relation string
+	Subject  Entity // Subject; its type, its ID or both may be empty
+}
+
+// Matches reports whether the tuple satisfies the filter
+func (f TupleFilter) Matches(t RelationTuple) bool {
+	return (f.Object.Type == "" || f.Object.Type == t.Object.Type) &&
+		(f.Object.ID == "" || f.Object.ID == t.Object.ID) &&
+		(f.Relation == "" || f.Relation == t.Relation) &&
+		(f.Subject.Type == "" || f.Subject.Type == t.Subject.Type) &&
+		(f.Subject.ID == "" || f.Subject.ID == t.Subject.ID)
+}
+
+// Namespace is the schema of the relations of one object type. A relation
+// without a rewrite holds exactly the subjects of its tuples.
+type Namespace struct {
+	Name      string              `json:"name"`      // Object type, e.g. "document"
+	Relations map[string]*Userset `json:"relations"` // Relations and their rewrites; nil for direct tuples only
+}
+
+// Userset is a rewrite rule that computes the subjects of a relation. At
+// most one field may be set; an empty userset is the same as This.
+type Userset struct {
+	This            bool            `json:"this,omitempty"`             // Subjects of the relation's own tuples
+	ComputedUserset string          `json:"computed_userset,omitempty"` // Subjects of another relation on the same object
+	TupleToUserset  *TupleToUserset `json:"tuple_to_userset,omitempty"` // Subjects of a relation on related objects
+	Union           []Userset       `json:"union,omitempty"`            // Subjects of any of the usersets
+	Intersection    []Userset       `json:"intersection,omitempty"`     // Subjects of all of the usersets
+	Exclusion       *Exclusion      `json:"exclusion,omitempty"`        // Subjects of one userset but not another
+}
+
+// TupleToUserset follows the tupleset relation from an object to other
+// objects, e.g. a document's parent folder, and takes the subjects of the
+// computed userset relation on them, e.g. the folder's viewers
+type TupleToUserset struct {
+	Tupleset        string `json:"tupleset"`
+	ComputedUserset string `json:"computed_userset"`
+}
+
+// Exclusion is the subjects of Base that are not in Subtract
+type Exclusion struct {
+	Base     Userset `json:"base"`
+	Subtract Userset `json:"subtract"`
+}
+
+// Validate checks that the namespace is well-formed and that its rewrites
+// refer to relations it defines
+func (ns Namespace) Validate() error {
+	if ns.Name == "" {
+		return fmt.Errorf("%w: namespace name is required", ErrInvalidPolicy)
+	}
+	for name, u := range ns.Relations {
+		if name == "" {
+			return fmt.Errorf("%w: namespace %s: relation name is required", ErrInvalidPolicy, ns.Name)
+		}
+		if u == nil {
+			continue
+		}
+		if err := u.validate(ns); err != nil {
+			return fmt.Errorf("%w: namespace %s: relation %s: %v", ErrInvalidPolicy, ns.Name, name, err)
+		}
+	}
+	return nil
+}
+
+// validate checks a rewrite rule of the namespace
+func (u Userset) validate(ns Namespace) error {
+	set := 0
+	if u.This {
+		set++
+	}
+	if u.ComputedUserset != "" {
+		set++
+		if _, ok := ns.Relations[u.ComputedUserset]; !ok {
+			return fmt.Errorf("computed_userset refers to undefined relation %q", u.ComputedUserset)
+		}
+	}
+	if u.TupleToUserset != nil {
+		set++
+		if _, ok := ns.Relations[u.TupleToUserset.Tupleset]; !ok {
+			return fmt.Errorf("tuple_to_userset refers to undefined tupleset relation %q", u.TupleToUserset.Tupleset)
+		}
+		if u.TupleToUserset.ComputedUserset == "" {
+			return errors.New("tuple_to_userset requires computed_userset")
+		}
+	}
+	if u.Union != nil {
+		set++
+	}
+	if u.Intersection != nil {
+		set++
+	}
+	if u.Exclusion != nil {
+		set++
+	}
+	if set > 1 {
+		return errors.New("a userset must set only one of this, computed_userset, tuple_to_userset, union, intersection and exclusion")
+	}
+
+	for _, child := range append(u.Union, u.Intersection...) {
+		if err := child.validate(ns); err != nil {
+			return err
+		}
+	}
+	if u.Exclusion != nil {
+		if err := u.Exclusion.Base.validate(ns); err != nil {
+			return err
+		}
+		if err := u.Exclusion.Subtract.validate(ns); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isThis reports whether the userset is the relation's own tuples
+func (u *Userset) isThis() bool {
+	return u == nil || (u.ComputedUserset == "" && u.TupleToUserset == nil &&
+		u.Union == nil && u.Intersection == nil && u.Exclusion == nil)
+}
+
+// Index keys of relation tuples
+
+// objectRelationKey indexes tuples by object and relation
+type objectRelationKey struct {
+	object   Entity
+	relation string
+}
+
+// subjectKey indexes tuples by subject, for reverse traversal
+type subjectKey Entity
+
+func (k objectRelationKey) shard() uint32 { return fnv(fnvEntity(fnvOffset, k.object), k.relation) }
+func (k subjectKey) shard() uint32        { return fnvEntity(fnvOffset, Entity(k)) }
+
+// hasTuple reports whether the tuple is stored
+func (st *state) hasTuple(t RelationTuple) bool {
+	for _, stored := range st.tuplesByObject.lookup(objectRelationKey{t.Object, t.Relation}) {
+		if stored == t {
+			return true
+		}
+	}
+	return false
+}
+
+// writeTuples adds and removes tuples. Adding a stored tuple or removing a
+// missing one has no effect.
+func (st *state) writeTuples(writes, deletes []RelationTuple) {
+	gen := st.generation
+	for _, t := range deletes {
+		if st.hasTuple(t) {
+			st.tuplesByObject.remove(gen, objectRelationKey{t.Object, t.Relation}, t)
+			st.tuplesBySubject.remove(gen, subjectKey(t.Subject), t)
+			st.tupleCount--
+		}
+	}
+	for _, t := range writes {
+		if !st.hasTuple(t) {
+			st.tuplesByObject.add(gen, objectRelationKey{t.Object, t.Relation}, t)
+			st.tuplesBySubject.add(gen, subjectKey(t.Subject), t)
+			st.tupleCount++
+		}
+	}
+}
+
+// checkTuples validates tuples against the namespaces of their objects
+func (st *state) checkTuples(tuples []RelationTuple) error {
+	for i, t := range tuples {
+		if err := t.Validate(); err != nil {
+			return fmt.Errorf("tuple %d: %w", i, err)
+		}
+		if ns, ok := st.namespaces[t.Object.Type]; ok {
+			if _, ok := ns.Relations[t.Relation]; !ok {
+				return fmt.Errorf("tuple %d: %w: namespace %s does not define relation %q", i, ErrInvalidPolicy, ns.Name, t.Relation)
+			}
+		}
+	}
+	return nil
+}
+
+// rewrite returns the rewrite rule of a relation. Object types without a
+// namespace have only direct tuples; relations their namespace does not
+// define have no subjects.
+func (st *state) rewrite(objectType, relation string) (*Userset, bool) {
+	ns, ok := st.namespaces[objectType]
+	if !ok {
+		return nil, true
+	}
+	u, ok := ns.Relations[relation]
+	return u, ok
+}
+
+// relationWalk is a traversal of the relation graph on behalf of one subject
+type relationWalk struct {
+	st       *state
+	subject  Entity
+	visiting map[objectRelationKey]bool // Usersets on the current path, to detect cycles
+}
+
+// checkRelation reports whether the subject has the relation to the object,
+// directly or through the namespace rewrites. A cycle in the graph grants
+// nothing; exceeding the depth limit is an error.
+func (st *state) checkRelation(object Entity, relation string, subject Entity) (bool, error) {
+	w := &relationWalk{st: st, subject: subject, visiting: make(map[objectRelationKey]bool)}
+	return w.check(object, relation, 0)
+}
+
+// check evaluates the relation of an object at the given depth
+func (w *relationWalk) check(object Entity, relation string, depth int) (bool, error) {
+	if depth > relationDepthLimit {
+		return false, errDepthExceeded
+	}
+	key := objectRelationKey{object, relation}
+	if w.visiting[key] {
+		return false, nil
+	}
+	w.visiting[key] = true
+	defer delete(w.visiting, key)
+
+	u, ok := w.st.rewrite(object.Type, relation)
+	if !ok {
+		return false, nil
+	}
+	return w.eval(object, relation, u, depth)
+}
+
+// eval evaluates a rewrite rule of the relation of an object
+func (w *relationWalk) eval(object Entity, relation string, u *Userset, depth int) (bool, error) {
+	switch {
+	case u.isThis():
+		var firstErr error
+		for _, t := range w.st.tuplesByObject.lookup(objectRelationKey{object, relation}) {
+			if t.SubjectRelation == "" {
+				if t.Subject == w.subject {
+					return true, nil
+				}
+				continue
+			}
+			ok, err := w.check(t.Subject, t.SubjectRelation, depth+1)
+			if ok {
+				return true, nil
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return false, firstErr
+
+	case u.ComputedUserset != "":
+		return w.check(object, u.ComputedUserset, depth+1)
+
+	case u.TupleToUserset != nil:
+		var firstErr error
+		for _, t := range w.st.tuplesByObject.lookup(objectRelationKey{object, u.TupleToUserset.Tupleset}) {
+			ok, err := w.check(t.Subject, u.TupleToUserset.ComputedUserset, depth+1)
+			if ok {
+				return true, nil
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return false, firstErr
+
+	case u.Union != nil:
+		var firstErr error
+		for i := range u.Union {
+			ok, err := w.eval(object, relation, &u.Union[i], depth)
+			if ok {
+				return true, nil
+			}
+			if err != nil && firstErr == nil {
+				firstErr = err
+			}
+		}
+		return false, firstErr
+
+	case u.Intersection != nil:
+		for i := range u.Intersection {
+			if ok, err := w.eval(object, relation, &u.Intersection[i], depth); !ok || err != nil {
+				return false, err
+			}
+		}
+		return len(u.Intersection) > 0, nil
+
+	default:
+		ok, err := w.eval(object, relation, &u.Exclusion.Base, depth)
+		if !ok || err != nil {
+			return false, err
+		}
+		excluded, err := w.eval(object, relation, &u.Exclusion.Subtract, depth)
+		return !excluded && err == nil, err
+	}
+}
+
+// decideRelation reports whether the relation graph grants the request,
+// reading the action as a relation on the resource
+func (st *state) decideRelation(req Request) bool {
+	if st.tupleCount == 0 {
+		return false
+	}
+	ok, err := st.checkRelation(req.Resource, req.Action, req.Subject)
+	if err != nil {
+		log.Printf("Relation check %s#%s@%s: %v", req.Resource, req.Action, req.Subject, err)
+		return false
+	}
+	return ok
+}
+
+// expandSubjects calls fn for every subject that may hold the relation on
+// the object: the leaves of the userset tree, with usersets such as nested
+// groups expanded transitively. Intersections and exclusions yield the
+// candidates of all their branches, which the caller verifies with a check.
+func (st *state) expandSubjects(object Entity, relation string, fn func(Entity)) {
+	visited := make(map[objectRelationKey]bool)
+
+	var expand func(object Entity, relation string, depth int)
+	var eval func(object Entity, relation string, u *Userset, depth int)
+	expand = func(object Entity, relation string, depth int) {
+		key := objectRelationKey{object, relation}
+		if depth > relationDepthLimit || visited[key] {
+			return
+		}
+		visited[key] = true
+		if u, ok := st.rewrite(object.Type, relation); ok {
+			eval(object, relation, u, depth)
+		}
+	}
+	eval = func(object Entity, relation string, u *Userset, depth int) {
+		switch {
+		case u.isThis():
+			for _, t := range st.tuplesByObject.lookup(objectRelationKey{object, relation}) {
+				if t.SubjectRelation == "" {
+					fn(t.Subject)
+				} else {
+					expand(t.Subject, t.SubjectRelation, depth+1)
+				}
+			}
+		case u.ComputedUserset != "":
+			expand(object, u.ComputedUserset, depth+1)
+		case u.TupleToUserset != nil:
+			for _, t := range st.tuplesByObject.lookup(objectRelationKey{object, u.TupleToUserset.Tupleset}) {
+				expand(t.Subject, u.TupleToUserset.ComputedUserset, depth+1)
+			}
+		case u.Exclusion != nil:
+			eval(object, relation, &u.Exclusion.Base, depth)
+		default:
+			for _, branch := range [][]Userset{u.Union, u.Intersection} {
+				for i := range branch {
+					eval(object, relation, &branch[i], depth)
+				}
+			}
+		}
+	}
+
+	expand(object, relation, 0)
+}
+
+// reachableObjects calls fn for every object that the subject is related
+// to, directly or through other objects, by following tuples backwards
+// from their subjects to their objects
+func (st *state) reachableObjects(subject Entity, fn func(Entity)) {
+	visited := map[Entity]bool{subject: true}
+	frontier := []Entity{subject}
+
+	for depth := 0; depth <= relationDepthLimit && len(frontier) > 0; depth++ {
+		var next []Entity
+		for _, e := range frontier {
+			for _, t := range st.tuplesBySubject.lookup(subjectKey(e)) {
+				if !visited[t.Object] {
+					visited[t.Object] = true
+					fn(t.Object)
+					next = append(next, t.Object)
+				}
+			}
+		}
+		frontier = next
+	}
+}
+
+// relationsOf calls fn for every relation that the subject may hold on the
+// object: the relations of the object's namespace, or without a namespace
+// the relations of the object's tuples that the subject can reach
+func (st *state) relationsOf(object, subject Entity, fn func(string)) {
+	if ns, ok := st.namespaces[object.Type]; ok {
+		for name := range ns.Relations {
+			fn(name)
+		}
+		return
+	}
+
+	relationsTo := func(e Entity) {
+		for _, t := range st.tuplesBySubject.lookup(subjectKey(e)) {
+			if t.Object == object {
+				fn(t.Relation)
+			}
+		}
+	}
+	relationsTo(subject)
+	st.reachableObjects(subject, relationsTo)
+}
@@ -0,0 +1,173 @@
+package policy
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+)
+
+// newRelationStore returns a store with document, folder and group
+// namespaces and tuples that include cycles of nested groups and folders
+func newRelationStore(t *testing.T) *MemoryStore {
+	t.Helper()
+	s := NewMemoryStore()
+	namespaces := []Namespace{
+		{Name: "group", Relations: map[string]*Userset{"member": nil}},
+		{Name: "folder", Relations: map[string]*Userset{
+			"parent": nil,
+			"viewer": {Union: []Userset{{This: true}, {TupleToUserset: &TupleToUserset{Tupleset: "parent", ComputedUserset: "viewer"}}}},
+		}},
+		{Name: "document", Relations: map[string]*Userset{
+			"owner":    nil,
+			"parent":   nil,
+			"banned":   nil,
+			"reviewer": nil,
+			"editor":   {Union: []Userset{{This: true}, {ComputedUserset: "owner"}}},
+			"viewer": {Union: []Userset{
+				{This: true},
+				{ComputedUserset: "editor"},
+				{TupleToUserset: &TupleToUserset{Tupleset: "parent", ComputedUserset: "viewer"}},
+			}},
+			"read":    {Exclusion: &Exclusion{Base: Userset{ComputedUserset: "viewer"}, Subtract: Userset{ComputedUserset: "banned"}}},
+			"publish": {Intersection: []Userset{{ComputedUserset: "editor"}, {ComputedUserset: "reviewer"}}},
+		}},
+	}
+	for _, ns := range namespaces {
+		if err := s.PutNamespace(ns); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var tuples []RelationTuple
+	for _, text := range []string{
+		"document:1#owner@user:alice",
+		"document:1#viewer@group:eng#member",
+		"group:eng#member@user:bob",
+		"group:eng#member@group:backend#member",
+		"group:backend#member@user:carol",
+		"group:backend#member@group:eng#member",
+		"document:1#parent@folder:a",
+		"folder:a#parent@folder:b",
+		"folder:b#parent@folder:a",
+		"folder:b#viewer@user:dave",
+		"folder:b#viewer@user:erin",
+		"document:1#banned@user:erin",
+		"document:1#editor@user:frank",
+		"document:1#reviewer@user:frank",
+	} {
+		tuple, err := ParseRelationTuple(text)
+		if err != nil {
+			t.Fatal(err)
+		}
+		tuples = append(tuples, tuple)
+	}
+	if err := s.WriteRelationTuples(tuples, nil); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func TestRelationRewrites(t *testing.T) {
+	s := newRelationStore(t)
+	tests := []struct {
+		subject  string
+		relation string
+		allow    bool
+	}{
+		{"alice", "owner", true},
+		{"alice", "editor", true}, // computed userset
+		{"alice", "viewer", true}, // computed userset of a computed userset
+		{"bob", "viewer", true},   // userset tuple
+		{"bob", "editor", false},
+		{"carol", "viewer", true}, // nested group in a group cycle
+		{"dave", "viewer", true},  // tuple to userset through a folder cycle
+		{"mallory", "viewer", false},
+		{"dave", "read", true},
+		{"erin", "viewer", true},
+		{"erin", "read", false}, // exclusion
+		{"frank", "publish", true},
+		{"alice", "publish", false}, // intersection
+		{"alice", "undefined", false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.subject+"#"+tt.relation, func(t *testing.T) {
+			d := s.Evaluate(Request{Subject: user(tt.subject), Resource: Entity{Type: "document", ID: "1"}, Action: tt.relation})
+			if d.Allow != tt.allow {
+				t.Fatalf("Allow = %v, want %v", d.Allow, tt.allow)
+			}
+			if want := "document:1#" + tt.relation; tt.allow && d.Relation != want {
+				t.Errorf("Relation = %q, want %q", d.Relation, want)
+			}
+		})
+	}
+}
+
+func TestRelationDepthLimit(t *testing.T) {
+	tests := []struct {
+		name  string
+		depth int
+		allow bool
+	}{
+		{name: "within the limit", depth: relationDepthLimit - 1, allow: true},
+		{name: "beyond the limit", depth: relationDepthLimit + 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore()
+			tuples := []RelationTuple{{Object: doc("1"), Relation: "read", Subject: group("g0"), SubjectRelation: "member"}}
+			for i := 0; i < tt.depth; i++ {
+				tuples = append(tuples, RelationTuple{Object: group(fmt.Sprint("g", i)), Relation: "member", Subject: group(fmt.Sprint("g", i+1)), SubjectRelation: "member"})
+			}
+			tuples = append(tuples, RelationTuple{Object: group(fmt.Sprint("g", tt.depth)), Relation: "member", Subject: user("alice")})
+			if err := s.WriteRelationTuples(tuples, nil); err != nil {
+				t.Fatal(err)
+			}
+			if d := s.Evaluate(Request{Subject: user("alice"), Resource: doc("1"), Action: "read"}); d.Allow != tt.allow {
+				t.Errorf("Allow = %v, want %v", d.Allow, tt.allow)
+			}
+		})
+	}
+}
+
+func TestRelationSchemaRejected(t *testing.T) {
+	tests := []struct {
+		name   string
+		change func(s *MemoryStore) error
+	}{
+		{
+			name: "computed userset of an undefined relation",
+			change: func(s *MemoryStore) error {
+				return s.PutNamespace(Namespace{Name: "x", Relations: map[string]*Userset{"a": {ComputedUserset: "b"}}})
+			},
+		},
+		{
+			name: "several rewrites in one userset",
+			change: func(s *MemoryStore) error {
+				return s.PutNamespace(Namespace{Name: "x", Relations: map[string]*Userset{"a": nil, "b": {This: true, ComputedUserset: "a"}}})
+			},
+		},
+		{
+			name: "tuple of an undefined relation",
+			change: func(s *MemoryStore) error {
+				return s.WriteRelationTuples([]RelationTuple{{Object: Entity{Type: "document", ID: "1"}, Relation: "undefined", Subject: user("alice")}}, nil)
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.change(newRelationStore(t)); !errors.Is(err, ErrInvalidPolicy) {
+				t.Fatalf("error = %v, want %v", err, ErrInvalidPolicy)
+			}
+		})
+	}
+}
+
+func TestFindSubjectsThroughRelations(t *testing.T) {
+	s := newRelationStore(t)
+	req := Request{Subject: Entity{Type: "user"}, Resource: Entity{Type: "document", ID: "1"}, Action: "read"}
+	want := []Entity{user("alice"), user("bob"), user("carol"), user("dave"), user("frank")}
+	if got, next := s.FindSubjectsForResource(req, Page{}, SubjectSearch{}); !reflect.DeepEqual(got, want) || next != "" {
+		t.Errorf("FindSubjectsForResource = %v, %q, want %v", got, next, want)
+	}
+}
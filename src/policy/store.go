@@ -1,126 +1,121 @@
 package policy
 
-import (
-	"sync"
-)
-
-// Policy represents an authorization policy
-type Policy struct {
-	Subject  string // Subject (user, etc.)
-	Resource string // Resource
-	Action   string // Action
-	Allow    bool   // Whether to allow or deny
-}
-
-// Store represents a policy store
-type Store struct {
-	policies []Policy
-	mu       sync.RWMutex
-}
-
-// NewStore creates a new policy store
-func NewStore() *Store {
-	return &Store{
-		policies: make([]Policy, 0),
-	}
-}
-
-// AddPolicy adds a policy to the store
-func (s *Store) AddPolicy(subject, resource, action string, allow bool) {
-	s.mu.Lock()
-	defer s.mu.Unlock()
-
-	s.policies = append(s.policies, Policy{
-		Subject:  subject,
-		Resource: resource,
-		Action:   action,
-		Allow:    allow,
-	})
-}
-
-// CheckPolicy checks if a policy exists for the given subject, resource, and action
-// If a policy exists, it returns the Allow value of that policy.
-// If no policy exists, it returns false.
-func (s *Store) CheckPolicy(subject, resource, action string) bool {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	for _, p := range s.policies {
-		if p.Subject == subject && p.Resource == resource && p.Action == action {
-			return p.Allow
-		}
-	}
-
-	return false
-}
-
-// ListPolicies returns all policies
-func (s *Store) ListPolicies() []Policy {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	// Create a copy of the policies to return
-	policies := make([]Policy, len(s.policies))
-	copy(policies, s.policies)
-	return policies
+import "time"
+
+// Store is the interface implemented by policy storage backends.
+// Implementations must be safe for concurrent use.
+type Store interface {
+	// CheckPolicy reports whether the subject may perform the action on the resource
+	CheckPolicy(subject, resource Entity, action string) bool
+	// Evaluate returns the decision for the request
+	Evaluate(req Request) Decision
+	// Explain returns the decision for the request and how it was reached
+	Explain(req Request) (Decision, Trace)
+
+	// ListPolicies returns the policies that match the filter
+	ListPolicies(filter PolicyFilter) []Policy
+	// GetPolicy returns the policy with the given ID
+	GetPolicy(id string) (Policy, error)
+	// CountPolicies returns the number of stored policies
+	CountPolicies() int
+
+	// FindSubjectsForResource returns a page of subjects of type req.Subject.Type allowed to perform the action on the resource
+	FindSubjectsForResource(req Request, page Page, opts SubjectSearch) ([]Entity, string)
+	// FindResourcesForSubject returns a page of resources of type req.Resource.Type the subject may perform the action on
+	FindResourcesForSubject(req Request, page Page) ([]Entity, string)
+	// FindActionsForSubjectAndResource returns a page of actions the subject may perform on the resource
+	FindActionsForSubjectAndResource(req Request, page Page) ([]string, string)
+
+	// AddPolicy adds a policy and returns it with its assigned ID
+	AddPolicy(p Policy) (Policy, error)
+	// UpdatePolicy replaces the policy with the same ID
+	UpdatePolicy(p Policy) error
+	// UpsertPolicies adds or replaces a batch of policies atomically
+	UpsertPolicies(policies []Policy) ([]Policy, error)
+	// RemovePolicy removes the policy with the given ID
+	RemovePolicy(id string) error
+	// ReplacePolicies atomically removes the policies with the given IDs and adds or replaces the given policies
+	ReplacePolicies(remove []string, policies []Policy) error
+	// RemoveExpiredPolicies removes the policies whose validity has ended at the given time and returns them
+	RemoveExpiredPolicies(now time.Time) ([]Policy, error)
+
+	// ListRoles returns all roles
+	ListRoles() []Role
+	// GetRole returns the role with the given ID
+	GetRole(id string) (Role, error)
+	// PutRole adds a role or replaces the role with the same ID
+	PutRole(r Role) error
+	// RemoveRole removes the role with the given ID
+	RemoveRole(id string) error
+
+	// ListGroups returns all groups
+	ListGroups() []Group
+	// GetGroup returns the group with the given ID
+	GetGroup(id string) (Group, error)
+	// PutGroup adds a group or replaces the group with the same ID
+	PutGroup(g Group) error
+	// ReplaceGroups atomically removes the groups with the given IDs and adds or replaces a batch of groups
+	ReplaceGroups(remove []string, groups []Group) error
+	// ReplacePoliciesAndGroups atomically replaces policies and groups as ReplacePolicies and ReplaceGroups do
+	ReplacePoliciesAndGroups(removePolicies []string, policies []Policy, removeGroups []string, groups []Group) error
+	// RemoveGroup removes the group with the given ID
+	RemoveGroup(id string) error
+
+	// ListRoleBindings returns all role bindings
+	ListRoleBindings() []RoleBinding
+	// GetRoleBinding returns the role binding with the given ID
+	GetRoleBinding(id string) (RoleBinding, error)
+	// CountRoleBindings returns the number of stored role bindings
+	CountRoleBindings() int
+	// AddRoleBinding adds a role binding and returns it with its assigned ID
+	AddRoleBinding(b RoleBinding) (RoleBinding, error)
+	// RemoveRoleBinding removes the role binding with the given ID
+	RemoveRoleBinding(id string) error
+
+	// CombiningAlgorithm returns the algorithm that combines policy sets and policies without a set
+	CombiningAlgorithm() CombiningAlgorithm
+	// SetCombiningAlgorithm changes the algorithm that combines policy sets and policies without a set
+	SetCombiningAlgorithm(a CombiningAlgorithm) error
+	// ListPolicySets returns all policy sets
+	ListPolicySets() []PolicySet
+	// GetPolicySet returns the policy set with the given name
+	GetPolicySet(name string) (PolicySet, error)
+	// PutPolicySet adds a policy set or replaces the set with the same name
+	PutPolicySet(ps PolicySet) error
+	// RemovePolicySet removes the policy set with the given name
+	RemovePolicySet(name string) error
+
+	// GetResourceNode returns the place of a resource in the hierarchy
+	GetResourceNode(resource Entity) (ResourceNode, error)
+	// ListResourceChildren returns the resources registered directly below the parent
+	ListResourceChildren(parent Entity) []ResourceNode
+	// PutResourceNode adds a resource to the hierarchy or replaces its place in it
+	PutResourceNode(n ResourceNode) error
+	// RemoveResourceNode removes a resource from the hierarchy
+	RemoveResourceNode(resource Entity) error
+
+	// ListNamespaces returns all relation namespaces
+	ListNamespaces() []Namespace
+	// GetNamespace returns the relation namespace of the given object type
+	GetNamespace(name string) (Namespace, error)
+	// PutNamespace adds a relation namespace or replaces the namespace with the same name
+	PutNamespace(ns Namespace) error
+	// RemoveNamespace removes the relation namespace with the given name
+	RemoveNamespace(name string) error
+	// WriteRelationTuples atomically removes and adds relation tuples
+	WriteRelationTuples(writes, deletes []RelationTuple) error
+	// ReadRelationTuples returns the relation tuples that match the filter
+	ReadRelationTuples(filter TupleFilter) []RelationTuple
+
+	// Generation returns a number that changes with every change to the store's content
+	Generation() uint64
+
+	// Close flushes pending writes and releases the store's resources
+	Close() error
 }
 
-// FindSubjectsForResource finds subjects that are allowed to perform the given action on the given resource
-func (s *Store) FindSubjectsForResource(resource, action string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	subjects := make([]string, 0)
-	seen := make(map[string]bool)
-
-	for _, p := range s.policies {
-		if p.Resource == resource && p.Action == action && p.Allow {
-			if !seen[p.Subject] {
-				subjects = append(subjects, p.Subject)
-				seen[p.Subject] = true
-			}
-		}
-	}
-
-	return subjects
-}
-
-// FindResourcesForSubject finds resources that the given subject is allowed to perform the given action on
-func (s *Store) FindResourcesForSubject(subject, action string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	resources := make([]string, 0)
-	seen := make(map[string]bool)
-
-	for _, p := range s.policies {
-		if p.Subject == subject && p.Action == action && p.Allow {
-			if !seen[p.Resource] {
-				resources = append(resources, p.Resource)
-				seen[p.Resource] = true
-			}
-		}
-	}
-
-	return resources
-}
-
-// FindActionsForSubjectAndResource finds actions that the given subject is allowed to perform on the given resource
-func (s *Store) FindActionsForSubjectAndResource(subject, resource string) []string {
-	s.mu.RLock()
-	defer s.mu.RUnlock()
-
-	actions := make([]string, 0)
-	seen := make(map[string]bool)
-
-	for _, p := range s.policies {
-		if p.Subject == subject && p.Resource == resource && p.Allow {
-			if !seen[p.Action] {
-				actions = append(actions, p.Action)
-				seen[p.Action] = true
-			}
-		}
-	}
-
-	return actions
-}
+// Compile-time checks that the backends implement Store
+var (
+	_ Store = (*MemoryStore)(nil)
+	_ Store = (*FileStore)(nil)
+)
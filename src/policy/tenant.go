@@ -0,0 +1,414 @@
+package policy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"sync"
+)
+
+// DefaultTenant is the tenant of requests that select none. It holds the
+// policies of a server that does not use tenants and cannot be removed.
+const DefaultTenant = "default"
+
+var (
+	ErrTenantNotFound = errors.New("tenant not found")
+	ErrInvalidTenant  = errors.New("invalid tenant")
+	ErrQuotaExceeded  = errors.New("tenant quota exceeded")
+)
+
+// tenantIDPattern restricts tenant IDs to names that are safe as directory names
+var tenantIDPattern = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,62}$`)
+
+// Tenant is an isolated namespace of policies. Each tenant has its own
+// policies, roles, groups, relations, resource hierarchy and policy sets, and
+// requests of one tenant never see the data of another.
+type Tenant struct {
+	ID                 string             `json:"id"`
+	Metadata           map[string]string  `json:"metadata,omitempty"`            // Free-form labels, e.g. the customer's name
+	CombiningAlgorithm CombiningAlgorithm `json:"combining_algorithm,omitempty"` // Combines the tenant's policies; the server's algorithm if empty
+	Quota              Quota              `json:"quota"`
+}
+
+// Quota limits what a tenant may store. A zero limit means no limit.
+type Quota struct {
+	MaxPolicies     int `json:"max_policies,omitempty"`
+	MaxRoleBindings int `json:"max_role_bindings,omitempty"`
+}
+
+// Validate checks that the tenant is complete
+func (t Tenant) Validate() error {
+	if !tenantIDPattern.MatchString(t.ID) {
+		return fmt.Errorf("%w: id must be 1-63 lowercase letters, digits, '-' or '_', got %q", ErrInvalidTenant, t.ID)
+	}
+	if t.CombiningAlgorithm != "" {
+		if err := t.CombiningAlgorithm.Validate(); err != nil {
+			return err
+		}
+	}
+	if t.Quota.MaxPolicies < 0 || t.Quota.MaxRoleBindings < 0 {
+		return fmt.Errorf("%w: quota limits must not be negative", ErrInvalidTenant)
+	}
+	return nil
+}
+
+// TenantOptions configures a TenantRegistry
+type TenantOptions struct {
+	Path string                         // JSON file the tenants are kept in; empty to keep them in memory
+	Open func(id string) (Store, error) // Opens the store of a tenant; a MemoryStore if nil
+	Drop func(id string) error          // Deletes the data of a removed tenant; nil if there is none
+}
+
+// TenantRegistry holds the tenants of a server and opens a store for each.
+// The stores enforce the tenants' quotas. It is safe for concurrent use.
+type TenantRegistry struct {
+	mu        sync.RWMutex
+	opts      TenantOptions
+	algorithm CombiningAlgorithm // Algorithm of tenants that do not set one
+	tenants   map[string]*tenantEntry
+}
+
+// tenantEntry is a tenant with its open store
+type tenantEntry struct {
+	tenant Tenant
+	store  *quotaStore
+}
+
+// NewTenantRegistry creates a registry whose default tenant uses the given
+// store and loads the other tenants from opts.Path, opening their stores
+func NewTenantRegistry(store Store, opts TenantOptions) (*TenantRegistry, error) {
+	if opts.Open == nil {
+		opts.Open = func(string) (Store, error) { return NewMemoryStore(), nil }
+	}
+	reg := &TenantRegistry{
+		opts:      opts,
+		algorithm: store.CombiningAlgorithm(),
+		tenants:   make(map[string]*tenantEntry),
+	}
+
+	var tenants []Tenant
+	if opts.Path != "" {
+		data, err := os.ReadFile(opts.Path)
+		if err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("read tenants: %w", err)
+		}
+		if len(data) > 0 {
+			if err := json.Unmarshal(data, &tenants); err != nil {
+				return nil, fmt.Errorf("read tenants: %w", err)
+			}
+		}
+	}
+
+	reg.tenants[DefaultTenant] = &tenantEntry{tenant: Tenant{ID: DefaultTenant}, store: &quotaStore{Store: store}}
+	for _, t := range tenants {
+		if err := t.Validate(); err != nil {
+			reg.Close()
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+		entry := reg.tenants[t.ID]
+		if entry == nil {
+			s, err := opts.Open(t.ID)
+			if err != nil {
+				reg.Close()
+				return nil, fmt.Errorf("open tenant %s: %w", t.ID, err)
+			}
+			entry = &tenantEntry{store: &quotaStore{Store: s}}
+			reg.tenants[t.ID] = entry
+		}
+		if err := reg.apply(entry, t); err != nil {
+			reg.Close()
+			return nil, fmt.Errorf("tenant %s: %w", t.ID, err)
+		}
+	}
+	return reg, nil
+}
+
+// apply sets the tenant of an entry and configures its store accordingly
+func (reg *TenantRegistry) apply(entry *tenantEntry, t Tenant) error {
+	algorithm := t.CombiningAlgorithm
+	if algorithm == "" {
+		algorithm = reg.algorithm
+	}
+	if err := entry.store.SetCombiningAlgorithm(algorithm); err != nil {
+		return err
+	}
+	entry.tenant = t
+	entry.store.setQuota(t.Quota)
+	return nil
+}
+
+// Store returns the store of the tenant with the given ID
+func (reg *TenantRegistry) Store(id string) (Store, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	entry, ok := reg.tenants[id]
+	if !ok {
+		return nil, fmt.Errorf("%w: %s", ErrTenantNotFound, id)
+	}
+	return entry.store, nil
+}
+
+// Get returns the tenant with the given ID
+func (reg *TenantRegistry) Get(id string) (Tenant, error) {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	entry, ok := reg.tenants[id]
+	if !ok {
+		return Tenant{}, fmt.Errorf("%w: %s", ErrTenantNotFound, id)
+	}
+	return entry.tenant, nil
+}
+
+// List returns all tenants, sorted by ID
+func (reg *TenantRegistry) List() []Tenant {
+	reg.mu.RLock()
+	defer reg.mu.RUnlock()
+
+	return reg.listLocked()
+}
+
+func (reg *TenantRegistry) listLocked() []Tenant {
+	tenants := make([]Tenant, 0, len(reg.tenants))
+	for _, entry := range reg.tenants {
+		tenants = append(tenants, entry.tenant)
+	}
+	sort.Slice(tenants, func(i, j int) bool { return tenants[i].ID < tenants[j].ID })
+	return tenants
+}
+
+// Each calls fn with the ID and store of every tenant, sorted by ID
+func (reg *TenantRegistry) Each(fn func(id string, s Store)) {
+	for _, t := range reg.List() {
+		if s, err := reg.Store(t.ID); err == nil {
+			fn(t.ID, s)
+		}
+	}
+}
+
+// Put validates a tenant and creates it, opening its store, or updates the
+// tenant with the same ID. It reports whether the tenant was created.
+// Lowering a quota below a tenant's usage only prevents further additions.
+func (reg *TenantRegistry) Put(t Tenant) (bool, error) {
+	if err := t.Validate(); err != nil {
+		return false, err
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	entry, ok := reg.tenants[t.ID]
+	if ok {
+		previous := entry.tenant
+		if err := reg.apply(entry, t); err != nil {
+			return false, err
+		}
+		if err := reg.saveLocked(); err != nil {
+			reg.apply(entry, previous)
+			return false, err
+		}
+		return false, nil
+	}
+
+	s, err := reg.opts.Open(t.ID)
+	if err != nil {
+		return false, fmt.Errorf("open tenant %s: %w", t.ID, err)
+	}
+	entry = &tenantEntry{store: &quotaStore{Store: s}}
+	if err := reg.apply(entry, t); err != nil {
+		s.Close()
+		return false, err
+	}
+	reg.tenants[t.ID] = entry
+	if err := reg.saveLocked(); err != nil {
+		delete(reg.tenants, t.ID)
+		s.Close()
+		return false, err
+	}
+	return true, nil
+}
+
+// Remove removes a tenant, closes its store and deletes its data. The
+// default tenant cannot be removed. Once the removal is saved it stands;
+// failing to close the store or delete the data is only logged.
+func (reg *TenantRegistry) Remove(id string) error {
+	if id == DefaultTenant {
+		return fmt.Errorf("%w: the default tenant cannot be removed", ErrInvalidTenant)
+	}
+
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	entry, ok := reg.tenants[id]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrTenantNotFound, id)
+	}
+	delete(reg.tenants, id)
+	if err := reg.saveLocked(); err != nil {
+		reg.tenants[id] = entry
+		return err
+	}
+	if err := entry.store.Close(); err != nil {
+		log.Printf("Close store of removed tenant %s: %v", id, err)
+	}
+	if reg.opts.Drop != nil {
+		if err := reg.opts.Drop(id); err != nil {
+			log.Printf("Delete data of removed tenant %s: %v", id, err)
+		}
+	}
+	return nil
+}
+
+// Close closes the stores of all tenants but the default one, whose store
+// belongs to the caller of NewTenantRegistry
+func (reg *TenantRegistry) Close() error {
+	reg.mu.Lock()
+	defer reg.mu.Unlock()
+
+	var err error
+	for id, entry := range reg.tenants {
+		if id == DefaultTenant {
+			continue
+		}
+		if closeErr := entry.store.Close(); err == nil {
+			err = closeErr
+		}
+	}
+	return err
+}
+
+// saveLocked writes the tenants to opts.Path, if set
+func (reg *TenantRegistry) saveLocked() error {
+	if reg.opts.Path == "" {
+		return nil
+	}
+	data, err := json.MarshalIndent(reg.listLocked(), "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Write to a temporary file and rename it so that a crash never leaves a partial file
+	tmp := reg.opts.Path + ".tmp"
+	if err := writeFileSync(tmp, data); err != nil {
+		return fmt.Errorf("write tenants: %w", err)
+	}
+	if err := os.Rename(tmp, reg.opts.Path); err != nil {
+		return fmt.Errorf("install tenants: %w", err)
+	}
+	if err := syncDir(filepath.Dir(reg.opts.Path)); err != nil {
+		return fmt.Errorf("install tenants: %w", err)
+	}
+	return nil
+}
+
+// quotaStore enforces a tenant's quota on the writes to its store. Writes
+// that can add policies or role bindings are serialized so that concurrent
+// requests cannot exceed the quota together.
+type quotaStore struct {
+	Store
+
+	mu    sync.Mutex
+	quota Quota
+}
+
+func (s *quotaStore) setQuota(q Quota) {
+	s.mu.Lock()
+	s.quota = q
+	s.mu.Unlock()
+}
+
+// checkPolicies returns ErrQuotaExceeded if the store cannot hold the given number of additional policies
+func (s *quotaStore) checkPolicies(added int) error {
+	if s.quota.MaxPolicies > 0 && added > 0 && s.CountPolicies()+added > s.quota.MaxPolicies {
+		return fmt.Errorf("%w: at most %d policies", ErrQuotaExceeded, s.quota.MaxPolicies)
+	}
+	return nil
+}
+
+// newPolicies counts the policies that are not stored yet, not counting
+// those removed by the same write
+func (s *quotaStore) newPolicies(remove []string, policies []Policy) int {
+	removed := make(map[string]bool, len(remove))
+	for _, id := range remove {
+		if _, err := s.GetPolicy(id); err == nil {
+			removed[id] = true
+		}
+	}
+	added := -len(removed)
+	seen := make(map[string]bool, len(policies))
+	for _, p := range policies {
+		if p.ID == "" {
+			added++
+			continue
+		}
+		if seen[p.ID] {
+			continue
+		}
+		seen[p.ID] = true
+		if _, err := s.GetPolicy(p.ID); err != nil || removed[p.ID] {
+			added++
+		}
+	}
+	return added
+}
+
+// AddPolicy adds a policy unless the tenant holds its maximum number of policies
+func (s *quotaStore) AddPolicy(p Policy) (Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPolicies(1); err != nil {
+		return Policy{}, err
+	}
+	return s.Store.AddPolicy(p)
+}
+
+// UpsertPolicies stores a batch of policies unless the new ones exceed the tenant's quota
+func (s *quotaStore) UpsertPolicies(policies []Policy) ([]Policy, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPolicies(s.newPolicies(nil, policies)); err != nil {
+		return nil, err
+	}
+	return s.Store.UpsertPolicies(policies)
+}
+
+// ReplacePolicies replaces policies unless the result exceeds the tenant's quota
+func (s *quotaStore) ReplacePolicies(remove []string, policies []Policy) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPolicies(s.newPolicies(remove, policies)); err != nil {
+		return err
+	}
+	return s.Store.ReplacePolicies(remove, policies)
+}
+
+// ReplacePoliciesAndGroups replaces policies and groups unless the result exceeds the tenant's quota
+func (s *quotaStore) ReplacePoliciesAndGroups(removePolicies []string, policies []Policy, removeGroups []string, groups []Group) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkPolicies(s.newPolicies(removePolicies, policies)); err != nil {
+		return err
+	}
+	return s.Store.ReplacePoliciesAndGroups(removePolicies, policies, removeGroups, groups)
+}
+
+// AddRoleBinding adds a role binding unless the tenant holds its maximum number of bindings
+func (s *quotaStore) AddRoleBinding(b RoleBinding) (RoleBinding, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.quota.MaxRoleBindings > 0 && s.CountRoleBindings() >= s.quota.MaxRoleBindings {
+		return RoleBinding{}, fmt.Errorf("%w: at most %d role bindings", ErrQuotaExceeded, s.quota.MaxRoleBindings)
+	}
+	return s.Store.AddRoleBinding(b)
+}
@@ -0,0 +1,156 @@
+package policy
+
+import (
+	"errors"
+	"io"
+	"log"
+	"testing"
+)
+
+// newQuotaStore returns the store of a tenant limited to two policies and one role binding
+func newQuotaStore(t *testing.T) Store {
+	t.Helper()
+	reg, err := NewTenantRegistry(NewMemoryStore(), TenantOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Put(Tenant{ID: "acme", Quota: Quota{MaxPolicies: 2, MaxRoleBindings: 1}}); err != nil {
+		t.Fatal(err)
+	}
+	s, err := reg.Store("acme")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.PutRole(Role{ID: "viewer", Permissions: []Permission{{ResourceType: "doc", Action: "read"}}}); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+// quotaPolicy returns a policy of alice reading the document with the given ID
+func quotaPolicy(id string) Policy {
+	return Policy{ID: id, Subject: user("alice"), Resource: doc(id), Action: "read", Allow: true}
+}
+
+func TestTenantQuota(t *testing.T) {
+	tests := []struct {
+		name  string
+		write func(s Store) error
+		want  error
+	}{
+		{
+			name: "add within the quota",
+			write: func(s Store) error {
+				_, err := s.AddPolicy(quotaPolicy("2"))
+				return err
+			},
+		},
+		{
+			name: "add beyond the quota",
+			write: func(s Store) error {
+				if _, err := s.AddPolicy(quotaPolicy("2")); err != nil {
+					return err
+				}
+				_, err := s.AddPolicy(quotaPolicy("3"))
+				return err
+			},
+			want: ErrQuotaExceeded,
+		},
+		{
+			name: "upsert replacing a stored policy",
+			write: func(s Store) error {
+				_, err := s.UpsertPolicies([]Policy{quotaPolicy("1"), quotaPolicy("2")})
+				return err
+			},
+		},
+		{
+			name: "upsert beyond the quota",
+			write: func(s Store) error {
+				_, err := s.UpsertPolicies([]Policy{quotaPolicy("2"), quotaPolicy("3")})
+				return err
+			},
+			want: ErrQuotaExceeded,
+		},
+		{
+			name: "upsert counting duplicates once",
+			write: func(s Store) error {
+				_, err := s.UpsertPolicies([]Policy{quotaPolicy("2"), quotaPolicy("2")})
+				return err
+			},
+		},
+		{
+			name: "replace removing as many as it adds",
+			write: func(s Store) error {
+				return s.ReplacePolicies([]string{"1"}, []Policy{quotaPolicy("2"), quotaPolicy("3")})
+			},
+		},
+		{
+			name:  "replace beyond the quota",
+			write: func(s Store) error { return s.ReplacePolicies(nil, []Policy{quotaPolicy("2"), quotaPolicy("3")}) },
+			want:  ErrQuotaExceeded,
+		},
+		{
+			name: "replace removing a missing policy",
+			write: func(s Store) error {
+				return s.ReplacePolicies([]string{"9"}, []Policy{quotaPolicy("2"), quotaPolicy("3")})
+			},
+			want: ErrQuotaExceeded,
+		},
+		{
+			name: "replace with groups beyond the quota",
+			write: func(s Store) error {
+				return s.ReplacePoliciesAndGroups(nil, []Policy{quotaPolicy("2"), quotaPolicy("3")}, nil, nil)
+			},
+			want: ErrQuotaExceeded,
+		},
+		{
+			name: "role binding beyond the quota",
+			write: func(s Store) error {
+				if _, err := s.AddRoleBinding(RoleBinding{Subject: user("alice"), Role: "viewer"}); err != nil {
+					return err
+				}
+				_, err := s.AddRoleBinding(RoleBinding{Subject: user("bob"), Role: "viewer"})
+				return err
+			},
+			want: ErrQuotaExceeded,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := newQuotaStore(t)
+			if _, err := s.AddPolicy(quotaPolicy("1")); err != nil {
+				t.Fatal(err)
+			}
+			if err := tt.write(s); !errors.Is(err, tt.want) {
+				t.Fatalf("error = %v, want %v", err, tt.want)
+			}
+			if n := s.CountPolicies(); n > 2 {
+				t.Errorf("%d policies stored beyond the quota", n)
+			}
+		})
+	}
+}
+
+func TestTenantRemoveIgnoresDropFailure(t *testing.T) {
+	defer log.SetOutput(log.Writer())
+	log.SetOutput(io.Discard)
+
+	reg, err := NewTenantRegistry(NewMemoryStore(), TenantOptions{
+		Drop: func(string) error { return errors.New("disk failure") },
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := reg.Put(Tenant{ID: "acme"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := reg.Remove("acme"); err != nil {
+		t.Fatalf("Remove = %v, want success once the removal is saved", err)
+	}
+	if _, err := reg.Get("acme"); !errors.Is(err, ErrTenantNotFound) {
+		t.Errorf("Get after Remove = %v, want %v", err, ErrTenantNotFound)
+	}
+	if err := reg.Remove(DefaultTenant); !errors.Is(err, ErrInvalidTenant) {
+		t.Errorf("Remove(default) = %v, want %v", err, ErrInvalidTenant)
+	}
+}
@@ -0,0 +1,368 @@
+package policy
+
+import (
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Trace explains how a decision was reached: the policies that were
+// considered, which of them applied and why, how the applicable ones were
+// combined and what decided. It holds the attribute values that conditions
+// were evaluated against.
+type Trace struct {
+	Time       time.Time       `json:"time"`                // Time the validity of the policies was checked at
+	Principals []Entity        `json:"principals"`          // The subject and the groups it is a member of
+	Lineage    []Entity        `json:"lineage"`             // The resource and its ancestors, nearest first
+	Policies   []PolicyTrace   `json:"policies"`            // Policies matching a principal, a resource of the lineage and the action, in the order considered
+	Combining  []CombiningStep `json:"combining,omitempty"` // Combinations of the applicable policies, those of policy sets first
+	DecidedBy  string          `json:"decided_by"`          // "policy", "conflict", "condition_error", "role_binding", "relation" or "default"
+	Rule       string          `json:"rule,omitempty"`      // ID of the deciding policy or role binding, or the deciding relation
+}
+
+// Values of Trace.DecidedBy
+const (
+	DecidedByPolicy         = "policy"          // An applicable policy, selected by the combining algorithm
+	DecidedByConflict       = "conflict"        // Several policies applied under the only-one-applicable algorithm
+	DecidedByConditionError = "condition_error" // The condition of a matching policy could not be evaluated
+	DecidedByRoleBinding    = "role_binding"    // No policy applied and a role binding granted access
+	DecidedByRelation       = "relation"        // Neither a policy nor a role binding applied and a relation granted access
+	DecidedByDefault        = "default"         // Nothing granted access
+)
+
+// PolicyTrace describes how a policy was evaluated
+type PolicyTrace struct {
+	ID         string           `json:"id"`
+	Effect     string           `json:"effect"` // "allow" or "deny"
+	Set        string           `json:"set,omitempty"`
+	Subject    Entity           `json:"subject"`
+	Resource   Entity           `json:"resource"`             // Resource of the lineage the policy matched
+	Valid      bool             `json:"valid"`                // Whether the time was within the policy's validity period
+	Applies    bool             `json:"applies"`              // Whether the policy applied to the request
+	Conditions []ConditionTrace `json:"conditions,omitempty"` // Results of the conditions; absent if the policy was not valid
+	Expression *ExpressionTrace `json:"expression,omitempty"`
+	Cedar      *CedarTrace      `json:"cedar,omitempty"`
+}
+
+// ConditionTrace describes how a condition was evaluated
+type ConditionTrace struct {
+	Attribute string      `json:"attribute"`
+	Operator  string      `json:"operator"`
+	Value     interface{} `json:"value,omitempty"` // Operand: the literal, or the value of value_from
+	ValueFrom string      `json:"value_from,omitempty"`
+	Actual    interface{} `json:"actual,omitempty"` // Value of the attribute; absent if the request lacks it
+	Result    bool        `json:"result"`
+}
+
+// ExpressionTrace describes how a condition expression was evaluated
+type ExpressionTrace struct {
+	Source string                 `json:"source"`
+	Values map[string]interface{} `json:"values,omitempty"` // Values of the attributes the expression reads, by path; absent attributes are left out
+	Result bool                   `json:"result"`
+	Error  string                 `json:"error,omitempty"`
+}
+
+// CedarTrace describes how a Cedar policy was evaluated
+type CedarTrace struct {
+	Scope      bool                  `json:"scope"`                // Whether the principal, action and resource matched the policy's scope
+	Conditions []CedarConditionTrace `json:"conditions,omitempty"` // Conditions up to the first one not satisfied
+	Error      string                `json:"error,omitempty"`      // Why the policy could not be parsed
+}
+
+// CedarConditionTrace describes how a when or unless clause was evaluated
+type CedarConditionTrace struct {
+	Kind      string `json:"kind"` // "when" or "unless"
+	Satisfied bool   `json:"satisfied"`
+	Error     string `json:"error,omitempty"`
+}
+
+// CombiningStep describes a combination of outcomes by an algorithm
+type CombiningStep struct {
+	Set       string             `json:"set,omitempty"` // Policy set whose policies were combined; empty for the final combination
+	Algorithm CombiningAlgorithm `json:"algorithm"`
+	Inputs    []string           `json:"inputs"`             // Policy IDs, and policy sets as "set:<name>", in evaluation order
+	Effect    string             `json:"effect"`             // "allow", "deny" or "conflict"
+	Selected  string             `json:"selected,omitempty"` // Input that decided; absent for a conflict
+}
+
+// tracer records a Trace during an evaluation. Its methods do nothing on a
+// nil tracer, which is what evaluations that are not explained use.
+type tracer struct {
+	trace Trace
+}
+
+// start records the principals and lineage of the request
+func (t *tracer) start(principals, lineage []Entity, v *validity) {
+	if t == nil {
+		return
+	}
+	t.trace.Time = v.now
+	t.trace.Principals = principals
+	t.trace.Lineage = lineage
+	t.trace.Policies = []PolicyTrace{}
+}
+
+// policy records a policy that matched the request's principal, resource
+// and action, re-evaluating its conditions to record their details
+func (t *tracer) policy(st *state, entry *stored, resource Entity, req Request, v *validity, applies bool) {
+	if t == nil {
+		return
+	}
+	p := entry.policy
+	pt := PolicyTrace{
+		ID:       p.ID,
+		Effect:   effectOf(p.Allow),
+		Set:      p.Set,
+		Subject:  p.Subject,
+		Resource: resource,
+		Valid:    p.ActiveAt(v.now),
+		Applies:  applies,
+	}
+	if pt.Valid {
+		if entry.cedar != nil {
+			pt.Cedar = entry.cedar.trace(st, req)
+		} else {
+			pt.Conditions, pt.Expression = traceConditions(p, entry.condition, entry.windows, req)
+		}
+	}
+	t.trace.Policies = append(t.trace.Policies, pt)
+}
+
+// traceConditions evaluates the conditions of a native policy, which are
+// either a list of conditions or a condition expression
+func traceConditions(p Policy, condition *Expression, windows timeWindows, req Request) ([]ConditionTrace, *ExpressionTrace) {
+	if condition != nil {
+		return nil, traceExpression(condition, req)
+	}
+
+	var conditions []ConditionTrace
+	for i, c := range p.Conditions {
+		ct := ConditionTrace{Attribute: c.Attribute, Operator: c.Operator, Value: c.Value, ValueFrom: c.ValueFrom, Result: c.matches(req, windows.at(i))}
+		if v, ok := req.Attribute(c.Attribute); ok {
+			ct.Actual = v
+		}
+		if c.ValueFrom != "" {
+			ct.Value, _ = req.Attribute(c.ValueFrom)
+		}
+		conditions = append(conditions, ct)
+	}
+	return conditions, nil
+}
+
+// traceExpression evaluates a condition expression
+func traceExpression(condition *Expression, req Request) *ExpressionTrace {
+	et := &ExpressionTrace{Source: condition.String()}
+	for _, path := range condition.paths {
+		if v, ok := req.Attribute(path); ok {
+			if et.Values == nil {
+				et.Values = make(map[string]interface{})
+			}
+			et.Values[path] = v
+		}
+	}
+	var err error
+	if et.Result, err = condition.Evaluate(req); err != nil {
+		et.Error = err.Error()
+	}
+	return et
+}
+
+// RedactExpression returns the source of a condition expression with the
+// string literals that are compared with, or passed along with, a sensitive
+// attribute replaced by mask. An attribute is sensitive if sensitive reports
+// so for its path, e.g. "context.api_key". A source that does not parse is
+// replaced as a whole.
+func RedactExpression(src string, sensitive func(path string) bool, mask string) string {
+	root, err := parseExpression(src)
+	if err != nil {
+		return mask
+	}
+	redact := make(map[int]bool) // Offsets of the string literals to replace
+	var walk func(n *node)
+	walk = func(n *node) {
+		switch n.kind {
+		case nodeBinary, nodeCall:
+			if n.kind == nodeBinary && (n.name == "&&" || n.name == "||") {
+				break
+			}
+			for i, arg := range n.args {
+				if !readsSensitive(arg, sensitive) {
+					continue
+				}
+				for j, other := range n.args {
+					if j != i {
+						stringLiterals(other, redact)
+					}
+				}
+			}
+		}
+		for _, arg := range n.args {
+			walk(arg)
+		}
+	}
+	walk(root)
+	if len(redact) == 0 {
+		return src
+	}
+
+	tokens, _ := lex(src)
+	var b strings.Builder
+	last := 0
+	for _, t := range tokens {
+		if t.kind != tokenString || !redact[t.pos] {
+			continue
+		}
+		_, end, _ := lexString(src, t.pos)
+		b.WriteString(src[last:t.pos])
+		b.WriteString(strconv.Quote(mask))
+		last = end
+	}
+	b.WriteString(src[last:])
+	return b.String()
+}
+
+// readsSensitive reports whether an expression reads a sensitive attribute
+func readsSensitive(n *node, sensitive func(path string) bool) bool {
+	if path, ok := attributePath(n); ok && sensitive(path) {
+		return true
+	}
+	for _, arg := range n.args {
+		if readsSensitive(arg, sensitive) {
+			return true
+		}
+	}
+	return false
+}
+
+// attributePath returns the path of a chain of field selections and
+// indexes with a string literal, e.g. context["api_key"].value
+func attributePath(n *node) (string, bool) {
+	switch n.kind {
+	case nodeIdent:
+		return n.name, true
+	case nodeSelect:
+		path, ok := attributePath(n.args[0])
+		return path + "." + n.name, ok
+	case nodeIndex:
+		key, isString := n.args[1].value.(string)
+		if n.args[1].kind != nodeLiteral || !isString {
+			return "", false
+		}
+		path, ok := attributePath(n.args[0])
+		return path + "." + key, ok
+	}
+	return "", false
+}
+
+// stringLiterals adds the offsets of the string literals of an expression to offsets
+func stringLiterals(n *node, offsets map[int]bool) {
+	if _, ok := n.value.(string); ok && n.kind == nodeLiteral {
+		offsets[n.pos] = true
+	}
+	for _, arg := range n.args {
+		stringLiterals(arg, offsets)
+	}
+}
+
+// trace evaluates the policy's scope and conditions, stopping where
+// evaluation does
+func (c *cedarPolicy) trace(st *state, req Request) *CedarTrace {
+	if c.err != nil {
+		return &CedarTrace{Error: c.err.Error()}
+	}
+	env := &cedarEnv{st: st, req: req}
+	ct := &CedarTrace{
+		Scope: c.principal.matches(env, req.Subject) && c.action.matches(req.Action) && c.resource.matches(env, req.Resource),
+	}
+	if !ct.Scope {
+		return ct
+	}
+	for _, cond := range c.conditions {
+		cc := CedarConditionTrace{Kind: "when"}
+		if !cond.when {
+			cc.Kind = "unless"
+		}
+		v, err := env.eval(cond.expr)
+		if err != nil {
+			cc.Error = err.Error()
+		} else if b, ok := v.(bool); ok {
+			cc.Satisfied = b == cond.when
+		}
+		ct.Conditions = append(ct.Conditions, cc)
+		if !cc.Satisfied {
+			break
+		}
+	}
+	return ct
+}
+
+// combined records how the outcomes of the applicable policies and policy
+// sets were combined into the final outcome
+func (t *tracer) combined(algorithm CombiningAlgorithm, outcomes []outcome, members map[string][]outcome, final outcome) {
+	if t == nil {
+		return
+	}
+	inputs := make([]string, len(outcomes))
+	for i, o := range outcomes {
+		if o.set == "" {
+			inputs[i] = o.entry.policy.ID
+			continue
+		}
+		inputs[i] = "set:" + o.set
+		ids := make([]string, len(members[o.set]))
+		for j, m := range members[o.set] {
+			ids[j] = m.entry.policy.ID
+		}
+		step := CombiningStep{Set: o.set, Algorithm: o.algorithm, Inputs: ids, Effect: outcomeEffect(o)}
+		if o.entry != nil {
+			step.Selected = o.entry.policy.ID
+		}
+		t.trace.Combining = append(t.trace.Combining, step)
+	}
+
+	step := CombiningStep{Algorithm: algorithm, Inputs: inputs, Effect: outcomeEffect(final)}
+	switch {
+	case final.set != "":
+		step.Selected = "set:" + final.set
+	case final.entry != nil:
+		step.Selected = final.entry.policy.ID
+	}
+	t.trace.Combining = append(t.trace.Combining, step)
+}
+
+// finish records what took the decision and returns the trace
+func (t *tracer) finish(d Decision) Trace {
+	switch {
+	case d.Error != nil:
+		t.trace.DecidedBy = DecidedByConditionError
+		t.trace.Rule = d.Policy.ID
+	case d.Conflict:
+		t.trace.DecidedBy = DecidedByConflict
+	case d.Policy != nil:
+		t.trace.DecidedBy = DecidedByPolicy
+		t.trace.Rule = d.Policy.ID
+	case d.Binding != nil:
+		t.trace.DecidedBy = DecidedByRoleBinding
+		t.trace.Rule = d.Binding.ID
+	case d.Relation != "":
+		t.trace.DecidedBy = DecidedByRelation
+		t.trace.Rule = d.Relation
+	default:
+		t.trace.DecidedBy = DecidedByDefault
+	}
+	return t.trace
+}
+
+func effectOf(allow bool) string {
+	if allow {
+		return "allow"
+	}
+	return "deny"
+}
+
+func outcomeEffect(o outcome) string {
+	if o.entry == nil {
+		return "conflict"
+	}
+	return effectOf(o.allow)
+}